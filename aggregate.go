@@ -0,0 +1,146 @@
+package bqstreamer
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// aggregateErrors coalesces insert errors reported by workers
+// over the window set using SetAsyncErrorAggregation(),
+// calling the error handler once per window with all of them,
+// until the error channel is closed.
+//
+// Tables without errors are omitted, and reports left once the channel
+// is closed are handled right away.
+func (s *AsyncWorkerGroup) aggregateErrors() {
+	c := s.clock
+	if c == nil {
+		c = realClock{}
+	}
+
+	var (
+		pending []*TableInsertErrors
+		timer   clockTimer
+		due     <-chan time.Time
+	)
+	flush := func() {
+		if len(pending) > 0 {
+			s.errorHandler(aggregatedErrors(pending))
+			pending = nil
+		}
+		if timer != nil {
+			timer.Stop()
+			timer, due = nil, nil
+		}
+	}
+	defer flush()
+
+	for {
+		select {
+		case insertErrs, ok := <-s.errorChan:
+			if !ok {
+				return
+			}
+			for _, table := range insertErrs.Tables {
+				if table.hasErrors() {
+					pending = append(pending, table)
+				}
+			}
+			// The window starts with its first error.
+			if len(pending) > 0 && timer == nil {
+				timer = c.NewTimer(s.errorAggregation)
+				due = timer.C()
+			}
+		case <-due:
+			timer, due = nil, nil
+			flush()
+		}
+	}
+}
+
+// aggregatedErrors returns given tables' errors as a single report,
+// with tables grouped by project, dataset, table and template suffix,
+// in the order they were reported.
+func aggregatedErrors(tables []*TableInsertErrors) *InsertErrors {
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tableName(tables[i]) < tableName(tables[j])
+	})
+	return &InsertErrors{Tables: tables}
+}
+
+// tableName returns the name of given table's insert operation,
+// i.e. "project.dataset.table" + template suffix.
+func tableName(table *TableInsertErrors) string {
+	for _, attempt := range table.InsertAttempts {
+		if attempt.Table != "" {
+			return attempt.Project + "." + attempt.Dataset + "." + attempt.Table + attempt.TemplateSuffix
+		}
+	}
+	return ""
+}
+
+// hasErrors returns true if any of the table's insert attempts
+// has either failed or rejected rows.
+func (table *TableInsertErrors) hasErrors() bool {
+	for _, attempt := range table.InsertAttempts {
+		if attempt.err != nil || len(attempt.rows) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorCounts returns the amount of errors of every table,
+// keyed by "project.dataset.table" + template suffix, per error reason.
+// Rejected rows are counted per row error reason, e.g. "invalid",
+// and failed insert requests per GoogleAPI error reason, e.g. "backendError",
+// or "error" if the request has failed for any other reason.
+//
+// It is useful for summarizing reports aggregated using
+// SetAsyncErrorAggregation(), e.g. for logging or alerting.
+//
+// Unlike Next() and All(), errors are not consumed.
+func (insert *InsertErrors) ErrorCounts() map[string]map[string]int {
+	counts := map[string]map[string]int{}
+	for _, table := range insert.Tables {
+		name := tableName(table)
+		for _, attempt := range table.InsertAttempts {
+			var reasons []string
+			var tooManyRetries *TooManyFailedInsertRetriesError
+			switch {
+			case attempt.err == nil:
+				for _, row := range attempt.rows {
+					for _, err := range row.Errors {
+						reasons = append(reasons, err.Reason)
+					}
+				}
+			case errors.As(attempt.err, &tooManyRetries):
+				// Not a request, but giving up on the failed ones.
+				continue
+			default:
+				reasons = append(reasons, requestErrorReason(attempt.err))
+			}
+
+			for _, reason := range reasons {
+				if counts[name] == nil {
+					counts[name] = map[string]int{}
+				}
+				counts[name][reason]++
+			}
+		}
+	}
+	return counts
+}
+
+// requestErrorReason returns the reason of given insert request error,
+// see ErrorCounts().
+func requestErrorReason(err error) string {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && len(apiErr.Errors) > 0 && apiErr.Errors[0].Reason != "" {
+		return apiErr.Errors[0].Reason
+	}
+	return "error"
+}
@@ -1,9 +1,12 @@
 package bqstreamer
 
 import (
+	"net/http"
 	"testing"
 	"time"
 
+	bigquery "google.golang.org/api/bigquery/v2"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,32 +22,212 @@ func TestAsyncWorkerGroupOptions(t *testing.T) {
 	assert.EqualError(SetAsyncNumWorkers(-1)(&m), "number of workers must be a positive int")
 	assert.EqualError(SetAsyncMaxRows(0)(&m), "max rows must be non-negative int")
 	assert.EqualError(SetAsyncMaxRows(-1)(&m), "max rows must be non-negative int")
+	assert.EqualError(SetAsyncMaxBytes(0)(&m), "max bytes must be a positive int")
+	assert.EqualError(SetAsyncMaxRowsPerRequest(0)(&m), "max rows per request must be a positive int not exceeding 10000")
+	assert.EqualError(SetAsyncMaxRowsPerRequest(10001)(&m), "max rows per request must be a positive int not exceeding 10000")
 	assert.EqualError(SetAsyncMaxDelay(0)(&m), "max delay must be a positive time.Duration")
 	assert.EqualError(SetAsyncMaxDelay(-1)(&m), "max delay must be a positive time.Duration")
+	assert.EqualError(SetAsyncMaxDelayJitter(-0.1)(&m), "max delay jitter must be a non-negative float smaller than 1")
+	assert.EqualError(SetAsyncMaxDelayJitter(1)(&m), "max delay jitter must be a non-negative float smaller than 1")
 	assert.EqualError(SetAsyncRetryInterval(0)(&m), "sleep before retry must be a positive time.Duration")
 	assert.EqualError(SetAsyncRetryInterval(-1)(&m), "sleep before retry must be a positive time.Duration")
 	assert.EqualError(SetAsyncMaxRetries(-1)(&m), "max retry insert must be a non-negative int")
 	assert.EqualError(SetAsyncErrorChannel(nil)(&m), "error channel is nil")
+	assert.EqualError(SetAsyncErrorHandler(nil)(&m), "error handler is nil")
+	assert.EqualError(SetAsyncEndpoint("localhost:9050")(&m), "endpoint must be a well-formed http or https URL")
+	assert.EqualError(SetAsyncStatsHandler(nil)(&m), "stats handler is nil")
+	assert.EqualError(SetAsyncLogger(nil)(&m), "logger is nil")
+	assert.EqualError(SetAsyncInsertTracer(nil)(&m), "insert tracer is nil")
+	assert.EqualError(SetAsyncDeadLetterHandler(nil)(&m), "dead-letter handler is nil")
+	assert.EqualError(SetAsyncSpillHandler(nil)(&m), "spill handler is nil")
+	assert.EqualError(SetAsyncFlushCallback(nil)(&m), "flush callback is nil")
+	assert.EqualError(SetAsyncShardKey(nil)(&m), "shard key is nil")
+	assert.EqualError(SetAsyncDispatchStrategy(DispatchStrategy(-1))(&m), "unknown dispatch strategy")
+	assert.EqualError(SetAsyncMaxBufferedBytes(0)(&m), "max buffered bytes must be a positive int")
+	assert.EqualError(SetAsyncOverflowPolicy(OverflowPolicy(-1))(&m), "unknown overflow policy")
+	assert.EqualError(SetAsyncRetryableFunc(nil)(&m), "retryable func is nil")
+	assert.EqualError(SetAsyncTableRetryable("p", "d", "t", nil)(&m), "table retryable func is nil")
+	assert.EqualError(SetAsyncSchemaRefresh(nil)(&m), "schema refresh is nil")
+	assert.EqualError(SetAsyncTraceID(nil)(&m), "trace ID func is nil")
+	assert.EqualError(SetAsyncAutoInsertID(nil)(&m), "auto insert ID func is nil")
+	assert.EqualError(SetAsyncRetryCallback(nil)(&m), "retry callback is nil")
+	assert.EqualError(SetAsyncMaxConcurrentInserts(0)(&m), "max concurrent inserts must be a positive int")
+	assert.EqualError(SetAsyncMaxInFlightBytes(0)(&m), "max in-flight bytes must be a positive int")
+	assert.EqualError(SetAsyncGzipMinBytes(-1)(&m), "gzip min bytes must be a non-negative int")
+	assert.EqualError(SetAsyncErrorAggregation(0)(&m), "error aggregation window must be a positive time.Duration")
+	assert.EqualError(SetAsyncTableStats(0)(&m), "max tracked tables must be a positive int")
+	assert.EqualError(SetAsyncRowTTL(0)(&m), "row TTL must be a positive time.Duration")
+	assert.EqualError(SetAsyncMaxLineSize(0)(&m), "max line size must be a positive int")
+	assert.EqualError(SetAsyncHighWaterMark(0, func(int, int) {})(&m), "high water mark must be within (0, 1]")
+	assert.EqualError(SetAsyncHighWaterMark(1.5, func(int, int) {})(&m), "high water mark must be within (0, 1]")
+	assert.EqualError(SetAsyncHighWaterMark(0.8, nil)(&m), "high water mark callback is nil")
+	assert.EqualError(SetAsyncLowWaterMark(1, func(int, int) {})(&m), "low water mark must be within [0, 1)")
+	assert.EqualError(SetAsyncLowWaterMark(0.2, nil)(&m), "low water mark callback is nil")
+	assert.EqualError(SetAsyncTransport(nil)(&m), "transport is nil")
+	assert.EqualError(SetAsyncInsertTimeout(0)(&m), "insert timeout must be a positive time.Duration")
+	assert.EqualError(SetAsyncCloseGracePeriod(0)(&m), "close grace period must be a positive time.Duration")
+	assert.EqualError(SetAsyncRetryBudget(1.5, 1)(&m), "retry budget ratio must be a float between 0 and 1")
+	assert.EqualError(SetAsyncRetryBudget(0.1, -1)(&m), "retry budget min retries per second must be a non-negative int")
+	assert.EqualError(SetAsyncHealthThresholds(-0.1, 0.9, time.Minute)(&m), "health max failure rate must be a float between 0 and 1")
+	assert.EqualError(SetAsyncHealthThresholds(0.5, 0, time.Minute)(&m), "health max buffer usage must be a float between 0 and 1")
+	assert.EqualError(SetAsyncHealthThresholds(0.5, 0.9, 0)(&m), "health window must be a positive time.Duration")
+	assert.EqualError(SetAsyncSchema("p", "d", "t", nil)(&m), "schema is nil")
+	assert.EqualError(SetAsyncRowTransform(nil)(&m), "row transform is nil")
+	assert.EqualError(SetAsyncDedupKey(nil)(&m), "dedup key is nil")
+	assert.EqualError(SetAsyncPreFlush(nil)(&m), "pre-flush is nil")
+	assert.EqualError(SetAsyncRowSizer(nil)(&m), "row sizer is nil")
+	assert.EqualError(SetAsyncDropHandler(nil)(&m), "drop handler is nil")
+	assert.EqualError(SetAsyncUserAgent("", false)(&m), "user agent must be a non-empty string")
+	assert.EqualError(SetAsyncQuotaProject("p")(&m), "quota project must be a valid project ID")
+	assert.EqualError(SetAsyncRetryDeadline(-1)(&m), "retry deadline must be a positive time.Duration")
+	assert.EqualError(SetAsyncScopes()(&m), "at least one scope must be given")
+	assert.EqualError(SetAsyncStartStagger(-1)(&m), "start stagger must be a non-negative time.Duration")
+	assert.EqualError(SetAsyncScopes(bigquery.BigqueryInsertdataScope, "")(&m), "scopes must be non-empty strings")
+	assert.EqualError(SetAsyncCircuitBreaker(0, time.Second)(&m), "circuit breaker failure threshold must be a positive int")
+	assert.EqualError(SetAsyncCircuitBreaker(1, 0)(&m), "circuit breaker cooldown must be a positive time.Duration")
+	assert.EqualError(SetAsyncDialTimeout(0)(&m), "dial timeout must be a positive time.Duration")
+	assert.EqualError(SetAsyncKeepAlive(0)(&m), "keep-alive must be a positive time.Duration")
+	assert.EqualError(SetAsyncNetworkMode(NetworkMode(3))(&m), "unknown network mode")
+	assert.EqualError(SetAsyncRetryBackoff(0, time.Second, 2)(&m), "initial backoff must be a positive time.Duration")
+	assert.EqualError(SetAsyncRetryBackoff(time.Second, time.Millisecond, 2)(&m), "max backoff must not be smaller than initial backoff")
+	assert.EqualError(SetAsyncRetryBackoff(time.Second, time.Second, 0.5)(&m), "backoff multiplier must be at least 1")
 
 	// Test valid arguments
 	m = AsyncWorkerGroup{}
 	assert.NoError(SetAsyncNumWorkers(5)(&m))
 	assert.NoError(SetAsyncMaxRows(1)(&m))
+	assert.NoError(SetAsyncMaxBytes(1024)(&m))
+	assert.NoError(SetAsyncMaxRowsPerRequest(500)(&m))
 	assert.NoError(SetAsyncMaxDelay(1 * time.Second)(&m))
+	assert.NoError(SetAsyncMaxDelayJitter(0.2)(&m))
+	assert.NoError(SetAsyncStartStagger(10 * time.Millisecond)(&m))
 	assert.NoError(SetAsyncRetryInterval(2 * time.Second)(&m))
 	assert.NoError(SetAsyncMaxRetries(2)(&m))
 	c := make(chan *InsertErrors)
 	assert.NoError(SetAsyncErrorChannel(c)(&m))
+	assert.NoError(SetAsyncErrorHandler(func(*InsertErrors) {})(&m))
 	assert.NoError(SetAsyncIgnoreUnknownValues(true)(&m))
 	assert.NoError(SetAsyncSkipInvalidRows(true)(&m))
+	assert.NoError(SetAsyncRetryStopped(true)(&m))
+	assert.NoError(SetAsyncRetryTransientRows(true)(&m))
+	assert.NoError(SetAsyncTableOptions("p", "d", "t", TableOptions{SkipInvalidRows: true})(&m))
+	assert.NoError(SetAsyncRetryBackoff(time.Second, time.Minute, 2)(&m))
+	assert.NoError(SetAsyncEndpoint("http://localhost:9050/bigquery/v2/")(&m))
+	assert.NoError(SetAsyncStatsHandler(NopStatsHandler{})(&m))
+	assert.NoError(SetAsyncLogger(nopLogger{})(&m))
+	tracer := &insertTracerRecorder{}
+	assert.NoError(SetAsyncInsertTracer(tracer)(&m))
+	assert.NoError(SetAsyncDeadLetterHandler(func(Row, error) {})(&m))
+	assert.NoError(SetAsyncSpillHandler(func([]Row) error { return nil })(&m))
+	assert.NoError(SetAsyncFlushCallback(func(int, int, string) {})(&m))
+	assert.NoError(SetAsyncShardKey(func(r Row) string { return r.TableID })(&m))
+	assert.NoError(SetAsyncDispatchStrategy(PerWorkerQueue)(&m))
+	assert.NoError(SetAsyncWarmup(true)(&m))
+	assert.NoError(SetAsyncPropagateContext(true)(&m))
+	assert.NoError(SetAsyncMaxBufferedBytes(1 << 20)(&m))
+	assert.NoError(SetAsyncOverflowPolicy(OverflowDropOldest)(&m))
+	assert.NoError(SetAsyncRetryableFunc(func(error) bool { return true })(&m))
+	assert.NoError(SetAsyncTableRetryable("p", "d", "t", func(error) bool { return false })(&m))
+	assert.NoError(SetAsyncSchemaRefresh(func(string) error { return nil })(&m))
+	assert.NoError(SetAsyncTraceID(func([]Row) string { return "id" })(&m))
+	assert.NoError(SetAsyncAutoInsertID(ContentHashInsertID)(&m))
+	assert.NoError(SetAsyncRetryCallback(func(int, error, time.Duration) {})(&m))
+	assert.NoError(SetAsyncMaxConcurrentInserts(3)(&m))
+	assert.NoError(SetAsyncMaxInFlightBytes(1024)(&m))
+	assert.NoError(SetAsyncHighWaterMark(0.8, func(int, int) {})(&m))
+	assert.NoError(SetAsyncLowWaterMark(0.2, func(int, int) {})(&m))
+	assert.NoError(SetAsyncGzip(true)(&m))
+	assert.NoError(SetAsyncGzipMinBytes(1024)(&m))
+	assert.NoError(SetAsyncErrorAggregation(time.Minute)(&m))
+	assert.NoError(SetAsyncTableStats(100)(&m))
+	assert.NoError(SetAsyncRowTTL(time.Hour)(&m))
+	assert.NoError(SetAsyncMaxLineSize(1024)(&m))
+	assert.NoError(SetAsyncCancelInFlightOnClose(true)(&m))
+	assert.NoError(SetAsyncUserAgent("my-app/1.0", true)(&m))
+	assert.NoError(SetAsyncQuotaProject("billing-project")(&m))
+	assert.NoError(SetAsyncScopes(bigquery.BigqueryInsertdataScope)(&m))
+	assert.NoError(SetAsyncVerifyCredentials(true)(&m))
+	assert.NoError(SetAsyncRetryDeadline(time.Minute)(&m))
+	assert.NoError(SetAsyncInsertTimeout(5 * time.Second)(&m))
+	assert.NoError(SetAsyncCloseGracePeriod(10 * time.Second)(&m))
+	assert.NoError(SetAsyncRetryBudget(0.1, 10)(&m))
+	assert.NoError(SetAsyncHealthThresholds(0.2, 0.8, 30*time.Second)(&m))
+	transport := &http.Transport{}
+	assert.NoError(SetAsyncTransport(transport)(&m))
+	assert.NoError(SetAsyncDialTimeout(3 * time.Second)(&m))
+	assert.NoError(SetAsyncKeepAlive(time.Minute)(&m))
+	assert.NoError(SetAsyncNetworkMode(NetworkIPv6)(&m))
+	schema := &bigquery.TableSchema{}
+	assert.NoError(SetAsyncSchema("p", "d", "t", schema)(&m))
+	assert.NoError(SetAsyncRowTransform(func(r Row) (Row, error) { return r, nil })(&m))
+	assert.NoError(SetAsyncDedupKey(func(r Row) string { return r.InsertID })(&m))
+	assert.NoError(SetAsyncPreFlush(func(rows []Row) []Row { return rows })(&m))
+	assert.NoError(SetAsyncRowSizer(func(Row) int { return 1 })(&m))
+	assert.NoError(SetAsyncDropHandler(func(Row, DropReason) {})(&m))
+	assert.NoError(SetAsyncCircuitBreaker(5, time.Minute)(&m))
 
 	assert.Equal(5, m.numWorkers)
 	assert.Equal(1, m.maxRows)
+	assert.Equal(1024, m.maxBytes)
+	assert.Equal(500, m.maxRowsPerRequest)
 	assert.Empty(m.rowChan)
 	assert.Equal(1*time.Second, m.maxDelay)
+	assert.Equal(0.2, m.maxDelayJitter)
+	assert.Equal(10*time.Millisecond, m.startStagger)
 	assert.Equal(2*time.Second, m.retryInterval)
 	assert.Equal(2, m.maxRetries)
 	assert.Equal(c, m.errorChan)
+	assert.NotNil(m.errorHandler)
 	assert.True(m.ignoreUnknownValues)
 	assert.True(m.skipInvalidRows)
+	assert.True(m.retryStopped)
+	assert.True(m.retryTransientRows)
+	assert.Equal(TableOptions{SkipInvalidRows: true}, m.tableOpts[tableKey{"p", "d", "t"}])
+	assert.Equal(time.Second, m.backoffInitial)
+	assert.Equal(time.Minute, m.backoffMax)
+	assert.Equal(2.0, m.backoffMultiplier)
+	assert.Equal("http://localhost:9050/bigquery/v2/", m.endpoint)
+	assert.Equal(NopStatsHandler{}, m.stats)
+	assert.Equal(nopLogger{}, m.logger)
+	assert.Equal(tracer, m.tracer)
+	assert.NotNil(m.deadLetter)
+	assert.NotNil(m.retryable)
+	assert.Contains(m.tableRetryable, tableKey{"p", "d", "t"})
+	assert.NotNil(m.schemaRefresh)
+	assert.NotNil(m.traceID)
+	assert.NotNil(m.autoInsertID)
+	assert.Equal(3, m.maxConcurrentInserts)
+	assert.True(m.gzip)
+	assert.Equal(1024, m.gzipMinBytes)
+	assert.Equal(time.Minute, m.errorAggregation)
+	assert.Equal(100, m.maxTrackedTables)
+	assert.Equal(time.Hour, m.rowTTL)
+	assert.Equal(1024, m.maxLineSize)
+	assert.True(m.cancelInFlightOnClose)
+	assert.Equal("my-app/1.0", m.userAgent)
+	assert.True(m.replaceUserAgent)
+	assert.Equal("billing-project", m.quotaProject)
+	assert.Equal([]string{bigquery.BigqueryInsertdataScope}, m.scopes)
+	assert.True(m.verifyCredentials)
+	assert.Equal(time.Minute, m.retryDeadline)
+	assert.Equal(5*time.Second, m.insertTimeout)
+	assert.Equal(10*time.Second, m.closeGracePeriod)
+	assert.Equal(0.1, m.retryBudget.ratio)
+	assert.Equal(0.2, m.healthMaxFailureRate)
+	assert.Equal(0.8, m.healthMaxBufferUsage)
+	assert.Equal(30*time.Second, m.healthWindow)
+	assert.Equal(1<<20, m.maxBufferedBytes)
+	assert.Equal(OverflowDropOldest, m.overflowPolicy)
+	assert.Equal(PerWorkerQueue, m.dispatch)
+	assert.True(m.warmup)
+	assert.Equal(transport, m.transport)
+	assert.Equal(3*time.Second, m.dialTimeout)
+	assert.Equal(time.Minute, m.keepAlive)
+	assert.Equal(NetworkIPv6, m.networkMode)
+	assert.Equal(schema, m.schemas[tableKey{"p", "d", "t"}])
+	assert.NotNil(m.transform)
+	assert.NotNil(m.dedupKey)
+	assert.NotNil(m.dropHandler)
+	assert.Equal(5, m.breaker.threshold)
+	assert.Equal(time.Minute, m.breaker.cooldown)
 }
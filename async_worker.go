@@ -1,6 +1,11 @@
 package bqstreamer
 
-import "time"
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
 
 // AsyncWorker implements an asynchronous streamer,
 // by wrapping around SyncWorker.
@@ -14,17 +19,78 @@ type asyncWorker struct {
 	// Errors are reported to this channel.
 	errorChan chan *InsertErrors
 
+	// Used for all insert operations.
+	// Canceled when rows should be abandoned instead of inserted,
+	// see AsyncWorkerGroup.CloseContext().
+	ctx context.Context
+
 	// Max amount of rows to enqueue before executing an insert operation to BigQuery.
 	maxRows int
 
-	// Max delay between insert operations to BigQuery.
+	// Max delay before enqueued rows of a table are inserted to BigQuery,
+	// starting from the table's oldest enqueued row.
 	maxDelay time.Duration
 
+	// Fraction of maxDelay by which each delay is randomly
+	// lengthened or shortened, see delay().
+	maxDelayJitter float64
+
+	// Delays reading rows after Start() if set,
+	// staggering the start of the group's workers.
+	startDelay time.Duration
+
+	// Warms up the HTTP client before reading rows if true.
+	warmup bool
+
+	// Tables of enqueued rows, with the time they are due for insert.
+	// Lazily initialized, and cleared on every insert operation.
+	tables map[tableKey]*pendingTable
+
+	// Bounds the size of rows buffered by all workers if set.
+	// Bytes of enqueued rows, tracked in bufferedBytes,
+	// are released once they're inserted.
+	budget        *bufferBudget
+	bufferedBytes int
+
+	// Observes the row channel's depth after reading a row if set,
+	// see SetAsyncHighWaterMark().
+	observeDepth func()
+
+	// Rows buffered for longer than rowTTL are dropped instead of inserted
+	// if set, see SetAsyncRowTTL(), and reported using dropped.
+	rowTTL  time.Duration
+	dropped func(rows []Row, reason DropReason)
+
 	// Shutdown channel to stop Start() execution.
 	done chan struct{}
 
+	// Pauses inserts triggered by max rows, bytes, or delay if paused.
+	// Unused if nil.
+	pause *pauseSwitch
+
+	// Set by retire() before closing done,
+	// causing Start() to return without draining the row channel.
+	retiring bool
+
 	// Used to notify the Start() loop has stopped and returned.
 	closedChan chan struct{}
+
+	// Flush requests are received on flushChan,
+	// and flushedChan is notified once the flush has completed.
+	// Requests hold the table to flush, or nil for all tables.
+	flushChan   chan *tableKey
+	flushedChan chan struct{}
+}
+
+// pendingTable tracks a table's enqueued rows.
+type pendingTable struct {
+	// Due for insert once passed, i.e. max delay after its oldest row.
+	due time.Time
+
+	// Accumulated size in bytes of the table's rows,
+	// and their size counted against the group's buffer budget.
+	bytes         int
+	bufferedBytes int
 }
 
 // Start reads rows from rowChan and enqueues them internally.
@@ -35,48 +101,230 @@ type asyncWorker struct {
 // and is stopped via calling Close().
 func (w *asyncWorker) Start() {
 	go func(w *asyncWorker) {
+		// Wait for the start delay, unless closed in the meantime,
+		// which still inserts rows left in the row channel below.
+		if w.startDelay > 0 {
+			t := w.worker.clock.NewTimer(w.startDelay)
+			select {
+			case <-t.C():
+			case <-w.done:
+				t.Stop()
+			}
+		}
+		if w.warmup {
+			w.warmupClient()
+		}
+		w.worker.logger.Debugf("bqstreamer: worker started")
+
 		// Notify on return.
 		defer func(stopped chan<- struct{}) {
+			w.worker.logger.Debugf("bqstreamer: worker stopped")
 			close(stopped)
 		}(w.closedChan)
 
-		timer := time.NewTimer(w.maxDelay)
+		// The timer fires once the oldest table is due,
+		// or after max delay if no rows are enqueued.
+		clock := w.worker.clock
+		timer := clock.NewTimer(w.delay())
+		next := clock.Now().Add(w.delay())
+		resetTimer := func(fired bool) {
+			// Since we do not know if the timer fired yet, we need to explicitly
+			// stop it and drain the channel
+			if !fired && !timer.Stop() {
+				<-timer.C()
+			}
+			d := w.nextDelay()
+			next = clock.Now().Add(d)
+			timer.Reset(d)
+		}
+
 		for {
+			// Stop reading rows once enough have been enqueued while paused,
+			// leaving them in the row channel until resumed.
+			resumed := w.pause.wait()
+			paused := resumed != nil
+			rowChan := w.rowChan
+			if paused && len(w.worker.rows) >= w.maxRows {
+				rowChan = nil
+			}
+
 			// Perform an insert operation and reset timer
 			// when one of the following signals is triggered:
 			select {
 			case <-w.done:
 				// Worker should close.
-				w.insert()
+				// Insert any rows left in the row channel before returning,
+				// unless only this worker is stopping and others keep reading it.
+				if w.retiring {
+					w.insert("retire")
+					return
+				}
+				w.drain()
+				w.insert("close")
 				return
-			case <-timer.C:
-				// Time delay between previous insert operation
-				// has passed
-				w.insert()
-				// Reset timer
-				timer.Reset(w.maxDelay)
-			case r := <-w.rowChan:
-				// A row has been enqueued.
-				// Insert row to queue.
-				w.worker.Enqueue(r)
-
-				// Don't insert yet if not enough rows have been enqueued.
-				if len(w.worker.rows) < w.maxRows {
+			case <-timer.C():
+				// Max delay has passed for the oldest table.
+				// Keep waiting while paused, instead of spinning on due tables.
+				if paused {
+					timer.Reset(w.delay())
 					continue
 				}
-				w.insert()
-				// Reset timer
-				// Since we do not know if the timer fired yet, we need to explicitly
-				// stop it and drain the channel
-				if !timer.Stop() {
-					<-timer.C
+				w.insertDue(clock.Now())
+				resetTimer(true)
+			case k := <-w.flushChan:
+				// Flush has been requested.
+				// Insert all rows in the row channel and queue immediately,
+				// or only the requested table's rows,
+				// then notify the flush has completed.
+				w.drain()
+				if k == nil {
+					w.insert("flush")
+				} else {
+					w.insertTables("flush", func(t tableKey, _ *pendingTable) bool { return t == *k })
+				}
+				resetTimer(false)
+				w.flushedChan <- struct{}{}
+			case <-resumed:
+				// Inserts have been resumed.
+				// Insert rows enqueued while paused.
+				w.insert("resume")
+				resetTimer(false)
+			case r := <-rowChan:
+				if w.observeDepth != nil {
+					w.observeDepth()
+				}
+
+				// A row has been enqueued.
+				// Reset timer if rows have been inserted,
+				// or the row's table is due before the timer fires.
+				inserted := w.enqueue(r, paused)
+				if due, ok := w.nextDue(); inserted || (ok && due.Before(next)) {
+					resetTimer(false)
 				}
-				timer.Reset(w.maxDelay)
 			}
 		}
 	}(w)
 }
 
+// delay returns the delay until the next insert operation.
+//
+// It is maxDelay randomized by up to maxDelayJitter in either direction,
+// so workers started together don't keep inserting at the same time.
+func (w *asyncWorker) delay() time.Duration {
+	if w.maxDelayJitter == 0 {
+		return w.maxDelay
+	}
+	return time.Duration(float64(w.maxDelay) * (1 + (2*rand.Float64()-1)*w.maxDelayJitter))
+}
+
+// nextDue returns the time the oldest table is due for insert,
+// or false if no rows are enqueued.
+func (w *asyncWorker) nextDue() (time.Time, bool) {
+	var next time.Time
+	for _, t := range w.tables {
+		if next.IsZero() || t.due.Before(next) {
+			next = t.due
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// nextDelay returns the delay until the oldest table is due for insert,
+// or max delay if no rows are enqueued.
+func (w *asyncWorker) nextDelay() time.Duration {
+	next, ok := w.nextDue()
+	if !ok {
+		return w.delay()
+	}
+	if d := next.Sub(w.worker.clock.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// track adds a row of given size to its table's pending rows,
+// making the table due after max delay if it had no enqueued rows.
+func (w *asyncWorker) track(r Row, size int) {
+	buffered := 0
+	if w.budget != nil {
+		buffered = w.budget.size(r)
+		w.bufferedBytes += buffered
+	}
+
+	k := tableKey{r.ProjectID, r.DatasetID, r.TableID}
+	t, ok := w.tables[k]
+	if !ok {
+		if w.tables == nil {
+			w.tables = map[tableKey]*pendingTable{}
+		}
+		t = &pendingTable{due: w.worker.clock.Now().Add(w.delay())}
+		w.tables[k] = t
+	}
+	t.bytes += size
+	t.bufferedBytes += buffered
+}
+
+// insertDue inserts rows of all tables due for insert at given time,
+// keeping rows of other tables enqueued.
+//
+// This way rows of rarely used tables are inserted after max delay,
+// even if rows of other tables keep being enqueued.
+func (w *asyncWorker) insertDue(now time.Time) {
+	w.insertTables("max delay", func(_ tableKey, t *pendingTable) bool { return !t.due.After(now) })
+}
+
+// insertTables inserts rows of all tables matching given function,
+// keeping rows of other tables enqueued.
+//
+// reason describes what triggered the insert, the same as insert().
+func (w *asyncWorker) insertTables(reason string, match func(tableKey, *pendingTable) bool) {
+	var kept []Row
+	keptTables := map[tableKey]*pendingTable{}
+	keptBytes, keptBuffered := 0, 0
+	for k, t := range w.tables {
+		if !match(k, t) {
+			keptTables[k] = t
+			keptBytes += t.bytes
+			keptBuffered += t.bufferedBytes
+		}
+	}
+	if len(keptTables) == len(w.tables) {
+		return
+	}
+	if len(keptTables) == 0 {
+		w.insert(reason)
+		return
+	}
+
+	// Set aside rows of other tables,
+	// and enqueue them back once matching tables have been inserted.
+	// They are still counted as buffered, since reset() only counts inserted rows.
+	rows := make([]Row, 0, len(w.worker.rows))
+	enqueuedAt := make([]time.Time, 0, len(w.worker.rows))
+	var keptEnqueuedAt []time.Time
+	for i, r := range w.worker.rows {
+		if _, ok := keptTables[tableKey{r.ProjectID, r.DatasetID, r.TableID}]; ok {
+			kept = append(kept, r)
+			keptEnqueuedAt = append(keptEnqueuedAt, w.worker.enqueuedAt[i])
+		} else {
+			rows = append(rows, r)
+			enqueuedAt = append(enqueuedAt, w.worker.enqueuedAt[i])
+		}
+	}
+	w.worker.rows = rows
+	w.worker.enqueuedAt = enqueuedAt
+	w.worker.rowsBytes -= keptBytes
+	w.bufferedBytes -= keptBuffered
+
+	w.insert(reason)
+
+	w.worker.rows = append(w.worker.rows, kept...)
+	w.worker.enqueuedAt = append(w.worker.enqueuedAt, keptEnqueuedAt...)
+	w.worker.rowsBytes += keptBytes
+	w.bufferedBytes += keptBuffered
+	w.tables = keptTables
+}
+
 // Close closes the "done" channel, causing Start()'s infinite loop to stop.
 // It returns a channel, which will be closed once the Start()
 // loop has returned.
@@ -86,18 +334,153 @@ func (w *asyncWorker) Close() <-chan struct{} {
 	return w.closedChan
 }
 
+// retire is similar to Close(),
+// but only inserts rows enqueued by the worker,
+// leaving rows in the row channel to other workers.
+func (w *asyncWorker) retire() <-chan struct{} {
+	w.retiring = true
+	return w.Close()
+}
+
+// flush requests the Start() loop to insert all enqueued rows immediately,
+// or only rows of given table if not nil,
+// and blocks until the insert operation has completed.
+//
+// It returns false if the worker has been closed.
+func (w *asyncWorker) flush(k *tableKey) bool {
+	select {
+	case w.flushChan <- k:
+	case <-w.closedChan:
+		return false
+	}
+	<-w.flushedChan
+	return true
+}
+
+// enqueue enqueues given row in the internal queue,
+// and returns true if an insert operation has been executed.
+//
+// Queued rows are inserted first if the row would exceed the max bytes limit,
+// and then again if enough rows have been enqueued.
+// Rows are only enqueued without inserting if paused is true.
+func (w *asyncWorker) enqueue(r Row, paused bool) bool {
+	size := w.worker.encodedSize(r)
+	if paused {
+		w.worker.enqueue(r, size)
+		w.track(r, size)
+		return false
+	}
+
+	inserted := false
+	if w.worker.exceedsMaxBytes(size) {
+		w.insert("max bytes")
+		inserted = true
+	}
+	w.worker.enqueue(r, size)
+	w.track(r, size)
+
+	// Insert if enough rows have been enqueued.
+	if len(w.worker.rows) >= w.maxRows {
+		w.insert("max rows")
+		inserted = true
+	}
+
+	return inserted
+}
+
+// drain enqueues all rows currently in the row channel,
+// inserting whenever enough rows have been enqueued.
+//
+// Draining is bounded by the amount of rows in the channel when called,
+// so it returns even if rows keep being sent to the channel.
+func (w *asyncWorker) drain() {
+	for n := len(w.rowChan); n > 0; n-- {
+		// Leave remaining rows in the channel if they're to be abandoned.
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case r := <-w.rowChan:
+			if w.observeDepth != nil {
+				w.observeDepth()
+			}
+			w.enqueue(r, false)
+		default:
+			// Channel was drained by other workers.
+			return
+		}
+	}
+}
+
+// abandonedRows returns the amount of rows of an insert operation
+// interrupted by a canceled context, which haven't been inserted.
+//
+// Rows which have failed before the context was canceled are excluded,
+// since they aren't abandoned but failed, and are spilled if set.
+func abandonedRows(insertErrs *InsertErrors) int {
+	n := 0
+	for _, tableErrs := range insertErrs.Tables {
+		n += len(tableErrs.notInserted) - len(tableErrs.failed)
+	}
+	return n
+}
+
 // insert performs an insert operation to BigQuery
 // using the internal SyncWorker.
-func (w *asyncWorker) insert() {
+//
+// reason describes what triggered the insert, and is logged.
+//
+// Enqueued rows are abandoned instead if ctx has been canceled,
+// including rows of an insert operation interrupted by it.
+func (w *asyncWorker) insert(reason string) {
 	// No-op if no lines have been enqueued.
 	if len(w.worker.rows) == 0 {
 		return
 	}
+	w.tables = nil
 
-	insertErrs := w.worker.InsertWithRetry()
+	// Release the rows' buffered bytes once inserted or abandoned.
+	if w.budget != nil {
+		defer w.budget.release(w.bufferedBytes)
+		w.bufferedBytes = 0
+	}
 
-	// Report errors to error channel if set.
+	n := len(w.worker.rows)
+	if err := w.ctx.Err(); err != nil {
+		w.worker.logger.Warnf("bqstreamer: abandoning %d rows", n)
+		atomic.AddInt64(&w.worker.counters.abandonedRows, int64(n))
+		resolveRows(w.worker.rows, err)
+		w.worker.reset()
+		return
+	}
+
+	w.dropExpired()
+	if n = len(w.worker.rows); n == 0 {
+		w.worker.reset()
+		return
+	}
+
+	w.worker.logger.Debugf("bqstreamer: inserting %d rows, triggered by %s", n, reason)
+
+	rows := w.worker.rows
+	insertErrs := w.worker.InsertWithRetryContext(w.ctx)
+	if err := w.ctx.Err(); err != nil {
+		// Tables inserted before ctx has been canceled are done,
+		// and their rows already resolved.
+		abandoned := abandonedRows(insertErrs)
+		w.worker.logger.Warnf("bqstreamer: abandoning %d rows", abandoned)
+		atomic.AddInt64(&w.worker.counters.abandonedRows, int64(abandoned))
+		resolveRows(rows, err)
+	}
+
+	// Report errors to error channel if set, otherwise discard them,
+	// since sending to a nil channel blocks forever.
+	// Give up if rows are being abandoned, since nobody may be reading.
 	if w.errorChan != nil {
-		w.errorChan <- insertErrs
+		select {
+		case w.errorChan <- insertErrs:
+		case <-w.ctx.Done():
+		}
 	}
 }
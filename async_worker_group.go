@@ -2,44 +2,300 @@ package bqstreamer
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
+	bigquery "google.golang.org/api/bigquery/v2"
 )
 
 // AsyncWorkerGroup asynchronously streams rows to BigQuery in bulk.
 type AsyncWorkerGroup struct {
 	// Sync worker slice.
-	workers []*asyncWorker
+	//
+	// workersMu guards workers and started,
+	// since workers may be added or removed by SetNumWorkers().
+	workersMu sync.Mutex
+	workers   []*asyncWorker
+	started   bool
+
+	// Tracks workers removed by SetNumWorkers() which haven't stopped yet,
+	// so Close() can wait for them as well.
+	retiring sync.WaitGroup
+
+	// Used for initializing workers added by SetNumWorkers().
+	newHTTPClient func() *http.Client
+	syncOptions   []SyncOptionFunc
+
+	// Authenticates workers if set, requesting given OAuth2 scopes.
+	jwtConfig *jwt.Config
+	scopes    []string
+
+	// Used by all workers as is if set,
+	// see NewAsyncWorkerGroupWithClient().
+	client *http.Client
 
 	// Channel for sending rows to background Workers.
 	rowChan chan Row
 
+	// Routes rows with the same key to the same worker if set,
+	// using a row channel per worker instead of rowChan.
+	shardKey   func(Row) string
+	shardChans []chan Row
+
+	// Uses a row channel per worker as well if set to PerWorkerQueue.
+	dispatch DispatchStrategy
+
+	// Bounds the size of all buffered rows if max buffered bytes is set.
+	maxBufferedBytes int
+	overflowPolicy   OverflowPolicy
+	budget           *bufferBudget
+
 	// Insert errors are reported to this channel.
 	errorChan chan *InsertErrors
 
+	// Reads errorChan instead of the user if set.
+	// handled is closed once all insert errors have been handled.
+	errorHandler func(*InsertErrors)
+	handled      chan struct{}
+
+	// Coalesces insert errors over this window before reporting them if set.
+	errorAggregation time.Duration
+
+	// Closed by Close(), causing blocked enqueue calls to return.
+	closed chan struct{}
+
+	// Set by Close(), after which no more rows are accepted.
+	//
+	// mu guards isClosed, and enqueueing tracks enqueue calls in progress,
+	// so Close() can wait for them before closing the workers.
+	mu         sync.RWMutex
+	isClosed   bool
+	enqueueing sync.WaitGroup
+
+	// Set by Close() once all workers have stopped,
+	// after which the AsyncWorkerGroup can be restarted.
+	// Guarded by mu, and done is closed along with it.
+	stopped bool
+	done    chan struct{}
+
+	// Bounds closing on StartContext()'s context cancellation if set.
+	closeGracePeriod time.Duration
+
+	// Bounds Close() by closeGracePeriod as well if true,
+	// canceling insert operations in progress once it has passed.
+	cancelInFlightOnClose bool
+
+	// Verify credentials when constructing the group.
+	verifyCredentials bool
+
+	// Passed to all workers for insert operations.
+	// Canceled by CloseContext() once its context is done,
+	// abandoning remaining rows.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Maintained by all workers for Stats().
+	counters *workerCounters
+
+	// Latencies of workers removed by SetNumWorkers(),
+	// since every worker maintains its own.
+	retiredLatency latencyHistogram
+
+	// Pauses inserts of all workers, see Pause().
+	pause *pauseSwitch
+
 	// Amount of background workers to use.
 	numWorkers int
 
 	// Max amount of rows to queue before flushing to BigQuery.
 	maxRows int
 
+	// Max accumulated size in bytes of queued rows before flushing to BigQuery.
+	// A zero value means no limit.
+	maxBytes int
+
+	// Max amount of rows per insert request if set.
+	maxRowsPerRequest int
+
 	// Max delay between insert operations to BigQuery.
 	maxDelay time.Duration
 
+	// Randomizes maxDelay per worker if set.
+	maxDelayJitter float64
+
+	// Delays the start of every worker by an incremental offset if set.
+	startStagger time.Duration
+
+	// Warms up every worker's HTTP client on start if true.
+	warmup bool
+
 	// Maximum insert operation retries for non-rejected rows,
 	// e.g. GoogleAPI HTTP errors, generic HTTP errors, etc.
 	maxRetries int
 
+	// Bounds the total time spent retrying an insert operation if set.
+	retryDeadline time.Duration
+
 	// Sleep delay after a rejected insert,
 	// before retrying an insert operation.
 	retryInterval time.Duration
 
+	// Exponential backoff between retry insert attempts,
+	// used instead of retryInterval if backoffInitial is set.
+	backoffInitial    time.Duration
+	backoffMax        time.Duration
+	backoffMultiplier float64
+
+	// Overrides the BigQuery API base URL if set.
+	endpoint string
+
+	// Connect to BigQuery using IPv4 only.
+	// Implies NetworkIPv4.
+	ipv4Only bool
+
+	// IP version used for connecting to BigQuery.
+	networkMode NetworkMode
+
+	// Enables or disables HTTP/2 if set.
+	// HTTP/2 is attempted by default, same as http.DefaultTransport.
+	forceHTTP2 *bool
+
+	// Replaces the base transport of workers' OAuth2 clients if set.
+	transport http.RoundTripper
+
+	// Used by all workers for connecting to BigQuery,
+	// unless a custom transport has been set.
+	dialer      *net.Dialer
+	dialTimeout time.Duration
+	keepAlive   time.Duration
+
+	// Receives insert related events from all workers if set.
+	stats StatsHandler
+
+	// Logs lifecycle and insert related events of all workers if set.
+	logger Logger
+
+	// Traces every insert request of all workers if set.
+	tracer InsertTracer
+
+	// Called for every row rejected by BigQuery if set.
+	deadLetter func(row Row, err error)
+
+	// Receives rows of failed insert operations if set.
+	spill func(rows []Row) error
+
+	// Called for every row which couldn't be enqueued if set.
+	dropHandler func(row Row, reason DropReason)
+
+	// Receives per table insert results of every insert operation if set.
+	flushCallback func(inserted, rejected int, table string)
+
+	// Overrides IsRetryable() for all workers if set.
+	retryable func(err error) bool
+
+	// Overrides retryable for inserts to particular tables of all workers.
+	tableRetryable map[tableKey]func(err error) bool
+
+	// Refreshes the schema of tables rejecting rows due to unknown fields
+	// if set.
+	schemaRefresh func(table string) error
+
+	// Returns the trace ID of insert requests of all workers if set.
+	traceID func(rows []Row) string
+
+	// Derives the insert ID of enqueued rows without one if set.
+	autoInsertID func(row Row) string
+
+	// Called before every retry of all workers if set.
+	retryCallback func(attempt int, err error, nextDelay time.Duration)
+
+	// Max amount of concurrent insert requests across all workers.
+	// A zero value means no limit.
+	maxConcurrentInserts int
+
+	// Max accumulated size in bytes of in-flight insert requests
+	// across all workers. A zero value means no limit.
+	maxInFlightBytes int
+	inFlight         *inFlightLimit
+
+	// Max duration of a single insert request of all workers.
+	// A zero value means no limit.
+	insertTimeout time.Duration
+
+	// Compress insert request bodies of all workers using gzip,
+	// if larger than gzipMinBytes.
+	gzip         bool
+	gzipMinBytes int
+
+	// Custom User-Agent header of all workers' insert requests if set.
+	userAgent        string
+	replaceUserAgent bool
+	quotaProject     string
+
+	// Rows of these tables are validated against their schema before insert.
+	schemas map[tableKey]*bigquery.TableSchema
+
+	// Transforms every row before insert if set.
+	transform func(Row) (Row, error)
+
+	// Collapses rows with the same key before insert if set.
+	dedupKey func(Row) string
+
+	// Replaces the rows of every insert request right before sending it if set.
+	preFlush func([]Row) []Row
+
+	// Per table insert counters of all workers if set,
+	// tracking up to maxTrackedTables tables.
+	maxTrackedTables int
+	tableStats       *tableStats
+
+	// Rows buffered for longer are dropped instead of inserted if set.
+	rowTTL time.Duration
+
+	// Max size of a line read by EnqueueNDJSON().
+	maxLineSize int
+
+	// Times max delay and retry backoff of all workers if set,
+	// see setAsyncClock().
+	clock clock
+
+	// Signals the row channel's depth crossing water marks if set.
+	highWaterMark, lowWaterMark float64
+	onHighWaterMark             func(depth, capacity int)
+	onLowWaterMark              func(depth, capacity int)
+	waterMark                   *waterMark
+
+	// Rows hold the context they've been enqueued with if true,
+	// which is used for inserting them.
+	propagateContext bool
+
+	// Estimates enqueued rows' size if set.
+	sizer func(Row) int
+
+	// Short-circuits insert requests of all workers
+	// after too many consecutive failures if set.
+	breaker *circuitBreaker
+
+	// Limits retries of all workers to a fraction of successful
+	// insert requests if set.
+	retryBudget *retryBudget
+
+	// Thresholds above which Healthy() reports the group as unhealthy,
+	// and the window of recent insert requests counted by all workers.
+	healthMaxFailureRate float64
+	healthMaxBufferUsage float64
+	healthWindow         time.Duration
+	health               *healthWindow
+
 	// Accept rows that contain values that do not match the schema.
 	// The unknown values are ignored.
 	// Default is false, which treats unknown values as errors.
@@ -49,12 +305,15 @@ type AsyncWorkerGroup struct {
 	// The default value is false, which causes the entire request
 	// to fail if any invalid rows exist.
 	skipInvalidRows bool
-}
 
-var dialer = &net.Dialer{}
+	// Override ignoreUnknownValues and skipInvalidRows for these tables.
+	tableOpts map[tableKey]TableOptions
 
-func connectIPv4Only(ctx context.Context, network, addr string) (net.Conn, error) {
-	return dialer.DialContext(ctx, "tcp4", addr)
+	// Retry rows rejected with the "stopped" reason.
+	retryStopped bool
+
+	// Retry rows rejected due to transient errors.
+	retryTransientRows bool
 }
 
 // New returns a new AsyncWorkerGroup using given OAuth2/JWT configuration.
@@ -63,18 +322,149 @@ func NewAsyncWorkerGroup(jwtConfig *jwt.Config, ipv4Only bool, options ...AsyncO
 		return nil, errors.New("jwt.Config is nil")
 	}
 
-	// Create a new Streamer, with OAuth2/JWT http.Client constructor function.
+	// The OAuth2/JWT http.Client constructor function is created once
+	// all options have been applied, since it depends on the scopes.
+	defaults := []AsyncOptionFunc{setAsyncIPv4Only(ipv4Only), setAsyncJWTConfig(jwtConfig)}
+	return newAsyncWorkerGroup(nil, append(defaults, options...)...)
+}
+
+// NewAsyncWorkerGroupFromJSON returns a new AsyncWorkerGroup
+// authenticating using given service account JSON key,
+// the same as NewAsyncWorkerGroup() using NewJWTConfig(),
+// for keys which aren't read from a file.
+//
+// It requests the minimal scope required for streaming inserts,
+// bigquery.BigqueryInsertdataScope. Use SetAsyncScopes() for other scopes.
+func NewAsyncWorkerGroupFromJSON(keyJSON []byte, ipv4Only bool, options ...AsyncOptionFunc) (*AsyncWorkerGroup, error) {
+	if len(keyJSON) == 0 {
+		return nil, errors.New("JSON key is empty")
+	}
+	jwtConfig, err := google.JWTConfigFromJSON(keyJSON, bigquery.BigqueryInsertdataScope)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON key: %w", err)
+	}
+	return NewAsyncWorkerGroup(jwtConfig, ipv4Only, options...)
+}
+
+// jwtScopes returns the OAuth2 scopes requested using the JWT configuration:
+// The scopes set using SetAsyncScopes() if any,
+// otherwise the configuration's scopes,
+// defaulting to the minimal scope required for streaming inserts.
+func (s *AsyncWorkerGroup) jwtScopes() []string {
+	switch {
+	case len(s.scopes) > 0:
+		return s.scopes
+	case len(s.jwtConfig.Scopes) > 0:
+		return s.jwtConfig.Scopes
+	default:
+		return []string{bigquery.BigqueryInsertdataScope}
+	}
+}
+
+// newJWTClient returns an OAuth2/JWT http.Client constructor function.
+//
+// All workers share a single token source,
+// so the token is cached and refreshed once for all of them.
+func (s *AsyncWorkerGroup) newJWTClient() func() *http.Client {
+	// Copy the configuration, so the given one isn't modified.
+	c := *s.jwtConfig
+	c.Scopes = s.jwtScopes()
+	ts := c.TokenSource(oauth2.NoContext)
+	return func() *http.Client {
+		return oauth2.NewClient(oauth2.NoContext, ts)
+	}
+}
+
+// NewAsyncWorkerGroupWithTokenSource returns a new AsyncWorkerGroup
+// authenticating using given OAuth2 token source.
+//
+// This allows using Application Default Credentials instead of a JWT key,
+// e.g. on GCE, GKE, or Cloud Run:
+//
+//  ts, err := google.DefaultTokenSource(ctx, bigquery.BigqueryInsertdataScope)
+//  g, err := NewAsyncWorkerGroupWithTokenSource(ts, false)
+//
+// The token source is shared by all workers,
+// and tokens are cached until they expire.
+func NewAsyncWorkerGroupWithTokenSource(ts oauth2.TokenSource, ipv4Only bool, options ...AsyncOptionFunc) (*AsyncWorkerGroup, error) {
+	if ts == nil {
+		return nil, errors.New("oauth2.TokenSource is nil")
+	}
+
+	// Wrap once, otherwise every worker's client caches tokens separately,
+	// refreshing them all at once when they expire.
+	ts = oauth2.ReuseTokenSource(nil, ts)
+
 	newHTTPClient := func() *http.Client {
-		c := jwtConfig.Client(oauth2.NoContext)
-		if ipv4Only {
-			c.Transport.(*oauth2.Transport).Base = &http.Transport{
-				DialContext:         connectIPv4Only,
-				TLSHandshakeTimeout: 2 * time.Second,
-			}
+		return oauth2.NewClient(oauth2.NoContext, ts)
+	}
+	return newAsyncWorkerGroup(newHTTPClient, append([]AsyncOptionFunc{setAsyncIPv4Only(ipv4Only)}, options...)...)
+}
+
+// NewAsyncWorkerGroupWithClient returns a new AsyncWorkerGroup
+// sending all requests using given http.Client, shared by all workers,
+// e.g. for custom authentication, mTLS, or routing through a service mesh.
+//
+// The client is used as is, so the caller is responsible for authenticating
+// its requests, usually by wrapping its transport with an OAuth2 transport.
+// Options configuring the client's transport, i.e. SetAsyncTransport(),
+// SetAsyncNetworkMode(), SetAsyncForceHTTP2() and SetAsyncScopes(),
+// can't be used with it, and dial timeouts and keep-alives are ignored.
+func NewAsyncWorkerGroupWithClient(client *http.Client, options ...AsyncOptionFunc) (*AsyncWorkerGroup, error) {
+	if client == nil {
+		return nil, errors.New("http.Client is nil")
+	}
+	return newAsyncWorkerGroup(nil, append([]AsyncOptionFunc{setAsyncClient(client)}, options...)...)
+}
+
+// setBaseTransport replaces given OAuth2 client's base transport
+// according to configuration, keeping the OAuth2 wrapping.
+//
+// Clients not using OAuth2, e.g. no-op clients for unit tests,
+// are left as is.
+func (s *AsyncWorkerGroup) setBaseTransport(c *http.Client) {
+	t, ok := c.Transport.(*oauth2.Transport)
+	if !ok {
+		return
+	}
+
+	if s.transport != nil {
+		t.Base = s.transport
+		return
+	}
+
+	network := s.networkMode.network()
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return s.dialer.DialContext(ctx, network, addr)
+	}
+	if s.ipv4Only {
+		base.TLSHandshakeTimeout = 2 * time.Second
+	}
+	if s.forceHTTP2 != nil {
+		base.ForceAttemptHTTP2 = *s.forceHTTP2
+		if !*s.forceHTTP2 {
+			// A non-nil empty map disables HTTP/2 altogether.
+			base.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
 		}
-		return c
 	}
-	return newAsyncWorkerGroup(newHTTPClient, options...)
+	t.Base = base
+}
+
+// verifyCredentials fetches an OAuth2 token using given client's token source,
+// returning a CredentialsError if it fails.
+//
+// Clients not using OAuth2, e.g. no-op clients for unit tests,
+// have no credentials to verify.
+func verifyCredentials(c *http.Client) error {
+	t, ok := c.Transport.(*oauth2.Transport)
+	if !ok || t.Source == nil {
+		return nil
+	}
+	if _, err := t.Source.Token(); err != nil {
+		return &CredentialsError{Err: err}
+	}
+	return nil
 }
 
 // newAsyncWorkerGroup returns a new AsyncWorkerGroup.
@@ -82,7 +472,15 @@ func NewAsyncWorkerGroup(jwtConfig *jwt.Config, ipv4Only bool, options ...AsyncO
 // It recieves an http.Client constructor, which is used to return an
 // authenticated OAuth2/JWT client, or a no-op client for unit tests.
 func newAsyncWorkerGroup(newHTTPClient func() *http.Client, options ...AsyncOptionFunc) (*AsyncWorkerGroup, error) {
-	m := AsyncWorkerGroup{}
+	m := AsyncWorkerGroup{
+		dialTimeout: DefaultAsyncDialTimeout,
+		keepAlive:   DefaultAsyncKeepAlive,
+		maxLineSize: DefaultAsyncMaxLineSize,
+
+		healthMaxFailureRate: DefaultAsyncHealthMaxFailureRate,
+		healthMaxBufferUsage: DefaultAsyncHealthMaxBufferUsage,
+		healthWindow:         DefaultAsyncHealthWindow,
+	}
 
 	// Override configuration defaults with options if given.
 	for _, option := range options {
@@ -90,43 +488,300 @@ func newAsyncWorkerGroup(newHTTPClient func() *http.Client, options ...AsyncOpti
 			return nil, err
 		}
 	}
-	m.rowChan = make(chan Row, m.maxRows*m.numWorkers)
+	if m.client != nil {
+		if m.transport != nil || m.networkMode != NetworkAuto || m.forceHTTP2 != nil || m.scopes != nil {
+			return nil, errors.New("transport options can't be used with a custom client")
+		}
+		client := m.client
+		newHTTPClient = func() *http.Client { return client }
+	} else if m.jwtConfig != nil {
+		newHTTPClient = m.newJWTClient()
+	} else if m.scopes != nil {
+		return nil, errors.New("scopes can't be used with a token source")
+	}
+	if m.ipv4Only && m.transport != nil {
+		return nil, errors.New("ipv4Only can't be used with a custom transport")
+	}
+	if m.networkMode != NetworkAuto && m.transport != nil {
+		return nil, errors.New("network mode can't be used with a custom transport")
+	}
+	if m.forceHTTP2 != nil && m.transport != nil {
+		return nil, errors.New("HTTP/2 selection can't be used with a custom transport")
+	}
+	if m.ipv4Only {
+		if m.networkMode == NetworkIPv6 {
+			return nil, errors.New("ipv4Only can't be used with NetworkIPv6")
+		}
+		m.networkMode = NetworkIPv4
+	}
+	if m.errorHandler != nil && m.errorChan != nil {
+		return nil, errors.New("error handler can't be used with an error channel")
+	}
+	if m.cancelInFlightOnClose && m.closeGracePeriod == 0 {
+		return nil, errors.New("canceling in-flight inserts on close requires a close grace period")
+	}
+	if m.onLowWaterMark != nil && m.onHighWaterMark == nil {
+		return nil, errors.New("low water mark can't be used without a high water mark")
+	}
+	if m.onLowWaterMark != nil && m.lowWaterMark >= m.highWaterMark {
+		return nil, errors.New("low water mark must be below the high water mark")
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	// Aggregated errors are reported to the error channel
+	// by an error handler reading the workers' own error channel.
+	if m.errorAggregation > 0 && m.errorChan != nil {
+		errorChan := m.errorChan
+		m.errorHandler = func(insertErrs *InsertErrors) { errorChan <- insertErrs }
+		m.errorChan = nil
+	}
+	m.dialer = &net.Dialer{
+		Timeout:   m.dialTimeout,
+		KeepAlive: m.keepAlive,
+	}
+	m.newHTTPClient = func() *http.Client {
+		c := newHTTPClient()
+		if m.client == nil {
+			m.setBaseTransport(c)
+		}
+		return c
+	}
+	if m.verifyCredentials {
+		if err := verifyCredentials(m.newHTTPClient()); err != nil {
+			return nil, err
+		}
+	}
+	if m.perWorkerQueues() {
+		m.shardChans = m.newShardChans()
+	} else {
+		m.rowChan = make(chan Row, m.maxRows*m.numWorkers)
+	}
+	m.closed = make(chan struct{})
+	m.done = make(chan struct{})
+	if m.maxBufferedBytes > 0 {
+		m.budget = newBufferBudget(m.maxBufferedBytes, m.overflowPolicy, m.bufferedSize)
+		m.budget.onDrop = func(row Row) { m.dropped([]Row{row}, DropOldest) }
+	}
+	if m.onHighWaterMark != nil {
+		m.waterMark = &waterMark{
+			high:   m.highWaterMark,
+			low:    m.highWaterMark / 2,
+			onHigh: m.onHighWaterMark,
+			onLow:  m.onLowWaterMark,
+		}
+		if m.onLowWaterMark != nil {
+			m.waterMark.low = m.lowWaterMark
+		}
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.counters = &workerCounters{}
+	if m.maxTrackedTables > 0 {
+		m.tableStats = newTableStats(m.maxTrackedTables)
+	}
+	m.health = newHealthWindow(m.healthWindow)
+	m.pause = &pauseSwitch{}
+	if m.errorHandler != nil {
+		m.errorChan = make(chan *InsertErrors, m.numWorkers)
+		m.handled = make(chan struct{})
+		go m.handleErrors()
+	}
 	m.workers = make([]*asyncWorker, m.numWorkers)
 
 	// Initialize workers and assign them a common row and error channel.
 	//
 	// NOTE AsyncWorkerGroup row length is set as following to avoid filling up
 	// in case workers get delayed with insert retries.
+	syncOptions := []SyncOptionFunc{
+		SetSyncMaxRetries(m.maxRetries),
+		SetSyncRetryInterval(m.retryInterval),
+		SetSyncIgnoreUnknownValues(m.ignoreUnknownValues),
+		SetSyncSkipInvalidRows(m.skipInvalidRows),
+		SetSyncRetryStopped(m.retryStopped),
+		SetSyncRetryTransientRows(m.retryTransientRows),
+		setSyncCounters(m.counters),
+		setSyncHealthWindow(m.health),
+	}
+	if m.retryDeadline > 0 {
+		syncOptions = append(syncOptions, SetSyncRetryDeadline(m.retryDeadline))
+	}
+	if m.insertTimeout > 0 {
+		syncOptions = append(syncOptions, SetSyncInsertTimeout(m.insertTimeout))
+	}
+	if m.gzip {
+		syncOptions = append(syncOptions, SetSyncGzip(true), SetSyncGzipMinBytes(m.gzipMinBytes))
+	}
+	if m.clock != nil {
+		syncOptions = append(syncOptions, setSyncClock(m.clock))
+	}
+	if m.tableStats != nil {
+		syncOptions = append(syncOptions, setSyncTableStats(m.tableStats))
+	}
+	if m.userAgent != "" {
+		syncOptions = append(syncOptions, SetSyncUserAgent(m.userAgent, m.replaceUserAgent))
+	}
+	if m.quotaProject != "" {
+		syncOptions = append(syncOptions, SetSyncQuotaProject(m.quotaProject))
+	}
+	if m.breaker != nil {
+		syncOptions = append(syncOptions, setSyncCircuitBreaker(m.breaker))
+	}
+	if m.retryBudget != nil {
+		syncOptions = append(syncOptions, setSyncRetryBudget(m.retryBudget))
+	}
+	for k, schema := range m.schemas {
+		syncOptions = append(syncOptions, SetSyncSchema(k.projectID, k.datasetID, k.tableID, schema))
+	}
+	for k, opts := range m.tableOpts {
+		syncOptions = append(syncOptions, SetSyncTableOptions(k.projectID, k.datasetID, k.tableID, opts))
+	}
+	for k, f := range m.tableRetryable {
+		syncOptions = append(syncOptions, SetSyncTableRetryable(k.projectID, k.datasetID, k.tableID, f))
+	}
+	if m.schemaRefresh != nil {
+		syncOptions = append(syncOptions, SetSyncSchemaRefresh(m.schemaRefresh))
+	}
+	if m.traceID != nil {
+		syncOptions = append(syncOptions, SetSyncTraceID(m.traceID))
+	}
+	if m.propagateContext {
+		syncOptions = append(syncOptions, setSyncDropped(m.dropped))
+	}
+	if m.transform != nil {
+		syncOptions = append(syncOptions, SetSyncRowTransform(m.transform))
+	}
+	if m.dedupKey != nil {
+		syncOptions = append(syncOptions, SetSyncDedupKey(m.dedupKey))
+	}
+	if m.preFlush != nil {
+		syncOptions = append(syncOptions, SetSyncPreFlush(m.preFlush))
+	}
+	if m.sizer != nil {
+		syncOptions = append(syncOptions, SetSyncRowSizer(m.sizer))
+	}
+	if m.maxBytes > 0 {
+		syncOptions = append(syncOptions, SetSyncMaxBytes(m.maxBytes))
+	}
+	if m.maxRowsPerRequest > 0 {
+		syncOptions = append(syncOptions, SetSyncMaxRowsPerRequest(m.maxRowsPerRequest))
+	}
+	if m.endpoint != "" {
+		syncOptions = append(syncOptions, SetSyncEndpoint(m.endpoint))
+	}
+	if m.stats != nil {
+		syncOptions = append(syncOptions, SetSyncStatsHandler(m.stats))
+	}
+	if m.logger != nil {
+		syncOptions = append(syncOptions, SetSyncLogger(m.logger))
+	}
+	if m.tracer != nil {
+		syncOptions = append(syncOptions, SetSyncInsertTracer(m.tracer))
+	}
+	if m.deadLetter != nil {
+		syncOptions = append(syncOptions, SetSyncDeadLetterHandler(m.deadLetter))
+	}
+	if m.spill != nil {
+		syncOptions = append(syncOptions, SetSyncSpillHandler(m.spill))
+	}
+	if m.flushCallback != nil {
+		syncOptions = append(syncOptions, SetSyncFlushCallback(m.flushCallback))
+	}
+	if m.retryable != nil {
+		syncOptions = append(syncOptions, SetSyncRetryableFunc(m.retryable))
+	}
+	if m.retryCallback != nil {
+		syncOptions = append(syncOptions, SetSyncRetryCallback(m.retryCallback))
+	}
+	if m.backoffInitial > 0 {
+		syncOptions = append(syncOptions, SetSyncRetryBackoff(m.backoffInitial, m.backoffMax, m.backoffMultiplier))
+	}
+
+	// Share a single semaphore among all workers for bounding concurrent
+	// insert requests.
+	if m.maxConcurrentInserts > 0 {
+		syncOptions = append(syncOptions, setSyncInsertSemaphore(make(chan struct{}, m.maxConcurrentInserts)))
+	}
+	if m.maxInFlightBytes > 0 {
+		m.inFlight = newInFlightLimit(m.maxInFlightBytes)
+		syncOptions = append(syncOptions, setSyncInFlightLimit(m.inFlight))
+	}
+
+	m.syncOptions = syncOptions
+
 	for i := 0; i < m.numWorkers; i++ {
-		syncWorker, err := NewSyncWorker(
-			newHTTPClient(),
-			SetSyncMaxRetries(m.maxRetries),
-			SetSyncRetryInterval(m.retryInterval),
-			SetSyncIgnoreUnknownValues(m.ignoreUnknownValues),
-			SetSyncSkipInvalidRows(m.skipInvalidRows),
-		)
+		w, err := m.newWorker(m.workerRowChan(i))
 		if err != nil {
 			return nil, err
 		}
+		m.workers[i] = w
+	}
 
-		m.workers[i] = &asyncWorker{
-			worker: syncWorker,
+	return &m, nil
+}
 
-			rowChan:   m.rowChan,
-			errorChan: m.errorChan,
+// validate returns an error if the configuration is invalid,
+// e.g. due to a required option which hasn't been given.
+//
+// Otherwise an invalid configuration could cause a deadlock,
+// e.g. a zero max rows means a zero row channel capacity.
+func (s *AsyncWorkerGroup) validate() error {
+	switch {
+	case s.numWorkers < 1:
+		return errors.New("number of workers must be set to a positive int using SetAsyncNumWorkers()")
+	case s.maxRows < 1:
+		return errors.New("max rows must be set to a positive int using SetAsyncMaxRows()")
+	case s.maxDelay <= 0:
+		return errors.New("max delay must be set to a positive time.Duration using SetAsyncMaxDelay()")
+	case s.maxRetries < 0:
+		return errors.New("max retries must be a non-negative int")
+	}
+	return nil
+}
 
-			maxRows:  m.maxRows,
-			maxDelay: m.maxDelay,
+// newWorker returns a new worker reading given row channel,
+// sharing the error channel with all other workers.
+func (s *AsyncWorkerGroup) newWorker(rowChan chan Row) (*asyncWorker, error) {
+	syncWorker, err := NewSyncWorker(s.newHTTPClient(), s.syncOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return s.newAsyncWorker(syncWorker, rowChan), nil
+}
 
-			done:       make(chan struct{}),
-			closedChan: make(chan struct{}),
-		}
-		if err != nil {
-			return nil, err
-		}
+// newAsyncWorker returns a new worker wrapping given SyncWorker,
+// reading given row channel.
+func (s *AsyncWorkerGroup) newAsyncWorker(syncWorker *SyncWorker, rowChan chan Row) *asyncWorker {
+	var observeDepth func()
+	if s.waterMark != nil {
+		dispatchChans := s.dispatchChans()
+		observeDepth = func() { s.observeDepth(rowChan, dispatchChans) }
 	}
 
-	return &m, nil
+	return &asyncWorker{
+		worker: syncWorker,
+
+		rowChan:   rowChan,
+		errorChan: s.errorChan,
+
+		ctx:    s.ctx,
+		pause:  s.pause,
+		budget: s.budget,
+
+		maxRows:        s.maxRows,
+		maxDelay:       s.maxDelay,
+		maxDelayJitter: s.maxDelayJitter,
+		warmup:         s.warmup,
+		observeDepth:   observeDepth,
+
+		rowTTL:  s.rowTTL,
+		dropped: s.dropped,
+
+		done:       make(chan struct{}),
+		closedChan: make(chan struct{}),
+
+		flushChan:   make(chan *tableKey),
+		flushedChan: make(chan struct{}),
+	}
 }
 
 // Start starts all background workers.
@@ -138,20 +793,220 @@ func newAsyncWorkerGroup(newHTTPClient func() *http.Client, options ...AsyncOpti
 //
 // Insert errors will be reported to the error channel if set.
 func (s *AsyncWorkerGroup) Start() {
-	for _, w := range s.workers {
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+
+	s.started = true
+	for i, w := range s.workers {
+		w.startDelay = time.Duration(i) * s.startStagger
 		w.Start()
 	}
 }
 
-// Close inserts any remaining rows enqueue by all workers,
-// then closes them.
+// StartContext is similar to Start(),
+// but also closes the AsyncWorkerGroup once ctx is done,
+// e.g. when the service using it shuts down.
+//
+// Closing is bounded by the grace period set using
+// SetAsyncCloseGracePeriod() if set, as if CloseContext() was called.
+// Rows left once it has passed are abandoned, and logged as such.
+// Use Done() for waiting until the group has closed.
+func (s *AsyncWorkerGroup) StartContext(ctx context.Context) {
+	s.Start()
+
+	s.mu.RLock()
+	closed := s.closed
+	s.mu.RUnlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-closed:
+			// Closed by the user instead.
+			return
+		}
+
+		closeCtx := context.Background()
+		if s.closeGracePeriod > 0 {
+			var cancel context.CancelFunc
+			closeCtx, cancel = context.WithTimeout(closeCtx, s.closeGracePeriod)
+			defer cancel()
+		}
+		if err := s.CloseContext(closeCtx); err != nil && s.logger != nil {
+			s.logger.Errorf("bqstreamer: closing after context done: %v", err)
+		}
+	}()
+}
+
+// SetNumWorkers changes the amount of background workers at runtime.
+//
+// Additional workers share the existing row and error channels,
+// and are started if Start() has already been called.
+// Surplus workers insert their enqueued rows before being stopped,
+// and SetNumWorkers() blocks until they have.
+//
+// NOTE the row channel's capacity is set on initialization
+// according to SetAsyncNumWorkers(), and isn't changed.
+//
+// It returns ErrGroupClosed if the AsyncWorkerGroup has been closed.
+func (s *AsyncWorkerGroup) SetNumWorkers(n int) error {
+	if n <= 0 {
+		return errors.New("number of workers must be a positive int")
+	}
+	if s.shardKey != nil {
+		return errors.New("number of workers can't be changed when using a shard key")
+	}
+	if s.dispatch == PerWorkerQueue {
+		return errors.New("number of workers can't be changed when using per-worker queues")
+	}
+
+	s.workersMu.Lock()
+
+	s.mu.RLock()
+	isClosed := s.isClosed
+	s.mu.RUnlock()
+	if isClosed {
+		s.workersMu.Unlock()
+		return ErrGroupClosed
+	}
+
+	for len(s.workers) < n {
+		w, err := s.newWorker(s.rowChan)
+		if err != nil {
+			s.workersMu.Unlock()
+			return err
+		}
+		if s.started {
+			w.Start()
+		}
+		s.workers = append(s.workers, w)
+	}
+
+	// Stop surplus workers outside the lock,
+	// since inserting their rows may take a while.
+	var wg sync.WaitGroup
+	if len(s.workers) > n {
+		if s.started {
+			for _, w := range s.workers[n:] {
+				wg.Add(1)
+				s.retiring.Add(1)
+				go func(w *asyncWorker) {
+					defer wg.Done()
+					defer s.retiring.Done()
+					<-w.retire()
+					s.retiredLatency.add(w.worker.latency.snapshot())
+				}(w)
+			}
+		}
+		s.workers = s.workers[:n:n]
+	}
+	s.numWorkers = n
+	s.workersMu.Unlock()
+
+	wg.Wait()
+	return nil
+}
+
+// Close stops accepting new rows,
+// inserts any remaining rows enqueued by all workers, then closes them.
 //
-// NOTE that the AsyncWorkerGroup cannot be restarted.
-// If you wish to perform any additional inserts to BigQuery,
-// a new one must be initialized.
+// Rows already in the row channel are drained and inserted as well.
+// Calling Close() more than once is a no-op.
+//
+// NOTE that the AsyncWorkerGroup can be reused after Close() has returned
+// by calling Restart().
+//
+// NOTE Close() blocks until all rows have been inserted,
+// which may take long if BigQuery is unreachable.
+// See CloseContext() or SetAsyncCancelInFlightOnClose()
+// for bounding the time spent waiting.
 func (s *AsyncWorkerGroup) Close() {
+	s.close()
+}
+
+// close implements Close(), bounded by the close grace period
+// if SetAsyncCancelInFlightOnClose() has been set.
+//
+// It returns the *UndrainedRowsError returned by CloseContext(), if any,
+// after logging it.
+func (s *AsyncWorkerGroup) close() error {
+	ctx := context.Background()
+	if s.cancelInFlightOnClose {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.closeGracePeriod)
+		defer cancel()
+	}
+	err := s.CloseContext(ctx)
+	if err != nil && s.logger != nil {
+		s.logger.Errorf("bqstreamer: closing after grace period: %v", err)
+	}
+	return err
+}
+
+// CloseWithSummary is similar to Close(),
+// but returns a summary of rows inserted and lost while closing,
+// e.g. for confirming a batch pipeline has shut down cleanly.
+//
+// The summary counts all insert operations completed from the moment
+// CloseWithSummary() is called until all workers have closed,
+// including ones which were already in progress.
+// Calling CloseWithSummary() on a closed AsyncWorkerGroup
+// returns an empty summary.
+func (s *AsyncWorkerGroup) CloseWithSummary() CloseSummary {
+	s.mu.RLock()
+	isClosed := s.isClosed
+	s.mu.RUnlock()
+	if isClosed {
+		return CloseSummary{}
+	}
+
+	before := s.counters.stats(0)
+	err := s.close()
+	after := s.counters.stats(0)
+
+	summary := CloseSummary{
+		FlushedRows:   after.InsertedRows - before.InsertedRows,
+		RejectedRows:  after.RejectedRows - before.RejectedRows,
+		FailedInserts: after.FailedInserts - before.FailedInserts,
+	}
+	var undrainedErr *UndrainedRowsError
+	if errors.As(err, &undrainedErr) {
+		summary.UndrainedRows = int64(undrainedErr.Rows)
+	}
+	return summary
+}
+
+// CloseContext is similar to Close(),
+// but abandons remaining rows if ctx is done before all workers have drained.
+// Insert operations in progress are interrupted.
+//
+// It returns an *UndrainedRowsError wrapping ctx.Err() in that case,
+// reporting how many rows were abandoned.
+// Calling CloseContext() on a closed AsyncWorkerGroup is a no-op returning nil.
+//
+// NOTE CloseContext() blocks until Start() has been called.
+func (s *AsyncWorkerGroup) CloseContext(ctx context.Context) error {
+	s.mu.Lock()
+	if s.isClosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.isClosed = true
+	// Release enqueue calls blocked on a full row channel.
+	close(s.closed)
+	s.mu.Unlock()
+
+	// Wait for enqueue calls in progress to return,
+	// so no rows are sent to the row channel after workers have drained it.
+	s.enqueueing.Wait()
+
+	// No workers are added or removed once the group has been closed.
+	s.workersMu.Lock()
+	workers := s.workers
+	s.workersMu.Unlock()
+
 	var wg sync.WaitGroup
-	for _, w := range s.workers {
+	for _, w := range workers {
 		wg.Add(1)
 		go func(w *asyncWorker) {
 			defer wg.Done()
@@ -159,9 +1014,688 @@ func (s *AsyncWorkerGroup) Close() {
 			<-w.Close()
 		}(w)
 	}
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		s.retiring.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		s.cancel()
+		// Wait for the error handler to handle the remaining errors.
+		if s.errorHandler != nil {
+			close(s.errorChan)
+			<-s.handled
+		}
+		s.setStopped()
+		return nil
+	case <-ctx.Done():
+	}
+
+	// Interrupt workers and wait for them to return,
+	// so abandoned rows can be counted.
+	s.cancel()
+	<-drained
+	if s.errorHandler != nil {
+		close(s.errorChan)
+	}
+
+	s.mu.RLock()
+	n := s.queuedRows() + int(atomic.LoadInt64(&s.counters.abandonedRows))
+	s.resolveQueued(s.ctx.Err())
+	s.mu.RUnlock()
+	s.setStopped()
+	return &UndrainedRowsError{Rows: n, Err: ctx.Err()}
+}
+
+// resolveQueued resolves the results of rows left in the row channels
+// with given error, emptying them. See EnqueueWithResult().
+//
+// NOTE s.mu must be locked, and workers must have stopped.
+func (s *AsyncWorkerGroup) resolveQueued(err error) {
+	for _, c := range append([]chan Row{s.rowChan}, s.shardChans...) {
+		for len(c) > 0 {
+			row := <-c
+			row.result.resolve(err)
+		}
+	}
+}
+
+// setStopped marks all workers have stopped after being closed.
+func (s *AsyncWorkerGroup) setStopped() {
+	s.mu.Lock()
+	s.stopped = true
+	close(s.done)
+	s.mu.Unlock()
+}
+
+// Done returns a channel closed once the AsyncWorkerGroup has closed,
+// and all of its workers have stopped.
+//
+// It is useful for waiting on a group closed by StartContext().
+func (s *AsyncWorkerGroup) Done() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.done
+}
+
+// Restart reopens a closed AsyncWorkerGroup and starts its workers,
+// reusing their configuration and HTTP clients.
+//
+// Enqueued rows are read from a new row channel.
+// Rows abandoned by CloseContext() are not inserted after restarting.
+//
+// It returns an error if the AsyncWorkerGroup hasn't been closed,
+// or if Close() hasn't returned yet.
+//
+// NOTE Start() must not be called again after Restart().
+func (s *AsyncWorkerGroup) Restart() error {
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isClosed {
+		return errors.New("AsyncWorkerGroup must be closed before restarting")
+	}
+	if !s.stopped {
+		return errors.New("AsyncWorkerGroup hasn't finished closing")
+	}
+
+	// Wait for errors left by CloseContext() to be handled,
+	// so the error handler isn't called concurrently.
+	if s.errorHandler != nil {
+		<-s.handled
+		s.errorChan = make(chan *InsertErrors, s.numWorkers)
+		s.handled = make(chan struct{})
+		go s.handleErrors()
+	}
+
+	if s.perWorkerQueues() {
+		s.shardChans = s.newShardChans()
+	} else {
+		s.rowChan = make(chan Row, s.maxRows*s.numWorkers)
+	}
+	s.closed = make(chan struct{})
+	s.done = make(chan struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	atomic.StoreInt64(&s.counters.abandonedRows, 0)
+	if s.budget != nil {
+		s.budget.reset()
+	}
+
+	// Replace stopped workers, keeping their SyncWorkers.
+	workers := make([]*asyncWorker, len(s.workers))
+	for i, w := range s.workers {
+		workers[i] = s.newAsyncWorker(w.worker, s.workerRowChan(i))
+		workers[i].startDelay = time.Duration(i) * s.startStagger
+		workers[i].Start()
+	}
+	s.workers = workers
+
+	s.isClosed = false
+	s.stopped = false
+	return nil
+}
+
+// handleErrors calls the error handler with every insert error
+// reported by workers, until the error channel is closed.
+//
+// Errors are aggregated instead if set using SetAsyncErrorAggregation().
+func (s *AsyncWorkerGroup) handleErrors() {
+	defer close(s.handled)
+	if s.errorAggregation > 0 {
+		s.aggregateErrors()
+		return
+	}
+	for insertErrs := range s.errorChan {
+		s.errorHandler(insertErrs)
+	}
+}
+
+// Flush forces all workers to insert their enqueued rows immediately,
+// including rows still in the row channel,
+// and blocks until all insert operations have completed.
+// Insert errors are reported to the error channel as usual.
+//
+// Unlike Close(), the AsyncWorkerGroup remains usable afterwards.
+// Rows enqueued concurrently with Flush() may or may not be inserted by it.
+//
+// It returns ErrGroupClosed if the AsyncWorkerGroup has been closed.
+//
+// NOTE Flush() blocks until Start() has been called.
+func (s *AsyncWorkerGroup) Flush() error {
+	return s.flush(nil)
+}
+
+// FlushTable is similar to Flush(),
+// but forces all workers to insert only the rows of given table immediately,
+// keeping rows of other tables enqueued, e.g. before deprecating a table.
+//
+// The table is given as "project.dataset.table",
+// the same as reported to the flush callback set using SetAsyncFlushCallback().
+// Rows of the table still in the row channel are inserted as well,
+// while rows of other tables in it are enqueued by workers as usual.
+//
+// Rows of the table enqueued afterwards are still accepted,
+// and inserted as usual. Stop enqueueing them separately if necessary.
+//
+// It returns ErrGroupClosed if the AsyncWorkerGroup has been closed.
+//
+// NOTE FlushTable() blocks until Start() has been called.
+func (s *AsyncWorkerGroup) FlushTable(table string) error {
+	k, ok := parseTableKey(table)
+	if !ok {
+		return errors.New("table must be given as project.dataset.table")
+	}
+	return s.flush(&k)
+}
+
+// flush implements Flush() and FlushTable(),
+// flushing only given table if not nil.
+func (s *AsyncWorkerGroup) flush(k *tableKey) error {
+	s.mu.RLock()
+	isClosed := s.isClosed
+	s.mu.RUnlock()
+	if isClosed {
+		return ErrGroupClosed
+	}
+
+	s.workersMu.Lock()
+	workers := s.workers
+	s.workersMu.Unlock()
+
+	var (
+		wg     sync.WaitGroup
+		closed int32
+	)
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *asyncWorker) {
+			defer wg.Done()
+			if !w.flush(k) {
+				atomic.StoreInt32(&closed, 1)
+			}
+		}(w)
+	}
 	wg.Wait()
+
+	// A worker may have been closed by SetNumWorkers() instead,
+	// in which case it has inserted its rows anyways.
+	if atomic.LoadInt32(&closed) == 1 {
+		s.mu.RLock()
+		isClosed = s.isClosed
+		s.mu.RUnlock()
+		if isClosed {
+			return ErrGroupClosed
+		}
+	}
+	return nil
+}
+
+// waitIdleInterval is the interval WaitIdle() polls the group's counters at.
+const waitIdleInterval = 10 * time.Millisecond
+
+// WaitIdle blocks until all enqueued rows have been processed,
+// i.e. the row channel is drained, workers have no enqueued rows,
+// and no insert operations are in flight.
+// Unlike Flush() it doesn't force an insert, waiting for max rows
+// or max delay to trigger it as usual.
+//
+// The group is considered idle once the counters reported by Stats()
+// have been observed idle twice in a row, so a row read from the row channel
+// but not yet enqueued by a worker isn't missed.
+// Rows enqueued concurrently with WaitIdle() may or may not be waited for.
+//
+// It returns ctx.Err() if ctx is done before the group is idle,
+// e.g. while paused, or ErrGroupClosed if the group has been closed.
+func (s *AsyncWorkerGroup) WaitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(waitIdleInterval)
+	defer ticker.Stop()
+
+	idle := false
+	for {
+		s.mu.RLock()
+		isClosed := s.isClosed
+		queued := s.queuedRows()
+		s.mu.RUnlock()
+		if isClosed {
+			return ErrGroupClosed
+		}
+
+		stats := s.counters.stats(queued)
+		if stats.QueuedRows == 0 && stats.InFlightInserts == 0 {
+			if idle {
+				return nil
+			}
+			idle = true
+		} else {
+			idle = false
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Stats returns a snapshot of the amount of queued rows and in-flight inserts,
+// and cumulative insert counters of all workers.
+//
+// It is safe for concurrent use,
+// e.g. for polling by a sidecar deciding whether to add workers.
+func (s *AsyncWorkerGroup) Stats() Stats {
+	s.mu.RLock()
+	queued := s.queuedRows()
+	s.mu.RUnlock()
+
+	stats := s.counters.stats(queued)
+	stats.Paused = s.pause.wait() != nil
+
+	// Merge latencies of all workers.
+	stats.Latency = s.retiredLatency.snapshot()
+	s.workersMu.Lock()
+	for _, w := range s.workers {
+		stats.Latency.add(w.worker.latency.snapshot())
+	}
+	s.workersMu.Unlock()
+
+	if s.breaker != nil {
+		stats.Circuit = s.breaker.currentState()
+	}
+	if s.retryBudget != nil {
+		stats.RetryBudget = s.retryBudget.remaining()
+	}
+	if s.budget != nil {
+		stats.BufferedBytes = s.budget.buffered()
+		stats.DroppedRows = atomic.LoadInt64(&s.budget.dropped)
+	}
+	if s.inFlight != nil {
+		stats.InFlightBytes = s.inFlight.inFlight()
+	}
+	return stats
+}
+
+// Healthy returns true if the AsyncWorkerGroup is able to insert rows,
+// e.g. for a Kubernetes readiness probe removing an unhealthy instance
+// from rotation. It is cheap enough to be called frequently.
+//
+// Otherwise it returns false along with the reason, i.e. one of:
+//  - ErrGroupClosed if the group has been closed.
+//  - ErrCircuitOpen if the circuit breaker set using SetAsyncCircuitBreaker()
+//    is open. A half-open circuit is reported as healthy,
+//    so the instance receives rows probing whether BigQuery has recovered.
+//  - ErrFailureRateExceeded if too many recent insert requests have failed,
+//    counting only errors which would be retried, same as the circuit breaker.
+//  - ErrBufferSaturated if the row channel, or the buffered bytes set using
+//    SetAsyncMaxBufferedBytes(), are filled up to the max buffer usage,
+//    meaning enqueueing is about to block.
+//
+// Thresholds are set using SetAsyncHealthThresholds().
+func (s *AsyncWorkerGroup) Healthy() (bool, error) {
+	s.mu.RLock()
+	closed := s.isClosed
+	usage := float64(s.queuedRows()) / float64(s.rowCapacity())
+	s.mu.RUnlock()
+
+	if closed {
+		return false, ErrGroupClosed
+	}
+	if s.breaker != nil && s.breaker.currentState() == CircuitOpen {
+		return false, ErrCircuitOpen
+	}
+	if rate, n := s.health.failureRate(); n >= healthMinRequests && rate > s.healthMaxFailureRate {
+		return false, ErrFailureRateExceeded
+	}
+	if s.budget != nil {
+		if u := float64(s.budget.buffered()) / float64(s.budget.max); u > usage {
+			usage = u
+		}
+	}
+	if usage >= s.healthMaxBufferUsage {
+		return false, ErrBufferSaturated
+	}
+	return true, nil
+}
+
+// Pause stops workers from inserting rows,
+// e.g. while BigQuery's quota is exceeded, until Resume() is called.
+//
+// Workers keep enqueuing rows while paused,
+// up to the max rows set using SetAsyncMaxRows() each.
+// Rows are then left in the row channel,
+// after which Enqueue() blocks and TryEnqueue() returns false.
+//
+// Insert operations already in progress are not interrupted.
+// Flush() and Close() insert rows regardless.
+func (s *AsyncWorkerGroup) Pause() {
+	s.pause.pause()
+}
+
+// Resume resumes inserts after Pause(),
+// with workers immediately inserting rows enqueued while paused.
+// Calling Resume() without pausing is a no-op.
+func (s *AsyncWorkerGroup) Resume() {
+	s.pause.resume()
+}
+
+// RowsEnqueued returns the amount of rows enqueued by all workers.
+//
+// Like the following counters, it is read atomically without locking,
+// and is cheap enough to be polled frequently, e.g. by a metrics collector.
+func (s *AsyncWorkerGroup) RowsEnqueued() uint64 {
+	return uint64(atomic.LoadInt64(&s.counters.enqueuedRows))
 }
 
-func (s *AsyncWorkerGroup) Enqueue(row Row) {
-	s.rowChan <- row
+// RowsInserted returns the amount of rows successfully inserted.
+func (s *AsyncWorkerGroup) RowsInserted() uint64 {
+	return uint64(atomic.LoadInt64(&s.counters.insertedRows))
+}
+
+// RowsRejected returns the amount of rows rejected by BigQuery,
+// or by schema validation.
+func (s *AsyncWorkerGroup) RowsRejected() uint64 {
+	return uint64(atomic.LoadInt64(&s.counters.rejectedRows))
+}
+
+// InsertsRetried returns the amount of failed insert requests
+// which have been retried.
+func (s *AsyncWorkerGroup) InsertsRetried() uint64 {
+	return uint64(atomic.LoadInt64(&s.counters.retriedInserts))
+}
+
+// InsertsFailed returns the amount of insert operations which have failed,
+// either after too many retries or due to a non-retryable error.
+func (s *AsyncWorkerGroup) InsertsFailed() uint64 {
+	return uint64(atomic.LoadInt64(&s.counters.failedInserts))
+}
+
+// Enqueue enqueues a row for insert by one of the background workers.
+//
+// Rows may target any project, dataset and table,
+// regardless of other rows enqueued to the group.
+//
+// It blocks if all workers are busy and the row channel is full.
+// See EnqueueContext() for bounding the time spent waiting.
+//
+// It returns ErrGroupClosed if the AsyncWorkerGroup has been closed.
+func (s *AsyncWorkerGroup) Enqueue(row Row) error {
+	return s.EnqueueContext(context.Background(), row)
+}
+
+// EnqueueWithResult is similar to Enqueue(), but returns a channel receiving
+// the row's insert result once its insert operation has finished,
+// e.g. for acknowledging rows to their producer without reading
+// the error channel. The channel is closed after receiving a single value:
+//   - nil if the row has been inserted, or superseded using SetAsyncDedupKey().
+//   - A *RowRejectedError if the row has been rejected, either by BigQuery
+//     or by its table's schema or row transform.
+//   - The error of the row's last insert attempt if its insert operation
+//     has failed, e.g. a *TooManyFailedInsertRetriesError.
+//   - A *RowDroppedError if the row has been dropped without being inserted,
+//     e.g. since the AsyncWorkerGroup has been closed.
+//   - context.Canceled if the row has been abandoned by CloseContext().
+//
+// Results of all rows are received once Close() or CloseContext() returns,
+// so callers waiting on them don't block forever.
+//
+// NOTE rows returned by the pre-flush hook set using SetAsyncPreFlush()
+// aren't matched to enqueued rows, so rows omitted by it receive nil.
+func (s *AsyncWorkerGroup) EnqueueWithResult(row Row) <-chan error {
+	row.result = newRowResult()
+	// Rows failing to enqueue are resolved when dropped.
+	_ = s.Enqueue(row)
+	return row.result.c
+}
+
+// EnqueueContext is similar to Enqueue(),
+// but returns early if ctx is done before the row could be enqueued.
+//
+// ctx is also used for inserting the row if set using
+// SetAsyncPropagateContext().
+//
+// It returns ctx.Err() if ctx is done first,
+// or ErrGroupClosed if the AsyncWorkerGroup has been closed.
+func (s *AsyncWorkerGroup) EnqueueContext(ctx context.Context, row Row) error {
+	s.mu.RLock()
+	if s.isClosed {
+		s.mu.RUnlock()
+		s.dropped([]Row{row}, DropGroupClosed)
+		return ErrGroupClosed
+	}
+	s.enqueueing.Add(1)
+	rowChan, dispatchChans, closed := s.rowChanFor(row), s.dispatchChans(), s.closed
+	s.mu.RUnlock()
+	defer s.enqueueing.Done()
+
+	if err := s.send(ctx, closed, rowChan, dispatchChans, row); err != nil {
+		s.dropped([]Row{row}, dropReason(err))
+		return err
+	}
+	return nil
+}
+
+// send sends given row to given row channel, blocking until it can,
+// after reserving the row's bytes if max buffered bytes has been set.
+//
+// If dispatchChans is set, the row is sent to whichever of them
+// has room first instead, see sendShortest().
+func (s *AsyncWorkerGroup) send(ctx context.Context, closed <-chan struct{}, rowChan chan Row, dispatchChans []chan Row, row Row) error {
+	if s.propagateContext && ctx != context.Background() {
+		row.ctx = ctx
+	}
+	row = s.enqueued(row)
+
+	size := 0
+	if s.budget != nil {
+		size = s.budget.size(row)
+		if err := s.budget.reserve(ctx, closed, rowChan, size); err != nil {
+			return err
+		}
+	}
+
+	if dispatchChans != nil {
+		err := sendShortest(ctx, closed, dispatchChans, row)
+		if err != nil {
+			s.releaseBuffered(size)
+		} else {
+			s.observeDepth(nil, dispatchChans)
+		}
+		return err
+	}
+
+	select {
+	case rowChan <- row:
+		s.observeDepth(rowChan, nil)
+		return nil
+	case <-ctx.Done():
+		s.releaseBuffered(size)
+		return ctx.Err()
+	case <-closed:
+		s.releaseBuffered(size)
+		return ErrGroupClosed
+	}
+}
+
+// enqueued returns given row as enqueued to the group:
+// with an insert ID derived using SetAsyncAutoInsertID() if it has none,
+// and stamped with the time it's being enqueued
+// if a row TTL has been set using SetAsyncRowTTL().
+func (s *AsyncWorkerGroup) enqueued(row Row) Row {
+	if s.autoInsertID != nil && row.InsertID == "" {
+		row.InsertID = s.autoInsertID(row)
+	}
+	if s.rowTTL > 0 {
+		c := s.clock
+		if c == nil {
+			c = realClock{}
+		}
+		row.enqueuedAt = c.Now()
+	}
+	return row
+}
+
+// releaseBuffered releases given amount of bytes reserved for a row
+// which hasn't been enqueued, if max buffered bytes has been set.
+func (s *AsyncWorkerGroup) releaseBuffered(size int) {
+	if s.budget != nil {
+		s.budget.release(size)
+	}
+}
+
+// bufferedSize returns given row's size counted against max buffered bytes,
+// as estimated by the row sizer if set,
+// or using the same JSON encoding used for the insert request.
+func (s *AsyncWorkerGroup) bufferedSize(row Row) int {
+	if s.sizer != nil {
+		return s.sizer(row)
+	}
+	return encodedRowSize(requestRow(row))
+}
+
+// EnqueueBatch is similar to Enqueue(), but enqueues multiple rows at once,
+// checking the AsyncWorkerGroup has been closed only once for all of them.
+//
+// It returns the amount of rows enqueued,
+// which is less than len(rows) only if an error is returned.
+//
+// NOTE rows are read by any of the workers,
+// so their insert order is not guaranteed, as with Enqueue(),
+// unless a shard key has been set using SetAsyncShardKey().
+func (s *AsyncWorkerGroup) EnqueueBatch(rows []Row) (int, error) {
+	return s.EnqueueBatchContext(context.Background(), rows)
+}
+
+// EnqueueBatchValidated is similar to EnqueueBatch(),
+// but validates rows before enqueueing them, enqueueing only valid rows.
+//
+// It returns an error for every given row, in the same order,
+// which is nil for rows which have been enqueued.
+// Invalid rows get a *RowRejectedError, as if rejected by BigQuery,
+// so producers can correct them before they reach a worker.
+//
+// Rows are invalid if they have no project, dataset or table ID,
+// or no data. They are validated the same as by workers otherwise,
+// i.e. transformed using SetAsyncRowTransform() and validated against
+// their table's schema if set using SetAsyncSchema().
+// Rows are enqueued as given though, and are transformed again by workers.
+//
+// Valid rows which couldn't be enqueued get the error EnqueueBatch()
+// would return, e.g. ErrGroupClosed.
+func (s *AsyncWorkerGroup) EnqueueBatchValidated(rows []Row) []error {
+	s.workersMu.Lock()
+	w := s.workers[0].worker
+	s.workersMu.Unlock()
+
+	errs := make([]error, len(rows))
+	valid := make([]Row, 0, len(rows))
+	indices := make([]int, 0, len(rows))
+	for i, r := range rows {
+		var rowErrs []*bigquery.ErrorProto
+		switch {
+		case r.ProjectID == "" || r.DatasetID == "" || r.TableID == "":
+			rowErrs = []*bigquery.ErrorProto{{Reason: "invalid", Message: "missing project, dataset or table ID."}}
+		case r.Data == nil && r.RawData == nil:
+			rowErrs = []*bigquery.ErrorProto{{Reason: "invalid", Message: "missing row data."}}
+		default:
+			_, rowErrs = w.validate(r)
+		}
+		if len(rowErrs) > 0 {
+			errs[i] = &RowRejectedError{Errors: rowErrs, Table: r.TableID, Dataset: r.DatasetID, Project: r.ProjectID}
+			continue
+		}
+		valid = append(valid, r)
+		indices = append(indices, i)
+	}
+
+	n, err := s.EnqueueBatch(valid)
+	for _, i := range indices[n:] {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Replay enqueues rows spilled by the handler set using SetAsyncSpillHandler(),
+// e.g. once read back from a local write-ahead log after BigQuery has recovered.
+//
+// It is equivalent to EnqueueBatch(), returning the amount of rows enqueued.
+// Rows are inserted at least once,
+// and may be inserted twice unless they have insert IDs set.
+func (s *AsyncWorkerGroup) Replay(rows []Row) (int, error) {
+	return s.EnqueueBatch(rows)
+}
+
+// EnqueueBatchContext is similar to EnqueueBatch(),
+// but returns early if ctx is done before all rows could be enqueued.
+//
+// It returns the amount of rows enqueued,
+// with ctx.Err() if ctx is done first,
+// or ErrGroupClosed if the AsyncWorkerGroup has been closed.
+func (s *AsyncWorkerGroup) EnqueueBatchContext(ctx context.Context, rows []Row) (int, error) {
+	s.mu.RLock()
+	if s.isClosed {
+		s.mu.RUnlock()
+		s.dropped(rows, DropGroupClosed)
+		return 0, ErrGroupClosed
+	}
+	s.enqueueing.Add(1)
+	rowChanFor, dispatchChans, closed := s.router(), s.dispatchChans(), s.closed
+	s.mu.RUnlock()
+	defer s.enqueueing.Done()
+
+	for i, row := range rows {
+		if err := s.send(ctx, closed, rowChanFor(row), dispatchChans, row); err != nil {
+			s.dropped(rows[i:], dropReason(err))
+			return i, err
+		}
+	}
+	return len(rows), nil
+}
+
+// TryEnqueue is similar to Enqueue(),
+// but never blocks: it returns false if the row channel is full
+// or the AsyncWorkerGroup has been closed.
+//
+// This is useful for producers that would rather drop or reroute rows
+// than wait for workers under load.
+// Rows not enqueued are reported as dropped regardless.
+func (s *AsyncWorkerGroup) TryEnqueue(row Row) bool {
+	ok, reason := s.tryEnqueue(row)
+	if !ok {
+		s.dropped([]Row{row}, reason)
+	}
+	return ok
+}
+
+// tryEnqueue implements TryEnqueue(),
+// returning the reason the row hasn't been enqueued if so.
+func (s *AsyncWorkerGroup) tryEnqueue(row Row) (bool, DropReason) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.isClosed {
+		return false, DropGroupClosed
+	}
+
+	row = s.enqueued(row)
+	rowChan := s.rowChanFor(row)
+	size := 0
+	if s.budget != nil {
+		size = s.budget.size(row)
+		if !s.budget.tryReserveNow(rowChan, size) {
+			return false, DropBufferFull
+		}
+	}
+
+	select {
+	case rowChan <- row:
+		s.observeDepth(rowChan, s.dispatchChans())
+		return true, 0
+	default:
+		s.releaseBuffered(size)
+		return false, DropBufferFull
+	}
 }
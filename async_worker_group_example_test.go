@@ -80,11 +80,15 @@ func ExampleAsyncWorkerGroup() {
 	// An insert operation will be executed once the time delay defined by
 	// SetAsyncMaxDelay is reached,
 	// or enough rows have been queued (not shown in this example).
-	g.Enqueue(
+	//
+	// An error is returned if the AsyncWorkerGroup has already been closed.
+	if err := g.Enqueue(
 		NewRow(
 			"my-project",
 			"my-dataset",
 			"my-table",
 			map[string]bigquery.JsonValue{"key": "value"},
-		))
+		)); err != nil {
+		log.Println(err)
+	}
 }
@@ -4,13 +4,26 @@ package bqstreamer
 
 import (
 	"errors"
+	"net/http"
 	"time"
+
+	"golang.org/x/oauth2/jwt"
+	bigquery "google.golang.org/api/bigquery/v2"
 )
 
 const (
 	DefaultAsyncNumWorkerss = 10
 	DefaultAsyncMaxRows     = 500
 	DefaultAsyncMaxDelay    = 5 * time.Second
+	DefaultAsyncDialTimeout = 5 * time.Second
+	DefaultAsyncKeepAlive   = 30 * time.Second
+
+	// BigQuery's max row size for streaming inserts.
+	DefaultAsyncMaxLineSize = 10 * 1024 * 1024
+
+	DefaultAsyncHealthMaxFailureRate = 0.5
+	DefaultAsyncHealthMaxBufferUsage = 0.9
+	DefaultAsyncHealthWindow         = 1 * time.Minute
 )
 
 type AsyncOptionFunc func(*AsyncWorkerGroup) error
@@ -32,9 +45,15 @@ func SetAsyncNumWorkers(workers int) AsyncOptionFunc {
 //
 // Use this option when you want all workers to report errors
 // to a unified channel.
+// If neither this option nor SetAsyncErrorHandler() is set,
+// insert errors are discarded without blocking workers.
+// They are still logged, and counted in Stats().
 //
 // NOTE the error channel is not closed when the AsyncWorkerGroup closes.
 // It is the responsibilty of the user to close it.
+//
+// NOTE workers block if the error channel is full.
+// See SetAsyncErrorHandler() for avoiding having to read it.
 func SetAsyncErrorChannel(errChan chan *InsertErrors) AsyncOptionFunc {
 	return func(w *AsyncWorkerGroup) error {
 		if errChan == nil {
@@ -45,6 +64,108 @@ func SetAsyncErrorChannel(errChan chan *InsertErrors) AsyncOptionFunc {
 	}
 }
 
+// SetAsyncErrorHandler sets a function called with workers' insert errors.
+//
+// The AsyncWorkerGroup reads its own error channel in the background,
+// and calls handler with each error, so workers never block reporting them.
+// Use either this option or SetAsyncErrorChannel(), but not both.
+//
+// NOTE handler is called from a single goroutine,
+// so a slow handler delays reporting errors by all workers.
+// Close() waits for handler to return for all remaining errors.
+func SetAsyncErrorHandler(handler func(*InsertErrors)) AsyncOptionFunc {
+	return func(w *AsyncWorkerGroup) error {
+		if handler == nil {
+			return errors.New("error handler is nil")
+		}
+		w.errorHandler = handler
+		return nil
+	}
+}
+
+// SetAsyncMaxLineSize sets the max size in bytes of a single line
+// read by AsyncWorkerGroup.EnqueueNDJSON().
+// Default value is DefaultAsyncMaxLineSize.
+//
+// NOTE value must be a positive int.
+func SetAsyncMaxLineSize(n int) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if n <= 0 {
+			return errors.New("max line size must be a positive int")
+		}
+		s.maxLineSize = n
+		return nil
+	}
+}
+
+// SetAsyncRowTTL sets the max time rows may be buffered for,
+// since being enqueued until their insert operation.
+// Older rows are dropped instead of inserted, and reported to the drop handler
+// set using SetAsyncDropHandler() with DropExpired,
+// e.g. so time sensitive data isn't inserted after a long stall,
+// and are counted in Stats.ExpiredRows.
+//
+// Rows are checked once per insert operation, before their first attempt,
+// so rows being retried are inserted regardless.
+//
+// NOTE value must be a positive time.Duration.
+func SetAsyncRowTTL(d time.Duration) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if d <= 0 {
+			return errors.New("row TTL must be a positive time.Duration")
+		}
+		s.rowTTL = d
+		return nil
+	}
+}
+
+// SetAsyncTableStats enables per table insert counters,
+// as returned by AsyncWorkerGroup.TableStats(), e.g. for capacity planning
+// of groups inserting to multiple tables.
+//
+// Up to maxTables tables are tracked, evicting the least recently inserted
+// table once exceeded, so high cardinality table names,
+// e.g. due to template suffixes, don't grow memory usage unboundedly.
+// An evicted table is tracked anew once inserted to again.
+//
+// NOTE inserted rows are encoded once more for measuring their size,
+// spending some CPU time on every insert request.
+//
+// NOTE value must be a positive int.
+func SetAsyncTableStats(maxTables int) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if maxTables <= 0 {
+			return errors.New("max tracked tables must be a positive int")
+		}
+		s.maxTrackedTables = maxTables
+		return nil
+	}
+}
+
+// SetAsyncErrorAggregation sets a window over which insert errors
+// are coalesced before being reported, reducing noise during outages.
+//
+// Instead of a report per insert operation, a single report of all tables
+// with errors reported during the window is sent to the error channel,
+// or given to the error handler, once the window since the first error
+// has passed. Tables are grouped by project, dataset and table,
+// and keep all of their insert attempts and row errors.
+// Use InsertErrors.ErrorCounts() for summarizing errors per table and reason.
+//
+// Tables without any errors aren't reported at all.
+// Errors left when the AsyncWorkerGroup closes are reported right away.
+//
+// NOTE value must be a positive time.Duration.
+func SetAsyncErrorAggregation(window time.Duration) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if window <= 0 {
+			return errors.New("error aggregation window must be a positive time.Duration")
+		}
+		s.errorAggregation = window
+		return nil
+	}
+}
+
 // SetAsyncMaxRetries sets the maximum amount of retries a failed insert
 // operation can be retried,
 // before dropping the rows and giving up on the insert operation entirely.
@@ -60,6 +181,20 @@ func SetAsyncMaxRetries(retries int) AsyncOptionFunc {
 	}
 }
 
+// SetAsyncRetryDeadline bounds the total time all workers spend on
+// an insert operation and its retries.
+//
+// See SetSyncRetryDeadline() for more info.
+func SetAsyncRetryDeadline(deadline time.Duration) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if deadline <= 0 {
+			return errors.New("retry deadline must be a positive time.Duration")
+		}
+		s.retryDeadline = deadline
+		return nil
+	}
+}
+
 // SetAsyncMaxRows sets the maximum amount of rows a worker can enqueue
 // before an insert operation is executed.
 //
@@ -77,9 +212,51 @@ func SetAsyncMaxRows(rowLen int) AsyncOptionFunc {
 	}
 }
 
+// SetAsyncMaxBytes sets the maximum accumulated size in bytes of rows
+// a worker can enqueue, as encoded in the insert request.
+// An insert operation is executed before enqueueing a row
+// that would cause the limit to be exceeded.
+//
+// A single row larger than the limit is still sent,
+// but BigQuery will reject it, reported as an insert error.
+//
+// See the following article for BigQuery's request size limits:
+// https://cloud.google.com/bigquery/quotas#streaming_inserts
+//
+// NOTE value must be a positive int.
+func SetAsyncMaxBytes(bytes int) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if bytes <= 0 {
+			return errors.New("max bytes must be a positive int")
+		}
+		s.maxBytes = bytes
+		return nil
+	}
+}
+
+// SetAsyncMaxRowsPerRequest sets the maximum amount of rows
+// a worker sends in a single insert request,
+// regardless of the max rows set using SetAsyncMaxRows().
+//
+// See SetSyncMaxRowsPerRequest() for more info.
+//
+// NOTE value must be a positive int, not exceeding BigQuery's limit of 10000.
+func SetAsyncMaxRowsPerRequest(rows int) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if rows <= 0 || rows > maxRequestRows {
+			return errors.New("max rows per request must be a positive int not exceeding 10000")
+		}
+		s.maxRowsPerRequest = rows
+		return nil
+	}
+}
+
 // SetAsyncMaxDelay sets the maximum time delay a worker should wait
 // before an insert operation is executed.
 //
+// The delay is tracked per table, starting from its oldest enqueued row,
+// so rows of rarely used tables aren't kept waiting by rows of other tables.
+//
 // NOTE value must be a positive time.Duration.
 func SetAsyncMaxDelay(delay time.Duration) AsyncOptionFunc {
 	return func(s *AsyncWorkerGroup) error {
@@ -91,6 +268,43 @@ func SetAsyncMaxDelay(delay time.Duration) AsyncOptionFunc {
 	}
 }
 
+// SetAsyncMaxDelayJitter randomizes each worker's max delay
+// by up to the given fraction in either direction,
+// e.g. 0.1 means a delay between 90% and 110% of SetAsyncMaxDelay().
+//
+// Workers pick a new delay for every table once it has enqueued rows,
+// spreading inserts of workers started together over time.
+//
+// NOTE value must be a non-negative float smaller than 1.
+func SetAsyncMaxDelayJitter(fraction float64) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if fraction < 0 || fraction >= 1 {
+			return errors.New("max delay jitter must be a non-negative float smaller than 1")
+		}
+		s.maxDelayJitter = fraction
+		return nil
+	}
+}
+
+// SetAsyncStartStagger delays the start of every worker by an incremental
+// offset, i.e. the n-th worker starts reading rows n*stagger after Start(),
+// so workers' first token fetches and inserts aren't all executed at once.
+//
+// Workers are still closed promptly while waiting to start,
+// but Flush() waits for them to start.
+// The default value is zero, which starts all workers at once.
+//
+// NOTE value must be a non-negative time.Duration.
+func SetAsyncStartStagger(stagger time.Duration) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if stagger < 0 {
+			return errors.New("start stagger must be a non-negative time.Duration")
+		}
+		s.startStagger = stagger
+		return nil
+	}
+}
+
 // SetAsyncRetryInterval sets the time delay before retrying a failed insert
 // operation (if required).
 //
@@ -105,25 +319,1016 @@ func SetAsyncRetryInterval(sleep time.Duration) AsyncOptionFunc {
 	}
 }
 
-// SetAsyncIgnoreUnknownValues sets whether to accept rows that contain values
-// that do not match the table schema.  The unknown values are ignored.
+// SetAsyncRetryBackoff sets an exponential backoff with full jitter
+// between retries of a failed insert operation,
+// instead of the flat interval set by SetAsyncRetryInterval().
 //
-// Default is false, which treats unknown values as errors.
-func SetAsyncIgnoreUnknownValues(ignore bool) AsyncOptionFunc {
-	return func(w *AsyncWorkerGroup) error {
-		w.ignoreUnknownValues = ignore
+// See SetSyncRetryBackoff() for how the delay is calculated.
+//
+// NOTE initial must be a positive time.Duration, max must not be smaller
+// than initial, and multiplier must be at least 1.
+func SetAsyncRetryBackoff(initial, max time.Duration, multiplier float64) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if initial <= 0 {
+			return errors.New("initial backoff must be a positive time.Duration")
+		}
+		if max < initial {
+			return errors.New("max backoff must not be smaller than initial backoff")
+		}
+		if multiplier < 1 {
+			return errors.New("backoff multiplier must be at least 1")
+		}
+		s.backoffInitial = initial
+		s.backoffMax = max
+		s.backoffMultiplier = multiplier
 		return nil
 	}
 }
 
-// SetAsyncSkipInvalidRows sets whether to insert all valid rows of a request,
-// even if invalid rows exist.
+// SetAsyncEndpoint overrides the BigQuery API base URL used by all workers,
+// e.g. for pointing the group at a local BigQuery emulator.
 //
-// The default value is false,
-// which causes the entire request to fail if any invalid rows exist.
-func SetAsyncSkipInvalidRows(skip bool) AsyncOptionFunc {
-	return func(w *AsyncWorkerGroup) error {
-		w.skipInvalidRows = skip
+// NOTE value must be a well-formed http or https URL.
+func SetAsyncEndpoint(endpoint string) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		// Validate early, so errors are returned before workers are created.
+		if err := SetSyncEndpoint(endpoint)(&SyncWorker{}); err != nil {
+			return errors.New("endpoint must be a well-formed http or https URL")
+		}
+		s.endpoint = endpoint
+		return nil
+	}
+}
+
+// SetAsyncStatsHandler sets a handler receiving insert related events
+// from all workers, e.g. for exporting metrics.
+//
+// NOTE the handler is called concurrently by all workers.
+func SetAsyncStatsHandler(h StatsHandler) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if h == nil {
+			return errors.New("stats handler is nil")
+		}
+		s.stats = h
+		return nil
+	}
+}
+
+// SetAsyncLogger sets a logger for lifecycle and insert related events
+// of all workers, e.g. worker start and stop, insert triggers, and retries.
+//
+// No messages are logged by default.
+//
+// NOTE the logger is called concurrently by all workers.
+func SetAsyncLogger(l Logger) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if l == nil {
+			return errors.New("logger is nil")
+		}
+		s.logger = l
+		return nil
+	}
+}
+
+// SetAsyncInsertTracer sets a tracer called around every insert request
+// of all workers.
+//
+// Use bqotel.SetAsyncTracerProvider() for tracing using OpenTelemetry.
+//
+// NOTE the tracer is called concurrently by all workers.
+func SetAsyncInsertTracer(t InsertTracer) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if t == nil {
+			return errors.New("insert tracer is nil")
+		}
+		s.tracer = t
+		return nil
+	}
+}
+
+// SetAsyncDeadLetterHandler sets a handler called for every row rejected
+// by BigQuery, e.g. due to a schema mismatch or invalid values.
+// Rejected rows are not retried.
+//
+// See SetSyncDeadLetterHandler() for more info.
+//
+// NOTE the handler is called concurrently by all workers.
+func SetAsyncDeadLetterHandler(h func(row Row, err error)) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if h == nil {
+			return errors.New("dead-letter handler is nil")
+		}
+		s.deadLetter = h
+		return nil
+	}
+}
+
+// SetAsyncDropHandler sets a handler called for every row which couldn't be
+// enqueued, and has thus been dropped, along with the reason,
+// e.g. for logging or persisting lost rows.
+//
+// Dropped rows are reported to the stats handler as well.
+// Calls returning an error, or TryEnqueue() returning false,
+// report their rows as dropped, as do rows dropped by OverflowDropOldest.
+//
+// NOTE the handler is called concurrently by enqueueing goroutines,
+// and must not enqueue rows to the group.
+func SetAsyncDropHandler(h func(row Row, reason DropReason)) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if h == nil {
+			return errors.New("drop handler is nil")
+		}
+		s.dropHandler = h
+		return nil
+	}
+}
+
+// SetAsyncSpillHandler sets a handler called with the rows of every insert
+// operation which has failed entirely, instead of dropping them.
+// Spilled rows can be enqueued again later using Replay().
+//
+// No rows are spilled by default.
+// See SetSyncSpillHandler() for more info.
+//
+// NOTE the handler is called concurrently by all workers.
+func SetAsyncSpillHandler(h func(rows []Row) error) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if h == nil {
+			return errors.New("spill handler is nil")
+		}
+		s.spill = h
+		return nil
+	}
+}
+
+// SetAsyncFlushCallback sets a function called after every insert operation
+// with the amount of rows inserted and rejected per table.
+//
+// See SetSyncFlushCallback() for more info.
+//
+// NOTE the callback is called concurrently by all workers.
+func SetAsyncFlushCallback(f func(inserted, rejected int, table string)) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if f == nil {
+			return errors.New("flush callback is nil")
+		}
+		s.flushCallback = f
+		return nil
+	}
+}
+
+// SetAsyncRetryableFunc overrides IsRetryable() for all workers,
+// which decides whether a failed insert operation should be retried.
+//
+// NOTE the function is called concurrently by all workers.
+func SetAsyncRetryableFunc(f func(err error) bool) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if f == nil {
+			return errors.New("retryable func is nil")
+		}
+		s.retryable = f
+		return nil
+	}
+}
+
+// SetAsyncSchemaRefresh sets a function refreshing the schema of a table
+// rejecting rows due to unknown fields for all workers.
+//
+// See SetSyncSchemaRefresh() for more info.
+//
+// NOTE the function is called concurrently by all workers,
+// possibly for the same table.
+func SetAsyncSchemaRefresh(refresh func(table string) error) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if refresh == nil {
+			return errors.New("schema refresh is nil")
+		}
+		s.schemaRefresh = refresh
+		return nil
+	}
+}
+
+// SetAsyncTraceID sets a function returning the trace ID of insert requests
+// of given rows by all workers.
+//
+// See SetSyncTraceID() for more info.
+//
+// NOTE the function is called concurrently by all workers.
+func SetAsyncTraceID(f func(rows []Row) string) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if f == nil {
+			return errors.New("trace ID func is nil")
+		}
+		s.traceID = f
+		return nil
+	}
+}
+
+// SetAsyncAutoInsertID sets a function deriving the insert ID of rows
+// enqueued without one, e.g. ContentHashInsertID(), so their inserts
+// are deduplicated by BigQuery without managing insert IDs.
+// Without it, such rows are sent without an insert ID.
+//
+// The ID is derived once when the row is enqueued, and is thus stable across
+// retries of the row. Rows enqueued with an insert ID keep it, including
+// random ones generated by NewRow().
+//
+// NOTE the function is called by the goroutines enqueueing rows.
+// Rows it returns the same ID for are deduplicated as duplicates,
+// so it must return a different ID for every distinct row.
+func SetAsyncAutoInsertID(f func(row Row) string) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if f == nil {
+			return errors.New("auto insert ID func is nil")
+		}
+		s.autoInsertID = f
+		return nil
+	}
+}
+
+// SetAsyncTableRetryable overrides IsRetryable(), or the function set using
+// SetAsyncRetryableFunc(), for inserts to given table by all workers.
+//
+// See SetSyncTableRetryable() for more info.
+//
+// NOTE the function is called concurrently by all workers.
+func SetAsyncTableRetryable(projectID, datasetID, tableID string, f func(err error) bool) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if f == nil {
+			return errors.New("table retryable func is nil")
+		}
+		if s.tableRetryable == nil {
+			s.tableRetryable = map[tableKey]func(err error) bool{}
+		}
+		s.tableRetryable[tableKey{projectID, datasetID, tableID}] = f
+		return nil
+	}
+}
+
+// SetAsyncRetryCallback sets a function called before every retry
+// of an insert operation of all workers.
+//
+// See SetSyncRetryCallback() for more info.
+//
+// NOTE the function is called concurrently by all workers.
+func SetAsyncRetryCallback(f func(attempt int, err error, nextDelay time.Duration)) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if f == nil {
+			return errors.New("retry callback is nil")
+		}
+		s.retryCallback = f
+		return nil
+	}
+}
+
+// SetAsyncMaxConcurrentInserts sets the maximum amount of insert requests
+// executed simultaneously across all workers.
+// Workers wait for an in-flight request to complete before executing another.
+//
+// By default every worker executes its own requests regardless of others.
+//
+// NOTE value must be a positive int.
+func SetAsyncMaxConcurrentInserts(n int) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if n <= 0 {
+			return errors.New("max concurrent inserts must be a positive int")
+		}
+		s.maxConcurrentInserts = n
+		return nil
+	}
+}
+
+// SetAsyncMaxInFlightBytes sets the maximum accumulated size in bytes
+// of insert requests in flight across all workers,
+// i.e. sent but not yet responded to, bounding peak outbound memory
+// and network usage under high concurrency.
+// Workers wait for in-flight requests to complete before sending a request
+// which would exceed it. A single request larger than the limit is
+// still sent once no other request is in flight.
+//
+// Unlike SetAsyncMaxBufferedBytes(), only rows being sent are counted.
+// Request sizes are measured by encoding their rows, as sent without gzip.
+//
+// By default in-flight requests are not bounded.
+//
+// NOTE value must be a positive int.
+func SetAsyncMaxInFlightBytes(n int) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if n <= 0 {
+			return errors.New("max in-flight bytes must be a positive int")
+		}
+		s.maxInFlightBytes = n
+		return nil
+	}
+}
+
+// SetAsyncInsertTimeout sets the maximum duration of a single insert request
+// of all workers, including its entire round trip.
+//
+// See SetSyncInsertTimeout() for more info.
+//
+// NOTE value must be a positive time.Duration.
+func SetAsyncInsertTimeout(d time.Duration) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if d <= 0 {
+			return errors.New("insert timeout must be a positive time.Duration")
+		}
+		s.insertTimeout = d
+		return nil
+	}
+}
+
+// SetAsyncGzip sets whether all workers compress insert request bodies
+// using gzip.
+//
+// See SetSyncGzip() for more info.
+func SetAsyncGzip(enabled bool) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		s.gzip = enabled
+		return nil
+	}
+}
+
+// SetAsyncGzipMinBytes sets the minimum size in bytes of insert request bodies
+// compressed by all workers, if enabled using SetAsyncGzip().
+//
+// See SetSyncGzipMinBytes() for more info.
+func SetAsyncGzipMinBytes(n int) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if n < 0 {
+			return errors.New("gzip min bytes must be a non-negative int")
+		}
+		s.gzipMinBytes = n
+		return nil
+	}
+}
+
+// SetAsyncUserAgent sets a custom User-Agent header on insert requests
+// of all workers.
+//
+// See SetSyncUserAgent() for more info.
+func SetAsyncUserAgent(userAgent string, replace bool) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if userAgent == "" {
+			return errors.New("user agent must be a non-empty string")
+		}
+		s.userAgent = userAgent
+		s.replaceUserAgent = replace
+		return nil
+	}
+}
+
+// SetAsyncQuotaProject bills quota and usage of insert requests of all workers
+// to given project.
+//
+// See SetSyncQuotaProject() for more info.
+func SetAsyncQuotaProject(projectID string) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if !projectIDRegexp.MatchString(projectID) {
+			return errors.New("quota project must be a valid project ID")
+		}
+		s.quotaProject = projectID
+		return nil
+	}
+}
+
+// SetAsyncTransport sets the base transport used by all workers
+// for connecting to BigQuery, e.g. for using an egress proxy,
+// a custom CA bundle or tuned connection pooling.
+//
+// Requests are still authenticated using OAuth2,
+// by wrapping the given transport.
+//
+// NOTE it can't be used together with ipv4Only,
+// since the IPv4 only transport would be replaced.
+// Configure the given transport to dial using "tcp4" instead.
+func SetAsyncTransport(base http.RoundTripper) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if base == nil {
+			return errors.New("transport is nil")
+		}
+		s.transport = base
+		return nil
+	}
+}
+
+// SetAsyncDialTimeout sets the maximum time workers wait for a connection
+// to BigQuery to be established.
+// Default is DefaultAsyncDialTimeout.
+//
+// NOTE it has no effect if a custom transport has been set
+// using SetAsyncTransport().
+//
+// NOTE value must be a positive time.Duration.
+func SetAsyncDialTimeout(d time.Duration) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if d <= 0 {
+			return errors.New("dial timeout must be a positive time.Duration")
+		}
+		s.dialTimeout = d
+		return nil
+	}
+}
+
+// SetAsyncKeepAlive sets the interval between keep-alive probes
+// of workers' connections to BigQuery.
+// Default is DefaultAsyncKeepAlive.
+//
+// NOTE it has no effect if a custom transport has been set
+// using SetAsyncTransport().
+//
+// NOTE value must be a positive time.Duration.
+func SetAsyncKeepAlive(d time.Duration) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if d <= 0 {
+			return errors.New("keep-alive must be a positive time.Duration")
+		}
+		s.keepAlive = d
+		return nil
+	}
+}
+
+// NetworkMode sets the IP version used for connecting to BigQuery.
+type NetworkMode int
+
+const (
+	// NetworkAuto connects using either IPv4 or IPv6 (dual-stack).
+	// This is the default.
+	NetworkAuto NetworkMode = iota
+
+	// NetworkIPv4 connects using IPv4 only,
+	// same as setting ipv4Only on initialization.
+	NetworkIPv4
+
+	// NetworkIPv6 connects using IPv6 only.
+	NetworkIPv6
+)
+
+// network returns the network name used for dialing.
+func (mode NetworkMode) network() string {
+	switch mode {
+	case NetworkIPv4:
+		return "tcp4"
+	case NetworkIPv6:
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// SetAsyncNetworkMode sets the IP version used by all workers
+// for connecting to BigQuery.
+// Default is NetworkAuto, unless ipv4Only has been set on initialization.
+//
+// NOTE it can't be used together with a custom transport set
+// using SetAsyncTransport().
+func SetAsyncNetworkMode(mode NetworkMode) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		switch mode {
+		case NetworkAuto, NetworkIPv4, NetworkIPv6:
+		default:
+			return errors.New("unknown network mode")
+		}
+		s.networkMode = mode
+		return nil
+	}
+}
+
+// SetAsyncForceHTTP2 sets whether workers connect to BigQuery using HTTP/2,
+// multiplexing concurrent insert requests over fewer connections,
+// or HTTP/1.1 only, using a connection per concurrent request.
+//
+// HTTP/2 is attempted by default, same as http.DefaultTransport.
+// HTTP/1.1 may still be faster over low latency networks,
+// see BenchmarkAsyncWorkerGroupHTTP2.
+//
+// NOTE it can't be used together with a custom transport set
+// using SetAsyncTransport().
+func SetAsyncForceHTTP2(enabled bool) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		s.forceHTTP2 = &enabled
+		return nil
+	}
+}
+
+// SetAsyncMaxBufferedBytes sets the maximum accumulated size in bytes
+// of all buffered rows, i.e. rows in the row channel and rows enqueued
+// by workers, as encoded in the insert request.
+// This bounds the memory used for buffering rows while BigQuery is slow,
+// since the row channel's capacity only bounds the amount of rows.
+//
+// What happens once exceeded is set using SetAsyncOverflowPolicy(),
+// blocking enqueueing by default.
+// A single row is allowed to exceed the limit if no other rows are buffered.
+// Buffered bytes are reported by Stats().
+//
+// NOTE every row is encoded for measuring its size when enqueued,
+// and when enqueued by a worker. Use SetAsyncRowSizer() for a cheaper estimate,
+// which must return the same size for the same row.
+//
+// NOTE value must be a positive int.
+func SetAsyncMaxBufferedBytes(bytes int) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if bytes <= 0 {
+			return errors.New("max buffered bytes must be a positive int")
+		}
+		s.maxBufferedBytes = bytes
+		return nil
+	}
+}
+
+// SetAsyncOverflowPolicy sets what happens when enqueueing a row would exceed
+// the max buffered bytes set using SetAsyncMaxBufferedBytes().
+// Default is OverflowBlock.
+//
+// TryEnqueue() never blocks, returning false instead,
+// unless a row could be dropped by the OverflowDropOldest policy.
+func SetAsyncOverflowPolicy(policy OverflowPolicy) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		switch policy {
+		case OverflowBlock, OverflowDropOldest, OverflowRejectNew:
+		default:
+			return errors.New("unknown overflow policy")
+		}
+		s.overflowPolicy = policy
+		return nil
+	}
+}
+
+// SetAsyncShardKey sets a function returning a key for every enqueued row,
+// such that rows with the same key are always inserted by the same worker,
+// in the order they were enqueued, e.g. a user ID for inserting every user's
+// events in order.
+//
+// Every worker reads rows from a row channel of its own,
+// selected by consistently hashing the row's key,
+// instead of all workers sharing a single row channel.
+// Thus a slow worker blocks enqueueing rows of its keys,
+// even if other workers are available.
+//
+// NOTE the order of rows with different keys is unspecified,
+// and the order of rows retried after failing is not guaranteed either.
+// The number of workers can't be changed using SetNumWorkers() if set.
+func SetAsyncShardKey(key func(Row) string) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if key == nil {
+			return errors.New("shard key is nil")
+		}
+		s.shardKey = key
+		return nil
+	}
+}
+
+// SetAsyncWarmup sets whether every worker warms up its HTTP client on start,
+// before reading rows, by sending a cheap request to the BigQuery API.
+// This establishes a connection, i.e. DNS lookup and TLS handshake,
+// and fetches an OAuth2 token, so the first inserts don't pay for them.
+//
+// Warmup failures are logged and otherwise ignored,
+// and warmup is bounded to 10 seconds per worker.
+// Flush() blocks until workers have warmed up.
+func SetAsyncWarmup(enabled bool) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		s.warmup = enabled
+		return nil
+	}
+}
+
+// SetAsyncDispatchStrategy sets how enqueued rows are dispatched to workers.
+// Default is SharedQueue.
+//
+// SharedQueue balances rows over available workers best,
+// while PerWorkerQueue bounds how many rows each worker holds back:
+// once a worker is stuck, e.g. retrying failed inserts,
+// its row channel fills up and no further rows are sent to it.
+// Rows already in its channel are only inserted once it recovers though,
+// and may be inserted long after rows enqueued later.
+//
+// NOTE the number of workers can't be changed using SetNumWorkers()
+// when using PerWorkerQueue.
+// Rows are always dispatched by key if set using SetAsyncShardKey().
+func SetAsyncDispatchStrategy(strategy DispatchStrategy) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		switch strategy {
+		case SharedQueue, PerWorkerQueue:
+		default:
+			return errors.New("unknown dispatch strategy")
+		}
+		s.dispatch = strategy
+		return nil
+	}
+}
+
+// setAsyncIPv4Only sets whether to connect to BigQuery using IPv4 only.
+func setAsyncIPv4Only(ipv4Only bool) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		s.ipv4Only = ipv4Only
+		return nil
+	}
+}
+
+// setAsyncJWTConfig sets the JWT configuration authenticating workers.
+func setAsyncJWTConfig(c *jwt.Config) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		s.jwtConfig = c
+		return nil
+	}
+}
+
+// setAsyncClock sets the clock used by all workers, e.g. a fake clock in tests.
+func setAsyncClock(c clock) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		s.clock = c
+		return nil
+	}
+}
+
+// setAsyncClient sets the http.Client used by all workers as is.
+func setAsyncClient(c *http.Client) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		s.client = c
+		return nil
+	}
+}
+
+// SetAsyncScopes sets the OAuth2 scopes requested using the JWT configuration
+// given to NewAsyncWorkerGroup(), replacing the configuration's own scopes,
+// e.g. bigquery.BigqueryInsertdataScope instead of the broader
+// bigquery.BigqueryScope.
+//
+// By default the configuration's scopes are requested if set,
+// otherwise bigquery.BigqueryInsertdataScope,
+// which is the minimal scope required for streaming inserts.
+//
+// NOTE at least one scope must be given.
+// Token sources already have their scopes set, e.g. google.DefaultTokenSource(),
+// so it can't be used with NewAsyncWorkerGroupWithTokenSource().
+func SetAsyncScopes(scopes ...string) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if len(scopes) == 0 {
+			return errors.New("at least one scope must be given")
+		}
+		for _, scope := range scopes {
+			if scope == "" {
+				return errors.New("scopes must be non-empty strings")
+			}
+		}
+		s.scopes = append([]string(nil), scopes...)
+		return nil
+	}
+}
+
+// SetAsyncVerifyCredentials sets whether to verify credentials when
+// constructing the AsyncWorkerGroup, failing fast with a CredentialsError
+// instead of failing every insert after Start(),
+// e.g. due to a misconfigured or deleted service account key.
+//
+// Credentials are verified by fetching an OAuth2 token,
+// which requires a request to Google's OAuth2 server,
+// but no request to BigQuery. Permissions to insert to specific tables
+// are thus not verified, since tables are only known once rows are enqueued,
+// and the minimal insert scope doesn't allow reading table metadata.
+//
+// The default value is false, which doesn't verify credentials.
+func SetAsyncVerifyCredentials(verify bool) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		s.verifyCredentials = verify
+		return nil
+	}
+}
+
+// SetAsyncSchema sets the schema of given table,
+// for validating its rows before sending them to BigQuery.
+// Use it multiple times for validating rows of multiple tables.
+//
+// Invalid rows are reported to the error channel along with the rest of
+// the insert operation's errors, without being sent to BigQuery.
+//
+// See SetSyncSchema() for more info.
+func SetAsyncSchema(projectID, datasetID, tableID string, schema *bigquery.TableSchema) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if schema == nil {
+			return errors.New("schema is nil")
+		}
+		if s.schemas == nil {
+			s.schemas = map[tableKey]*bigquery.TableSchema{}
+		}
+		s.schemas[tableKey{projectID, datasetID, tableID}] = schema
+		return nil
+	}
+}
+
+// SetAsyncRowSizer sets a function estimating a row's size in bytes,
+// called by all workers concurrently.
+//
+// See SetSyncRowSizer() for more info.
+func SetAsyncRowSizer(sizer func(Row) int) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if sizer == nil {
+			return errors.New("row sizer is nil")
+		}
+		s.sizer = sizer
+		return nil
+	}
+}
+
+// SetAsyncRowTransform sets a function transforming every row before insert,
+// called by all workers concurrently.
+//
+// See SetSyncRowTransform() for more info.
+func SetAsyncRowTransform(transform func(Row) (Row, error)) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if transform == nil {
+			return errors.New("row transform is nil")
+		}
+		s.transform = transform
+		return nil
+	}
+}
+
+// SetAsyncHighWaterMark sets a function called once the row channel's depth
+// crosses given fraction of its capacity, e.g. 0.8 for 80%,
+// so producers can slow down before enqueueing blocks.
+//
+// It's called again only after the depth has recovered below the
+// low water mark, set using SetAsyncLowWaterMark(), or half the high water mark
+// by default, so it isn't called repeatedly while the depth hovers around it.
+//
+// The depth is observed whenever a row is enqueued or read by a worker,
+// and the callback is called synchronously by whichever does so,
+// so it should return quickly. Water mark callbacks are never called
+// concurrently. With a shard key set using SetAsyncShardKey(),
+// the depth and capacity are those of a single worker's row channel.
+//
+// NOTE fraction must be within (0, 1], and the function must not be nil.
+func SetAsyncHighWaterMark(fraction float64, cb func(depth, capacity int)) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if fraction <= 0 || fraction > 1 {
+			return errors.New("high water mark must be within (0, 1]")
+		}
+		if cb == nil {
+			return errors.New("high water mark callback is nil")
+		}
+		s.highWaterMark = fraction
+		s.onHighWaterMark = cb
+		return nil
+	}
+}
+
+// SetAsyncLowWaterMark sets a function called once the row channel's depth
+// recovers below given fraction of its capacity, after having crossed
+// the high water mark set using SetAsyncHighWaterMark().
+//
+// See SetAsyncHighWaterMark() for more info.
+//
+// NOTE fraction must be within [0, 1) and below the high water mark,
+// and the function must not be nil.
+func SetAsyncLowWaterMark(fraction float64, cb func(depth, capacity int)) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if fraction < 0 || fraction >= 1 {
+			return errors.New("low water mark must be within [0, 1)")
+		}
+		if cb == nil {
+			return errors.New("low water mark callback is nil")
+		}
+		s.lowWaterMark = fraction
+		s.onLowWaterMark = cb
+		return nil
+	}
+}
+
+// SetAsyncPropagateContext sets whether the context given to EnqueueContext()
+// and EnqueueBatchContext() travels with the enqueued rows to their insert,
+// so insert requests are traced as part of the producer's span,
+// and respect the producer's deadline.
+//
+// Since rows enqueued with different contexts are inserted together,
+// the contexts of a table's rows in an insert operation are merged:
+//   - Values, e.g. trace spans, are those of the first row's context.
+//   - The deadline is the latest deadline among the rows' contexts,
+//     bounding the entire insert operation including retries,
+//     if all rows have one. This way no row's deadline aborts the insert
+//     of other rows before their own deadline, e.g. those of other producers.
+//     Rows whose insert operation exceeded it are spilled if set.
+//   - Rows whose context's deadline has already passed once their
+//     insert operation starts aren't inserted. They're reported as dropped
+//     with DropContextDone instead, and spilled if set.
+//   - Rows' contexts canceled before their deadline are ignored,
+//     since other rows are still to be inserted.
+//
+// Rows enqueued using Enqueue() or without a context are unaffected.
+// Contexts are retained until their rows have been inserted.
+//
+// Default is false, inserting rows using the group's context only.
+func SetAsyncPropagateContext(propagate bool) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		s.propagateContext = propagate
+		return nil
+	}
+}
+
+// SetAsyncPreFlush sets a function called with the rows of every insert request
+// right before sending it, called by all workers concurrently.
+//
+// See SetSyncPreFlush() for more info.
+func SetAsyncPreFlush(preFlush func([]Row) []Row) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if preFlush == nil {
+			return errors.New("pre-flush is nil")
+		}
+		s.preFlush = preFlush
+		return nil
+	}
+}
+
+// SetAsyncDedupKey sets a function returning a key for every enqueued row,
+// collapsing rows with the same key buffered by a worker,
+// called by all workers concurrently.
+//
+// See SetSyncDedupKey() for more info.
+func SetAsyncDedupKey(key func(Row) string) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if key == nil {
+			return errors.New("dedup key is nil")
+		}
+		s.dedupKey = key
+		return nil
+	}
+}
+
+// SetAsyncCircuitBreaker sets a circuit breaker shared by all workers,
+// protecting BigQuery and the client while BigQuery is down.
+//
+// After failureThreshold consecutive insert requests have failed across all
+// workers, the circuit opens: inserts are short-circuited without sending
+// requests, and reported to the error channel with ErrCircuitOpen.
+// Once cooldown has passed, a single request is allowed as a probe.
+// The circuit is closed if it succeeds, and opened again otherwise.
+//
+// Only errors which would be retried count as failures,
+// i.e. according to IsRetryable() or SetAsyncRetryableFunc().
+// The circuit's state is reported by Stats().
+//
+// NOTE values must be positive.
+func SetAsyncCircuitBreaker(failureThreshold int, cooldown time.Duration) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if failureThreshold <= 0 {
+			return errors.New("circuit breaker failure threshold must be a positive int")
+		}
+		if cooldown <= 0 {
+			return errors.New("circuit breaker cooldown must be a positive time.Duration")
+		}
+		s.breaker = newCircuitBreaker(failureThreshold, cooldown)
+		return nil
+	}
+}
+
+// SetAsyncHealthThresholds sets the thresholds above which
+// AsyncWorkerGroup.Healthy() reports the group as unhealthy.
+//
+// The group is unhealthy if more than maxFailureRate of the insert requests
+// sent within the last window have failed, e.g. 0.5 for half of them,
+// or if at least maxBufferUsage of the buffer is used, e.g. 0.9 for 90%.
+// A failure rate is only computed for at least 5 recent requests.
+//
+// Defaults are DefaultAsyncHealthMaxFailureRate,
+// DefaultAsyncHealthMaxBufferUsage and DefaultAsyncHealthWindow.
+//
+// NOTE rates must be between 0 and 1, and window must be positive.
+func SetAsyncHealthThresholds(maxFailureRate, maxBufferUsage float64, window time.Duration) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if maxFailureRate < 0 || maxFailureRate > 1 {
+			return errors.New("health max failure rate must be a float between 0 and 1")
+		}
+		if maxBufferUsage <= 0 || maxBufferUsage > 1 {
+			return errors.New("health max buffer usage must be a float between 0 and 1")
+		}
+		if window <= 0 {
+			return errors.New("health window must be a positive time.Duration")
+		}
+		s.healthMaxFailureRate = maxFailureRate
+		s.healthMaxBufferUsage = maxBufferUsage
+		s.healthWindow = window
+		return nil
+	}
+}
+
+// SetAsyncRetryBudget limits retries of all workers to a fraction of
+// successful insert requests, preventing retries from multiplying the load
+// on BigQuery during an outage, similar to gRPC's retry throttling.
+//
+// Every successful insert request allows ratio retries,
+// e.g. 0.1 for a retry every 10 successful requests,
+// and minPerSec retries per second are always allowed.
+// Once exhausted, failed inserts aren't retried,
+// and are reported to the error channel with ErrRetryBudgetExhausted.
+// The remaining budget is reported by Stats().
+//
+// NOTE ratio must be between 0 and 1, and minPerSec must be non-negative.
+func SetAsyncRetryBudget(ratio float64, minPerSec int) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if ratio < 0 || ratio > 1 {
+			return errors.New("retry budget ratio must be a float between 0 and 1")
+		}
+		if minPerSec < 0 {
+			return errors.New("retry budget min retries per second must be a non-negative int")
+		}
+		s.retryBudget = newRetryBudget(ratio, minPerSec)
+		return nil
+	}
+}
+
+// SetAsyncIgnoreUnknownValues sets whether to accept rows that contain values
+// that do not match the table schema.  The unknown values are ignored.
+//
+// Default is false, which treats unknown values as errors.
+func SetAsyncIgnoreUnknownValues(ignore bool) AsyncOptionFunc {
+	return func(w *AsyncWorkerGroup) error {
+		w.ignoreUnknownValues = ignore
+		return nil
+	}
+}
+
+// SetAsyncSkipInvalidRows sets whether to insert all valid rows of a request,
+// even if invalid rows exist.
+//
+// The default value is false,
+// which causes the entire request to fail if any invalid rows exist.
+func SetAsyncSkipInvalidRows(skip bool) AsyncOptionFunc {
+	return func(w *AsyncWorkerGroup) error {
+		w.skipInvalidRows = skip
+		return nil
+	}
+}
+
+// SetAsyncTableOptions sets the insert request options of given table
+// for all workers, overriding the ones set using SetAsyncIgnoreUnknownValues()
+// and SetAsyncSkipInvalidRows().
+//
+// See SetSyncTableOptions() for more info.
+func SetAsyncTableOptions(projectID, datasetID, tableID string, opts TableOptions) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if s.tableOpts == nil {
+			s.tableOpts = map[tableKey]TableOptions{}
+		}
+		s.tableOpts[tableKey{projectID, datasetID, tableID}] = opts
+		return nil
+	}
+}
+
+// SetAsyncRetryStopped sets whether to retry rows rejected with the "stopped"
+// reason, i.e. valid rows not inserted due to other invalid rows
+// in the same request.
+//
+// See SetSyncRetryStopped() for more info.
+func SetAsyncRetryStopped(retry bool) AsyncOptionFunc {
+	return func(w *AsyncWorkerGroup) error {
+		w.retryStopped = retry
+		return nil
+	}
+}
+
+// SetAsyncCancelInFlightOnClose sets whether Close() and CloseWithSummary()
+// are bounded by the close grace period set using SetAsyncCloseGracePeriod(),
+// as if CloseContext() was called with it, e.g. for a bounded shutdown
+// even if BigQuery is unresponsive.
+//
+// Once the grace period has passed, insert operations in progress
+// are canceled instead of waited for, and their rows are abandoned
+// along with remaining rows. Abandoned rows are logged, and reported
+// by CloseSummary.UndrainedRows.
+//
+// NOTE a close grace period must be set as well.
+func SetAsyncCancelInFlightOnClose(cancel bool) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		s.cancelInFlightOnClose = cancel
+		return nil
+	}
+}
+
+// SetAsyncRetryTransientRows sets whether to retry rows rejected due to
+// transient errors, without the rows already inserted in the same request.
+//
+// See SetSyncRetryTransientRows() for more info.
+func SetAsyncRetryTransientRows(retry bool) AsyncOptionFunc {
+	return func(w *AsyncWorkerGroup) error {
+		w.retryTransientRows = retry
+		return nil
+	}
+}
+
+// SetAsyncCloseGracePeriod sets the maximum time spent inserting remaining
+// rows when closing the AsyncWorkerGroup due to StartContext()'s context
+// being done. Closing isn't bounded by default.
+// See SetAsyncCancelInFlightOnClose() for bounding Close() as well.
+//
+// NOTE value must be a positive time.Duration.
+func SetAsyncCloseGracePeriod(d time.Duration) AsyncOptionFunc {
+	return func(s *AsyncWorkerGroup) error {
+		if d <= 0 {
+			return errors.New("close grace period must be a positive time.Duration")
+		}
+		s.closeGracePeriod = d
 		return nil
 	}
 }
@@ -1,14 +1,25 @@
 package bqstreamer
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
 	bigquery "google.golang.org/api/bigquery/v2"
 
 	"github.com/stretchr/testify/assert"
@@ -26,6 +37,10 @@ func TestAsyncWorkerGroupNew(t *testing.T) {
 	var err error
 	_, err = NewAsyncWorkerGroup(nil, false)
 	assert.EqualError(err, "jwt.Config is nil")
+	_, err = NewAsyncWorkerGroupWithTokenSource(nil, false)
+	assert.EqualError(err, "oauth2.TokenSource is nil")
+	_, err = NewAsyncWorkerGroupWithClient(nil)
+	assert.EqualError(err, "http.Client is nil")
 	errChan := make(chan *InsertErrors)
 	_, err = newAsyncWorkerGroup(
 		nil,
@@ -73,6 +88,38 @@ func TestAsyncWorkerGroupNew(t *testing.T) {
 	}
 }
 
+// TestAsyncWorkerGroupValidate tests invalid configurations return an error.
+func TestAsyncWorkerGroupValidate(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	valid := func() *AsyncWorkerGroup {
+		return &AsyncWorkerGroup{numWorkers: 1, maxRows: 1, maxDelay: time.Second}
+	}
+	assert.NoError(valid().validate())
+
+	tests := []struct {
+		modify func(m *AsyncWorkerGroup)
+		err    string
+	}{
+		{func(m *AsyncWorkerGroup) { m.numWorkers = 0 }, "number of workers must be set to a positive int using SetAsyncNumWorkers()"},
+		{func(m *AsyncWorkerGroup) { m.maxRows = 0 }, "max rows must be set to a positive int using SetAsyncMaxRows()"},
+		{func(m *AsyncWorkerGroup) { m.maxDelay = 0 }, "max delay must be set to a positive time.Duration using SetAsyncMaxDelay()"},
+		{func(m *AsyncWorkerGroup) { m.maxDelay = -time.Second }, "max delay must be set to a positive time.Duration using SetAsyncMaxDelay()"},
+		{func(m *AsyncWorkerGroup) { m.maxRetries = -1 }, "max retries must be a non-negative int"},
+	}
+	for _, test := range tests {
+		m := valid()
+		test.modify(m)
+		assert.EqualError(m.validate(), test.err)
+	}
+
+	// Test missing options are reported on initialization.
+	_, err := newAsyncWorkerGroup(func() *http.Client { return &http.Client{} }, SetAsyncNumWorkers(1), SetAsyncMaxDelay(time.Second))
+	assert.EqualError(err, "max rows must be set to a positive int using SetAsyncMaxRows()")
+}
+
 // TestAsyncWorkerGroupClose tests calling Close() closes all workers.
 func TestAsyncWorkerGroupClose(t *testing.T) {
 	t.Parallel()
@@ -198,3 +245,2551 @@ func TestAsyncWorkerGroupQueueRowStreamer(t *testing.T) {
 		},
 		ps)
 }
+
+// TestAsyncWorkerGroupMultipleTables tests rows enqueued to different tables
+// are inserted in a separate request per table.
+func TestAsyncWorkerGroupMultipleTables(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Track amount of insert requests and rows per table.
+	var (
+		mu       sync.Mutex
+		requests = map[string]int{}
+		rows     = map[string]int{}
+	)
+
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			pID, dID, tID := getInsertMetadata(req.URL.Path)
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+
+			key := pID + "." + dID + "." + tID
+			mu.Lock()
+			requests[key]++
+			rows[key] += len(tableReq.Rows)
+			mu.Unlock()
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Use a single worker so all rows are inserted in the same batch.
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+
+	// Interleave rows for 3 different tables.
+	for i := 0; i < 2; i++ {
+		require.NoError(m.Enqueue(NewRow("p1", "d1", "t1", map[string]bigquery.JsonValue{"k": i})))
+		require.NoError(m.Enqueue(NewRow("p1", "d2", "t1", map[string]bigquery.JsonValue{"k": i})))
+		require.NoError(m.Enqueue(NewRow("p2", "d1", "t1", map[string]bigquery.JsonValue{"k": i})))
+	}
+
+	// Close forces a single insert of all enqueued rows.
+	m.Start()
+	m.Close()
+
+	assert.Equal(map[string]int{"p1.d1.t1": 1, "p1.d2.t1": 1, "p2.d1.t1": 1}, requests)
+	assert.Equal(map[string]int{"p1.d1.t1": 2, "p1.d2.t1": 2, "p2.d1.t1": 2}, rows)
+}
+
+// TestAsyncWorkerGroupFlush tests Flush() inserts all enqueued rows
+// before returning, and the group remains usable afterwards.
+func TestAsyncWorkerGroupFlush(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Track amount of inserted rows.
+	var inserted int64
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+			atomic.AddInt64(&inserted, int64(len(tableReq.Rows)))
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Use thresholds that won't trigger an insert during the test.
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(3), SetAsyncMaxRows(100), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	m.Start()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": i})))
+	}
+	require.NoError(m.Flush())
+	assert.Equal(int64(5), atomic.LoadInt64(&inserted))
+
+	// Group should still accept and flush rows.
+	require.NoError(m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": 5})))
+	require.NoError(m.Flush())
+	assert.Equal(int64(6), atomic.LoadInt64(&inserted))
+
+	m.Close()
+	assert.Equal(ErrGroupClosed, m.Flush())
+}
+
+// TestAsyncWorkerGroupFlushTable tests only the given table's rows
+// are inserted, keeping rows of other tables enqueued.
+func TestAsyncWorkerGroupFlushTable(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Track amount of inserted rows per table.
+	var mu sync.Mutex
+	inserted := map[string]int{}
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			_, _, tID := getInsertMetadata(req.URL.Path)
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+			mu.Lock()
+			inserted[tID] += len(tableReq.Rows)
+			mu.Unlock()
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Use thresholds that won't trigger an insert during the test.
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(3), SetAsyncMaxRows(100), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	m.Start()
+
+	assert.EqualError(m.FlushTable("d.t1"), "table must be given as project.dataset.table")
+
+	for i := 0; i < 5; i++ {
+		require.NoError(m.Enqueue(NewRow("p", "d", "t1", map[string]bigquery.JsonValue{"k": i})))
+		require.NoError(m.Enqueue(NewRow("p", "d", "t2", map[string]bigquery.JsonValue{"k": i})))
+	}
+	require.NoError(m.FlushTable("p.d.t1"))
+	mu.Lock()
+	assert.Equal(map[string]int{"t1": 5}, inserted)
+	mu.Unlock()
+	assert.Equal(5, m.Stats().QueuedRows)
+
+	require.NoError(m.Flush())
+	mu.Lock()
+	assert.Equal(map[string]int{"t1": 5, "t2": 5}, inserted)
+	mu.Unlock()
+
+	m.Close()
+	assert.Equal(ErrGroupClosed, m.FlushTable("p.d.t1"))
+}
+
+// TestAsyncWorkerGroupWaitIdle tests WaitIdle() returns once all enqueued
+// rows have been inserted, without closing the group.
+func TestAsyncWorkerGroupWaitIdle(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Track amount of inserted rows.
+	var inserted int64
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+			atomic.AddInt64(&inserted, int64(len(tableReq.Rows)))
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(3), SetAsyncMaxRows(2), SetAsyncMaxDelay(50*time.Millisecond), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	m.Start()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": i})))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(m.WaitIdle(ctx))
+	assert.Equal(int64(5), atomic.LoadInt64(&inserted))
+
+	// Test WaitIdle() times out while rows are held back by pausing.
+	m.Pause()
+	require.NoError(m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": 5})))
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, m.WaitIdle(ctx))
+
+	m.Resume()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(m.WaitIdle(ctx))
+	assert.Equal(int64(6), atomic.LoadInt64(&inserted))
+
+	m.Close()
+	assert.Equal(ErrGroupClosed, m.WaitIdle(context.Background()))
+}
+
+// TestAsyncWorkerGroupMaxConcurrentInserts tests insert requests are not
+// executed simultaneously by more workers than allowed.
+func TestAsyncWorkerGroupMaxConcurrentInserts(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Track amount of in-flight requests.
+	var inFlight, maxInFlight, requests int32
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			atomic.AddInt32(&requests, 1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			// Keep the request in flight long enough for other workers to overlap.
+			time.Sleep(20 * time.Millisecond)
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(4), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncMaxConcurrentInserts(2))
+	require.NoError(err)
+
+	// Start first, since the row channel can't hold all rows.
+	m.Start()
+	for i := 0; i < 8; i++ {
+		require.NoError(m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": i})))
+	}
+	m.Close()
+
+	assert.Equal(int32(8), atomic.LoadInt32(&requests))
+	assert.Equal(int32(2), atomic.LoadInt32(&maxInFlight))
+}
+
+// TestAsyncWorkerGroupMaxInFlightBytes tests insert requests are not
+// in flight simultaneously if their accumulated size would exceed the limit,
+// and in-flight bytes are reported in Stats().
+func TestAsyncWorkerGroupMaxInFlightBytes(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Track amount of in-flight requests, and their reported bytes.
+	var inFlight, maxInFlight, requests int32
+	var m *AsyncWorkerGroup
+	var inFlightBytes int64
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			atomic.AddInt32(&requests, 1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			atomic.StoreInt64(&inFlightBytes, m.Stats().InFlightBytes)
+			// Keep the request in flight long enough for other workers to overlap.
+			time.Sleep(20 * time.Millisecond)
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Every request holds a single row, so only one fits in flight at once.
+	var err error
+	m, err = newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(4), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncMaxInFlightBytes(requestOverheadBytes+100))
+	require.NoError(err)
+
+	// Start first, since the row channel can't hold all rows.
+	m.Start()
+	for i := 0; i < 4; i++ {
+		require.NoError(m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": i})))
+	}
+	m.Close()
+
+	assert.Equal(int32(4), atomic.LoadInt32(&requests))
+	assert.Equal(int32(1), atomic.LoadInt32(&maxInFlight))
+	assert.True(atomic.LoadInt64(&inFlightBytes) > requestOverheadBytes)
+	assert.Equal(int64(0), m.Stats().InFlightBytes)
+}
+
+// TestAsyncWorkerGroupStats tests queued rows, in-flight inserts and insert
+// counters are reported while rows are being inserted.
+//
+// NOTE run this test with -race.
+func TestAsyncWorkerGroupStats(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that fails the first request,
+	// and blocks the retry until released.
+	var requests int32
+	release := make(chan struct{})
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			switch atomic.AddInt32(&requests, 1) {
+			case 1:
+				res.StatusCode = 503
+			case 2:
+				<-release
+			}
+
+			return &res, nil
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(2), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Millisecond), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	assert.Equal(Stats{}, m.Stats())
+	m.Start()
+
+	// The worker inserts the first 2 rows, leaving the last in the row channel.
+	for i := 0; i < 3; i++ {
+		require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})))
+	}
+
+	// Wait for the retry to be in flight.
+	for m.Stats().InFlightInserts == 0 {
+		time.Sleep(1 * time.Millisecond)
+	}
+	stats := m.Stats()
+	assert.Equal(int64(1), stats.Latency.Count())
+	stats.Latency = LatencyHistogram{}
+	assert.Equal(Stats{QueuedRows: 3, InFlightInserts: 1, EnqueuedRows: 2, RetriedInserts: 1}, stats)
+
+	close(release)
+	m.Close()
+	stats = m.Stats()
+	assert.Equal(int64(3), stats.Latency.Count())
+	stats.Latency = LatencyHistogram{}
+	assert.Equal(Stats{EnqueuedRows: 3, InsertedRows: 3, RetriedInserts: 1}, stats)
+	assert.Equal(uint64(3), m.RowsEnqueued())
+	assert.Equal(uint64(3), m.RowsInserted())
+	assert.Equal(uint64(0), m.RowsRejected())
+	assert.Equal(uint64(1), m.InsertsRetried())
+	assert.Equal(uint64(0), m.InsertsFailed())
+}
+
+// TestAsyncWorkerGroupCircuitBreaker tests inserts are short-circuited
+// once enough insert requests have failed.
+func TestAsyncWorkerGroupCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that always fails.
+	var requests int32
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&requests, 1)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 503,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	errChan := make(chan *InsertErrors, 10)
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Millisecond), SetAsyncMaxRetries(10), SetAsyncErrorChannel(errChan), SetAsyncCircuitBreaker(2, time.Minute))
+	require.NoError(err)
+	m.Start()
+
+	// The first insert fails twice, opening the circuit,
+	// and the second insert is short-circuited altogether.
+	for i := 0; i < 2; i++ {
+		require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})))
+	}
+	m.Close()
+
+	assert.Equal(int32(2), atomic.LoadInt32(&requests))
+	assert.Equal(CircuitOpen, m.Stats().Circuit)
+	require.Len(errChan, 2)
+	for i := 0; i < 2; i++ {
+		tables := (<-errChan).All()
+		require.Len(tables, 1)
+		attempts := tables[0].Attempts()
+		assert.Equal(ErrCircuitOpen, attempts[len(attempts)-1].Error())
+	}
+}
+
+// TestAsyncWorkerGroupHealthy tests unhealthy groups are reported as such,
+// along with the reason.
+func TestAsyncWorkerGroupHealthy(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	row := NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})
+
+	// Test a saturated row channel.
+	// Rows are left in the row channel until the group is started.
+	m, err := NewFakeWorkerGroup(&FakeBigQuery{}, SetAsyncMaxRows(2))
+	require.NoError(err)
+	healthy, err := m.Healthy()
+	assert.True(healthy)
+	assert.NoError(err)
+	require.NoError(m.Enqueue(row))
+	healthy, err = m.Healthy()
+	assert.True(healthy)
+	assert.NoError(err)
+	require.NoError(m.Enqueue(row))
+	healthy, err = m.Healthy()
+	assert.False(healthy)
+	assert.Equal(ErrBufferSaturated, err)
+
+	m.Start()
+	m.Close()
+	healthy, err = m.Healthy()
+	assert.False(healthy)
+	assert.Equal(ErrGroupClosed, err)
+
+	// Test failed inserts, retried until the failure rate is exceeded.
+	fake := &FakeBigQuery{FailInsert: func(p, d, t string) int { return 503 }}
+	m, err = NewFakeWorkerGroup(fake, SetAsyncMaxRetries(4))
+	require.NoError(err)
+	m.Start()
+	require.NoError(m.Enqueue(row))
+	require.NoError(m.Flush())
+	assert.Equal(5, fake.Requests())
+	healthy, err = m.Healthy()
+	assert.False(healthy)
+	assert.Equal(ErrFailureRateExceeded, err)
+	m.Close()
+
+	// Test an open circuit.
+	m, err = NewFakeWorkerGroup(fake, SetAsyncCircuitBreaker(1, time.Minute))
+	require.NoError(err)
+	m.Start()
+	require.NoError(m.Enqueue(row))
+	require.NoError(m.Flush())
+	healthy, err = m.Healthy()
+	assert.False(healthy)
+	assert.Equal(ErrCircuitOpen, err)
+	m.Close()
+}
+
+// TestAsyncWorkerGroupRetryBudget tests failed inserts aren't retried
+// once the retry budget has been exhausted.
+func TestAsyncWorkerGroupRetryBudget(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that always fails.
+	var requests int32
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&requests, 1)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 503,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	errChan := make(chan *InsertErrors, 10)
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Millisecond), SetAsyncMaxRetries(10), SetAsyncErrorChannel(errChan), SetAsyncRetryBudget(0.1, 2))
+	require.NoError(err)
+	assert.Equal(2.0, m.Stats().RetryBudget)
+	m.Start()
+
+	// The first insert is retried twice, exhausting the budget,
+	// and the second insert isn't retried at all.
+	for i := 0; i < 2; i++ {
+		require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})))
+	}
+	m.Close()
+
+	assert.Equal(int32(4), atomic.LoadInt32(&requests))
+	assert.True(m.Stats().RetryBudget < 1)
+	assert.Equal(uint64(2), m.InsertsRetried())
+	assert.Equal(uint64(2), m.InsertsFailed())
+	require.Len(errChan, 2)
+	for i := 0; i < 2; i++ {
+		tables := (<-errChan).All()
+		require.Len(tables, 1)
+		attempts := tables[0].Attempts()
+		assert.Equal(ErrRetryBudgetExhausted, attempts[len(attempts)-1].Error())
+	}
+}
+
+// TestAsyncWorkerGroupErrorHandler tests the error handler is called
+// with all insert errors, without an error channel backing up.
+func TestAsyncWorkerGroupErrorHandler(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that always fails.
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 503,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	newClient := func() *http.Client { return &client }
+	_, err := newAsyncWorkerGroup(newClient, SetAsyncNumWorkers(1), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncErrorChannel(make(chan *InsertErrors)), SetAsyncErrorHandler(func(*InsertErrors) {}))
+	assert.EqualError(err, "error handler can't be used with an error channel")
+
+	var handled []*InsertErrors
+	m, err := newAsyncWorkerGroup(newClient, SetAsyncNumWorkers(1), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Millisecond), SetAsyncMaxRetries(0), SetAsyncErrorHandler(func(insertErrs *InsertErrors) {
+		handled = append(handled, insertErrs)
+	}))
+	require.NoError(err)
+	m.Start()
+
+	// Report more errors than the error channel's capacity.
+	for i := 0; i < 10; i++ {
+		require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})))
+	}
+	m.Close()
+
+	require.Len(handled, 10)
+	for _, insertErrs := range handled {
+		assert.Len(insertErrs.All(), 1)
+	}
+	assert.Equal(uint64(10), m.RowsEnqueued())
+	assert.Equal(uint64(10), m.InsertsFailed())
+}
+
+// TestAsyncWorkerGroupRestart tests a closed group can be restarted,
+// and keeps inserting rows afterwards.
+func TestAsyncWorkerGroupRestart(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that always return an "OK" empty response.
+	var requests int32
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&requests, 1)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	var handled int32
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(2), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncErrorHandler(func(*InsertErrors) {
+		atomic.AddInt32(&handled, 1)
+	}))
+	require.NoError(err)
+	assert.EqualError(m.Restart(), "AsyncWorkerGroup must be closed before restarting")
+
+	m.Start()
+	require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})))
+	m.Close()
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(ErrGroupClosed, m.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k0": "v0"})))
+
+	require.NoError(m.Restart())
+	require.Len(m.workers, 2)
+	require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k0": "v0"})))
+	require.NoError(m.Flush())
+	assert.Equal(int32(2), atomic.LoadInt32(&requests))
+	m.Close()
+
+	assert.Equal(int32(2), atomic.LoadInt32(&handled))
+}
+
+// TestAsyncWorkerGroupRestartClosing tests restarting a group
+// which is still closing returns an error.
+func TestAsyncWorkerGroupRestartClosing(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &http.Client{} }, SetAsyncNumWorkers(1), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+
+	// Close() blocks until Start() has been called.
+	closed := make(chan struct{})
+	go func() {
+		m.Close()
+		close(closed)
+	}()
+	for isClosed := false; !isClosed; time.Sleep(1 * time.Millisecond) {
+		m.mu.RLock()
+		isClosed = m.isClosed
+		m.mu.RUnlock()
+	}
+	assert.EqualError(m.Restart(), "AsyncWorkerGroup hasn't finished closing")
+
+	m.Start()
+	<-closed
+	assert.NoError(m.Restart())
+	m.Close()
+}
+
+// TestAsyncWorkerGroupEnqueueContext tests EnqueueContext() returns
+// when the context is done or the group is closed,
+// instead of blocking on a full row channel.
+func TestAsyncWorkerGroupEnqueueContext(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that always return an "OK" empty response.
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Use a single worker with a single row buffer,
+	// and don't start it so the row channel fills up.
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Second), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	require.Equal(1, cap(m.rowChan))
+
+	row := NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})
+	require.NoError(m.EnqueueContext(context.Background(), row))
+
+	// Test a full row channel blocks until the context deadline passes.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, m.EnqueueContext(ctx, row))
+
+	// Test a closed group returns immediately.
+	// Closing the group drains and inserts the enqueued row.
+	m.Start()
+	m.Close()
+	assert.Equal(ErrGroupClosed, m.EnqueueContext(context.Background(), row))
+	assert.Empty(m.rowChan)
+}
+
+// TestAsyncWorkerGroupEnqueueBatch tests EnqueueBatchContext() enqueues rows
+// until the row channel is full and the context is done,
+// reporting how many rows were enqueued.
+func TestAsyncWorkerGroupEnqueueBatch(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that always return an "OK" empty response.
+	var requests int32
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&requests, 1)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Don't start the workers so the row channel fills up.
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(3), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	require.Equal(3, cap(m.rowChan))
+
+	rows := make([]Row, 5)
+	for i := range rows {
+		rows[i] = NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})
+	}
+	n, err := m.EnqueueBatch(rows[:2])
+	require.NoError(err)
+	assert.Equal(2, n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	n, err = m.EnqueueBatchContext(ctx, rows[2:])
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Equal(1, n)
+
+	// Closing the group drains and inserts the enqueued rows.
+	m.Start()
+	m.Close()
+	assert.Equal(int32(1), atomic.LoadInt32(&requests))
+	assert.Equal(uint64(3), m.RowsInserted())
+
+	n, err = m.EnqueueBatch(rows)
+	assert.Equal(ErrGroupClosed, err)
+	assert.Equal(0, n)
+}
+
+// TestAsyncWorkerGroupEnqueueBatchValidated tests only valid rows are enqueued,
+// and an error is returned for every invalid row.
+func TestAsyncWorkerGroupEnqueueBatchValidated(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	schema := &bigquery.TableSchema{
+		Fields: []*bigquery.TableFieldSchema{{Name: "k0", Type: "STRING"}},
+	}
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(3), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncSchema("p", "d", "t", schema))
+	require.NoError(err)
+
+	rows := []Row{
+		NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}),
+		NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}),
+		NewRowWithID("p", "d", "", "id2", map[string]bigquery.JsonValue{"k0": "v0"}),
+		NewRowWithID("p", "d", "t", "id3", nil),
+		NewRowWithID("p", "d", "t", "id4", map[string]bigquery.JsonValue{"k0": "v0"}),
+	}
+	errs := m.EnqueueBatchValidated(rows)
+	require.Len(errs, 5)
+	assert.NoError(errs[0])
+	assert.EqualError(errs[1], "Row rejected by table p.d.t: invalid: no such field.")
+	assert.EqualError(errs[2], "Row rejected by table p.d.: invalid: missing project, dataset or table ID.")
+	assert.EqualError(errs[3], "Row rejected by table p.d.t: invalid: missing row data.")
+	assert.NoError(errs[4])
+	assert.Len(m.rowChan, 2)
+
+	m.Start()
+	m.Close()
+	assert.Equal(uint64(2), m.RowsInserted())
+
+	errs = m.EnqueueBatchValidated(rows[:2])
+	assert.Equal(ErrGroupClosed, errs[0])
+	assert.IsType(&RowRejectedError{}, errs[1])
+}
+
+// TestAsyncWorkerGroupPropagateContext tests rows are inserted using
+// the contexts they've been enqueued with, merging their values and deadlines.
+func TestAsyncWorkerGroupPropagateContext(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	type contextKey struct{}
+	for _, propagate := range []bool{false, true} {
+		// Record values and deadlines of insert requests' contexts.
+		var (
+			mu        sync.Mutex
+			values    []interface{}
+			deadlines []time.Time
+		)
+		client := http.Client{
+			Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+				mu.Lock()
+				values = append(values, req.Context().Value(contextKey{}))
+				deadline, _ := req.Context().Deadline()
+				deadlines = append(deadlines, deadline)
+				mu.Unlock()
+
+				res := http.Response{
+					Header:     make(http.Header),
+					Request:    req,
+					StatusCode: 200,
+					// Empty JSON body, meaning "no errors".
+					Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+				return &res, nil
+			})}
+
+		m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncPropagateContext(propagate))
+		require.NoError(err)
+		assert.Equal(propagate, m.Config().PropagateContext)
+
+		deadline := time.Now().Add(2 * time.Minute)
+		later, cancelLater := context.WithDeadline(context.WithValue(context.Background(), contextKey{}, "producer"), deadline)
+		earlier, cancelEarlier := context.WithTimeout(context.Background(), 1*time.Minute)
+		require.NoError(m.EnqueueContext(later, NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": 1})))
+		require.NoError(m.EnqueueContext(earlier, NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": 2})))
+		// Rows of another table without a context aren't bounded.
+		require.NoError(m.EnqueueContext(later, NewRow("p", "d", "t2", map[string]bigquery.JsonValue{"k": 3})))
+		require.NoError(m.Enqueue(NewRow("p", "d", "t2", map[string]bigquery.JsonValue{"k": 0})))
+		m.Start()
+		m.Close()
+		cancelLater()
+		cancelEarlier()
+
+		require.Len(values, 2)
+		if !propagate {
+			for i := range values {
+				assert.Nil(values[i])
+				assert.True(deadlines[i].IsZero())
+			}
+			continue
+		}
+
+		// Values are of the first row with a context,
+		// and the deadline is the latest one if all rows have one.
+		assert.Equal([]interface{}{"producer", "producer"}, values)
+		assert.ElementsMatch([]time.Time{deadline, {}}, deadlines)
+	}
+}
+
+// TestAsyncWorkerGroupPropagateContextExpired tests a row whose context's
+// deadline has passed before its insert is dropped and spilled,
+// without failing the insert of other rows of its table.
+func TestAsyncWorkerGroupPropagateContextExpired(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var (
+		mu      sync.Mutex
+		spilled []Row
+		dropped = map[DropReason][]Row{}
+	)
+	spill := func(rows []Row) error {
+		mu.Lock()
+		defer mu.Unlock()
+		spilled = append(spilled, rows...)
+		return nil
+	}
+	handler := func(row Row, reason DropReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		dropped[reason] = append(dropped[reason], row)
+	}
+
+	fake := FakeBigQuery{}
+	m, err := NewFakeWorkerGroup(&fake, SetAsyncNumWorkers(1), SetAsyncPropagateContext(true), SetAsyncSpillHandler(spill), SetAsyncDropHandler(handler))
+	require.NoError(err)
+
+	// Rows are left in the row channel until the group is started,
+	// by which time the first row's deadline has passed.
+	expired, cancelExpired := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelExpired()
+	live, cancelLive := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancelLive()
+	require.NoError(m.EnqueueContext(expired, NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})))
+	require.NoError(m.EnqueueContext(live, NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"})))
+	<-expired.Done()
+	m.Start()
+	m.Close()
+
+	rows := fake.Rows()
+	require.Len(rows, 1)
+	assert.Equal("id1", rows[0].InsertID)
+	require.Len(spilled, 1)
+	assert.Equal("id0", spilled[0].InsertID)
+	require.Len(dropped[DropContextDone], 1)
+	assert.Equal("id0", dropped[DropContextDone][0].InsertID)
+	assert.Len(dropped, 1)
+
+	// Test rows whose insert exceeds their deadline are spilled.
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})}
+	spilled = nil
+	m, err = newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncPropagateContext(true), SetAsyncSpillHandler(spill))
+	require.NoError(err)
+	m.Start()
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	require.NoError(m.EnqueueContext(short, NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k2": "v2"})))
+	m.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(spilled, 1)
+	assert.Equal("id2", spilled[0].InsertID)
+}
+
+// TestAsyncWorkerGroupConfig tests the resolved configuration is returned,
+// including defaults and the current amount of workers.
+func TestAsyncWorkerGroupConfig(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	client := http.Client{}
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(2), SetAsyncMaxRows(3), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncSkipInvalidRows(true), SetAsyncGzip(true))
+	require.NoError(err)
+
+	config := m.Config()
+	assert.Equal(2, config.NumWorkers)
+	assert.Equal(3, config.MaxRows)
+	assert.Equal(1*time.Minute, config.MaxDelay)
+	assert.Equal(10, config.MaxRetries)
+	assert.Equal(1*time.Second, config.RetryInterval)
+	assert.False(config.IgnoreUnknownValues)
+	assert.True(config.SkipInvalidRows)
+	assert.True(config.Gzip)
+	assert.Equal(DefaultAsyncDialTimeout, config.DialTimeout)
+	assert.Equal(SharedQueue, config.DispatchStrategy)
+
+	require.NoError(m.SetNumWorkers(4))
+	assert.Equal(4, m.Config().NumWorkers)
+}
+
+// TestAsyncWorkerGroupStartContext tests the AsyncWorkerGroup
+// inserts remaining rows and closes once its context is done.
+func TestAsyncWorkerGroupStartContext(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	fake := &FakeBigQuery{}
+	m, err := NewFakeWorkerGroup(fake, SetAsyncMaxDelay(1*time.Minute), SetAsyncCloseGracePeriod(1*time.Second))
+	require.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.StartContext(ctx)
+	for i := 0; i < 3; i++ {
+		require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})))
+	}
+
+	select {
+	case <-m.Done():
+		assert.Fail("closed before context was done")
+	default:
+	}
+
+	cancel()
+	select {
+	case <-m.Done():
+	case <-time.After(5 * time.Second):
+		require.Fail("not closed after context was done")
+	}
+
+	assert.Len(fake.Rows(), 3)
+	assert.Equal(ErrGroupClosed, m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"})))
+
+	// Test closing before the context is done.
+	m, err = NewFakeWorkerGroup(fake)
+	require.NoError(err)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	m.StartContext(ctx)
+	m.Close()
+	<-m.Done()
+}
+
+// TestAsyncWorkerGroupReplay tests rows spilled while BigQuery is unavailable
+// can be replayed once it has recovered.
+func TestAsyncWorkerGroupReplay(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	unavailable := &FakeBigQuery{
+		FailInsert: func(p, d, t string) int { return 503 },
+	}
+	var (
+		mu      sync.Mutex
+		spilled []Row
+	)
+	m, err := NewFakeWorkerGroup(unavailable, SetAsyncMaxRetries(1), SetAsyncSpillHandler(func(rows []Row) error {
+		mu.Lock()
+		defer mu.Unlock()
+		spilled = append(spilled, rows...)
+		return nil
+	}))
+	require.NoError(err)
+	m.Start()
+
+	rows := make([]Row, 3)
+	for i := range rows {
+		rows[i] = NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})
+	}
+	_, err = m.EnqueueBatch(rows)
+	require.NoError(err)
+	m.Close()
+	assert.Empty(unavailable.Rows())
+	mu.Lock()
+	assert.Equal(rows, spilled)
+	mu.Unlock()
+
+	// Replay spilled rows once BigQuery is available.
+	available := &FakeBigQuery{}
+	m, err = NewFakeWorkerGroup(available)
+	require.NoError(err)
+	m.Start()
+	n, err := m.Replay(spilled)
+	require.NoError(err)
+	assert.Equal(3, n)
+	m.Close()
+	assert.Equal(rows, available.Rows())
+}
+
+// TestAsyncWorkerGroupShardKey tests rows with the same key are inserted
+// in the order they were enqueued, even by multiple workers.
+func TestAsyncWorkerGroupShardKey(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	fake := &FakeBigQuery{}
+	m, err := NewFakeWorkerGroup(fake, SetAsyncNumWorkers(4), SetAsyncMaxRows(3), SetAsyncMaxDelay(1*time.Millisecond), SetAsyncShardKey(func(r Row) string {
+		return r.Data["user"].(string)
+	}))
+	require.NoError(err)
+	assert.EqualError(m.SetNumWorkers(2), "number of workers can't be changed when using a shard key")
+	m.Start()
+
+	rows := make([]Row, 0, 200)
+	for i := 0; i < cap(rows); i++ {
+		rows = append(rows, NewRow("p", "d", "t", map[string]bigquery.JsonValue{
+			"user": fmt.Sprintf("user%d", i%10),
+			"seq":  i,
+		}))
+	}
+	for _, r := range rows[:100] {
+		require.NoError(m.Enqueue(r))
+	}
+	_, err = m.EnqueueBatch(rows[100:])
+	require.NoError(err)
+	m.Close()
+
+	inserted := fake.Rows()
+	require.Len(inserted, len(rows))
+	last := map[string]int{}
+	for _, r := range inserted {
+		user, seq := r.Data["user"].(string), r.Data["seq"].(float64)
+		if prev, ok := last[user]; ok {
+			assert.True(int(seq) > prev, "%s row %v inserted after row %d", user, seq, prev)
+		}
+		last[user] = int(seq)
+	}
+}
+
+// TestAsyncWorkerGroupPerWorkerQueue tests rows are dispatched
+// to other workers while a worker is stuck inserting.
+func TestAsyncWorkerGroupPerWorkerQueue(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Block the first insert request until released.
+	var requests, inserted int64
+	release := make(chan struct{})
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			if atomic.AddInt64(&requests, 1) == 1 {
+				<-release
+			}
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+			atomic.AddInt64(&inserted, int64(len(tableReq.Rows)))
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(2), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncDispatchStrategy(PerWorkerQueue), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	assert.EqualError(m.SetNumWorkers(3), "number of workers can't be changed when using per-worker queues")
+	m.Start()
+
+	// The first row blocks the worker inserting it.
+	require.NoError(m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": 0})))
+	for start := time.Now(); atomic.LoadInt64(&requests) == 0 && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Only a single row is held back in the stuck worker's channel,
+	// all others are dispatched to the other worker.
+	for i := 1; i < 10; i++ {
+		require.NoError(m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": i})))
+	}
+	for start := time.Now(); atomic.LoadInt64(&inserted) < 8 && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(int64(8), atomic.LoadInt64(&inserted))
+
+	close(release)
+	m.Close()
+	assert.Equal(int64(10), atomic.LoadInt64(&inserted))
+}
+
+// TestAsyncWorkerGroupMaxBufferedBytes tests every overflow policy
+// once enqueued rows exceed max buffered bytes.
+func TestAsyncWorkerGroupMaxBufferedBytes(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rows := make([]Row, 4)
+	for i := range rows {
+		rows[i] = NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})
+	}
+	size := encodedRowSize(requestRow(rows[0]))
+
+	for _, policy := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowRejectNew} {
+		fake := &FakeBigQuery{}
+		m, err := NewFakeWorkerGroup(fake, SetAsyncMaxBufferedBytes(3*size), SetAsyncOverflowPolicy(policy))
+		require.NoError(err)
+
+		// Rows are left in the row channel until the group is started.
+		_, err = m.EnqueueBatch(rows[:3])
+		require.NoError(err)
+		assert.Equal(int64(3*size), m.Stats().BufferedBytes)
+
+		inserted := rows[:3]
+		switch policy {
+		case OverflowBlock:
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			assert.Equal(context.DeadlineExceeded, m.EnqueueContext(ctx, rows[3]))
+			cancel()
+			assert.False(m.TryEnqueue(rows[3]))
+		case OverflowDropOldest:
+			require.NoError(m.Enqueue(rows[3]))
+			assert.Equal(int64(1), m.Stats().DroppedRows)
+			inserted = rows[1:]
+		case OverflowRejectNew:
+			assert.Equal(ErrBufferFull, m.Enqueue(rows[3]))
+		}
+		assert.Equal(int64(3*size), m.Stats().BufferedBytes)
+
+		m.Start()
+		m.Close()
+		assert.Equal(inserted, fake.Rows())
+		assert.Equal(int64(0), m.Stats().BufferedBytes)
+	}
+}
+
+// dropRecorder is a StatsHandler recording dropped rows per reason.
+type dropRecorder struct {
+	NopStatsHandler
+
+	mu      sync.Mutex
+	dropped map[DropReason]int
+}
+
+func (s *dropRecorder) RowsDropped(n int, reason DropReason) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dropped == nil {
+		s.dropped = map[DropReason]int{}
+	}
+	s.dropped[reason] += n
+}
+
+// TestAsyncWorkerGroupDropHandler tests rows which couldn't be enqueued
+// are reported as dropped, along with the reason.
+func TestAsyncWorkerGroupDropHandler(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rows := make([]Row, 6)
+	for i := range rows {
+		rows[i] = NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})
+	}
+
+	var (
+		stats   dropRecorder
+		mu      sync.Mutex
+		dropped = map[DropReason][]Row{}
+	)
+	handler := func(row Row, reason DropReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		dropped[reason] = append(dropped[reason], row)
+	}
+
+	// Rows are left in the row channel until the group is started.
+	m, err := NewFakeWorkerGroup(&FakeBigQuery{}, SetAsyncMaxRows(1), SetAsyncStatsHandler(&stats), SetAsyncDropHandler(handler))
+	require.NoError(err)
+	require.NoError(m.Enqueue(rows[0]))
+	assert.False(m.TryEnqueue(rows[1]))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(context.Canceled, m.EnqueueContext(ctx, rows[2]))
+	n, err := m.EnqueueBatchContext(ctx, rows[3:5])
+	assert.Equal(0, n)
+	assert.Equal(context.Canceled, err)
+	m.Start()
+	m.Close()
+	assert.Equal(ErrGroupClosed, m.Enqueue(rows[5]))
+
+	// Test rows dropped by the OverflowDropOldest policy.
+	size := encodedRowSize(requestRow(rows[0]))
+	m, err = NewFakeWorkerGroup(&FakeBigQuery{}, SetAsyncMaxBufferedBytes(size), SetAsyncOverflowPolicy(OverflowDropOldest), SetAsyncStatsHandler(&stats), SetAsyncDropHandler(handler))
+	require.NoError(err)
+	require.NoError(m.Enqueue(rows[0]))
+	require.NoError(m.Enqueue(rows[1]))
+	m.Start()
+	m.Close()
+
+	assert.Equal(map[DropReason][]Row{
+		DropBufferFull:  {rows[1]},
+		DropContextDone: {rows[2], rows[3], rows[4]},
+		DropGroupClosed: {rows[5]},
+		DropOldest:      {rows[0]},
+	}, dropped)
+	assert.Equal(map[DropReason]int{
+		DropBufferFull:  1,
+		DropContextDone: 3,
+		DropGroupClosed: 1,
+		DropOldest:      1,
+	}, stats.dropped)
+	assert.Equal("dropped oldest", DropOldest.String())
+}
+
+// TestAsyncWorkerGroupRowTTL tests rows buffered for longer than the row TTL
+// are dropped instead of inserted, and counted as expired.
+func TestAsyncWorkerGroupRowTTL(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var (
+		stats   dropRecorder
+		mu      sync.Mutex
+		dropped []Row
+	)
+	handler := func(row Row, reason DropReason) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(DropExpired, reason)
+		dropped = append(dropped, row)
+	}
+
+	// Rows are left in the row channel until the group is started.
+	fake := FakeBigQuery{}
+	clock := newFakeClock()
+	m, err := NewFakeWorkerGroup(&fake, SetAsyncNumWorkers(1), SetAsyncRowTTL(time.Minute), setAsyncClock(clock), SetAsyncStatsHandler(&stats), SetAsyncDropHandler(handler))
+	require.NoError(err)
+	r0 := NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})
+	r1 := NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"})
+	require.NoError(m.Enqueue(r0))
+	clock.Advance(2 * time.Minute)
+	require.True(m.TryEnqueue(r1))
+	m.Start()
+	m.Close()
+
+	require.Len(dropped, 1)
+	assert.Equal("id0", dropped[0].InsertID)
+	require.Len(fake.Rows(), 1)
+	assert.Equal("id1", fake.Rows()[0].InsertID)
+	assert.Equal(map[DropReason]int{DropExpired: 1}, stats.dropped)
+
+	s := m.Stats()
+	assert.EqualValues(1, s.ExpiredRows)
+	assert.EqualValues(1, s.InsertedRows)
+	assert.Equal(0, s.QueuedRows)
+	assert.Equal("expired", DropExpired.String())
+}
+
+// TestAsyncWorkerGroupEnqueueNDJSON tests rows are enqueued
+// from newline-delimited JSON, until the first invalid line.
+func TestAsyncWorkerGroupEnqueueNDJSON(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	fake := FakeBigQuery{}
+	m, err := NewFakeWorkerGroup(&fake, SetAsyncNumWorkers(1), SetAsyncMaxLineSize(32))
+	require.NoError(err)
+
+	_, err = m.EnqueueNDJSON("t", strings.NewReader(`{"k0":"v0"}`))
+	assert.EqualError(err, "table must be given as project.dataset.table")
+
+	// Test empty lines are skipped, and the last line may lack a newline.
+	n, err := m.EnqueueNDJSON("p.d.t", strings.NewReader("{\"k0\":\"v0\"}\n\n {\"k1\":\"v1\"}\r\n{\"k2\":\"v2\"}"))
+	require.NoError(err)
+	assert.Equal(3, n)
+
+	// Test reading stops at an invalid line.
+	n, err = m.EnqueueNDJSON("p.d.t", strings.NewReader("{\"k3\":\"v3\"}\n[1]\n{\"k4\":\"v4\"}\n"))
+	assert.EqualError(err, "line 2: invalid JSON object")
+	assert.Equal(1, n)
+	n, err = m.EnqueueNDJSON("p.d.t", strings.NewReader("{\"k4\":\"v4\"}\n{\"k5\":\n"))
+	assert.EqualError(err, "line 2: invalid JSON object")
+	assert.Equal(1, n)
+
+	// Test lines longer than max line size.
+	n, err = m.EnqueueNDJSON("p.d.t", strings.NewReader(`{"k6":"`+strings.Repeat("v", 32)+`"}`))
+	assert.True(errors.Is(err, bufio.ErrTooLong))
+	assert.Equal(0, n)
+
+	m.Start()
+	m.Close()
+
+	rows := fake.Rows()
+	require.Len(rows, 5)
+	for i, row := range rows {
+		assert.Equal("t", row.TableID)
+		assert.NotEmpty(row.InsertID)
+		assert.Equal(map[string]bigquery.JsonValue{fmt.Sprintf("k%d", i): fmt.Sprintf("v%d", i)}, row.Data)
+	}
+
+	_, err = m.EnqueueNDJSON("p.d.t", strings.NewReader(`{"k0":"v0"}`))
+	assert.Equal(ErrGroupClosed, err)
+}
+
+// TestAsyncWorkerGroupStartStagger tests workers start with incremental
+// delays, and are closed promptly while waiting to start.
+func TestAsyncWorkerGroupStartStagger(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	logger := &logRecorder{}
+	started := func() int {
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		n := 0
+		for _, msg := range logger.messages {
+			if msg == "debug: bqstreamer: worker started" {
+				n++
+			}
+		}
+		return n
+	}
+
+	fake := &FakeBigQuery{}
+	m, err := NewFakeWorkerGroup(fake, SetAsyncNumWorkers(3), SetAsyncMaxRows(1), SetAsyncStartStagger(time.Minute), SetAsyncLogger(logger))
+	require.NoError(err)
+	m.Start()
+	for i, w := range m.workers {
+		assert.Equal(time.Duration(i)*time.Minute, w.startDelay)
+	}
+
+	// Test only the first worker has started, and inserts rows.
+	row := NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})
+	require.NoError(m.Enqueue(row))
+	for start := time.Now(); len(fake.Rows()) == 0 && time.Since(start) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal([]Row{row}, fake.Rows())
+	assert.Equal(1, started())
+
+	start := time.Now()
+	m.Close()
+	assert.True(time.Since(start) < time.Second)
+}
+
+// TestAsyncWorkerGroupWarmup tests every worker sends a warmup request
+// on start, and failing to do so doesn't stop it from inserting rows.
+func TestAsyncWorkerGroupWarmup(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var warmups, inserted int64
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			if req.Method == "HEAD" {
+				atomic.AddInt64(&warmups, 1)
+				return nil, errors.New("connection refused")
+			}
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+			atomic.AddInt64(&inserted, int64(len(tableReq.Rows)))
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	logger := &logRecorder{}
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(2), SetAsyncMaxRows(10), SetAsyncWarmup(true), SetAsyncLogger(logger), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	m.Start()
+
+	require.NoError(m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": 0})))
+	require.NoError(m.Flush())
+	assert.Equal(int64(2), atomic.LoadInt64(&warmups))
+	assert.Equal(int64(1), atomic.LoadInt64(&inserted))
+	m.Close()
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	n := 0
+	for _, msg := range logger.messages {
+		if strings.HasPrefix(msg, "warn: bqstreamer: warmup failed: ") {
+			n++
+		}
+	}
+	assert.Equal(2, n)
+}
+
+// TestAsyncWorkerGroupPause tests paused workers keep enqueuing rows
+// up to max rows without inserting them, until resumed.
+func TestAsyncWorkerGroupPause(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that always return an "OK" empty response.
+	var requests int32
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&requests, 1)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(2), SetAsyncMaxDelay(5*time.Millisecond), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	m.Pause()
+	assert.True(m.Stats().Paused)
+	m.Start()
+
+	// The worker enqueues the first 2 rows, leaving the rest in the row channel.
+	for i := 0; i < 4; i++ {
+		require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})))
+	}
+	for atomic.LoadInt64(&m.counters.bufferedRows) < 2 {
+		time.Sleep(1 * time.Millisecond)
+	}
+	assert.False(m.TryEnqueue(NewRowWithID("p", "d", "t", "id4", map[string]bigquery.JsonValue{"k0": "v0"})))
+
+	// Test no inserts are executed, even once max delay passes.
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(int32(0), atomic.LoadInt32(&requests))
+	assert.Equal(4, m.Stats().QueuedRows)
+
+	m.Resume()
+	assert.False(m.Stats().Paused)
+	for m.RowsInserted() < 4 {
+		time.Sleep(1 * time.Millisecond)
+	}
+	m.Close()
+	assert.Equal(uint64(4), m.RowsInserted())
+}
+
+// TestAsyncWorkerGroupNoErrorChannel tests workers don't block
+// reporting insert errors if no error channel has been set.
+func TestAsyncWorkerGroupNoErrorChannel(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	fake := &FakeBigQuery{
+		RejectRow: func(row Row) []*bigquery.ErrorProto {
+			return []*bigquery.ErrorProto{{Reason: "invalid", Message: "m"}}
+		},
+	}
+	m, err := NewFakeWorkerGroup(fake, SetAsyncNumWorkers(2), SetAsyncMaxRows(1))
+	require.NoError(err)
+	require.Nil(m.errorChan)
+	m.Start()
+
+	// Every row is rejected by a separate insert,
+	// far more than an error channel could buffer.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			assert.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k": i})))
+		}
+		m.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers blocked reporting insert errors")
+	}
+	assert.Len(fake.RejectedRows(), 20)
+	assert.Equal(int64(20), m.Stats().RejectedRows)
+}
+
+// TestAsyncWorkerGroupCloseWithSummary tests the summary counts rows
+// inserted, rejected and failed while closing.
+func TestAsyncWorkerGroupCloseWithSummary(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	fake := &FakeBigQuery{
+		RejectRow: func(row Row) []*bigquery.ErrorProto {
+			if row.InsertID == "id1" {
+				return []*bigquery.ErrorProto{{Reason: "invalid", Message: "m1"}}
+			}
+			return nil
+		},
+		FailInsert: func(projectID, datasetID, tableID string) int {
+			if tableID == "t2" {
+				return 400
+			}
+			return 0
+		},
+	}
+	m, err := NewFakeWorkerGroup(fake, SetAsyncMaxDelay(1*time.Minute), SetAsyncErrorHandler(func(*InsertErrors) {}))
+	require.NoError(err)
+	m.Start()
+
+	// Rows inserted before closing aren't counted.
+	require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", "id", map[string]bigquery.JsonValue{"k": "v"})))
+	require.NoError(m.Flush())
+
+	for i := 0; i < 3; i++ {
+		require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k": "v"})))
+	}
+	require.NoError(m.Enqueue(NewRowWithID("p", "d", "t2", "id3", map[string]bigquery.JsonValue{"k": "v"})))
+	assert.Equal(CloseSummary{FlushedRows: 2, RejectedRows: 1, FailedInserts: 1}, m.CloseWithSummary())
+
+	// Closing again is a no-op.
+	assert.Equal(CloseSummary{}, m.CloseWithSummary())
+}
+
+// TestAsyncWorkerGroupCloseContext tests closing a group with rows that can't
+// be inserted abandons them once the context deadline passes.
+func TestAsyncWorkerGroupCloseContext(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that hangs until the request is canceled,
+	// like an unreachable BigQuery.
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(2), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	m.Start()
+
+	// The worker inserts the first 2 rows and hangs,
+	// leaving the rest in the row channel.
+	for i := 0; i < 4; i++ {
+		require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = m.CloseContext(ctx)
+	require.Error(err)
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	undrainedErr, ok := err.(*UndrainedRowsError)
+	require.True(ok)
+	assert.Equal(4, undrainedErr.Rows)
+
+	// Test closing again is a no-op.
+	assert.NoError(m.CloseContext(context.Background()))
+}
+
+// TestAsyncWorkerGroupCancelInFlightOnClose tests Close() cancels hung
+// insert operations once the close grace period has passed,
+// reporting their rows as undrained.
+func TestAsyncWorkerGroupCancelInFlightOnClose(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that hangs until the request is canceled,
+	// like an unresponsive BigQuery.
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})}
+	newClient := func() *http.Client { return &client }
+
+	_, err := newAsyncWorkerGroup(newClient, SetAsyncCancelInFlightOnClose(true))
+	assert.EqualError(err, "canceling in-flight inserts on close requires a close grace period")
+
+	m, err := newAsyncWorkerGroup(newClient, SetAsyncNumWorkers(1), SetAsyncMaxRows(2), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncCloseGracePeriod(50*time.Millisecond), SetAsyncCancelInFlightOnClose(true))
+	require.NoError(err)
+	m.Start()
+
+	// The worker inserts the first 2 rows and hangs,
+	// leaving the last one in the row channel.
+	for i := 0; i < 3; i++ {
+		require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})))
+	}
+	waitFor(t, func() bool { return m.Stats().InFlightInserts == 1 })
+
+	summary := m.CloseWithSummary()
+	assert.EqualValues(0, summary.FlushedRows)
+	assert.EqualValues(3, summary.UndrainedRows)
+	assert.True(m.Config().CancelInFlightOnClose)
+}
+
+// TestAsyncWorkerGroupCancelInFlightPartial tests only rows of tables
+// not inserted by a canceled insert operation are reported as undrained.
+func TestAsyncWorkerGroupCancelInFlightPartial(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client inserting the first table's rows,
+	// and hanging on the second table until the request is canceled.
+	var calls int32
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("{}"))}, nil
+			}
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(2), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncCloseGracePeriod(50*time.Millisecond), SetAsyncCancelInFlightOnClose(true))
+	require.NoError(err)
+	m.Start()
+
+	// Whichever table is inserted first succeeds.
+	require.NoError(m.Enqueue(NewRowWithID("p", "d", "t1", "id0", map[string]bigquery.JsonValue{"k0": "v0"})))
+	require.NoError(m.Enqueue(NewRowWithID("p", "d", "t2", "id1", map[string]bigquery.JsonValue{"k0": "v0"})))
+	waitFor(t, func() bool { return atomic.LoadInt32(&calls) == 2 })
+
+	summary := m.CloseWithSummary()
+	assert.EqualValues(1, summary.UndrainedRows)
+	assert.EqualValues(1, m.Stats().InsertedRows)
+}
+
+// TestAsyncWorkerGroupEnqueueClose tests calling Enqueue() concurrently
+// with Close(). Every row enqueued successfully must be inserted,
+// and Enqueue() must return ErrGroupClosed once Close() has been called.
+//
+// NOTE run this test with -race.
+func TestAsyncWorkerGroupEnqueueClose(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Count inserted rows.
+	var mu sync.Mutex
+	inserted := 0
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+
+			mu.Lock()
+			inserted += len(tableReq.Rows)
+			mu.Unlock()
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(5), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Second), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	m.Start()
+
+	// Enqueue rows from multiple goroutines and close the group midway.
+	var wg sync.WaitGroup
+	var enqueued, rejected int64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id := fmt.Sprintf("id%d-%d", i, j)
+				switch err := m.Enqueue(NewRowWithID("p", "d", "t", id, map[string]bigquery.JsonValue{"k": "v"})); err {
+				case nil:
+					atomic.AddInt64(&enqueued, 1)
+				case ErrGroupClosed:
+					atomic.AddInt64(&rejected, 1)
+				default:
+					assert.Fail("unexpected enqueue error", err.Error())
+				}
+			}
+		}(i)
+	}
+	time.Sleep(1 * time.Millisecond)
+	m.Close()
+	wg.Wait()
+
+	// Test all rows were either inserted or rejected.
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(int64(1000), enqueued+rejected)
+	assert.Equal(int(enqueued), inserted)
+
+	// Test enqueueing after close returns an error.
+	assert.Equal(ErrGroupClosed, m.Enqueue(NewRowWithID("p", "d", "t", "id", map[string]bigquery.JsonValue{"k": "v"})))
+
+	// Test closing twice is a no-op.
+	m.Close()
+}
+
+// TestAsyncWorkerGroupSetNumWorkers tests scaling workers up and down
+// while rows are enqueued continuously. No rows must be lost.
+//
+// NOTE run this test with -race.
+func TestAsyncWorkerGroupSetNumWorkers(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Count inserted rows.
+	var mu sync.Mutex
+	inserted := 0
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+
+			mu.Lock()
+			inserted += len(tableReq.Rows)
+			mu.Unlock()
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(2), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Second), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+	assert.Error(m.SetNumWorkers(0))
+
+	// Test scaling before Start().
+	require.NoError(m.SetNumWorkers(3))
+	assert.Len(m.workers, 3)
+	m.Start()
+
+	// Enqueue rows while scaling up and down.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k": "v"})))
+		}
+	}()
+	for _, n := range []int{5, 1, 4, 2, 1} {
+		require.NoError(m.SetNumWorkers(n))
+		assert.Len(m.workers, n)
+		require.NoError(m.Flush())
+	}
+	<-done
+	m.Close()
+
+	// Test all rows were inserted.
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(1000, inserted)
+
+	// Test scaling after close returns an error.
+	assert.Equal(ErrGroupClosed, m.SetNumWorkers(2))
+}
+
+// TestAsyncWorkerGroupNewWithTokenSource tests creating a new AsyncWorkerGroup
+// using an OAuth2 token source instead of a JWT configuration.
+func TestAsyncWorkerGroupNewWithTokenSource(t *testing.T) {
+	t.Parallel()
+
+	require := require.New(t)
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	for _, ipv4Only := range []bool{false, true} {
+		m, err := NewAsyncWorkerGroupWithTokenSource(
+			ts,
+			ipv4Only,
+			SetAsyncNumWorkers(2),
+			SetAsyncMaxRows(10),
+			SetAsyncMaxDelay(1*time.Second),
+			SetAsyncRetryInterval(1*time.Second),
+			SetAsyncMaxRetries(10),
+		)
+		require.NoError(err)
+		require.Len(m.workers, 2)
+		for _, w := range m.workers {
+			require.NotNil(w.worker.service)
+		}
+	}
+}
+
+// TestAsyncWorkerGroupNewFromJSON tests creating a new AsyncWorkerGroup
+// using a service account JSON key, and malformed keys are rejected.
+func TestAsyncWorkerGroupNewFromJSON(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var err error
+	_, err = NewAsyncWorkerGroupFromJSON(nil, false)
+	assert.EqualError(err, "JSON key is empty")
+	_, err = NewAsyncWorkerGroupFromJSON([]byte(`{"type":`), false)
+	assert.Error(err)
+	assert.Contains(err.Error(), "invalid JSON key: ")
+	_, err = NewAsyncWorkerGroupFromJSON([]byte(`{"type":"authorized_user"}`), false)
+	assert.Error(err)
+	assert.Contains(err.Error(), "invalid JSON key: ")
+
+	key := []byte(`{
+		"type": "service_account",
+		"client_email": "streamer@project.iam.gserviceaccount.com",
+		"private_key": "key",
+		"token_uri": "https://oauth2.googleapis.com/token"
+	}`)
+	m, err := NewAsyncWorkerGroupFromJSON(
+		key,
+		false,
+		SetAsyncNumWorkers(2),
+		SetAsyncMaxRows(10),
+		SetAsyncMaxDelay(1*time.Second),
+		SetAsyncRetryInterval(1*time.Second),
+		SetAsyncMaxRetries(10),
+	)
+	require.NoError(err)
+	require.Len(m.workers, 2)
+	assert.Equal("streamer@project.iam.gserviceaccount.com", m.jwtConfig.Email)
+	assert.Equal([]string{bigquery.BigqueryInsertdataScope}, m.jwtScopes())
+}
+
+// TestAsyncWorkerGroupNewWithClient tests creating a new AsyncWorkerGroup
+// using a custom http.Client as is.
+func TestAsyncWorkerGroupNewWithClient(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var auth []string
+	var mu sync.Mutex
+	mock := newTransport(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		auth = append(auth, req.Header.Get("Authorization"))
+		mu.Unlock()
+
+		res := http.Response{
+			Header:     make(http.Header),
+			Request:    req,
+			StatusCode: 200,
+			// Empty JSON body, meaning "no errors".
+			Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+		return &res, nil
+	})
+	transport := &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+		Base:   mock,
+	}
+	client := &http.Client{Transport: transport}
+
+	options := []AsyncOptionFunc{SetAsyncNumWorkers(2), SetAsyncMaxRows(10), SetAsyncMaxDelay(1 * time.Minute), SetAsyncRetryInterval(1 * time.Second), SetAsyncMaxRetries(10)}
+	_, err := NewAsyncWorkerGroupWithClient(client, append(options, SetAsyncTransport(http.DefaultTransport))...)
+	assert.EqualError(err, "transport options can't be used with a custom client")
+	_, err = NewAsyncWorkerGroupWithClient(client, append(options, SetAsyncScopes(bigquery.BigqueryScope))...)
+	assert.EqualError(err, "transport options can't be used with a custom client")
+
+	m, err := NewAsyncWorkerGroupWithClient(client, options...)
+	require.NoError(err)
+	m.Start()
+	for i := 0; i < 3; i++ {
+		require.NoError(m.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": i})))
+	}
+	m.Close()
+
+	// Test the client's transport wasn't replaced.
+	assert.Equal(mock, transport.Base)
+	require.NotEmpty(auth)
+	for _, a := range auth {
+		assert.Equal("Bearer token", a)
+	}
+}
+
+// TestAsyncWorkerGroupScopes tests the OAuth2 scopes requested
+// using a JWT configuration.
+func TestAsyncWorkerGroupScopes(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	options := []AsyncOptionFunc{
+		SetAsyncNumWorkers(1),
+		SetAsyncMaxRows(10),
+		SetAsyncMaxDelay(1 * time.Second),
+		SetAsyncRetryInterval(1 * time.Second),
+		SetAsyncMaxRetries(10),
+	}
+
+	// Test the minimal insert scope is requested by default.
+	m, err := NewAsyncWorkerGroup(&jwt.Config{}, false, options...)
+	require.NoError(err)
+	assert.Equal([]string{bigquery.BigqueryInsertdataScope}, m.jwtScopes())
+
+	// Test the configuration's scopes are requested if set,
+	// unless replaced using SetAsyncScopes().
+	c := &jwt.Config{Scopes: []string{bigquery.BigqueryScope}}
+	m, err = NewAsyncWorkerGroup(c, false, options...)
+	require.NoError(err)
+	assert.Equal([]string{bigquery.BigqueryScope}, m.jwtScopes())
+
+	m, err = NewAsyncWorkerGroup(c, false, append(options, SetAsyncScopes(bigquery.BigqueryInsertdataScope))...)
+	require.NoError(err)
+	assert.Equal([]string{bigquery.BigqueryInsertdataScope}, m.jwtScopes())
+	assert.Equal([]string{bigquery.BigqueryScope}, c.Scopes)
+
+	// Test scopes can't be set for a token source.
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	_, err = NewAsyncWorkerGroupWithTokenSource(ts, false, append(options, SetAsyncScopes(bigquery.BigqueryScope))...)
+	assert.EqualError(err, "scopes can't be used with a token source")
+}
+
+// failingTokenSource fails to return a token.
+type failingTokenSource struct{}
+
+func (failingTokenSource) Token() (*oauth2.Token, error) {
+	return nil, errors.New("invalid_grant")
+}
+
+// TestAsyncWorkerGroupVerifyCredentials tests constructing a group fails
+// if its credentials are invalid and are to be verified.
+func TestAsyncWorkerGroupVerifyCredentials(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	options := []AsyncOptionFunc{
+		SetAsyncNumWorkers(1),
+		SetAsyncMaxRows(10),
+		SetAsyncMaxDelay(1 * time.Second),
+		SetAsyncRetryInterval(1 * time.Second),
+		SetAsyncMaxRetries(10),
+	}
+
+	// Test credentials aren't verified by default.
+	_, err := NewAsyncWorkerGroupWithTokenSource(failingTokenSource{}, false, options...)
+	require.NoError(err)
+
+	options = append(options, SetAsyncVerifyCredentials(true))
+	_, err = NewAsyncWorkerGroupWithTokenSource(failingTokenSource{}, false, options...)
+	var credsErr *CredentialsError
+	require.True(errors.As(err, &credsErr))
+	assert.EqualError(credsErr.Err, "invalid_grant")
+	assert.EqualError(err, "invalid credentials: invalid_grant")
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	_, err = NewAsyncWorkerGroupWithTokenSource(ts, false, options...)
+	assert.NoError(err)
+}
+
+// countingTokenSource counts calls to Token().
+type countingTokenSource struct {
+	calls int32
+}
+
+func (ts *countingTokenSource) Token() (*oauth2.Token, error) {
+	atomic.AddInt32(&ts.calls, 1)
+	return &oauth2.Token{AccessToken: "token"}, nil
+}
+
+// TestAsyncWorkerGroupSharedTokenSource tests all workers share
+// a single token cache.
+func TestAsyncWorkerGroupSharedTokenSource(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	transport := newTransport(func(req *http.Request) (*http.Response, error) {
+		res := http.Response{
+			Header:     make(http.Header),
+			Request:    req,
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+		return &res, nil
+	})
+
+	ts := &countingTokenSource{}
+	m, err := NewAsyncWorkerGroupWithTokenSource(ts, false, SetAsyncNumWorkers(2), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncTransport(transport))
+	require.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		res, err := m.newHTTPClient().Get("http://localhost/")
+		require.NoError(err)
+		res.Body.Close()
+	}
+	assert.Equal(int32(1), atomic.LoadInt32(&ts.calls))
+}
+
+// TestAsyncWorkerGroupTransport tests workers send OAuth2 authenticated
+// requests using a custom base transport.
+func TestAsyncWorkerGroupTransport(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var auth []string
+	var mu sync.Mutex
+	transport := newTransport(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		auth = append(auth, req.Header.Get("Authorization"))
+		mu.Unlock()
+
+		res := http.Response{
+			Header:     make(http.Header),
+			Request:    req,
+			StatusCode: 200,
+			// Empty JSON body, meaning "no errors".
+			Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+		return &res, nil
+	})
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+
+	// Test ipv4Only can't be used with a custom transport.
+	_, err := NewAsyncWorkerGroupWithTokenSource(ts, true, SetAsyncTransport(transport))
+	assert.EqualError(err, "ipv4Only can't be used with a custom transport")
+
+	m, err := NewAsyncWorkerGroupWithTokenSource(ts, false, SetAsyncNumWorkers(2), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncTransport(transport))
+	require.NoError(err)
+	m.Start()
+	require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})))
+	m.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal([]string{"Bearer token"}, auth)
+}
+
+// TestAsyncWorkerGroupDialer tests workers connect using the group's dialer.
+func TestAsyncWorkerGroupDialer(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	m, err := NewAsyncWorkerGroupWithTokenSource(ts, true, SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Second), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncDialTimeout(3*time.Second))
+	require.NoError(err)
+	assert.Equal(3*time.Second, m.dialer.Timeout)
+	assert.Equal(DefaultAsyncKeepAlive, m.dialer.KeepAlive)
+
+	c := oauth2.NewClient(oauth2.NoContext, ts)
+	m.setBaseTransport(c)
+	base, ok := c.Transport.(*oauth2.Transport).Base.(*http.Transport)
+	require.True(ok)
+	assert.Equal(2*time.Second, base.TLSHandshakeTimeout)
+
+	// Test dialing a local listener.
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(err)
+	defer l.Close()
+	conn, err := base.DialContext(context.Background(), "tcp", l.Addr().String())
+	require.NoError(err)
+	conn.Close()
+}
+
+// TestAsyncWorkerGroupNetworkMode tests workers dial using the network
+// matching the network mode.
+func TestAsyncWorkerGroupNetworkMode(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	newGroup := func(ipv4Only bool, options ...AsyncOptionFunc) (*AsyncWorkerGroup, error) {
+		options = append([]AsyncOptionFunc{SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1 * time.Second), SetAsyncRetryInterval(1 * time.Second), SetAsyncMaxRetries(10)}, options...)
+		return NewAsyncWorkerGroupWithTokenSource(ts, ipv4Only, options...)
+	}
+
+	// Test conflicting configuration.
+	_, err := newGroup(true, SetAsyncNetworkMode(NetworkIPv6))
+	assert.EqualError(err, "ipv4Only can't be used with NetworkIPv6")
+	_, err = newGroup(false, SetAsyncNetworkMode(NetworkIPv6), SetAsyncTransport(&http.Transport{}))
+	assert.EqualError(err, "network mode can't be used with a custom transport")
+
+	m, err := newGroup(false)
+	require.NoError(err)
+	assert.Equal(NetworkAuto, m.networkMode)
+	m, err = newGroup(true)
+	require.NoError(err)
+	assert.Equal(NetworkIPv4, m.networkMode)
+
+	// Test an IPv6 only group can't connect to an IPv4 listener.
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(err)
+	defer l.Close()
+	for mode, ok := range map[NetworkMode]bool{NetworkAuto: true, NetworkIPv4: true, NetworkIPv6: false} {
+		m, err := newGroup(false, SetAsyncNetworkMode(mode))
+		require.NoError(err)
+		c := oauth2.NewClient(oauth2.NoContext, ts)
+		m.setBaseTransport(c)
+		base := c.Transport.(*oauth2.Transport).Base.(*http.Transport)
+		conn, err := base.DialContext(context.Background(), "tcp", l.Addr().String())
+		if ok {
+			require.NoError(err)
+			conn.Close()
+		} else {
+			assert.Error(err)
+		}
+	}
+}
+
+// newHTTP2Server returns a TLS server supporting HTTP/2,
+// responding to every insert request with no errors,
+// and a client connecting to it using given group's base transport.
+func newHTTP2Server(m *AsyncWorkerGroup, handler func(r *http.Request)) (*httptest.Server, *http.Client) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+
+	c := oauth2.NewClient(oauth2.NoContext, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}))
+	m.setBaseTransport(c)
+	base := c.Transport.(*oauth2.Transport).Base.(*http.Transport)
+	base.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	return srv, c
+}
+
+// TestAsyncWorkerGroupForceHTTP2 tests workers connect using HTTP/2 by default,
+// and HTTP/1.1 only if HTTP/2 has been disabled.
+func TestAsyncWorkerGroupForceHTTP2(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	newGroup := func(options ...AsyncOptionFunc) (*AsyncWorkerGroup, error) {
+		options = append([]AsyncOptionFunc{SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1 * time.Second), SetAsyncRetryInterval(1 * time.Second), SetAsyncMaxRetries(10)}, options...)
+		return NewAsyncWorkerGroupWithTokenSource(ts, false, options...)
+	}
+
+	_, err := newGroup(SetAsyncForceHTTP2(true), SetAsyncTransport(&http.Transport{}))
+	assert.EqualError(err, "HTTP/2 selection can't be used with a custom transport")
+
+	for _, test := range []struct {
+		options []AsyncOptionFunc
+		proto   int
+	}{
+		{nil, 2},
+		{[]AsyncOptionFunc{SetAsyncForceHTTP2(true)}, 2},
+		{[]AsyncOptionFunc{SetAsyncForceHTTP2(false)}, 1},
+	} {
+		m, err := newGroup(test.options...)
+		require.NoError(err)
+
+		var proto int
+		srv, c := newHTTP2Server(m, func(r *http.Request) { proto = r.ProtoMajor })
+		w, err := NewSyncWorker(c, SetSyncEndpoint(srv.URL+"/"))
+		require.NoError(err)
+		w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+		assert.Nil(w.Insert().All()[0].Attempts()[0].Error())
+		assert.Equal(test.proto, proto)
+		srv.Close()
+	}
+}
+
+// BenchmarkAsyncWorkerGroupHTTP2 measures insert request throughput
+// of 8 concurrent workers, with and without HTTP/2.
+func BenchmarkAsyncWorkerGroupHTTP2(b *testing.B) {
+	for _, enabled := range []bool{true, false} {
+		b.Run(fmt.Sprintf("http2=%t", enabled), func(b *testing.B) {
+			ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+			m, err := NewAsyncWorkerGroupWithTokenSource(ts, false, SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Second), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncForceHTTP2(enabled))
+			if err != nil {
+				b.Fatal(err)
+			}
+			srv, c := newHTTP2Server(m, func(*http.Request) {})
+			defer srv.Close()
+
+			const numWorkers = 8
+			var n int64
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for i := 0; i < numWorkers; i++ {
+				w, err := NewSyncWorker(c, SetSyncEndpoint(srv.URL+"/"))
+				if err != nil {
+					b.Fatal(err)
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for atomic.AddInt64(&n, 1) <= int64(b.N) {
+						w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+						if err := w.Insert().All()[0].Attempts()[0].Error(); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// TestAsyncWorkerGroupTryEnqueue tests TryEnqueue() doesn't block
+// when the row channel is full or the group has been closed.
+func TestAsyncWorkerGroupTryEnqueue(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that always return an "OK" empty response.
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Use a single worker with a single row buffer,
+	// and don't start it so the row channel fills up.
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Second), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10))
+	require.NoError(err)
+
+	row := NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})
+	assert.True(m.TryEnqueue(row))
+	assert.False(m.TryEnqueue(row))
+	assert.Len(m.rowChan, 1)
+
+	// Test a closed group returns false instead of panicking.
+	m.Start()
+	m.Close()
+	assert.False(m.TryEnqueue(row))
+}
+
+// TestAsyncWorkerGroupWaterMark tests water mark callbacks are called
+// as rows are enqueued and read by workers.
+func TestAsyncWorkerGroupWaterMark(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+	newClient := func() *http.Client { return &client }
+
+	onHigh := func(depth, capacity int) {}
+	_, err := newAsyncWorkerGroup(newClient, SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Minute), SetAsyncLowWaterMark(0.2, onHigh))
+	assert.EqualError(err, "low water mark can't be used without a high water mark")
+	_, err = newAsyncWorkerGroup(newClient, SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Minute), SetAsyncHighWaterMark(0.5, onHigh), SetAsyncLowWaterMark(0.5, onHigh))
+	assert.EqualError(err, "low water mark must be below the high water mark")
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) func(depth, capacity int) {
+		return func(depth, capacity int) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, fmt.Sprintf("%s %d/%d", name, depth, capacity))
+		}
+	}
+
+	m, err := newAsyncWorkerGroup(newClient, SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), SetAsyncHighWaterMark(0.8, record("high")), SetAsyncLowWaterMark(0.2, record("low")))
+	require.NoError(err)
+	assert.Equal(0.8, m.Config().HighWaterMark)
+	assert.Equal(0.2, m.Config().LowWaterMark)
+
+	// Fill the row channel of the unstarted worker past the high water mark.
+	row := NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})
+	for i := 0; i < 9; i++ {
+		require.NoError(m.Enqueue(row))
+	}
+	mu.Lock()
+	assert.Equal([]string{"high 8/10"}, calls)
+	mu.Unlock()
+
+	// Test the low water mark callback is called once the worker drains it.
+	m.Start()
+	m.Close()
+	assert.Equal([]string{"high 8/10", "low 2/10"}, calls)
+}
+
+// TestAsyncWorkerGroupEnqueueWithResult tests every row's result is received
+// once its insert operation has finished, or once it has been dropped.
+func TestAsyncWorkerGroupEnqueueWithResult(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client rejecting the second row of table "t",
+	// and failing all inserts to table "bad".
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			_, _, tableID := getInsertMetadata(req.URL.Path)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"insertErrors":[{"index":1,"errors":[{"reason":"invalid","message":"m"}]}]}`))}
+			if tableID == "bad" {
+				res.StatusCode = 400
+				res.Body = ioutil.NopCloser(bytes.NewBufferString(`{}`))
+			}
+
+			return &res, nil
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Millisecond), SetAsyncMaxRetries(0))
+	require.NoError(err)
+	m.Start()
+
+	inserted := m.EnqueueWithResult(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	rejected := m.EnqueueWithResult(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	failed := m.EnqueueWithResult(NewRowWithID("p", "d", "bad", "id2", map[string]bigquery.JsonValue{"k2": "v2"}))
+	require.NoError(m.Flush())
+
+	assert.NoError(<-inserted)
+	err = <-rejected
+	require.IsType(&RowRejectedError{}, err)
+	assert.Equal("t", err.(*RowRejectedError).Table)
+	require.Error(<-failed)
+
+	// Test channels are closed after receiving the result.
+	_, ok := <-inserted
+	assert.False(ok)
+
+	// Test rows enqueued to a closed group are dropped.
+	m.Close()
+	assert.Equal(&RowDroppedError{Reason: DropGroupClosed}, <-m.EnqueueWithResult(NewRowWithID("p", "d", "t", "id3", map[string]bigquery.JsonValue{"k3": "v3"})))
+}
+
+// TestAsyncWorkerGroupEnqueueWithResultAbandoned tests results of rows
+// abandoned by CloseContext() are received as well.
+func TestAsyncWorkerGroupEnqueueWithResultAbandoned(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that hangs until the request is canceled.
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})}
+
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(1), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Millisecond), SetAsyncMaxRetries(0))
+	require.NoError(err)
+	m.Start()
+
+	// The first row is being inserted, while the second is left in the row channel.
+	var results []<-chan error
+	for i := 0; i < 2; i++ {
+		results = append(results, m.EnqueueWithResult(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(m.CloseContext(ctx))
+	for _, result := range results {
+		assert.Error(<-result)
+	}
+}
+
+// TestAsyncWorkerGroupErrorAggregation tests insert errors are reported
+// to the error channel once per window, grouped by table.
+func TestAsyncWorkerGroupErrorAggregation(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client rejecting a row of table "t1",
+	// and failing all inserts to table "t2".
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			_, _, tableID := getInsertMetadata(req.URL.Path)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"insertErrors":[{"index":0,"errors":[{"reason":"invalid","message":"m"}]}]}`))}
+			if tableID == "t2" {
+				res.StatusCode = 400
+				res.Body = ioutil.NopCloser(bytes.NewBufferString(`{"error":{"code":400,"message":"m","errors":[{"reason":"badRequest","message":"m"}]}}`))
+			}
+
+			return &res, nil
+		})}
+
+	clock := newFakeClock()
+	errChan := make(chan *InsertErrors, 10)
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Millisecond), SetAsyncMaxRetries(0), SetAsyncErrorChannel(errChan), SetAsyncErrorAggregation(10*time.Second), setAsyncClock(clock))
+	require.NoError(err)
+	m.Start()
+
+	// Insert every table twice, in separate insert operations.
+	for _, tableID := range []string{"t2", "t1", "t2", "t1"} {
+		require.NoError(m.Enqueue(NewRow("p", "d", tableID, map[string]bigquery.JsonValue{"k0": "v0"})))
+		require.NoError(m.Flush())
+	}
+	assert.Len(errChan, 0)
+
+	// Test a single report is sent once the window has passed,
+	// waiting for the window's timer along the worker's max delay one.
+	waitFor(t, func() bool { return clock.activeTimers() == 2 })
+	clock.Advance(10 * time.Second)
+	insertErrs := <-errChan
+	require.Len(insertErrs.Tables, 4)
+	assert.Equal(map[string]map[string]int{
+		"p.d.t1": {"invalid": 2},
+		"p.d.t2": {"badRequest": 2},
+	}, insertErrs.ErrorCounts())
+	assert.Len(insertErrs.RowErrors(), 2)
+	for i, table := range insertErrs.Tables {
+		assert.Equal([]string{"t1", "t1", "t2", "t2"}[i], table.Attempts()[0].Table)
+	}
+
+	// Test errors left on close are reported right away.
+	require.NoError(m.Enqueue(NewRow("p", "d", "t1", map[string]bigquery.JsonValue{"k0": "v0"})))
+	m.Close()
+	require.Len(errChan, 1)
+	assert.Len((<-errChan).Tables, 1)
+}
@@ -2,9 +2,12 @@ package bqstreamer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
@@ -24,11 +27,16 @@ func newAsyncWorker(sw *SyncWorker, rows int, delay time.Duration) *asyncWorker
 		rowChan:   make(chan Row, 100),
 		errorChan: make(chan *InsertErrors, 20),
 
+		ctx: context.Background(),
+
 		maxRows:  rows,
 		maxDelay: delay,
 
 		done:       make(chan struct{}),
 		closedChan: make(chan struct{}),
+
+		flushChan:   make(chan *tableKey),
+		flushedChan: make(chan struct{}),
 	}
 }
 
@@ -228,6 +236,115 @@ func TestAsyncWorkerMaxDelay(t *testing.T) {
 		ps)
 }
 
+// TestAsyncWorkerMaxDelayPerTable tests rows of a rarely used table are
+// inserted after max delay starting from their own enqueue time,
+// independently of rows of a frequently used table.
+func TestAsyncWorkerMaxDelayPerTable(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Record the time of every insert request per table.
+	fake := &FakeBigQuery{}
+	var mu sync.Mutex
+	inserts := map[string][]time.Time{}
+	c := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			_, _, tID := getInsertMetadata(req.URL.Path)
+			mu.Lock()
+			inserts[tID] = append(inserts[tID], time.Now())
+			mu.Unlock()
+			return fake.RoundTrip(req)
+		})}
+
+	sw, err := NewSyncWorker(&c, SetSyncMaxRetries(10), SetSyncRetryInterval(1*time.Second))
+	require.NoError(err)
+	w := newAsyncWorker(sw, 1000, 100*time.Millisecond)
+	w.Start()
+
+	// Keep enqueueing rows to the high volume table,
+	// and enqueue a single trickle table row in between.
+	var highEnqueued, trickleEnqueued time.Time
+	for i := 0; i < 25; i++ {
+		if i == 0 {
+			highEnqueued = time.Now()
+		}
+		w.rowChan <- NewRowWithID("p", "d", "high", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"})
+		if i == 3 {
+			trickleEnqueued = time.Now()
+			w.rowChan <- NewRowWithID("p", "d", "trickle", "id", map[string]bigquery.JsonValue{"k0": "v0"})
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-w.Close():
+	case <-time.After(1 * time.Second):
+		require.Fail("Close() didn't work as expected")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(fake.Rows(), 26)
+	require.True(len(inserts["high"]) >= 2)
+	require.Len(inserts["trickle"], 1)
+
+	// Test each table was inserted once its own oldest row was due,
+	// and not along with the other table.
+	high := inserts["high"][0].Sub(highEnqueued)
+	assert.True(high >= 100*time.Millisecond && high < 180*time.Millisecond, "high volume table inserted after %s", high)
+	trickle := inserts["trickle"][0].Sub(trickleEnqueued)
+	assert.True(trickle >= 100*time.Millisecond && trickle < 180*time.Millisecond, "trickle table inserted after %s", trickle)
+}
+
+// TestAsyncWorkerInsertDueQueueTime tests enqueue times of rows
+// of tables which aren't due are kept along with their rows.
+func TestAsyncWorkerInsertDueQueueTime(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	stats := &statsRecorder{}
+	sw, err := NewSyncWorker(&http.Client{Transport: &FakeBigQuery{}}, SetSyncStatsHandler(stats))
+	require.NoError(err)
+	w := newAsyncWorker(sw, 1000, time.Minute)
+
+	w.enqueue(NewRowWithID("p", "d", "due", "id0", map[string]bigquery.JsonValue{"k0": "v0"}), false)
+	w.enqueue(NewRowWithID("p", "d", "kept", "id1", map[string]bigquery.JsonValue{"k1": "v1"}), false)
+	w.enqueue(NewRowWithID("p", "d", "due", "id2", map[string]bigquery.JsonValue{"k2": "v2"}), false)
+	keptAt := sw.enqueuedAt[1]
+	w.tables[tableKey{"p", "d", "due"}].due = time.Now().Add(-time.Second)
+	w.insertDue(time.Now())
+
+	require.Len(stats.queueTimes, 1)
+	assert.Equal(int64(2), stats.queueTimes[0].Count())
+	require.Len(sw.rows, 1)
+	assert.Equal("id1", sw.rows[0].InsertID)
+	assert.Equal([]time.Time{keptAt}, sw.enqueuedAt)
+}
+
+// TestAsyncWorkerMaxDelayJitter tests jittered delays fall within
+// the configured bounds, and are randomized.
+func TestAsyncWorkerMaxDelayJitter(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	w := newAsyncWorker(nil, 10, 1*time.Second)
+	assert.Equal(1*time.Second, w.delay())
+
+	w.maxDelayJitter = 0.2
+	delays := make(map[time.Duration]struct{})
+	for i := 0; i < 1000; i++ {
+		d := w.delay()
+		assert.True(d >= 800*time.Millisecond && d <= 1200*time.Millisecond, "delay %s out of bounds", d)
+		delays[d] = struct{}{}
+	}
+	assert.True(len(delays) > 1)
+}
+
 // TestAsyncWorkerMaxRows tests the Worker executes an insert to BigQuery
 // whem max rows are enqueued for a specific table.
 func TestAsyncWorkerMaxRows(t *testing.T) {
@@ -434,3 +551,131 @@ func TestAsyncWorkerInsertErrors(t *testing.T) {
 	// ErrorChan should not receive any more errors in the meanwhile
 	assert.Empty(w.errorChan)
 }
+
+// TestAsyncWorkerMaxBytes tests the Worker executes an insert to BigQuery
+// before enqueueing a row that would exceed the max bytes limit.
+func TestAsyncWorkerMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client which reports the amount of rows in every request.
+	inserted := make(chan int, 10)
+	c := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+
+			inserted <- len(tableReq.Rows)
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Every row is encoded as {"insertId":"idN","json":{"kN":"vN"}}
+	// which is 37 bytes long, plus a separating comma.
+	// Set max bytes to fit exactly two rows.
+	//
+	// Also set max rows and delay to be big enough
+	// so they will not interfere with this test.
+	sw, err := NewSyncWorker(&c, SetSyncMaxRetries(10), SetSyncRetryInterval(1*time.Second), SetSyncMaxBytes(2*38))
+	require.NoError(err)
+	w := newAsyncWorker(sw, 100, 1*time.Minute)
+
+	for i := 0; i < 5; i++ {
+		w.rowChan <- NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{fmt.Sprintf("k%d", i): fmt.Sprintf("v%d", i)})
+	}
+	w.Start()
+
+	// Test two inserts of two rows each were executed,
+	// and the last row is still enqueued.
+	for i := 0; i < 2; i++ {
+		select {
+		case n := <-inserted:
+			assert.Equal(2, n)
+		case <-time.After(1 * time.Second):
+			require.Fail("insert didn't occur as expected")
+		}
+	}
+
+	// Test closing the worker inserts the remaining row.
+	select {
+	case <-w.Close():
+	case <-time.After(1 * time.Second):
+		assert.Fail("Close() didn't work as expected")
+	}
+	require.Len(inserted, 1)
+	assert.Equal(1, <-inserted)
+}
+
+// logRecorder is a Logger recording all messages.
+type logRecorder struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *logRecorder) Debugf(format string, args ...interface{}) { l.record("debug", format, args) }
+func (l *logRecorder) Warnf(format string, args ...interface{})  { l.record("warn", format, args) }
+func (l *logRecorder) Errorf(format string, args ...interface{}) { l.record("error", format, args) }
+
+func (l *logRecorder) record(level, format string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, level+": "+fmt.Sprintf(format, args...))
+}
+
+// TestAsyncWorkerLogger tests worker lifecycle and insert triggers are logged.
+func TestAsyncWorkerLogger(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that always return an "OK" empty response.
+	c := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	l := logRecorder{}
+	sw, err := NewSyncWorker(&c, SetSyncLogger(&l))
+	require.NoError(err)
+	w := newAsyncWorker(sw, 2, 1*time.Minute)
+
+	// Enqueue enough rows for a single insert triggered by max rows,
+	// and another one triggered by closing the worker.
+	for i := 0; i < 3; i++ {
+		w.rowChan <- NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": i})
+	}
+	w.Start()
+
+	select {
+	case <-w.Close():
+	case <-time.After(1 * time.Second):
+		require.Fail("Close() didn't work as expected")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	assert.Equal([]string{
+		"debug: bqstreamer: worker started",
+		"debug: bqstreamer: inserting 2 rows, triggered by max rows",
+		"debug: bqstreamer: inserting 1 rows, triggered by close",
+		"debug: bqstreamer: worker stopped",
+	}, l.messages)
+}
@@ -0,0 +1,99 @@
+// Package bqotel traces bqstreamer insert requests using OpenTelemetry.
+//
+// Every tabledata.insertAll request is wrapped in a client span,
+// carrying the table, amount of rows, attempt number and outcome
+// as attributes:
+//
+//	w, err := bqstreamer.NewSyncWorker(client, bqotel.SetSyncTracerProvider(tp))
+//
+// It is kept in a separate package so bqstreamer itself
+// does not depend on OpenTelemetry.
+package bqotel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	bqstreamer "github.com/verbal/go-bqstreamer"
+)
+
+// Name of the tracer used for creating insert spans.
+const tracerName = "github.com/verbal/go-bqstreamer"
+
+// Span outcome attribute values.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeRejected = "rejected"
+	OutcomeError    = "error"
+)
+
+// insertTracer is a bqstreamer.InsertTracer creating a span for every
+// insert request.
+type insertTracer struct {
+	tracer trace.Tracer
+}
+
+// NewInsertTracer returns a bqstreamer.InsertTracer creating spans
+// using given tracer provider.
+func NewInsertTracer(tp trace.TracerProvider) bqstreamer.InsertTracer {
+	return &insertTracer{tracer: tp.Tracer(tracerName)}
+}
+
+// StartInsert starts a span for a single insert request,
+// which is ended once the returned function is called.
+//
+// The span records an error status if the request has failed
+// or rows have been rejected.
+func (t *insertTracer) StartInsert(ctx context.Context, info bqstreamer.InsertInfo) (context.Context, func(bqstreamer.InsertResult)) {
+	ctx, span := t.tracer.Start(ctx, "bigquery.tabledata.insertAll",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("bigquery.project", info.Project),
+			attribute.String("bigquery.dataset", info.Dataset),
+			attribute.String("bigquery.table", info.Table),
+			attribute.Int("bqstreamer.rows", info.Rows),
+			attribute.Int("bqstreamer.attempt", info.Attempt),
+		))
+
+	return ctx, func(res bqstreamer.InsertResult) {
+		defer span.End()
+
+		span.SetAttributes(attribute.Int("bqstreamer.rejected_rows", res.RejectedRows))
+		switch {
+		case res.Err != nil:
+			span.SetAttributes(attribute.String("bqstreamer.outcome", OutcomeError))
+			span.RecordError(res.Err)
+			span.SetStatus(codes.Error, res.Err.Error())
+		case res.RejectedRows > 0:
+			span.SetAttributes(attribute.String("bqstreamer.outcome", OutcomeRejected))
+			span.SetStatus(codes.Error, "rows rejected")
+		default:
+			span.SetAttributes(attribute.String("bqstreamer.outcome", OutcomeSuccess))
+		}
+	}
+}
+
+// SetSyncTracerProvider sets a SyncWorker to create a span around every
+// insert request to BigQuery, using given tracer provider.
+//
+// NOTE value must not be nil.
+func SetSyncTracerProvider(tp trace.TracerProvider) bqstreamer.SyncOptionFunc {
+	if tp == nil {
+		return bqstreamer.SetSyncInsertTracer(nil)
+	}
+	return bqstreamer.SetSyncInsertTracer(NewInsertTracer(tp))
+}
+
+// SetAsyncTracerProvider sets all workers of an AsyncWorkerGroup to create
+// a span around every insert request to BigQuery, using given tracer provider.
+//
+// NOTE value must not be nil.
+func SetAsyncTracerProvider(tp trace.TracerProvider) bqstreamer.AsyncOptionFunc {
+	if tp == nil {
+		return bqstreamer.SetAsyncInsertTracer(nil)
+	}
+	return bqstreamer.SetAsyncInsertTracer(NewInsertTracer(tp))
+}
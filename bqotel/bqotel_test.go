@@ -0,0 +1,118 @@
+package bqotel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	bqstreamer "github.com/verbal/go-bqstreamer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// spanRecorder is a trace.TracerProvider recording all created spans.
+type spanRecorder struct {
+	noop.TracerProvider
+	spans []*recordedSpan
+}
+
+func (r *spanRecorder) Tracer(name string, options ...trace.TracerOption) trace.Tracer {
+	return &recordingTracer{Tracer: noop.Tracer{}, recorder: r}
+}
+
+type recordingTracer struct {
+	noop.Tracer
+	recorder *spanRecorder
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	s := &recordedSpan{name: name, kind: cfg.SpanKind(), attrs: map[attribute.Key]interface{}{}}
+	s.SetAttributes(cfg.Attributes()...)
+	t.recorder.spans = append(t.recorder.spans, s)
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type recordedSpan struct {
+	noop.Span
+	name   string
+	kind   trace.SpanKind
+	attrs  map[attribute.Key]interface{}
+	status codes.Code
+	ended  bool
+}
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, kv := range kv {
+		s.attrs[kv.Key] = kv.Value.AsInterface()
+	}
+}
+func (s *recordedSpan) SetStatus(code codes.Code, description string) { s.status = code }
+func (s *recordedSpan) End(options ...trace.SpanEndOption)            { s.ended = true }
+
+// TestInsertTracer tests a span is created for every insert request,
+// carrying its attributes and outcome.
+func TestInsertTracer(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tp := spanRecorder{}
+	tracer := NewInsertTracer(&tp)
+
+	for i, res := range []bqstreamer.InsertResult{
+		{Err: errors.New("server error")},
+		{RejectedRows: 1},
+		{},
+	} {
+		ctx, finish := tracer.StartInsert(context.Background(), bqstreamer.InsertInfo{
+			Project: "p", Dataset: "d", Table: "t", Rows: 2, Attempt: i,
+		})
+		require.Equal(tp.spans[i], trace.SpanFromContext(ctx))
+		assert.False(tp.spans[i].ended)
+		finish(res)
+	}
+
+	require.Len(tp.spans, 3)
+	for i, s := range tp.spans {
+		assert.Equal("bigquery.tabledata.insertAll", s.name)
+		assert.Equal(trace.SpanKindClient, s.kind)
+		assert.True(s.ended)
+		assert.Equal("p", s.attrs["bigquery.project"])
+		assert.Equal("d", s.attrs["bigquery.dataset"])
+		assert.Equal("t", s.attrs["bigquery.table"])
+		assert.Equal(int64(2), s.attrs["bqstreamer.rows"])
+		assert.Equal(int64(i), s.attrs["bqstreamer.attempt"])
+	}
+
+	assert.Equal(OutcomeError, tp.spans[0].attrs["bqstreamer.outcome"])
+	assert.Equal(codes.Error, tp.spans[0].status)
+	assert.Equal(OutcomeRejected, tp.spans[1].attrs["bqstreamer.outcome"])
+	assert.Equal(int64(1), tp.spans[1].attrs["bqstreamer.rejected_rows"])
+	assert.Equal(codes.Error, tp.spans[1].status)
+	assert.Equal(OutcomeSuccess, tp.spans[2].attrs["bqstreamer.outcome"])
+	assert.Equal(codes.Unset, tp.spans[2].status)
+}
+
+// TestSetTracerProvider tests the option passthroughs reject a nil provider.
+func TestSetTracerProvider(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	w, err := bqstreamer.NewSyncWorker(&http.Client{}, SetSyncTracerProvider(nil))
+	assert.Nil(w)
+	assert.EqualError(err, "insert tracer is nil")
+
+	w, err = bqstreamer.NewSyncWorker(&http.Client{}, SetSyncTracerProvider(noop.NewTracerProvider()))
+	assert.NotNil(w)
+	assert.NoError(err)
+}
@@ -0,0 +1,461 @@
+// Package bqstorage streams rows to BigQuery using the Storage Write API,
+// as an alternative to bqstreamer's tabledata.insertAll based workers.
+//
+// A StorageWorkerGroup has the same Enqueue(), Start() and Close() surface
+// as bqstreamer.AsyncWorkerGroup. Rows are batched per table by background
+// workers, and appended to the table's default stream:
+//
+//	g, err := bqstorage.NewStorageWorkerGroup(client, bqstorage.SetMaxRows(500))
+//	g.Start()
+//	defer g.Close()
+//	err = g.Enqueue(bqstorage.NewRow("project", "dataset", "table", msg))
+//
+// Rows are protocol buffer messages instead of JSON values.
+// Every message's fields must match the destination table's columns
+// by name and compatible type, as described in
+// https://cloud.google.com/bigquery/docs/write-api#data_type_conversions.
+// Messages may omit nullable columns, but must not have fields
+// missing from the table schema.
+// Different message types may be enqueued to the same table,
+// in which case each is appended using its own stream connection.
+// Use adapt.StorageSchemaToProto2Descriptor() from
+// cloud.google.com/go/bigquery/storage/managedwriter/adapt
+// for deriving a matching descriptor from an existing table schema.
+//
+// The default stream has at-least-once semantics:
+// a retried append may write rows twice, and there are no insert IDs
+// for deduplicating them.
+//
+// It is kept in a separate package so bqstreamer itself
+// does not depend on gRPC and the Storage Write API client.
+package bqstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	bqstreamer "github.com/verbal/go-bqstreamer"
+)
+
+// Row is a single row appended to a table using the Storage Write API.
+type Row struct {
+	ProjectID string
+	DatasetID string
+	TableID   string
+
+	// Message holds the row's column values.
+	// Its fields must match the table schema, see the package doc.
+	Message proto.Message
+}
+
+// NewRow returns a new Row instance, with a message to be appended to given table.
+func NewRow(projectID, datasetID, tableID string, msg proto.Message) Row {
+	return Row{
+		ProjectID: projectID,
+		DatasetID: datasetID,
+		TableID:   tableID,
+		Message:   msg,
+	}
+}
+
+// AppendError is reported for rows which could not be appended to a table,
+// after all retries have been exhausted or due to a non-retryable error.
+type AppendError struct {
+	ProjectID string
+	DatasetID string
+	TableID   string
+
+	// Amount of rows which have not been appended.
+	Rows int
+
+	// Amount of append attempts made.
+	Attempts int
+
+	Err error
+}
+
+func (err *AppendError) Error() string {
+	return fmt.Sprintf("%s.%s.%s: %d rows not appended after %d attempts: %v",
+		err.ProjectID, err.DatasetID, err.TableID, err.Rows, err.Attempts, err.Err)
+}
+
+func (err *AppendError) Unwrap() error { return err.Err }
+
+// IsRetryable returns true if err is a transient Storage Write API error,
+// i.e. appending the same rows again may succeed.
+func IsRetryable(err error) bool {
+	s, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	switch s.Code() {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Internal,
+		codes.Aborted, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// stream appends encoded rows to a single table.
+//
+// It is implemented by managedwriter's ManagedStream,
+// and replaced by a fake in tests.
+type stream interface {
+	append(ctx context.Context, rows [][]byte) error
+	close() error
+}
+
+// openFunc opens a stream to given table, for rows encoded using
+// given message descriptor.
+type openFunc func(ctx context.Context, t table, desc *descriptorpb.DescriptorProto) (stream, error)
+
+// table identifies a destination table.
+type table struct {
+	projectID string
+	datasetID string
+	tableID   string
+}
+
+// streamKey identifies a stream: rows of every message type
+// are appended to a table using a stream of their own,
+// since a stream's schema is fixed when it is opened.
+type streamKey struct {
+	table
+	message protoreflect.FullName
+}
+
+// encodedRow is a row encoded when enqueued,
+// so invalid messages are returned to the caller.
+type encodedRow struct {
+	key  streamKey
+	desc protoreflect.MessageDescriptor
+	data []byte
+}
+
+// StorageWorkerGroup appends rows to BigQuery tables using the
+// Storage Write API's default stream, by multiple background workers.
+//
+// Use NewStorageWorkerGroup() for creating a new StorageWorkerGroup,
+// and call Start() for starting its workers.
+type StorageWorkerGroup struct {
+	open openFunc
+
+	numWorkers    int
+	maxRows       int
+	maxDelay      time.Duration
+	maxRetries    int
+	retryInterval time.Duration
+	errorHandler  func(*AppendError)
+	logger        bqstreamer.Logger
+
+	rowChan chan encodedRow
+
+	// Open streams, by table and message type.
+	streamsMu sync.Mutex
+	streams   map[streamKey]stream
+
+	// Streams' context, canceled once they have been closed
+	// on Close().
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.RWMutex
+	started bool
+	closed  bool
+
+	wg sync.WaitGroup
+}
+
+// NewStorageWorkerGroup returns a new StorageWorkerGroup,
+// appending rows using given managedwriter client.
+//
+// NOTE the client is not closed when the StorageWorkerGroup closes.
+// It is the responsibility of the user to close it.
+func NewStorageWorkerGroup(client *managedwriter.Client, options ...OptionFunc) (*StorageWorkerGroup, error) {
+	if client == nil {
+		return nil, errors.New("client is nil")
+	}
+	return newStorageWorkerGroup(managedStreamOpener(client), options...)
+}
+
+// managedStreamOpener returns an openFunc opening a table's default stream
+// using given client.
+func managedStreamOpener(client *managedwriter.Client) openFunc {
+	return func(ctx context.Context, t table, desc *descriptorpb.DescriptorProto) (stream, error) {
+		ms, err := client.NewManagedStream(ctx,
+			managedwriter.WithDestinationTable(
+				managedwriter.TableParentFromParts(t.projectID, t.datasetID, t.tableID)),
+			managedwriter.WithType(managedwriter.DefaultStream),
+			managedwriter.WithSchemaDescriptor(desc))
+		if err != nil {
+			return nil, err
+		}
+		return &managedStream{ms}, nil
+	}
+}
+
+// managedStream is a stream appending rows using managedwriter.
+type managedStream struct {
+	ms *managedwriter.ManagedStream
+}
+
+// append appends rows and waits for the append to be acknowledged.
+func (s *managedStream) append(ctx context.Context, rows [][]byte) error {
+	res, err := s.ms.AppendRows(ctx, rows)
+	if err != nil {
+		return err
+	}
+	_, err = res.GetResult(ctx)
+	return err
+}
+
+func (s *managedStream) close() error { return s.ms.Close() }
+
+func newStorageWorkerGroup(open openFunc, options ...OptionFunc) (*StorageWorkerGroup, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := StorageWorkerGroup{
+		open:          open,
+		numWorkers:    bqstreamer.DefaultAsyncNumWorkerss,
+		maxRows:       bqstreamer.DefaultAsyncMaxRows,
+		maxDelay:      bqstreamer.DefaultAsyncMaxDelay,
+		maxRetries:    bqstreamer.DefaultSyncMaxRetries,
+		retryInterval: bqstreamer.DefaultSyncRetryInterval,
+		logger:        nopLogger{},
+		streams:       make(map[streamKey]stream),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+
+	for _, option := range options {
+		if err := option(&g); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	// Allow enqueueing up to a full batch per worker before blocking.
+	g.rowChan = make(chan encodedRow, g.maxRows*g.numWorkers)
+
+	return &g, nil
+}
+
+// Start starts all background workers.
+//
+// Rows enqueued before Start() is called are buffered,
+// and Enqueue() blocks once the buffer is full.
+func (g *StorageWorkerGroup) Start() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.started || g.closed {
+		return
+	}
+	g.started = true
+
+	g.wg.Add(g.numWorkers)
+	for i := 0; i < g.numWorkers; i++ {
+		go g.work()
+	}
+}
+
+// Close appends all remaining rows, then stops all workers
+// and closes all streams.
+//
+// It blocks until all workers have returned.
+// Any following Enqueue() calls return bqstreamer.ErrGroupClosed.
+//
+// NOTE Close() must not be called before Start(),
+// or remaining rows are never appended.
+func (g *StorageWorkerGroup) Close() {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return
+	}
+	g.closed = true
+	close(g.rowChan)
+	g.mu.Unlock()
+
+	g.wg.Wait()
+	defer g.cancel()
+
+	g.streamsMu.Lock()
+	defer g.streamsMu.Unlock()
+	for k, s := range g.streams {
+		if err := s.close(); err != nil {
+			g.logger.Warnf("bqstorage: closing %s.%s.%s stream: %v",
+				k.projectID, k.datasetID, k.tableID, err)
+		}
+		delete(g.streams, k)
+	}
+}
+
+// Enqueue encodes given row and enqueues it for appending.
+//
+// It returns an error if the row's message could not be encoded,
+// or bqstreamer.ErrGroupClosed if the StorageWorkerGroup has been closed.
+//
+// NOTE Enqueue() blocks if the workers' buffer is full.
+func (g *StorageWorkerGroup) Enqueue(row Row) error {
+	if row.Message == nil {
+		return errors.New("message is nil")
+	}
+	data, err := proto.Marshal(row.Message)
+	if err != nil {
+		return fmt.Errorf("encoding message: %w", err)
+	}
+	desc := row.Message.ProtoReflect().Descriptor()
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.closed {
+		return bqstreamer.ErrGroupClosed
+	}
+
+	g.rowChan <- encodedRow{
+		key: streamKey{
+			table:   table{row.ProjectID, row.DatasetID, row.TableID},
+			message: desc.FullName(),
+		},
+		desc: desc,
+		data: data,
+	}
+	return nil
+}
+
+// batch holds a single stream's rows buffered by a worker.
+type batch struct {
+	desc protoreflect.MessageDescriptor
+	rows [][]byte
+}
+
+// work reads enqueued rows, and appends them once
+// a stream's batch reaches the max rows,
+// or the max delay has passed since the first buffered row.
+func (g *StorageWorkerGroup) work() {
+	defer g.wg.Done()
+
+	batches := make(map[streamKey]*batch)
+	timer := time.NewTimer(g.maxDelay)
+	timer.Stop()
+
+	flush := func() {
+		for k, b := range batches {
+			g.appendRows(k, b)
+			delete(batches, k)
+		}
+	}
+
+	for {
+		select {
+		case r, ok := <-g.rowChan:
+			if !ok {
+				timer.Stop()
+				flush()
+				return
+			}
+
+			if len(batches) == 0 {
+				timer.Reset(g.maxDelay)
+			}
+			b, ok := batches[r.key]
+			if !ok {
+				b = &batch{desc: r.desc}
+				batches[r.key] = b
+			}
+			b.rows = append(b.rows, r.data)
+
+			if len(b.rows) >= g.maxRows {
+				g.appendRows(r.key, b)
+				delete(batches, r.key)
+				if len(batches) == 0 {
+					// A timer which has already fired
+					// just flushes no batches.
+					timer.Stop()
+				}
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// appendRows appends a batch to its stream,
+// retrying transient errors up to the max retries.
+// Rows which could not be appended are reported to the error handler.
+func (g *StorageWorkerGroup) appendRows(k streamKey, b *batch) {
+	var err error
+	attempts := 0
+	for {
+		var s stream
+		if s, err = g.stream(k, b.desc); err == nil {
+			attempts++
+			if err = s.append(g.ctx, b.rows); err == nil {
+				return
+			}
+		}
+
+		if !IsRetryable(err) || attempts > g.maxRetries || g.ctx.Err() != nil {
+			break
+		}
+		g.logger.Warnf("bqstorage: retrying %d rows to %s.%s.%s: %v",
+			len(b.rows), k.projectID, k.datasetID, k.tableID, err)
+
+		select {
+		case <-time.After(g.retryInterval):
+		case <-g.ctx.Done():
+		}
+	}
+
+	appendErr := &AppendError{
+		ProjectID: k.projectID,
+		DatasetID: k.datasetID,
+		TableID:   k.tableID,
+		Rows:      len(b.rows),
+		Attempts:  attempts,
+		Err:       err,
+	}
+	g.logger.Errorf("bqstorage: %v", appendErr)
+	if g.errorHandler != nil {
+		g.errorHandler(appendErr)
+	}
+}
+
+// stream returns the open stream for given key, opening it if required.
+func (g *StorageWorkerGroup) stream(k streamKey, md protoreflect.MessageDescriptor) (stream, error) {
+	g.streamsMu.Lock()
+	defer g.streamsMu.Unlock()
+
+	if s, ok := g.streams[k]; ok {
+		return s, nil
+	}
+
+	desc, err := adapt.NormalizeDescriptor(md)
+	if err != nil {
+		return nil, fmt.Errorf("normalizing %s descriptor: %w", md.FullName(), err)
+	}
+	s, err := g.open(g.ctx, k.table, desc)
+	if err != nil {
+		return nil, err
+	}
+	g.streams[k] = s
+	return s, nil
+}
+
+// nopLogger is a bqstreamer.Logger discarding all messages.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Warnf(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
@@ -0,0 +1,232 @@
+package bqstorage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	bqstreamer "github.com/verbal/go-bqstreamer"
+)
+
+// fakeStreams records rows appended to every opened stream,
+// failing appends with errs in order until none remain.
+type fakeStreams struct {
+	mu     sync.Mutex
+	opened []table
+	rows   map[table][][]byte
+	closed int
+	errs   []error
+}
+
+func (f *fakeStreams) open(ctx context.Context, t table, desc *descriptorpb.DescriptorProto) (stream, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.opened = append(f.opened, t)
+	return &fakeStream{f: f, t: t}, nil
+}
+
+func (f *fakeStreams) appended(t table) [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rows[t]
+}
+
+type fakeStream struct {
+	f *fakeStreams
+	t table
+}
+
+func (s *fakeStream) append(ctx context.Context, rows [][]byte) error {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	if len(s.f.errs) > 0 {
+		err := s.f.errs[0]
+		s.f.errs = s.f.errs[1:]
+		return err
+	}
+	if s.f.rows == nil {
+		s.f.rows = make(map[table][][]byte)
+	}
+	s.f.rows[s.t] = append(s.f.rows[s.t], rows...)
+	return nil
+}
+
+func (s *fakeStream) close() error {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	s.f.closed++
+	return nil
+}
+
+func marshal(t *testing.T, msg proto.Message) []byte {
+	b, err := proto.Marshal(msg)
+	require.NoError(t, err)
+	return b
+}
+
+func TestNewStorageWorkerGroup(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewStorageWorkerGroup(nil)
+	assert.EqualError(t, err, "client is nil")
+
+	f := &fakeStreams{}
+	for _, option := range []struct {
+		o   OptionFunc
+		err string
+	}{
+		{SetNumWorkers(0), "number of workers must be a positive int"},
+		{SetMaxRows(0), "max rows must be a positive int"},
+		{SetMaxDelay(0), "max delay must be a positive time.Duration"},
+		{SetMaxRetries(-1), "max retries must be a non-negative int"},
+		{SetRetryInterval(0), "retry interval must be a positive time.Duration"},
+		{SetErrorHandler(nil), "error handler is nil"},
+		{SetLogger(nil), "logger is nil"},
+	} {
+		_, err := newStorageWorkerGroup(f.open, option.o)
+		assert.EqualError(t, err, option.err)
+	}
+
+	g, err := newStorageWorkerGroup(f.open,
+		SetNumWorkers(2),
+		SetMaxRows(5),
+		SetMaxDelay(time.Second),
+		SetMaxRetries(1),
+		SetRetryInterval(time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, 2, g.numWorkers)
+	assert.Equal(t, 5, g.maxRows)
+	assert.Equal(t, time.Second, g.maxDelay)
+	assert.Equal(t, 1, g.maxRetries)
+	assert.Equal(t, time.Millisecond, g.retryInterval)
+	assert.Equal(t, 10, cap(g.rowChan))
+}
+
+// Test rows are batched per table and appended by max rows,
+// and remaining rows are appended on Close().
+func TestStorageWorkerGroupAppend(t *testing.T) {
+	t.Parallel()
+
+	f := &fakeStreams{}
+	g, err := newStorageWorkerGroup(f.open,
+		SetNumWorkers(1),
+		SetMaxRows(2),
+		SetMaxDelay(time.Hour))
+	require.NoError(t, err)
+	g.Start()
+
+	t1 := table{"p", "d", "t1"}
+	t2 := table{"p", "d", "t2"}
+	require.NoError(t, g.Enqueue(NewRow("p", "d", "t1", wrapperspb.String("a"))))
+	require.NoError(t, g.Enqueue(NewRow("p", "d", "t2", wrapperspb.String("b"))))
+	require.NoError(t, g.Enqueue(NewRow("p", "d", "t1", wrapperspb.String("c"))))
+
+	// t1 has reached max rows, t2 is still buffered.
+	assert.Eventually(t, func() bool { return len(f.appended(t1)) == 2 }, time.Second, time.Millisecond)
+	assert.Empty(t, f.appended(t2))
+
+	g.Close()
+	assert.Equal(t,
+		[][]byte{marshal(t, wrapperspb.String("a")), marshal(t, wrapperspb.String("c"))},
+		f.appended(t1))
+	assert.Equal(t, [][]byte{marshal(t, wrapperspb.String("b"))}, f.appended(t2))
+	assert.ElementsMatch(t, []table{t1, t2}, f.opened)
+	assert.Equal(t, 2, f.closed)
+
+	assert.Equal(t, bqstreamer.ErrGroupClosed,
+		g.Enqueue(NewRow("p", "d", "t1", wrapperspb.String("d"))))
+}
+
+// Test buffered rows are appended once the max delay has passed.
+func TestStorageWorkerGroupMaxDelay(t *testing.T) {
+	t.Parallel()
+
+	f := &fakeStreams{}
+	g, err := newStorageWorkerGroup(f.open,
+		SetNumWorkers(1),
+		SetMaxRows(10),
+		SetMaxDelay(10*time.Millisecond))
+	require.NoError(t, err)
+	g.Start()
+	defer g.Close()
+
+	require.NoError(t, g.Enqueue(NewRow("p", "d", "t", wrapperspb.String("a"))))
+	assert.Eventually(t, func() bool { return len(f.appended(table{"p", "d", "t"})) == 1 },
+		time.Second, time.Millisecond)
+}
+
+// Test retryable errors are retried up to the max retries,
+// and other errors are reported to the error handler.
+func TestStorageWorkerGroupRetry(t *testing.T) {
+	t.Parallel()
+
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	invalid := status.Error(codes.InvalidArgument, "invalid")
+
+	for _, tc := range []struct {
+		name     string
+		errs     []error
+		appended int
+		attempts int
+		err      error
+	}{
+		{"retried", []error{unavailable}, 1, 0, nil},
+		{"exhausted", []error{unavailable, unavailable, unavailable}, 0, 2, unavailable},
+		{"not retryable", []error{invalid}, 0, 1, invalid},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var mu sync.Mutex
+			var reported []*AppendError
+
+			f := &fakeStreams{errs: tc.errs}
+			g, err := newStorageWorkerGroup(f.open,
+				SetNumWorkers(1),
+				SetMaxRows(1),
+				SetMaxRetries(1),
+				SetRetryInterval(time.Millisecond),
+				SetErrorHandler(func(err *AppendError) {
+					mu.Lock()
+					defer mu.Unlock()
+					reported = append(reported, err)
+				}))
+			require.NoError(t, err)
+			g.Start()
+
+			require.NoError(t, g.Enqueue(NewRow("p", "d", "t", wrapperspb.String("a"))))
+			g.Close()
+
+			assert.Len(t, f.appended(table{"p", "d", "t"}), tc.appended)
+			if tc.err == nil {
+				assert.Empty(t, reported)
+				return
+			}
+			require.Len(t, reported, 1)
+			assert.Equal(t, "t", reported[0].TableID)
+			assert.Equal(t, 1, reported[0].Rows)
+			assert.Equal(t, tc.attempts, reported[0].Attempts)
+			assert.True(t, errors.Is(reported[0], tc.err))
+		})
+	}
+}
+
+func TestStorageWorkerGroupEnqueueNilMessage(t *testing.T) {
+	t.Parallel()
+
+	f := &fakeStreams{}
+	g, err := newStorageWorkerGroup(f.open)
+	require.NoError(t, err)
+	assert.EqualError(t, g.Enqueue(NewRow("p", "d", "t", nil)), "message is nil")
+}
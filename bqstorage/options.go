@@ -0,0 +1,115 @@
+package bqstorage
+
+import (
+	"errors"
+	"time"
+
+	bqstreamer "github.com/verbal/go-bqstreamer"
+)
+
+// OptionFunc is an option for constructing a new StorageWorkerGroup.
+//
+// Defaults are the same as bqstreamer.AsyncWorkerGroup's.
+type OptionFunc func(*StorageWorkerGroup) error
+
+// SetNumWorkers sets the amount of background workers.
+//
+// NOTE value must be a positive int.
+func SetNumWorkers(workers int) OptionFunc {
+	return func(g *StorageWorkerGroup) error {
+		if workers <= 0 {
+			return errors.New("number of workers must be a positive int")
+		}
+		g.numWorkers = workers
+		return nil
+	}
+}
+
+// SetMaxRows sets the maximum amount of rows a worker appends
+// to a single table in one request.
+//
+// NOTE value must be a positive int.
+// A single append request must not exceed 10MB.
+func SetMaxRows(rowLen int) OptionFunc {
+	return func(g *StorageWorkerGroup) error {
+		if rowLen <= 0 {
+			return errors.New("max rows must be a positive int")
+		}
+		g.maxRows = rowLen
+		return nil
+	}
+}
+
+// SetMaxDelay sets the maximum time delay a worker waits
+// before appending its buffered rows, since the first one was enqueued.
+//
+// NOTE value must be a positive time.Duration.
+func SetMaxDelay(delay time.Duration) OptionFunc {
+	return func(g *StorageWorkerGroup) error {
+		if delay <= 0 {
+			return errors.New("max delay must be a positive time.Duration")
+		}
+		g.maxDelay = delay
+		return nil
+	}
+}
+
+// SetMaxRetries sets the maximum amount of retries a failed append
+// is retried, if the error is retryable according to IsRetryable().
+//
+// NOTE value must be a non-negative int.
+func SetMaxRetries(retries int) OptionFunc {
+	return func(g *StorageWorkerGroup) error {
+		if retries < 0 {
+			return errors.New("max retries must be a non-negative int")
+		}
+		g.maxRetries = retries
+		return nil
+	}
+}
+
+// SetRetryInterval sets the time delay before retrying a failed append.
+//
+// NOTE value must be a positive time.Duration.
+func SetRetryInterval(sleep time.Duration) OptionFunc {
+	return func(g *StorageWorkerGroup) error {
+		if sleep <= 0 {
+			return errors.New("retry interval must be a positive time.Duration")
+		}
+		g.retryInterval = sleep
+		return nil
+	}
+}
+
+// SetErrorHandler sets a function called with rows which could not
+// be appended.
+//
+// Errors are only logged by default.
+//
+// NOTE handler is called by the appending worker,
+// which blocks until it returns.
+func SetErrorHandler(handler func(*AppendError)) OptionFunc {
+	return func(g *StorageWorkerGroup) error {
+		if handler == nil {
+			return errors.New("error handler is nil")
+		}
+		g.errorHandler = handler
+		return nil
+	}
+}
+
+// SetLogger sets a logger for append related events,
+// e.g. retries and failures.
+//
+// No messages are logged by default.
+//
+// NOTE the logger is called concurrently by all workers.
+func SetLogger(l bqstreamer.Logger) OptionFunc {
+	return func(g *StorageWorkerGroup) error {
+		if l == nil {
+			return errors.New("logger is nil")
+		}
+		g.logger = l
+		return nil
+	}
+}
@@ -18,4 +18,15 @@
 //    of enqueued rows or time thresholds.
 //  - Errors are reported to an error channel for processing by the user.
 //  - This provides a higher insert throughput for larger scale scenarios.
+//
+// Every Row carries its own project, dataset and table IDs,
+// so a single worker (or worker group) can insert rows to any number of tables.
+// Enqueued rows are grouped by table before inserting,
+// and a separate InsertAll() request is made for every distinct table.
+//
+// This package only uses the InsertAll() API.
+// The BigQuery Storage Write API is cheaper and has a higher throughput,
+// but requires encoding rows as protocol buffers matching the table's schema.
+// The bqstorage subpackage provides a StorageWorkerGroup for it,
+// kept separate so this package doesn't depend on gRPC.
 package bqstreamer
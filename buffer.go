@@ -0,0 +1,176 @@
+package bqstreamer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrBufferFull is returned when enqueueing a row would exceed
+// the max buffered bytes set using SetAsyncMaxBufferedBytes(),
+// and the OverflowRejectNew policy has been set.
+var ErrBufferFull = errors.New("max buffered bytes exceeded")
+
+// OverflowPolicy decides what happens when enqueueing a row would exceed
+// the max buffered bytes set using SetAsyncMaxBufferedBytes().
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks enqueueing until enough buffered rows have been
+	// inserted, the same as when the row channel is full. This is the default.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest rows in the row channel
+	// until the row fits, counted in Stats().DroppedRows.
+	// Rows already enqueued by workers aren't dropped,
+	// so enqueueing still blocks if the row channel is empty.
+	OverflowDropOldest
+
+	// OverflowRejectNew rejects the enqueued row, returning ErrBufferFull.
+	OverflowRejectNew
+)
+
+// bufferBudget bounds the accumulated size of buffered rows,
+// i.e. rows in the row channel and rows enqueued by workers,
+// shared by an AsyncWorkerGroup and all of its workers.
+//
+// Bytes are reserved when a row is enqueued to the group,
+// and released once the worker's insert operation has completed,
+// or the row has been dropped.
+type bufferBudget struct {
+	max    int64
+	policy OverflowPolicy
+
+	// Returns a row's size in bytes.
+	size func(Row) int
+
+	// Counts dropped rows, accessed atomically.
+	dropped int64
+
+	// Called for every dropped row if set.
+	onDrop func(Row)
+
+	mu   sync.Mutex
+	used int64
+
+	// Closed and replaced on every release, notifying blocked reservations.
+	released chan struct{}
+}
+
+func newBufferBudget(max int, policy OverflowPolicy, size func(Row) int) *bufferBudget {
+	return &bufferBudget{
+		max:      int64(max),
+		policy:   policy,
+		size:     size,
+		released: make(chan struct{}),
+	}
+}
+
+// tryReserve reserves given amount of bytes if they fit,
+// or if nothing is buffered, so a single row larger than the limit
+// can still be enqueued.
+//
+// Otherwise it returns false, along with a channel closed once bytes
+// have been released.
+func (b *bufferBudget) tryReserve(n int) (bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.used > 0 && b.used+int64(n) > b.max {
+		return false, b.released
+	}
+	b.used += int64(n)
+	return true, nil
+}
+
+// reserve reserves given amount of bytes for a row sent to rowChan,
+// according to the overflow policy.
+//
+// It returns ctx.Err() if ctx is done while blocking,
+// or ErrGroupClosed if closed is closed.
+func (b *bufferBudget) reserve(ctx context.Context, closed <-chan struct{}, rowChan chan Row, n int) error {
+	for {
+		ok, released := b.tryReserve(n)
+		if ok {
+			return nil
+		}
+
+		switch b.policy {
+		case OverflowRejectNew:
+			return ErrBufferFull
+		case OverflowDropOldest:
+			if b.drop(rowChan) {
+				continue
+			}
+		}
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-closed:
+			return ErrGroupClosed
+		}
+	}
+}
+
+// tryReserveNow is similar to reserve(), but never blocks,
+// returning false instead.
+func (b *bufferBudget) tryReserveNow(rowChan chan Row, n int) bool {
+	for {
+		if ok, _ := b.tryReserve(n); ok {
+			return true
+		}
+		if b.policy != OverflowDropOldest || !b.drop(rowChan) {
+			return false
+		}
+	}
+}
+
+// drop drops the oldest row in given row channel, releasing its bytes.
+// It returns false if the channel is empty.
+func (b *bufferBudget) drop(rowChan chan Row) bool {
+	select {
+	case r := <-rowChan:
+		atomic.AddInt64(&b.dropped, 1)
+		b.release(b.size(r))
+		if b.onDrop != nil {
+			b.onDrop(r)
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// release releases given amount of bytes.
+func (b *bufferBudget) release(n int) {
+	if n == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used -= int64(n)
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// reset releases all bytes, e.g. of rows abandoned in the row channel.
+func (b *bufferBudget) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used = 0
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// buffered returns the amount of bytes currently reserved.
+func (b *bufferBudget) buffered() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
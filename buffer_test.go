@@ -0,0 +1,58 @@
+package bqstreamer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBufferBudget tests reserving and releasing buffered bytes.
+func TestBufferBudget(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	b := newBufferBudget(10, OverflowBlock, func(Row) int { return 4 })
+
+	// Test a single row is allowed to exceed the limit.
+	ok, _ := b.tryReserve(20)
+	assert.True(ok)
+	ok, released := b.tryReserve(1)
+	assert.False(ok)
+	b.release(20)
+	<-released
+
+	// Test blocked reservations proceed once bytes are released.
+	assert.NoError(b.reserve(context.Background(), nil, nil, 8))
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		b.release(8)
+	}()
+	assert.NoError(b.reserve(context.Background(), nil, nil, 8))
+	assert.Equal(int64(8), b.buffered())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, b.reserve(ctx, nil, nil, 8))
+	closed := make(chan struct{})
+	close(closed)
+	assert.Equal(ErrGroupClosed, b.reserve(context.Background(), closed, nil, 8))
+
+	// Test the oldest rows are dropped, until the row channel is empty.
+	b = newBufferBudget(10, OverflowDropOldest, func(Row) int { return 4 })
+	rowChan := make(chan Row, 2)
+	for i := 0; i < 2; i++ {
+		assert.True(b.tryReserveNow(rowChan, 4))
+		rowChan <- Row{InsertID: "old"}
+	}
+	assert.True(b.tryReserveNow(rowChan, 4))
+	assert.Len(rowChan, 1)
+	assert.False(b.tryReserveNow(rowChan, 10))
+	assert.Equal(int64(2), b.dropped)
+	assert.Equal(int64(4), b.buffered())
+
+	b.reset()
+	assert.Equal(int64(0), b.buffered())
+}
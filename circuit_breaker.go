@@ -0,0 +1,142 @@
+package bqstreamer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is reported for inserts short-circuited by the circuit breaker
+// set using SetAsyncCircuitBreaker(), without being sent to BigQuery.
+//
+// It is not retried by IsRetryable().
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed allows all inserts. This is the default.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen short-circuits all inserts until the cooldown has passed.
+	CircuitOpen
+
+	// CircuitHalfOpen allows a single insert, probing whether BigQuery
+	// has recovered. The circuit is closed if it succeeds,
+	// and opened again otherwise.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker short-circuits insert requests after too many consecutive
+// failures, shared by all workers of an AsyncWorkerGroup.
+type circuitBreaker struct {
+	// Amount of consecutive failures opening the circuit.
+	threshold int
+
+	// Time to wait after opening the circuit before probing.
+	cooldown time.Duration
+
+	// Returns the current time, overridden by unit tests.
+	now func() time.Time
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+
+	// Set while the half-open probe is in flight.
+	probing bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow returns true if an insert request may be executed.
+//
+// Once the cooldown has passed, a single request is allowed as a probe.
+// Every allowed request must be followed by a call to success(), failure()
+// or release().
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		fallthrough
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// success records a successful insert request, closing the circuit.
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = CircuitClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// failure records a failed insert request,
+// opening the circuit if it was the probe or too many have failed.
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.state = CircuitOpen
+		b.openedAt = b.now()
+	}
+	b.probing = false
+}
+
+// release records an insert request with an inconclusive result,
+// e.g. one canceled by its context, allowing another probe.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// currentState returns the circuit's current state.
+//
+// An open circuit whose cooldown has passed is reported as half-open.
+func (b *circuitBreaker) currentState() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
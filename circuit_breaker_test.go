@@ -0,0 +1,59 @@
+package bqstreamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCircuitBreaker tests circuit breaker state transitions.
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	now := time.Now()
+	b := newCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	// Test a success resets consecutive failures.
+	assert.True(b.allow())
+	b.failure()
+	assert.True(b.allow())
+	b.success()
+	assert.True(b.allow())
+	b.failure()
+	assert.Equal(CircuitClosed, b.currentState())
+
+	// Test the circuit opens after enough consecutive failures.
+	assert.True(b.allow())
+	b.failure()
+	assert.Equal(CircuitOpen, b.currentState())
+	assert.False(b.allow())
+
+	// Test a single probe is allowed after the cooldown,
+	// and a failed probe opens the circuit again.
+	now = now.Add(time.Minute)
+	assert.Equal(CircuitHalfOpen, b.currentState())
+	assert.True(b.allow())
+	assert.False(b.allow())
+	b.failure()
+	assert.Equal(CircuitOpen, b.currentState())
+	assert.False(b.allow())
+
+	// Test an inconclusive probe allows another one.
+	now = now.Add(time.Minute)
+	assert.True(b.allow())
+	b.release()
+	assert.Equal(CircuitHalfOpen, b.currentState())
+	assert.True(b.allow())
+
+	// Test a successful probe closes the circuit.
+	b.success()
+	assert.Equal(CircuitClosed, b.currentState())
+	assert.True(b.allow())
+	assert.True(b.allow())
+
+	assert.Equal("half-open", CircuitHalfOpen.String())
+}
@@ -0,0 +1,34 @@
+package bqstreamer
+
+import "time"
+
+// clock tells the time and creates timers for workers,
+// so time based behavior, e.g. max delay and retry backoff,
+// can be tested deterministically using a fake clock.
+//
+// It is realClock unless set using setSyncClock() or setAsyncClock().
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) clockTimer
+}
+
+// clockTimer is a timer created by a clock, same as time.Timer.
+type clockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// realClock is a clock using the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) clockTimer { return realTimer{time.NewTimer(d)} }
+
+// realTimer is a clockTimer wrapping a time.Timer.
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
@@ -0,0 +1,264 @@
+package bqstreamer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock only advanced by Advance(),
+// firing timers due by then.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) clockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	t.reset(d)
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance advances the clock by given duration, firing due timers.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		if t.active && !t.due.After(c.now) {
+			t.active = false
+			t.c <- c.now
+		}
+	}
+}
+
+// activeTimers returns the amount of timers not fired nor stopped yet.
+func (c *fakeClock) activeTimers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	c      chan time.Time
+	due    time.Time
+	active bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.active
+	t.reset(d)
+	return active
+}
+
+// reset activates the timer, due after given duration.
+//
+// NOTE the clock's mutex must be locked.
+func (t *fakeTimer) reset(d time.Duration) {
+	t.due = t.clock.now.Add(d)
+	t.active = true
+}
+
+// waitFor polls given condition until it holds, failing after a second.
+func waitFor(t *testing.T, cond func() bool) {
+	for start := time.Now(); !cond(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("condition not met")
+		}
+	}
+}
+
+// TestAsyncWorkerGroupClock tests rows are inserted after max delay
+// according to the clock, without waiting for it in real time.
+func TestAsyncWorkerGroupClock(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var calls int32
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	clock := newFakeClock()
+	m, err := newAsyncWorkerGroup(func() *http.Client { return &client }, SetAsyncNumWorkers(1), SetAsyncMaxRows(10), SetAsyncMaxDelay(1*time.Minute), SetAsyncRetryInterval(1*time.Second), SetAsyncMaxRetries(10), setAsyncClock(clock))
+	require.NoError(err)
+	m.Start()
+	defer m.Close()
+
+	// Wait for the worker to read the row, and set its timer.
+	require.NoError(m.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})))
+	waitFor(t, func() bool { return m.Stats().EnqueuedRows == 1 && clock.activeTimers() == 1 })
+
+	// Test rows aren't inserted before max delay.
+	clock.Advance(30 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	assert.EqualValues(0, atomic.LoadInt32(&calls))
+
+	// Test rows are inserted once max delay has passed.
+	clock.Advance(30 * time.Second)
+	waitFor(t, func() bool { return m.Stats().InsertedRows == 1 })
+	assert.EqualValues(1, atomic.LoadInt32(&calls))
+}
+
+// TestSyncWorkerClock tests retries are delayed according to the clock.
+func TestSyncWorkerClock(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that always fails.
+	var calls int32
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 503,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Retry after an hour, which would time out the test in real time.
+	clock := newFakeClock()
+	w, err := NewSyncWorker(&client, SetSyncMaxRetries(1), SetSyncRetryInterval(1*time.Hour), setSyncClock(clock))
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	done := make(chan *InsertErrors)
+	go func() { done <- w.InsertWithRetry() }()
+
+	waitFor(t, func() bool { return clock.activeTimers() == 1 })
+	assert.EqualValues(1, atomic.LoadInt32(&calls))
+	clock.Advance(1 * time.Hour)
+
+	tables := (<-done).All()
+	require.Len(tables, 1)
+	assert.Len(tables[0].Attempts(), 3)
+	assert.EqualValues(2, atomic.LoadInt32(&calls))
+}
+
+// TestSyncWorkerRetryAfterBackoff tests retries wait for the longer of the
+// retry backoff and the delay requested by the Retry-After header,
+// for both failed requests and rows rejected due to transient errors.
+func TestSyncWorkerRetryAfterBackoff(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+	}{
+		{"request", 503, `{}`},
+		{"rows", 200, `{"insertErrors":[{"index":0,"errors":[{"reason":"backendError"}]}]}`},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Fail the first request, requesting a retry after 5 seconds.
+			var calls int32
+			client := http.Client{
+				Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+					res := http.Response{
+						Header:     make(http.Header),
+						Request:    req,
+						StatusCode: 200,
+						// Empty JSON body, meaning "no errors".
+						Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+					if atomic.AddInt32(&calls, 1) == 1 {
+						res.StatusCode = test.statusCode
+						res.Header.Set("Retry-After", "5")
+						res.Body = ioutil.NopCloser(bytes.NewBufferString(test.body))
+					}
+
+					return &res, nil
+				})}
+
+			clock := newFakeClock()
+			w, err := NewSyncWorker(&client, SetSyncMaxRetries(1), SetSyncRetryBackoff(1*time.Second, 1*time.Second, 1), SetSyncRetryTransientRows(true), setSyncClock(clock))
+			require.NoError(err)
+
+			w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+			done := make(chan *InsertErrors)
+			go func() { done <- w.InsertWithRetry() }()
+
+			// Test the backoff alone doesn't trigger the retry,
+			// i.e. the retry timer is still pending after advancing past it.
+			waitFor(t, func() bool { return clock.activeTimers() == 1 })
+			clock.Advance(4 * time.Second)
+			assert.Equal(1, clock.activeTimers())
+			assert.EqualValues(1, atomic.LoadInt32(&calls))
+
+			// Test rows are retried once the requested delay has passed.
+			clock.Advance(1 * time.Second)
+			<-done
+			assert.EqualValues(2, atomic.LoadInt32(&calls))
+			assert.EqualValues(1, w.counters.stats(0).InsertedRows)
+		})
+	}
+}
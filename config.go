@@ -0,0 +1,191 @@
+package bqstreamer
+
+import "time"
+
+// Config is a snapshot of an AsyncWorkerGroup's resolved configuration,
+// i.e. its defaults overridden by given options,
+// as returned by AsyncWorkerGroup.Config().
+//
+// It's meant for logging the effective configuration, e.g. for debugging,
+// and modifying it has no effect on the group.
+type Config struct {
+	// Current amount of background workers,
+	// see AsyncWorkerGroup.SetNumWorkers().
+	NumWorkers int
+
+	// Same as SetAsyncMaxRows(), SetAsyncMaxBytes()
+	// and SetAsyncMaxRowsPerRequest().
+	MaxRows           int
+	MaxBytes          int
+	MaxRowsPerRequest int
+
+	// Same as SetAsyncMaxDelay() and SetAsyncMaxDelayJitter().
+	MaxDelay       time.Duration
+	MaxDelayJitter float64
+
+	// Same as SetAsyncStartStagger() and SetAsyncWarmup().
+	StartStagger time.Duration
+	Warmup       bool
+
+	// Same as SetAsyncMaxRetries(), SetAsyncRetryInterval()
+	// and SetAsyncRetryDeadline().
+	MaxRetries    int
+	RetryInterval time.Duration
+	RetryDeadline time.Duration
+
+	// Same as SetAsyncRetryBackoff(), zero if not set.
+	BackoffInitial    time.Duration
+	BackoffMax        time.Duration
+	BackoffMultiplier float64
+
+	// Same as SetAsyncRetryStopped() and SetAsyncRetryTransientRows().
+	RetryStopped       bool
+	RetryTransientRows bool
+
+	// Same as SetAsyncIgnoreUnknownValues() and SetAsyncSkipInvalidRows().
+	// Tables may override them using SetAsyncTableOptions().
+	IgnoreUnknownValues bool
+	SkipInvalidRows     bool
+
+	// Same as SetAsyncMaxBufferedBytes() and SetAsyncOverflowPolicy().
+	MaxBufferedBytes int
+	OverflowPolicy   OverflowPolicy
+
+	// Same as SetAsyncDispatchStrategy().
+	// Sharded is true if a shard key has been set using SetAsyncShardKey().
+	DispatchStrategy DispatchStrategy
+	Sharded          bool
+
+	// Same as SetAsyncMaxConcurrentInserts(), SetAsyncMaxInFlightBytes()
+	// and SetAsyncInsertTimeout().
+	MaxConcurrentInserts int
+	MaxInFlightBytes     int
+	InsertTimeout        time.Duration
+
+	// Same as SetAsyncCloseGracePeriod() and SetAsyncCancelInFlightOnClose().
+	CloseGracePeriod      time.Duration
+	CancelInFlightOnClose bool
+
+	// Same as SetAsyncErrorAggregation().
+	ErrorAggregation time.Duration
+
+	// Same as SetAsyncTableStats(), zero if not set.
+	MaxTrackedTables int
+
+	// Same as SetAsyncRowTTL(), zero if not set.
+	RowTTL time.Duration
+
+	// Same as SetAsyncMaxLineSize().
+	MaxLineSize int
+
+	// Same as SetAsyncPropagateContext().
+	PropagateContext bool
+
+	// Same as SetAsyncHighWaterMark() and SetAsyncLowWaterMark(),
+	// zero if not set. LowWaterMark defaults to half of HighWaterMark.
+	HighWaterMark float64
+	LowWaterMark  float64
+
+	// Connection settings, see SetAsyncEndpoint(), SetAsyncNetworkMode(),
+	// SetAsyncDialTimeout() and SetAsyncKeepAlive().
+	// They have no effect if a custom transport or http.Client is used.
+	Endpoint    string
+	NetworkMode NetworkMode
+	DialTimeout time.Duration
+	KeepAlive   time.Duration
+
+	// Same as SetAsyncGzip(), SetAsyncGzipMinBytes(), SetAsyncUserAgent()
+	// and SetAsyncQuotaProject().
+	Gzip         bool
+	GzipMinBytes int
+	UserAgent    string
+	QuotaProject string
+
+	// Same as SetAsyncHealthThresholds().
+	HealthMaxFailureRate float64
+	HealthMaxBufferUsage float64
+	HealthWindow         time.Duration
+}
+
+// Config returns the group's resolved configuration,
+// e.g. for confirming options have been applied as intended.
+//
+// It is safe for concurrent use.
+func (s *AsyncWorkerGroup) Config() Config {
+	s.workersMu.Lock()
+	numWorkers := s.numWorkers
+	s.workersMu.Unlock()
+
+	var lowWaterMark float64
+	if s.waterMark != nil {
+		lowWaterMark = s.waterMark.low
+	}
+
+	return Config{
+		NumWorkers: numWorkers,
+
+		MaxRows:           s.maxRows,
+		MaxBytes:          s.maxBytes,
+		MaxRowsPerRequest: s.maxRowsPerRequest,
+
+		MaxDelay:       s.maxDelay,
+		MaxDelayJitter: s.maxDelayJitter,
+
+		StartStagger: s.startStagger,
+		Warmup:       s.warmup,
+
+		MaxRetries:    s.maxRetries,
+		RetryInterval: s.retryInterval,
+		RetryDeadline: s.retryDeadline,
+
+		BackoffInitial:    s.backoffInitial,
+		BackoffMax:        s.backoffMax,
+		BackoffMultiplier: s.backoffMultiplier,
+
+		RetryStopped:       s.retryStopped,
+		RetryTransientRows: s.retryTransientRows,
+
+		IgnoreUnknownValues: s.ignoreUnknownValues,
+		SkipInvalidRows:     s.skipInvalidRows,
+
+		MaxBufferedBytes: s.maxBufferedBytes,
+		OverflowPolicy:   s.overflowPolicy,
+
+		DispatchStrategy: s.dispatch,
+		Sharded:          s.shardKey != nil,
+
+		MaxConcurrentInserts: s.maxConcurrentInserts,
+		MaxInFlightBytes:     s.maxInFlightBytes,
+		InsertTimeout:        s.insertTimeout,
+
+		CloseGracePeriod:      s.closeGracePeriod,
+		CancelInFlightOnClose: s.cancelInFlightOnClose,
+
+		ErrorAggregation: s.errorAggregation,
+
+		MaxTrackedTables: s.maxTrackedTables,
+
+		RowTTL: s.rowTTL,
+
+		MaxLineSize: s.maxLineSize,
+
+		PropagateContext: s.propagateContext,
+
+		HighWaterMark: s.highWaterMark,
+		LowWaterMark:  lowWaterMark,
+
+		Endpoint:    s.endpoint,
+		NetworkMode: s.networkMode,
+		DialTimeout: s.dialTimeout,
+		KeepAlive:   s.keepAlive,
+
+		Gzip:         s.gzip,
+		GzipMinBytes: s.gzipMinBytes,
+		UserAgent:    s.userAgent,
+		QuotaProject: s.quotaProject,
+
+		HealthMaxFailureRate: s.healthMaxFailureRate,
+		HealthMaxBufferUsage: s.healthMaxBufferUsage,
+		HealthWindow:         s.healthWindow,
+	}
+}
@@ -0,0 +1,111 @@
+package bqstreamer
+
+import (
+	"context"
+	"time"
+)
+
+// insertContext merges the contexts of a table's rows enqueued using
+// AsyncWorkerGroup.EnqueueContext(), see SetAsyncPropagateContext().
+type insertContext struct {
+	// Context of the table's first row with a context, providing values,
+	// e.g. the producer's trace span.
+	values context.Context
+
+	// Latest deadline among the rows' contexts,
+	// and the amount of rows with a deadline.
+	deadline  time.Time
+	deadlines int
+}
+
+// add merges given row context.
+func (c *insertContext) add(ctx context.Context) {
+	if c.values == nil {
+		c.values = ctx
+	}
+	if d, ok := ctx.Deadline(); ok {
+		c.deadlines++
+		if d.After(c.deadline) {
+			c.deadline = d
+		}
+	}
+}
+
+// context returns a context derived from given insert operation's context
+// of a table's n rows, holding the row contexts' values,
+// and the latest deadline if all rows have one.
+// This way no row's deadline aborts the insert of other rows before theirs.
+// Row contexts canceled before their deadline don't cancel it,
+// since other rows of the table are still to be inserted.
+//
+// It returns ctx as is if no row contexts have been added.
+func (c *insertContext) context(ctx context.Context, n int) (context.Context, context.CancelFunc) {
+	if c == nil || c.values == nil {
+		return ctx, func() {}
+	}
+	ctx = valuesContext{Context: ctx, values: c.values}
+	if c.deadlines < n {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, c.deadline)
+}
+
+// deadlineExceeded returns true if given row has been enqueued with
+// a context whose deadline has already passed, see SetAsyncPropagateContext().
+func deadlineExceeded(r Row) bool {
+	return r.ctx != nil && r.ctx.Err() == context.DeadlineExceeded
+}
+
+// expiredContexts reports given rows, whose contexts' deadlines passed
+// before their insert, as dropped without inserting them,
+// and spills them if set.
+func (w *SyncWorker) expiredContexts(rows []Row) {
+	if len(rows) == 0 {
+		return
+	}
+
+	w.logger.Warnf("bqstreamer: dropping %d rows whose context deadline has passed", len(rows))
+	if w.spill != nil {
+		if err := w.spill(rows); err != nil {
+			w.logger.Errorf("bqstreamer: spilling %d rows failed: %v", len(rows), err)
+		}
+	}
+	if w.dropped != nil {
+		w.dropped(rows, DropContextDone)
+	} else {
+		resolveRows(rows, context.DeadlineExceeded)
+	}
+}
+
+// valuesContext is a context looking up values in a row context first,
+// while being canceled along with the insert operation's context.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+// traceIDKey is the context key of an insert request's trace ID,
+// see SetSyncTraceID().
+type traceIDKey struct{}
+
+// traceContext returns given context holding the trace ID of an insert
+// request of given rows, if a trace ID func has been set.
+func (w *SyncWorker) traceContext(ctx context.Context, rows []Row) context.Context {
+	if w.traceID == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, traceIDKey{}, w.traceID(rows))
+}
+
+// traceID returns the trace ID held by given context, if any.
+func traceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
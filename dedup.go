@@ -0,0 +1,38 @@
+package bqstreamer
+
+import "sync/atomic"
+
+// dedupKey identifies duplicate rows of a table.
+type dedupKey struct {
+	table tableKey
+	key   string
+}
+
+// dedup returns given rows, keeping only the last occurrence of rows
+// of the same table with the same dedup key, in their original order.
+// Rows with an empty key are always kept.
+//
+// Collapsed rows are counted in the worker's counters,
+// and the given slice isn't modified.
+func (w *SyncWorker) dedup(rows []Row) []Row {
+	last := make(map[dedupKey]int, len(rows))
+	for i, r := range rows {
+		if k := w.dedupKey(r); k != "" {
+			last[dedupKey{tableKey{r.ProjectID, r.DatasetID, r.TableID}, k}] = i
+		}
+	}
+
+	kept := make([]Row, 0, len(last))
+	for i, r := range rows {
+		if k := w.dedupKey(r); k != "" && last[dedupKey{tableKey{r.ProjectID, r.DatasetID, r.TableID}, k}] != i {
+			// Superseded rows aren't inserted on purpose.
+			r.result.resolve(nil)
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if n := len(rows) - len(kept); n > 0 {
+		atomic.AddInt64(&w.counters.dedupedRows, int64(n))
+	}
+	return kept
+}
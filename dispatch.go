@@ -0,0 +1,73 @@
+package bqstreamer
+
+import (
+	"context"
+	"reflect"
+)
+
+// DispatchStrategy decides how enqueued rows are dispatched to workers,
+// set using SetAsyncDispatchStrategy().
+type DispatchStrategy int
+
+const (
+	// SharedQueue sends rows to a single row channel shared by all workers,
+	// so whichever worker is available reads the next row. This is the default.
+	//
+	// Rows are never held back by a busy worker,
+	// e.g. one retrying a failed insert, since other workers keep reading them.
+	SharedQueue DispatchStrategy = iota
+
+	// PerWorkerQueue sends rows to a row channel per worker,
+	// picking the channel holding the fewest rows,
+	// or whichever has room first once all of them are full.
+	//
+	// A busy worker's channel fills up, so new rows are sent to less loaded
+	// workers instead. However, rows already in its channel wait for it,
+	// even if other workers are available.
+	PerWorkerQueue
+)
+
+// shortestChan returns the channel holding the fewest rows,
+// or the first of them if several do.
+func shortestChan(chans []chan Row) chan Row {
+	c := chans[0]
+	for _, other := range chans[1:] {
+		if len(other) < len(c) {
+			c = other
+		}
+	}
+	return c
+}
+
+// sendShortest sends given row to the channel holding the fewest rows,
+// or blocks until any of them has room if all are full,
+// so enqueueing isn't held back by a single stuck worker.
+//
+// It returns ctx.Err() if ctx is done first,
+// or ErrGroupClosed if closed is closed.
+func sendShortest(ctx context.Context, closed <-chan struct{}, chans []chan Row, row Row) error {
+	select {
+	case shortestChan(chans) <- row:
+		return nil
+	default:
+	}
+
+	// The amount of channels is only known at runtime.
+	cases := make([]reflect.SelectCase, 0, len(chans)+2)
+	cases = append(cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(closed)},
+	)
+	v := reflect.ValueOf(row)
+	for _, c := range chans {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(c), Send: v})
+	}
+
+	switch i, _, _ := reflect.Select(cases); i {
+	case 0:
+		return ctx.Err()
+	case 1:
+		return ErrGroupClosed
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package bqstreamer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestShortestChan tests the channel holding the fewest rows is returned,
+// preferring the first one.
+func TestShortestChan(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	chans := []chan Row{make(chan Row, 3), make(chan Row, 3), make(chan Row, 3)}
+	assert.Equal(chans[0], shortestChan(chans))
+
+	chans[0] <- Row{}
+	assert.Equal(chans[1], shortestChan(chans))
+
+	chans[1] <- Row{}
+	chans[2] <- Row{}
+	assert.Equal(chans[0], shortestChan(chans))
+
+	chans[0] <- Row{}
+	chans[1] <- Row{}
+	assert.Equal(chans[2], shortestChan(chans))
+}
+
+// TestSendShortest tests rows are sent to any channel with room
+// once the shortest one is full.
+func TestSendShortest(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	chans := []chan Row{make(chan Row, 1), make(chan Row, 1)}
+	closed := make(chan struct{})
+	assert.NoError(sendShortest(context.Background(), closed, chans, Row{TableID: "t0"}))
+	assert.NoError(sendShortest(context.Background(), closed, chans, Row{TableID: "t1"}))
+	assert.Len(chans[0], 1)
+	assert.Len(chans[1], 1)
+
+	// Block until the second channel has room.
+	go func() { <-chans[1] }()
+	assert.NoError(sendShortest(context.Background(), closed, chans, Row{TableID: "t2"}))
+	assert.Equal(Row{TableID: "t0"}, <-chans[0])
+	assert.Equal(Row{TableID: "t2"}, <-chans[1])
+
+	// Test returning early once all channels are full.
+	chans[0] <- Row{}
+	chans[1] <- Row{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(context.Canceled, sendShortest(ctx, closed, chans, Row{}))
+	close(closed)
+	assert.Equal(ErrGroupClosed, sendShortest(context.Background(), closed, chans, Row{}))
+}
@@ -0,0 +1,91 @@
+package bqstreamer
+
+import "errors"
+
+// DropReason is the reason a row couldn't be enqueued to an AsyncWorkerGroup,
+// and has thus been dropped without being inserted.
+//
+// It is reported to StatsHandler.RowsDropped(),
+// and to the handler set using SetAsyncDropHandler().
+type DropReason int
+
+const (
+	// DropGroupClosed is reported for rows enqueued to a closed group.
+	DropGroupClosed DropReason = iota
+
+	// DropContextDone is reported for rows whose enqueue context was done
+	// before they could be enqueued, or whose enqueue context's deadline
+	// passed before their insert, see SetAsyncPropagateContext().
+	DropContextDone
+
+	// DropBufferFull is reported for rows rejected due to a full buffer,
+	// i.e. by the OverflowRejectNew policy, or by TryEnqueue().
+	DropBufferFull
+
+	// DropOldest is reported for buffered rows dropped
+	// by the OverflowDropOldest policy, making room for new ones.
+	DropOldest
+
+	// DropExpired is reported for buffered rows older than the row TTL
+	// set using SetAsyncRowTTL(), dropped instead of being inserted.
+	DropExpired
+)
+
+func (r DropReason) String() string {
+	switch r {
+	case DropGroupClosed:
+		return "group closed"
+	case DropContextDone:
+		return "context done"
+	case DropBufferFull:
+		return "buffer full"
+	case DropOldest:
+		return "dropped oldest"
+	case DropExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// RowDroppedError is received for a row enqueued using
+// AsyncWorkerGroup.EnqueueWithResult() which has been dropped
+// without being inserted, for given reason.
+//
+// It implements the error interface.
+type RowDroppedError struct {
+	Reason DropReason
+}
+
+func (err *RowDroppedError) Error() string {
+	return "Row dropped: " + err.Reason.String()
+}
+
+// dropReason returns the reason of rows not enqueued due to given error.
+func dropReason(err error) DropReason {
+	switch {
+	case errors.Is(err, ErrGroupClosed):
+		return DropGroupClosed
+	case errors.Is(err, ErrBufferFull):
+		return DropBufferFull
+	default:
+		return DropContextDone
+	}
+}
+
+// dropped reports given rows as dropped for given reason,
+// to the stats handler and the drop handler if set.
+func (s *AsyncWorkerGroup) dropped(rows []Row, reason DropReason) {
+	if len(rows) == 0 {
+		return
+	}
+	resolveRows(rows, &RowDroppedError{Reason: reason})
+	if s.stats != nil {
+		s.stats.RowsDropped(len(rows), reason)
+	}
+	if s.dropHandler != nil {
+		for _, row := range rows {
+			s.dropHandler(row, reason)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package bqstreamer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrGroupClosed is returned when enqueueing rows into an AsyncWorkerGroup
+// that has been closed.
+var ErrGroupClosed = errors.New("worker group is closed")
+
+// UndrainedRowsError is returned by AsyncWorkerGroup.CloseContext()
+// if its context is done before all rows have been inserted.
+type UndrainedRowsError struct {
+	// Amount of rows abandoned without being inserted.
+	// This includes rows of insert operations interrupted by the context,
+	// which may or may not have been inserted.
+	Rows int
+
+	// The context error, e.g. context.DeadlineExceeded.
+	Err error
+}
+
+func (err *UndrainedRowsError) Error() string {
+	return fmt.Sprintf("%d rows were not inserted: %s", err.Rows, err.Err)
+}
+
+// Unwrap returns the context error.
+func (err *UndrainedRowsError) Unwrap() error { return err.Err }
+
+// CredentialsError is returned when constructing an AsyncWorkerGroup
+// whose credentials failed to be verified, see SetAsyncVerifyCredentials().
+type CredentialsError struct {
+	// The error returned when fetching an OAuth2 token.
+	Err error
+}
+
+func (err *CredentialsError) Error() string {
+	return fmt.Sprintf("invalid credentials: %s", err.Err)
+}
+
+// Unwrap returns the token error.
+func (err *CredentialsError) Unwrap() error { return err.Err }
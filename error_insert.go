@@ -57,3 +57,18 @@ func (insert *InsertErrors) All() []*TableInsertErrors {
 	}
 	return tables
 }
+
+// RowErrors returns errors of all rows rejected by BigQuery,
+// matched to the original enqueued rows,
+// so callers don't need to map row indices back to their rows.
+//
+// Unlike Next() and All(), row errors are not consumed.
+// However, tables already iterated over using Next() or All()
+// are not included.
+func (insert *InsertErrors) RowErrors() []RowError {
+	var rowErrs []RowError
+	for _, table := range insert.Tables {
+		rowErrs = append(rowErrs, table.rowErrors()...)
+	}
+	return rowErrs
+}
@@ -167,3 +167,53 @@ func TestInsertErrors(t *testing.T) {
 	// Compare constructed insertErrors and the original one.
 	assert.EqualValues(cmpInsertErrs, &InsertErrors{ts})
 }
+
+// TestInsertErrorsRowErrors tests row errors are matched to their rows.
+func TestInsertErrorsRowErrors(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rows := []Row{
+		NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}),
+		NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}),
+	}
+	rowErr := &bigquery.ErrorProto{Location: "k1", Message: "m", Reason: "invalid"}
+	insertErrs := &InsertErrors{
+		[]*TableInsertErrors{
+			&TableInsertErrors{
+				rows: rows,
+				InsertAttempts: []*TableInsertAttemptErrors{
+					// Failed requests reject no rows.
+					&TableInsertAttemptErrors{
+						err:       errs.New("test error"),
+						insertIDs: []string{"id0", "id1"},
+						rows: []*bigquery.TableDataInsertAllResponseInsertErrors{
+							&bigquery.TableDataInsertAllResponseInsertErrors{Index: 0},
+						},
+					},
+					&TableInsertAttemptErrors{
+						insertIDs: []string{"id0", "id1"},
+						rows: []*bigquery.TableDataInsertAllResponseInsertErrors{
+							&bigquery.TableDataInsertAllResponseInsertErrors{
+								Index:  1,
+								Errors: []*bigquery.ErrorProto{rowErr},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	rowErrs := insertErrs.RowErrors()
+	require.Len(rowErrs, 1)
+	assert.Equal(rows[1], rowErrs[0].Row)
+	assert.Equal(1, rowErrs[0].Index)
+	assert.Equal([]*bigquery.ErrorProto{rowErr}, rowErrs[0].Errors)
+
+	// Test row errors are not consumed.
+	assert.Len(insertErrs.RowErrors(), 1)
+	assert.Len(insertErrs.All()[0].Attempts()[1].All(), 1)
+}
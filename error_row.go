@@ -1,16 +1,23 @@
 package bqstreamer
 
-import bigquery "google.golang.org/api/bigquery/v2"
+import (
+	"strings"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+)
 
 // RowErrors contains errors relating to a single row.
 // Each row can have multiple errors associated with it.
 type RowErrors struct {
-	// A table insert operation can be split into multiple requests
-	// if too many rows have been queued. This means that rows
-	// containing errors cannot be identified by their table index.
-	// Therefore, each row can be identified by its insert ID instead.
+	// The row's insert ID, used for deduplication.
 	InsertID string
 
+	// The row's index in the table's enqueued rows.
+	//
+	// If the table's rows have been split into multiple requests,
+	// the index is still relative to all of the table's rows.
+	Index int64
+
 	tableDataInsertAllResponseInsertErrors bigquery.TableDataInsertAllResponseInsertErrors
 }
 
@@ -52,3 +59,54 @@ func (row *RowErrors) All() []*bigquery.ErrorProto {
 	}
 	return errors
 }
+
+// RowError associates errors of a row rejected by BigQuery
+// with the original enqueued Row.
+type RowError struct {
+	// The rejected row, as enqueued.
+	Row Row
+
+	// The row's index in its table's enqueued rows.
+	Index int
+
+	// The errors BigQuery reported for the row.
+	Errors []*bigquery.ErrorProto
+
+	// The insert attempt which rejected the row.
+	attempt *TableInsertAttemptErrors
+}
+
+// RowRejectedError is passed to the dead-letter handler,
+// for a row that has been rejected by BigQuery and will not be retried.
+//
+// It implements the error interface.
+type RowRejectedError struct {
+	// The errors BigQuery reported for the row.
+	Errors []*bigquery.ErrorProto
+
+	// The table name associated with the row.
+	Table string
+
+	// The  dataset name associated with the row.
+	Dataset string
+
+	// The project associated with the row.
+	Project string
+}
+
+func (err *RowRejectedError) Error() string {
+	// The equivalent format is "Row rejected by table %s.%s.%s: %s: %s, ..."
+	reasons := make([]string, 0, len(err.Errors))
+	for _, e := range err.Errors {
+		reasons = append(reasons, e.Reason+": "+e.Message)
+	}
+	return strings.Join(
+		[]string{
+			"Row rejected by table ",
+			err.Project, ".",
+			err.Dataset, ".",
+			err.Table, ": ",
+			strings.Join(reasons, ", "),
+		},
+		"")
+}
@@ -39,3 +39,18 @@ func TestRowErrors(t *testing.T) {
 		assert.Equal(t, errCmp.Reason, err.Reason, i)
 	}
 }
+
+func TestRowRejectedError(t *testing.T) {
+	t.Parallel()
+
+	err := RowRejectedError{
+		Errors: []*bigquery.ErrorProto{
+			&bigquery.ErrorProto{Location: "l1", Message: "m1", Reason: "r1"},
+			&bigquery.ErrorProto{Location: "l2", Message: "m2", Reason: "r2"},
+		},
+		Table:   "t",
+		Dataset: "d",
+		Project: "p",
+	}
+	assert.EqualError(t, &err, "Row rejected by table p.d.t: r1: m1, r2: m2")
+}
@@ -1,8 +1,10 @@
 package bqstreamer
 
 import (
+	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	bigquery "google.golang.org/api/bigquery/v2"
 )
@@ -11,6 +13,51 @@ import (
 // from a bulk insert operation.
 type TableInsertErrors struct {
 	InsertAttempts []*TableInsertAttemptErrors
+
+	// The table's enqueued rows, in order,
+	// used for matching row errors to their rows by index.
+	rows []Row
+
+	// Indices of rows of failed insert operations, which weren't inserted.
+	failed []int
+
+	// Errors of all rows not inserted by index, i.e. failed rows
+	// and rows of insert operations interrupted by a done context,
+	// see AsyncWorkerGroup.EnqueueWithResult().
+	notInserted map[int]error
+
+	// Amount of rows inserted, i.e. neither rejected nor failed.
+	inserted int
+
+	// Amount of rows inserted by a retry of a timed out request,
+	// counted as likely suppressed duplicates instead of inserted.
+	suppressed int
+}
+
+// rowErrors returns errors of all rows rejected in the table's insert
+// attempts, matched to their enqueued rows.
+//
+// Row errors are not consumed, and can still be iterated over afterwards.
+func (table *TableInsertErrors) rowErrors() []RowError {
+	var rowErrs []RowError
+	for _, attempt := range table.InsertAttempts {
+		// Rows are only rejected by a successful insert request.
+		if attempt.err != nil {
+			continue
+		}
+		for _, errs := range attempt.rows {
+			if errs.Index < 0 || errs.Index >= int64(len(table.rows)) {
+				continue
+			}
+			rowErrs = append(rowErrs, RowError{
+				Row:     table.rows[errs.Index],
+				Index:   int(errs.Index),
+				Errors:  errs.Errors,
+				attempt: attempt,
+			})
+		}
+	}
+	return rowErrs
 }
 
 // Attempts returns all insert attempts for a single table,
@@ -36,6 +83,9 @@ type TableInsertAttemptErrors struct {
 	// Used for identifying rows by their insertIDs instead of their index.
 	insertIDs []string
 
+	// Relevant headers of BigQuery's response, see responseHeader().
+	header http.Header
+
 	// The table name associated with the insert attempt.
 	Table string
 
@@ -44,6 +94,10 @@ type TableInsertAttemptErrors struct {
 
 	// The project associated with the insert attempt.
 	Project string
+
+	// The template suffix associated with the insert attempt, if any.
+	// Rows were inserted to the table named Table + TemplateSuffix.
+	TemplateSuffix string
 }
 
 // Next iterates over the attempt's rows once,
@@ -65,7 +119,8 @@ func (table *TableInsertAttemptErrors) Next() (*RowErrors, bool) {
 
 	row := RowErrors{
 		tableDataInsertAllResponseInsertErrors: errors,
-		InsertID: table.insertIDs[errors.Index],
+		InsertID:                               table.insertIDs[errors.Index],
+		Index:                                  errors.Index,
 	}
 
 	return &row, true
@@ -96,6 +151,37 @@ func (table *TableInsertAttemptErrors) All() []*RowErrors {
 // SkipInvalidRows set to false.
 func (table *TableInsertAttemptErrors) Error() error { return table.err }
 
+// Header returns headers of BigQuery's response to the insert attempt
+// relevant for debugging, e.g. for logging quota related headers.
+//
+// Only Retry-After and headers prefixed with X-Goog- are kept,
+// e.g. X-Goog-Quota-* headers.
+// Nil is returned if the attempt has failed without a response.
+func (table *TableInsertAttemptErrors) Header() http.Header { return table.header }
+
+// InsertIDs returns the insert IDs of the attempt's rows, in order,
+// e.g. for correlating rows with BigQuery's logs.
+// Rows enqueued without an insert ID have an empty one.
+func (table *TableInsertAttemptErrors) InsertIDs() []string {
+	return append([]string(nil), table.insertIDs...)
+}
+
+// responseHeader returns the headers of given response headers
+// relevant for debugging, or nil if there are none.
+func responseHeader(h http.Header) http.Header {
+	var relevant http.Header
+	for k, v := range h {
+		if k != "Retry-After" && !strings.HasPrefix(k, "X-Goog-") {
+			continue
+		}
+		if relevant == nil {
+			relevant = make(http.Header)
+		}
+		relevant[k] = v
+	}
+	return relevant
+}
+
 // TooManyFailedInsertAttemptsError is returned when a specific insert attempt
 // has been retried and failed multiple times,
 // causing the worker to stop retrying and drop that table's insert operation
@@ -130,3 +216,17 @@ func (err *TooManyFailedInsertRetriesError) Error() string {
 		},
 		"")
 }
+
+// InsertTimeoutError is returned when a single insert request has exceeded
+// the timeout set using SetSyncInsertTimeout().
+// It is retried by IsRetryable().
+//
+// It implements the error interface.
+type InsertTimeoutError struct {
+	// The insert request timeout.
+	Timeout time.Duration
+}
+
+func (err *InsertTimeoutError) Error() string {
+	return "Insert request timed out after " + err.Timeout.String()
+}
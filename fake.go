@@ -0,0 +1,193 @@
+// Here be an in-memory fake BigQuery for testing code using this package.
+
+package bqstreamer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// FakeBigQuery is an in-memory fake of BigQuery's insert API,
+// recording inserted rows instead of sending them.
+//
+// It implements http.RoundTripper, and can be used for testing producers
+// without credentials or network access, either using NewFakeWorkerGroup(),
+// or with a SyncWorker:
+//
+//	fake := &FakeBigQuery{}
+//	w, err := NewSyncWorker(&http.Client{Transport: fake})
+//
+// The zero value is ready to use, accepting all rows.
+// It is safe for concurrent use by multiple workers.
+type FakeBigQuery struct {
+	// RejectRow is called for every row sent to BigQuery if set.
+	// Returning errors rejects the row using them,
+	// e.g. for testing handling of rejected rows.
+	RejectRow func(row Row) []*bigquery.ErrorProto
+
+	// FailInsert is called for every insert request if set.
+	// Returning a non-zero HTTP status code fails the entire request with it,
+	// e.g. 503 for testing retries, or 400 for a non-retryable error.
+	FailInsert func(projectID, datasetID, tableID string) int
+
+	mu       sync.Mutex
+	rows     []Row
+	rejected []Row
+	requests int
+}
+
+// Rows returns all rows inserted so far, in their order of insertion.
+//
+// Rows inserted by different workers are not ordered between them,
+// and rows of retried requests may be returned multiple times.
+func (f *FakeBigQuery) Rows() []Row {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Row(nil), f.rows...)
+}
+
+// RejectedRows returns all rows rejected using RejectRow so far.
+func (f *FakeBigQuery) RejectedRows() []Row {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Row(nil), f.rejected...)
+}
+
+// Requests returns the amount of insert requests received so far,
+// including failed ones.
+func (f *FakeBigQuery) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// RoundTrip handles given insert request, and returns BigQuery's response.
+func (f *FakeBigQuery) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Transports must close the request body.
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+
+	projectID, datasetID, tableID, ok := parseInsertPath(req.URL.Path)
+	if !ok {
+		return fakeResponse(req, http.StatusNotFound, `{"error":{"code":404,"message":"not found"}}`), nil
+	}
+
+	f.mu.Lock()
+	f.requests++
+	failInsert := f.FailInsert
+	f.mu.Unlock()
+
+	if failInsert != nil {
+		if code := failInsert(projectID, datasetID, tableID); code != 0 {
+			body := fmt.Sprintf(`{"error":{"code":%d,"message":%q}}`, code, http.StatusText(code))
+			return fakeResponse(req, code, body), nil
+		}
+	}
+
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = zr
+	}
+	var tableReq bigquery.TableDataInsertAllRequest
+	if err := json.NewDecoder(body).Decode(&tableReq); err != nil {
+		return fakeResponse(req, http.StatusBadRequest, `{"error":{"code":400,"message":"invalid request"}}`), nil
+	}
+
+	var res bigquery.TableDataInsertAllResponse
+	f.mu.Lock()
+	for i, reqRow := range tableReq.Rows {
+		row := Row{
+			ProjectID:      projectID,
+			DatasetID:      datasetID,
+			TableID:        tableID,
+			Data:           reqRow.Json,
+			InsertID:       reqRow.InsertId,
+			TemplateSuffix: tableReq.TemplateSuffix,
+		}
+		if f.RejectRow != nil {
+			if errs := f.RejectRow(row); len(errs) > 0 {
+				res.InsertErrors = append(res.InsertErrors, &bigquery.TableDataInsertAllResponseInsertErrors{
+					Index:  int64(i),
+					Errors: errs,
+				})
+				f.rejected = append(f.rejected, row)
+				continue
+			}
+		}
+		f.rows = append(f.rows, row)
+	}
+	f.mu.Unlock()
+
+	b, err := json.Marshal(&res)
+	if err != nil {
+		return nil, err
+	}
+	return fakeResponse(req, http.StatusOK, string(b)), nil
+}
+
+// parseInsertPath returns the table IDs of given insert request URL path,
+// or false if it isn't an insert request.
+func parseInsertPath(path string) (projectID, datasetID, tableID string, ok bool) {
+	parts := strings.Split(strings.TrimSuffix(path, "/insertAll"), "/")
+	if len(parts) < 6 || !strings.HasSuffix(path, "/insertAll") {
+		return "", "", "", false
+	}
+	parts = parts[len(parts)-6:]
+	if parts[0] != "projects" || parts[2] != "datasets" || parts[4] != "tables" {
+		return "", "", "", false
+	}
+	return parts[1], parts[3], parts[5], true
+}
+
+// fakeResponse returns a JSON response to given request.
+func fakeResponse(req *http.Request, code int, body string) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	return &http.Response{
+		Header:     header,
+		Request:    req,
+		StatusCode: code,
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+	}
+}
+
+// NewFakeWorkerGroup returns a new AsyncWorkerGroup inserting rows
+// to given fake BigQuery, for testing code using an AsyncWorkerGroup.
+//
+// Workers use the same options as NewAsyncWorkerGroup(),
+// except that a single worker is used by default,
+// inserting rows every 10ms with a 1ms retry interval,
+// so tests don't need to set all options.
+func NewFakeWorkerGroup(fake *FakeBigQuery, options ...AsyncOptionFunc) (*AsyncWorkerGroup, error) {
+	if fake == nil {
+		return nil, errors.New("fake BigQuery is nil")
+	}
+
+	newHTTPClient := func() *http.Client {
+		return &http.Client{Transport: fake}
+	}
+	defaults := []AsyncOptionFunc{
+		SetAsyncNumWorkers(1),
+		SetAsyncMaxRows(DefaultAsyncMaxRows),
+		SetAsyncMaxDelay(10 * time.Millisecond),
+		SetAsyncRetryInterval(1 * time.Millisecond),
+		SetAsyncMaxRetries(DefaultSyncMaxRetries),
+	}
+	return newAsyncWorkerGroup(newHTTPClient, append(defaults, options...)...)
+}
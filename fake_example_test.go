@@ -0,0 +1,34 @@
+package bqstreamer
+
+import (
+	"fmt"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// This example tests a producer using an AsyncWorkerGroup,
+// by inserting its rows to a fake BigQuery instead.
+func ExampleNewFakeWorkerGroup() {
+	fake := &FakeBigQuery{}
+	g, err := NewFakeWorkerGroup(fake)
+	if err != nil {
+		panic(err)
+	}
+	g.Start()
+
+	// The producer under test enqueues rows.
+	for i := 0; i < 3; i++ {
+		g.Enqueue(NewRow("my-project", "my-dataset", "my-table", map[string]bigquery.JsonValue{"n": i}))
+	}
+
+	// Closing the group inserts all enqueued rows.
+	g.Close()
+
+	for _, row := range fake.Rows() {
+		fmt.Println(row.TableID, row.Data["n"])
+	}
+	// Output:
+	// my-table 0
+	// my-table 1
+	// my-table 2
+}
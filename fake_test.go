@@ -0,0 +1,86 @@
+package bqstreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// TestFakeBigQuery tests the fake records inserted rows,
+// and simulates rejected rows and failed requests.
+func TestFakeBigQuery(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	fake := &FakeBigQuery{
+		RejectRow: func(row Row) []*bigquery.ErrorProto {
+			if row.InsertID == "id1" {
+				return []*bigquery.ErrorProto{{Reason: "invalid", Message: "m1"}}
+			}
+			return nil
+		},
+		FailInsert: func(projectID, datasetID, tableID string) int {
+			if tableID == "t2" {
+				return 400
+			}
+			return 0
+		},
+	}
+
+	errChan := make(chan *InsertErrors, 10)
+	g, err := NewFakeWorkerGroup(fake, SetAsyncErrorChannel(errChan), SetAsyncGzip(true))
+	require.NoError(err)
+	g.Start()
+
+	require.NoError(g.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})))
+	require.NoError(g.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"})))
+	require.NoError(g.Enqueue(NewRowWithID("p", "d", "t2", "id2", map[string]bigquery.JsonValue{"k2": "v2"})))
+	g.Close()
+
+	assert.Equal([]Row{NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"})}, fake.Rows())
+	rejected := fake.RejectedRows()
+	require.Len(rejected, 1)
+	assert.Equal("id1", rejected[0].InsertID)
+	assert.Equal(2, fake.Requests())
+
+	// Test errors were reported as usual.
+	var (
+		rowErrs int
+		failed  int
+	)
+	for len(errChan) > 0 {
+		for _, table := range (<-errChan).All() {
+			for _, attempt := range table.Attempts() {
+				if attempt.Error() != nil {
+					failed++
+				}
+				rowErrs += len(attempt.All())
+			}
+		}
+	}
+	assert.Equal(1, rowErrs)
+	assert.Equal(1, failed)
+
+	_, err = NewFakeWorkerGroup(nil)
+	assert.EqualError(err, "fake BigQuery is nil")
+}
+
+// TestParseInsertPath tests parsing insert request URL paths.
+func TestParseInsertPath(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	p, d, tbl, ok := parseInsertPath("/bigquery/v2/projects/p/datasets/d/tables/t/insertAll")
+	assert.True(ok)
+	assert.Equal([]string{"p", "d", "t"}, []string{p, d, tbl})
+
+	_, _, _, ok = parseInsertPath("/bigquery/v2/projects/p/datasets/d/tables/t")
+	assert.False(ok)
+	_, _, _, ok = parseInsertPath("/insertAll")
+	assert.False(ok)
+}
@@ -0,0 +1,67 @@
+package bqstreamer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// gzipTransport is an http.RoundTripper compressing request bodies
+// using gzip, before sending them using the base RoundTripper.
+type gzipTransport struct {
+	// Uses http.DefaultTransport if nil.
+	base http.RoundTripper
+
+	// Bodies of up to this size in bytes are sent uncompressed,
+	// see SetSyncGzipMinBytes().
+	minBytes int
+}
+
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return base.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Send small bodies as is, without the Content-Encoding header.
+	if len(body) <= t.minBytes {
+		return base.RoundTrip(withBody(req, body))
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	r := withBody(req, buf.Bytes())
+	r.Header.Set("Content-Encoding", "gzip")
+
+	return base.RoundTrip(r)
+}
+
+// withBody returns a clone of given request with given body,
+// since a RoundTripper must not modify the given request.
+func withBody(req *http.Request, b []byte) *http.Request {
+	r := req.Clone(req.Context())
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	r.ContentLength = int64(len(b))
+	return r
+}
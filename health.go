@@ -0,0 +1,95 @@
+package bqstreamer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrFailureRateExceeded is returned by AsyncWorkerGroup.Healthy()
+// when too many recent insert requests have failed.
+var ErrFailureRateExceeded = errors.New("insert failure rate exceeded")
+
+// ErrBufferSaturated is returned by AsyncWorkerGroup.Healthy()
+// when buffered rows are close to blocking enqueueing.
+var ErrBufferSaturated = errors.New("buffer saturated")
+
+// healthMinRequests is the minimum amount of recent insert requests
+// for computing a failure rate, so a single failed request of an otherwise
+// idle group doesn't report it as unhealthy.
+const healthMinRequests = 5
+
+// healthBuckets is the amount of buckets a health window is divided into.
+const healthBuckets = 10
+
+// healthBucket counts insert requests of a single window bucket.
+type healthBucket struct {
+	// Index of the bucket's time slot, counting from the Unix epoch.
+	slot     int64
+	requests int
+	failures int
+}
+
+// healthWindow counts insert requests and their failures
+// over a sliding time window, shared by all workers of an AsyncWorkerGroup.
+//
+// The window is divided into fixed buckets,
+// which are reset once they fall out of it.
+type healthWindow struct {
+	bucket time.Duration
+
+	// Returns the current time, overridden by unit tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets [healthBuckets]healthBucket
+}
+
+func newHealthWindow(window time.Duration) *healthWindow {
+	bucket := window / healthBuckets
+	if bucket < 1 {
+		bucket = 1
+	}
+	return &healthWindow{
+		bucket: bucket,
+		now:    time.Now,
+	}
+}
+
+// observe counts an insert request, and whether it has failed.
+func (h *healthWindow) observe(failed bool) {
+	slot := h.now().UnixNano() / int64(h.bucket)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	b := &h.buckets[slot%healthBuckets]
+	if b.slot != slot {
+		*b = healthBucket{slot: slot}
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+}
+
+// failureRate returns the fraction of failed insert requests in the window,
+// along with the amount of requests.
+func (h *healthWindow) failureRate() (float64, int) {
+	slot := h.now().UnixNano() / int64(h.bucket)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var requests, failures int
+	for _, b := range h.buckets {
+		if b.slot > slot-healthBuckets && b.slot <= slot {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	if requests == 0 {
+		return 0, 0
+	}
+	return float64(failures) / float64(requests), requests
+}
@@ -0,0 +1,44 @@
+package bqstreamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHealthWindow tests failure rates are computed over recent requests only.
+func TestHealthWindow(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	now := time.Unix(0, 0)
+	h := newHealthWindow(10 * time.Second)
+	h.now = func() time.Time { return now }
+
+	rate, n := h.failureRate()
+	assert.Equal(0.0, rate)
+	assert.Equal(0, n)
+
+	h.observe(true)
+	h.observe(false)
+	now = now.Add(5 * time.Second)
+	h.observe(true)
+	h.observe(true)
+	rate, n = h.failureRate()
+	assert.Equal(0.75, rate)
+	assert.Equal(4, n)
+
+	// Test requests falling out of the window aren't counted.
+	now = now.Add(5 * time.Second)
+	h.observe(false)
+	rate, n = h.failureRate()
+	assert.Equal(2.0/3, rate)
+	assert.Equal(3, n)
+
+	now = now.Add(time.Minute)
+	rate, n = h.failureRate()
+	assert.Equal(0.0, rate)
+	assert.Equal(0, n)
+}
@@ -0,0 +1,80 @@
+package bqstreamer
+
+import (
+	"context"
+	"sync"
+)
+
+// inFlightLimit is a weighted semaphore bounding the accumulated size
+// of in-flight insert requests, i.e. sent but not yet responded to,
+// shared by all workers of an AsyncWorkerGroup.
+//
+// Bytes are acquired before every request with its size,
+// and released once it has completed.
+type inFlightLimit struct {
+	max int64
+
+	mu   sync.Mutex
+	used int64
+
+	// Closed and replaced on every release, notifying blocked requests.
+	released chan struct{}
+}
+
+func newInFlightLimit(max int) *inFlightLimit {
+	return &inFlightLimit{
+		max:      int64(max),
+		released: make(chan struct{}),
+	}
+}
+
+// acquire acquires given amount of bytes, blocking until they fit.
+// A single request larger than the limit is let through if no other
+// request is in flight, so it doesn't block forever.
+//
+// It returns ctx.Err() if ctx is done while blocking.
+func (l *inFlightLimit) acquire(ctx context.Context, n int) error {
+	for {
+		l.mu.Lock()
+		if l.used == 0 || l.used+int64(n) <= l.max {
+			l.used += int64(n)
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release releases given amount of bytes.
+func (l *inFlightLimit) release(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.used -= int64(n)
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// inFlight returns the amount of bytes currently acquired.
+func (l *inFlightLimit) inFlight() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.used
+}
+
+// requestBytes returns the size in bytes of an insert request
+// of given table's rows, as encoded for the request.
+func requestBytes(tbl table) int {
+	n := requestOverheadBytes
+	for _, row := range tbl {
+		n += encodedRowSize(row)
+	}
+	return n
+}
@@ -0,0 +1,38 @@
+package bqstreamer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInFlightLimit tests acquiring and releasing in-flight bytes.
+func TestInFlightLimit(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	l := newInFlightLimit(10)
+
+	// Test a single request is allowed to exceed the limit.
+	assert.NoError(l.acquire(context.Background(), 20))
+	assert.Equal(int64(20), l.inFlight())
+	l.release(20)
+
+	// Test blocked requests proceed once bytes are released.
+	assert.NoError(l.acquire(context.Background(), 8))
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		l.release(8)
+	}()
+	assert.NoError(l.acquire(context.Background(), 8))
+	assert.Equal(int64(8), l.inFlight())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, l.acquire(ctx, 8))
+	assert.NoError(l.acquire(context.Background(), 2))
+	assert.Equal(int64(10), l.inFlight())
+}
@@ -0,0 +1,39 @@
+package bqstreamer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// ContentHashInsertID returns an insert ID derived from given row's contents,
+// i.e. its table, template suffix and data, for use with SetAsyncAutoInsertID().
+//
+// It is the hex encoded SHA-256 hash of the contents, the same for rows with
+// the same contents, so identical rows enqueued more than once are inserted
+// only once on a best effort basis. Rows expected to be identical
+// should hold a distinguishing value, e.g. a timestamp or sequence number.
+//
+// Data rows are hashed as encoded for the insert request, with their columns
+// sorted by name, so a row's Data and its RawData equivalent may be hashed
+// differently. It returns an empty ID, sending none, if Data fails encoding.
+func ContentHashInsertID(row Row) string {
+	data := row.RawData
+	if data == nil {
+		b, err := json.Marshal(row.Data)
+		if err != nil {
+			return ""
+		}
+		data = b
+	}
+
+	h := sha256.New()
+	for _, s := range []string{row.ProjectID, row.DatasetID, row.TableID, row.TemplateSuffix} {
+		// Separate fields with a null byte, so e.g. tables "ab" and "a"
+		// with suffix "b" aren't hashed the same.
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,81 @@
+package bqstreamer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// TestContentHashInsertID tests insert IDs are the same for rows
+// with the same contents, and differ otherwise.
+func TestContentHashInsertID(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	row := NewRowWithID("p", "d", "t", "", map[string]bigquery.JsonValue{"k0": "v0", "k1": "v1"})
+	id := ContentHashInsertID(row)
+	assert.Len(id, 64)
+	assert.Equal(id, ContentHashInsertID(NewRowWithID("p", "d", "t", "", map[string]bigquery.JsonValue{"k1": "v1", "k0": "v0"})))
+	// The row's own insert ID isn't part of its contents.
+	assert.Equal(id, ContentHashInsertID(NewRowWithID("p", "d", "t", "id0", row.Data)))
+
+	suffixed := row
+	suffixed.TemplateSuffix = "_s"
+	for _, other := range []Row{
+		NewRowWithID("p", "d", "t", "", map[string]bigquery.JsonValue{"k0": "v0", "k1": "v2"}),
+		NewRowWithID("p", "d", "t2", "", row.Data),
+		NewRowWithID("p", "d2", "t", "", row.Data),
+		NewRowWithID("p", "dt", "", "", row.Data),
+		suffixed,
+	} {
+		assert.NotEqual(id, ContentHashInsertID(other))
+	}
+
+	raw := NewRawRow("p", "d", "t", json.RawMessage(`{"k0":"v0","k1":"v1"}`))
+	assert.Equal(ContentHashInsertID(raw), ContentHashInsertID(NewRawRow("p", "d", "t", raw.RawData)))
+
+	// Test rows failing encoding get no insert ID.
+	assert.Equal("", ContentHashInsertID(NewRowWithID("p", "d", "t", "", map[string]bigquery.JsonValue{"k0": func() {}})))
+}
+
+// TestAsyncWorkerGroupAutoInsertID tests rows enqueued without an insert ID
+// are sent with a derived one, while other rows keep theirs.
+func TestAsyncWorkerGroupAutoInsertID(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	fake := FakeBigQuery{}
+	m, err := NewFakeWorkerGroup(&fake, SetAsyncNumWorkers(1), SetAsyncAutoInsertID(ContentHashInsertID))
+	require.NoError(err)
+
+	r0 := NewRowWithID("p", "d", "t", "", map[string]bigquery.JsonValue{"k0": "v0"})
+	r1 := NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"})
+	r2 := NewRowWithID("p", "d", "t", "", map[string]bigquery.JsonValue{"k2": "v2"})
+	require.NoError(m.Enqueue(r0))
+	require.NoError(m.Enqueue(r1))
+	require.True(m.TryEnqueue(r2))
+	m.Start()
+	m.Close()
+
+	rows := fake.Rows()
+	require.Len(rows, 3)
+	assert.Equal(ContentHashInsertID(r0), rows[0].InsertID)
+	assert.Equal("id1", rows[1].InsertID)
+	assert.Equal(ContentHashInsertID(r2), rows[2].InsertID)
+
+	// Test rows are sent without an insert ID by default.
+	fake = FakeBigQuery{}
+	m, err = NewFakeWorkerGroup(&fake)
+	require.NoError(err)
+	require.NoError(m.Enqueue(r0))
+	m.Start()
+	m.Close()
+	require.Len(fake.Rows(), 1)
+	assert.Equal("", fake.Rows()[0].InsertID)
+}
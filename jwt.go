@@ -5,18 +5,21 @@ import (
 
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2/jwt"
+	bigquery "google.golang.org/api/bigquery/v2"
 )
 
 // NewJWTConfig returns a new JWT configuration from a JSON key,
 // acquired via https://console.developers.google.com.
 //
-// It returns a jwt.Config, used to authenticate with Google OAuth2.
+// It returns a jwt.Config, used to authenticate with Google OAuth2,
+// requesting the minimal scope required for streaming inserts,
+// bigquery.BigqueryInsertdataScope. Use SetAsyncScopes() for other scopes.
 func NewJWTConfig(keyPath string) (c *jwt.Config, err error) {
 	b, err := ioutil.ReadFile(keyPath)
 	if err != nil {
 		return
 	}
-	c, err = google.JWTConfigFromJSON(b, "https://www.googleapis.com/auth/bigquery")
+	c, err = google.JWTConfigFromJSON(b, bigquery.BigqueryInsertdataScope)
 	// No need to check if err != nil since we return anyways.
 	return
 }
@@ -0,0 +1,129 @@
+package bqstreamer
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of insert latency histogram buckets.
+// Latencies above the last bound are counted in an additional bucket.
+var latencyBuckets = [...]time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	60 * time.Second,
+}
+
+// LatencyHistogram counts insert requests by their latency,
+// i.e. their entire round trip, as returned in Stats.Latency.
+//
+// Requests are counted in fixed buckets with the following upper bounds:
+// 5ms, 10ms, 25ms, 50ms, 100ms, 250ms, 500ms, 1s, 2.5s, 5s, 10s, 30s, 60s,
+// with an additional bucket for requests taking longer.
+type LatencyHistogram struct {
+	// Amount of requests per bucket, in the order of their bounds.
+	Counts [len(latencyBuckets) + 1]int64
+}
+
+// LatencyPercentiles are percentiles of insert request latencies.
+type LatencyPercentiles struct {
+	P50, P95, P99 time.Duration
+}
+
+// Count returns the total amount of requests.
+func (h LatencyHistogram) Count() int64 {
+	var n int64
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+// Percentile returns the latency below which given percent of requests
+// completed, e.g. 99 for the 99th percentile.
+//
+// The latency is estimated as the upper bound of the bucket holding
+// the percentile. Latencies above the last bucket bound are reported
+// as the last bound, and zero is returned if no requests have been counted.
+func (h LatencyHistogram) Percentile(percent float64) time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(percent / 100 * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for i, c := range h.Counts {
+		n += c
+		if n >= rank {
+			if i == len(latencyBuckets) {
+				break
+			}
+			return latencyBuckets[i]
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+// add adds given histogram's counts.
+func (h *LatencyHistogram) add(other LatencyHistogram) {
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+}
+
+// queueTimes returns a histogram of how long rows enqueued at given times
+// have been queued at now.
+func queueTimes(enqueuedAt []time.Time, now time.Time) LatencyHistogram {
+	var h latencyHistogram
+	for _, t := range enqueuedAt {
+		h.observe(now.Sub(t))
+	}
+	return h.snapshot()
+}
+
+// latencyHistogram is a LatencyHistogram updated concurrently.
+//
+// Every worker maintains its own, avoiding contention between workers.
+// All fields must be accessed atomically.
+type latencyHistogram struct {
+	counts [len(latencyBuckets) + 1]int64
+}
+
+// observe counts a request taking given duration.
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+}
+
+// snapshot returns the current counts.
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	var s LatencyHistogram
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	return s
+}
+
+// add adds given counts.
+func (h *latencyHistogram) add(s LatencyHistogram) {
+	for i, c := range s.Counts {
+		atomic.AddInt64(&h.counts[i], c)
+	}
+}
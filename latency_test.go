@@ -0,0 +1,50 @@
+package bqstreamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLatencyHistogram tests latencies are counted in their buckets,
+// and percentiles are estimated by bucket bounds.
+func TestLatencyHistogram(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	var h latencyHistogram
+	assert.Equal(LatencyPercentiles{}, Stats{Latency: h.snapshot()}.LatencyPercentiles())
+
+	// 90 fast requests, 9 slower, and a single one taking too long.
+	for i := 0; i < 90; i++ {
+		h.observe(3 * time.Millisecond)
+	}
+	for i := 0; i < 9; i++ {
+		h.observe(200 * time.Millisecond)
+	}
+	h.observe(2 * time.Minute)
+
+	s := h.snapshot()
+	assert.Equal(int64(100), s.Count())
+	assert.Equal(int64(90), s.Counts[0])
+	assert.Equal(int64(9), s.Counts[5])
+	assert.Equal(int64(1), s.Counts[len(latencyBuckets)])
+
+	assert.Equal(LatencyPercentiles{
+		P50: 5 * time.Millisecond,
+		P95: 250 * time.Millisecond,
+		P99: 250 * time.Millisecond,
+	}, Stats{Latency: s}.LatencyPercentiles())
+	assert.Equal(60*time.Second, s.Percentile(100))
+
+	// Test a latency equal to a bound is counted in its bucket.
+	h = latencyHistogram{}
+	h.observe(10 * time.Millisecond)
+	assert.Equal(int64(1), h.snapshot().Counts[1])
+
+	// Test merging histograms.
+	s.add(h.snapshot())
+	assert.Equal(int64(101), s.Count())
+}
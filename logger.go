@@ -0,0 +1,22 @@
+package bqstreamer
+
+// Logger logs worker lifecycle and insert related events,
+// e.g. worker start and stop, insert triggers, and retries.
+//
+// Implement it to adapt any logging library.
+// Loggers may be shared by multiple workers,
+// and must thus be safe for concurrent use.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// nopLogger is a Logger discarding all messages.
+//
+// It is used by default if no Logger has been set.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Warnf(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
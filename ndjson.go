@@ -0,0 +1,57 @@
+package bqstreamer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EnqueueNDJSON reads newline-delimited JSON from r until EOF,
+// enqueueing each line as a row of given table, given as "project.dataset.table",
+// e.g. for shipping log files without parsing them into rows first.
+//
+// Each line must hold a single JSON object, enqueued as is using
+// NewRawRow(), with an automatically generated insert ID.
+// Empty lines are skipped, and lines may end with either "\n" or "\r\n".
+// Lines longer than the max line size set using SetAsyncMaxLineSize()
+// fail reading, since rows that large would be rejected by BigQuery anyways.
+//
+// It blocks the same as Enqueue() for each row,
+// and returns the amount of rows enqueued until the first error,
+// either due to an invalid line, reading r, or enqueueing a row.
+// Rows of lines read before an invalid line remain enqueued.
+func (s *AsyncWorkerGroup) EnqueueNDJSON(table string, r io.Reader) (int, error) {
+	k, ok := parseTableKey(table)
+	if !ok {
+		return 0, errors.New("table must be given as project.dataset.table")
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, s.maxLineSize)
+	n, line := 0, 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		if b[0] != '{' || !json.Valid(b) {
+			return n, fmt.Errorf("line %d: invalid JSON object", line)
+		}
+
+		// Copy the line, since the scanner reuses its buffer.
+		data := make(json.RawMessage, len(b))
+		copy(data, b)
+		if err := s.Enqueue(NewRawRow(k.projectID, k.datasetID, k.tableID, data)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return n, fmt.Errorf("line %d: %w", line+1, err)
+	}
+	return n, nil
+}
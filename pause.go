@@ -0,0 +1,44 @@
+package bqstreamer
+
+import "sync"
+
+// pauseSwitch pauses and resumes inserts of all workers sharing it.
+//
+// A nil *pauseSwitch is never paused.
+type pauseSwitch struct {
+	mu sync.Mutex
+
+	// Closed and set to nil on resume.
+	// A nil channel means not paused.
+	resumed chan struct{}
+}
+
+// pause pauses inserts, if not paused already.
+func (p *pauseSwitch) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+	}
+}
+
+// resume resumes inserts, if paused.
+func (p *pauseSwitch) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+}
+
+// wait returns a channel closed once resumed,
+// or nil if not paused.
+func (p *pauseSwitch) wait() <-chan struct{} {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed
+}
@@ -1,8 +1,38 @@
 package bqstreamer
 
-import bigquery "google.golang.org/api/bigquery/v2"
+import (
+	"strings"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+)
 
 type projects map[string]project
 type project map[string]dataset
 type dataset map[string]table
 type table []*bigquery.TableDataInsertAllRequestRows
+
+// tableKey identifies a single table by its project, dataset and table IDs.
+type tableKey struct {
+	projectID, datasetID, tableID string
+}
+
+// parseTableKey parses a table given as "project.dataset.table".
+//
+// Project IDs may hold dots when prefixed by a domain,
+// e.g. "example.com:project", so the table is split at its last two dots.
+// It returns false if any of the IDs is missing.
+func parseTableKey(s string) (tableKey, bool) {
+	i := strings.LastIndexByte(s, '.')
+	if i < 0 {
+		return tableKey{}, false
+	}
+	j := strings.LastIndexByte(s[:i], '.')
+	if j < 0 {
+		return tableKey{}, false
+	}
+	k := tableKey{s[:j], s[j+1 : i], s[i+1:]}
+	if k.projectID == "" || k.datasetID == "" || k.tableID == "" {
+		return tableKey{}, false
+	}
+	return k, true
+}
@@ -0,0 +1,27 @@
+package bqstreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseTableKey tests parsing tables given as "project.dataset.table".
+func TestParseTableKey(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	k, ok := parseTableKey("p.d.t")
+	assert.True(ok)
+	assert.Equal(tableKey{"p", "d", "t"}, k)
+
+	k, ok = parseTableKey("example.com:p.d.t$20200101")
+	assert.True(ok)
+	assert.Equal(tableKey{"example.com:p", "d", "t$20200101"}, k)
+
+	for _, s := range []string{"", "t", "d.t", ".d.t", "p..t", "p.d."} {
+		_, ok = parseTableKey(s)
+		assert.False(ok, s)
+	}
+}
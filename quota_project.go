@@ -0,0 +1,37 @@
+package bqstreamer
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// quotaProjectHeader is the header BigQuery bills requests' quota to,
+// instead of the project owning the table.
+const quotaProjectHeader = "X-Goog-User-Project"
+
+// projectIDRegexp matches Google Cloud project IDs, i.e. 6 to 30 lowercase
+// letters, digits or hyphens, starting with a letter and not ending with
+// a hyphen. Legacy domain-scoped project IDs are prefixed with "domain:".
+var projectIDRegexp = regexp.MustCompile(`^([a-z0-9][a-z0-9.-]*[a-z0-9]:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+// quotaProjectTransport is an http.RoundTripper setting the quota project
+// header on every request, before sending it using the base RoundTripper.
+type quotaProjectTransport struct {
+	projectID string
+
+	// Uses http.DefaultTransport if nil.
+	base http.RoundTripper
+}
+
+func (t *quotaProjectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	// A RoundTripper must not modify the given request.
+	r := req.Clone(req.Context())
+	r.Header.Set(quotaProjectHeader, t.projectID)
+
+	return base.RoundTrip(r)
+}
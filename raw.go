@@ -0,0 +1,142 @@
+package bqstreamer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// rawDataKey holds a row's raw JSON data in its request row,
+// instead of the row's values.
+//
+// BigQuery column names can't contain a NUL character,
+// so it never collides with an actual value.
+const rawDataKey = "\x00raw"
+
+// requestRow returns given row as sent in the insert request.
+//
+// Raw JSON data is kept as is under rawDataKey,
+// and is only replaced when encoding the request, see insertAllRaw().
+func requestRow(row Row) *bigquery.TableDataInsertAllRequestRows {
+	data := row.Data
+	if row.RawData != nil {
+		data = map[string]bigquery.JsonValue{rawDataKey: row.RawData}
+	}
+
+	return &bigquery.TableDataInsertAllRequestRows{
+		InsertId: row.InsertID,
+		Json:     data,
+	}
+}
+
+// rawData returns given request row's raw JSON data,
+// or false if it holds the row's values instead.
+func rawData(row *bigquery.TableDataInsertAllRequestRows) (json.RawMessage, bool) {
+	if len(row.Json) != 1 {
+		return nil, false
+	}
+	data, ok := row.Json[rawDataKey].(json.RawMessage)
+	return data, ok
+}
+
+// hasRawRows returns true if any of given table's rows holds raw JSON data.
+func hasRawRows(tbl table) bool {
+	for _, row := range tbl {
+		if _, ok := rawData(row); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rowValues returns given row's values.
+// Raw JSON data is decoded, and is expected to be a JSON object.
+func rowValues(row Row) (map[string]bigquery.JsonValue, error) {
+	if row.RawData == nil {
+		return row.Data, nil
+	}
+
+	var data map[string]bigquery.JsonValue
+	if err := json.Unmarshal(row.RawData, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// rawInsertAllRequest is encoded the same as bigquery.TableDataInsertAllRequest,
+// except row data may be raw JSON.
+type rawInsertAllRequest struct {
+	Kind                string                   `json:"kind,omitempty"`
+	Rows                []rawInsertAllRequestRow `json:"rows,omitempty"`
+	IgnoreUnknownValues bool                     `json:"ignoreUnknownValues,omitempty"`
+	SkipInvalidRows     bool                     `json:"skipInvalidRows,omitempty"`
+	TemplateSuffix      string                   `json:"templateSuffix,omitempty"`
+	TraceId             string                   `json:"traceId,omitempty"`
+}
+
+type rawInsertAllRequestRow struct {
+	InsertId string      `json:"insertId,omitempty"`
+	Json     interface{} `json:"json,omitempty"`
+}
+
+// insertAllRaw executes an insert request similar to
+// bigquery.TabledataService.InsertAll(),
+// but sends rows holding raw JSON data without decoding it.
+func (w *SyncWorker) insertAllRaw(ctx context.Context, projectID, datasetID, tableID string, req *bigquery.TableDataInsertAllRequest) (*bigquery.TableDataInsertAllResponse, error) {
+	rawReq := rawInsertAllRequest{
+		Kind:                req.Kind,
+		Rows:                make([]rawInsertAllRequestRow, 0, len(req.Rows)),
+		IgnoreUnknownValues: req.IgnoreUnknownValues,
+		SkipInvalidRows:     req.SkipInvalidRows,
+		TemplateSuffix:      req.TemplateSuffix,
+		TraceId:             req.TraceId,
+	}
+	for _, row := range req.Rows {
+		r := rawInsertAllRequestRow{InsertId: row.InsertId, Json: row.Json}
+		if data, ok := rawData(row); ok {
+			r.Json = data
+		}
+		rawReq.Rows = append(rawReq.Rows, r)
+	}
+	body, err := json.Marshal(&rawReq)
+	if err != nil {
+		return nil, err
+	}
+
+	u := w.service.BasePath +
+		"projects/" + url.PathEscape(projectID) +
+		"/datasets/" + url.PathEscape(datasetID) +
+		"/tables/" + url.PathEscape(tableID) +
+		"/insertAll?alt=json"
+	httpReq, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", googleapi.UserAgent)
+
+	res, err := w.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, err
+	}
+
+	ret := bigquery.TableDataInsertAllResponse{
+		ServerResponse: googleapi.ServerResponse{
+			Header:         res.Header,
+			HTTPStatusCode: res.StatusCode,
+		},
+	}
+	if err := json.NewDecoder(res.Body).Decode(&ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
@@ -0,0 +1,55 @@
+package bqstreamer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// benchmarkRowJSON returns a JSON object of about 1KB.
+func benchmarkRowJSON() []byte {
+	data := map[string]interface{}{}
+	for i := 0; i < 16; i++ {
+		data[fmt.Sprintf("key%d", i)] = strings.Repeat("v", 50)
+	}
+	b, _ := json.Marshal(data)
+	return b
+}
+
+// BenchmarkRowData measures decoding JSON rows into values,
+// and encoding them in an insert request.
+func BenchmarkRowData(b *testing.B) {
+	raw := benchmarkRowJSON()
+
+	b.SetBytes(int64(len(raw)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var data map[string]bigquery.JsonValue
+		if err := json.Unmarshal(raw, &data); err != nil {
+			b.Fatal(err)
+		}
+		row := requestRow(NewRowWithID("p", "d", "t", "id", data))
+		if _, err := json.Marshal(&bigquery.TableDataInsertAllRequest{Rows: table{row}}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkRowRawData measures encoding raw JSON rows in an insert request.
+func BenchmarkRowRawData(b *testing.B) {
+	raw := benchmarkRowJSON()
+
+	b.SetBytes(int64(len(raw)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		row := requestRow(Row{ProjectID: "p", DatasetID: "d", TableID: "t", InsertID: "id", RawData: raw})
+		data, _ := rawData(row)
+		req := rawInsertAllRequest{Rows: []rawInsertAllRequestRow{{InsertId: row.InsertId, Json: data}}}
+		if _, err := json.Marshal(&req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
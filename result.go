@@ -0,0 +1,68 @@
+package bqstreamer
+
+import "sync"
+
+// rowResult delivers the result of inserting a single row
+// enqueued using AsyncWorkerGroup.EnqueueWithResult().
+type rowResult struct {
+	c    chan error
+	once sync.Once
+}
+
+func newRowResult() *rowResult {
+	return &rowResult{c: make(chan error, 1)}
+}
+
+// resolve sends given error, or nil if the row has been inserted,
+// and closes the result's channel.
+//
+// Only the first call has an effect, so rows may be resolved
+// by whichever part of the pipeline is done with them first.
+// It is a no-op on a nil result, i.e. a row enqueued without one.
+func (r *rowResult) resolve(err error) {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		r.c <- err
+		close(r.c)
+	})
+}
+
+// resolveRows resolves the results of given rows with given error, if any.
+func resolveRows(rows []Row, err error) {
+	for _, row := range rows {
+		row.result.resolve(err)
+	}
+}
+
+// resolveTable resolves the results of given table's rows, if any,
+// once its insert operation has finished:
+//   - Rejected rows receive a *RowRejectedError.
+//   - Rows not inserted receive the error of their last insert attempt.
+//   - All other rows have been inserted, and receive nil.
+func resolveTable(tableErrs *TableInsertErrors) {
+	hasResults := false
+	for _, row := range tableErrs.rows {
+		hasResults = hasResults || row.result != nil
+	}
+	if !hasResults {
+		return
+	}
+
+	errs := make(map[int]error, len(tableErrs.notInserted))
+	for i, err := range tableErrs.notInserted {
+		errs[i] = err
+	}
+	for _, rowErr := range tableErrs.rowErrors() {
+		errs[rowErr.Index] = &RowRejectedError{
+			Errors:  rowErr.Errors,
+			Table:   rowErr.attempt.Table,
+			Dataset: rowErr.attempt.Dataset,
+			Project: rowErr.attempt.Project,
+		}
+	}
+	for i, row := range tableErrs.rows {
+		row.result.resolve(errs[i])
+	}
+}
@@ -0,0 +1,145 @@
+package bqstreamer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// ErrRetryDeadlineExceeded is reported for failed inserts not retried
+// since retrying would exceed the deadline set using SetSyncRetryDeadline().
+var ErrRetryDeadlineExceeded = errors.New("retry deadline exceeded")
+
+// IsRetryable returns true if given insert error is transient,
+// meaning the insert operation should be retried.
+//
+// Transient errors are GoogleAPI HTTP 408, 429, 500, 502, 503 and 504 errors,
+// GoogleAPI errors with a rateLimitExceeded, backendError or internalError
+// reason, network timeouts, connection resets and other network errors,
+// and unexpected EOFs (i.e. the connection was closed mid-response).
+//
+// Insert requests timed out according to SetSyncInsertTimeout()
+// are transient as well.
+//
+// All other errors, e.g. 400 or 403, are considered permanent.
+// This includes context cancellation and deadline errors,
+// even though they also implement net.Error when returned by an HTTP client.
+//
+// See the following article for more info:
+// https://cloud.google.com/bigquery/troubleshooting-errors
+func IsRetryable(err error) bool {
+	var timeoutErr *InsertTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return true
+	}
+
+	// An expired or canceled context fails any retry as well.
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case 408, 429, 500, 502, 503, 504:
+			return true
+		}
+		for _, item := range apiErr.Errors {
+			switch item.Reason {
+			case "rateLimitExceeded", "backendError", "internalError":
+				return true
+			}
+		}
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// Dial, read and write errors.
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// sleepContext sleeps for given duration according to given clock,
+// or returns ctx.Err() if ctx is done first.
+func sleepContext(ctx context.Context, c clock, d time.Duration) error {
+	timer := c.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfter returns the delay requested by BigQuery before retrying,
+// according to the Retry-After header of given GoogleAPI error.
+//
+// The header value is either a delay in seconds, or an HTTP-date relative
+// to now. It returns false if no valid header is present.
+func retryAfter(err error, now time.Time) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return 0, false
+	}
+	return retryAfterHeader(apiErr.Header, now)
+}
+
+// retryAfterHeader is similar to retryAfter(),
+// but reads the Retry-After header of given response header,
+// e.g. of a successful request with rows rejected due to transient errors.
+func retryAfterHeader(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// isRateLimited returns true if given insert error indicates
+// BigQuery is rate limiting inserts.
+func isRateLimited(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code == 429 {
+		return true
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "rateLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
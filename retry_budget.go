@@ -0,0 +1,100 @@
+package bqstreamer
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRetryBudgetExhausted is reported for failed inserts not retried
+// since the retry budget set using SetAsyncRetryBudget() has been exhausted.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// retryBudgetMaxDeposits is the maximum amount of retries
+// which can be deposited by successful insert requests,
+// bounding retries after a long period without failures.
+const retryBudgetMaxDeposits = 100
+
+// retryBudget is a token bucket limiting retries of insert requests
+// to a fraction of successful ones, shared by all workers of
+// an AsyncWorkerGroup.
+//
+// Every successful insert request deposits ratio tokens,
+// and every retry withdraws a single token.
+// In addition, minPerSec retries per second are always allowed,
+// so inserts are retried even if no requests have succeeded yet.
+type retryBudget struct {
+	ratio     float64
+	minPerSec float64
+
+	// Returns the current time, overridden by unit tests.
+	now func() time.Time
+
+	mu sync.Mutex
+
+	// Tokens deposited by successful requests.
+	deposits float64
+
+	// Tokens refilled at minPerSec per second, up to minPerSec.
+	reserve    float64
+	refilledAt time.Time
+}
+
+func newRetryBudget(ratio float64, minPerSec int) *retryBudget {
+	b := &retryBudget{
+		ratio:     ratio,
+		minPerSec: float64(minPerSec),
+		now:       time.Now,
+	}
+	b.reserve = b.minPerSec
+	b.refilledAt = b.now()
+	return b
+}
+
+// deposit records a successful insert request.
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deposits += b.ratio
+	if b.deposits > retryBudgetMaxDeposits {
+		b.deposits = retryBudgetMaxDeposits
+	}
+}
+
+// withdraw returns true if a retry is allowed,
+// withdrawing a token for it.
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	switch {
+	case b.reserve >= 1:
+		b.reserve--
+	case b.deposits >= 1:
+		b.deposits--
+	default:
+		return false
+	}
+	return true
+}
+
+// remaining returns the amount of retries currently allowed.
+func (b *retryBudget) remaining() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	return b.reserve + b.deposits
+}
+
+// refill refills the reserve for the time passed since last refilled.
+func (b *retryBudget) refill() {
+	now := b.now()
+	b.reserve += now.Sub(b.refilledAt).Seconds() * b.minPerSec
+	if b.reserve > b.minPerSec {
+		b.reserve = b.minPerSec
+	}
+	b.refilledAt = now
+}
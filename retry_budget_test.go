@@ -0,0 +1,46 @@
+package bqstreamer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRetryBudget tests retries are limited to the minimum per second,
+// and a fraction of successful requests.
+func TestRetryBudget(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	b := newRetryBudget(0.5, 2)
+	now := b.refilledAt
+	b.now = func() time.Time { return now }
+
+	// Test the minimum retries per second are allowed without any successes.
+	assert.Equal(2.0, b.remaining())
+	assert.True(b.withdraw())
+	assert.True(b.withdraw())
+	assert.False(b.withdraw())
+
+	// Test successful requests deposit a fraction of a retry each.
+	b.deposit()
+	assert.False(b.withdraw())
+	b.deposit()
+	assert.Equal(1.0, b.remaining())
+	assert.True(b.withdraw())
+	assert.False(b.withdraw())
+
+	// Test the reserve is refilled over time, up to the minimum per second.
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(1.0, b.remaining())
+	now = now.Add(time.Minute)
+	assert.Equal(2.0, b.remaining())
+
+	// Test deposits are bounded.
+	for i := 0; i < 2*retryBudgetMaxDeposits/0.5; i++ {
+		b.deposit()
+	}
+	assert.Equal(2.0+retryBudgetMaxDeposits, b.remaining())
+}
@@ -0,0 +1,105 @@
+package bqstreamer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// timeoutError is a net.Error mocking a timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// TestIsRetryable tests transient errors are classified as retryable,
+// and permanent ones are not.
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	for _, tt := range []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{errors.New("generic error"), false},
+
+		{&googleapi.Error{Code: 408}, true},
+		{&googleapi.Error{Code: 429}, true},
+		{&googleapi.Error{Code: 500}, true},
+		{&googleapi.Error{Code: 502}, true},
+		{&googleapi.Error{Code: 503}, true},
+		{&googleapi.Error{Code: 504}, true},
+		{&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true},
+		{&googleapi.Error{Code: 400, Errors: []googleapi.ErrorItem{{Reason: "backendError"}}}, true},
+		{&googleapi.Error{Code: 400, Errors: []googleapi.ErrorItem{{Reason: "invalid"}}}, false},
+		{&googleapi.Error{Code: 400}, false},
+		{&googleapi.Error{Code: 401}, false},
+		{&googleapi.Error{Code: 403}, false},
+		{&googleapi.Error{Code: 404}, false},
+		{&googleapi.Error{Code: 501}, false},
+		{fmt.Errorf("wrapped: %w", &googleapi.Error{Code: 503}), true},
+
+		{&url.Error{Op: "Post", URL: "u", Err: timeoutError{}}, true},
+		{&url.Error{Op: "Post", URL: "u", Err: io.EOF}, true},
+		{&url.Error{Op: "Post", URL: "u", Err: io.ErrUnexpectedEOF}, true},
+		{&url.Error{Op: "Post", URL: "u", Err: errors.New("certificate error")}, false},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{&url.Error{Op: "Post", URL: "u", Err: context.DeadlineExceeded}, false},
+		{&url.Error{Op: "Post", URL: "u", Err: context.Canceled}, false},
+	} {
+		assert.Equal(tt.retryable, IsRetryable(tt.err), fmt.Sprintf("%#v", tt.err))
+	}
+}
+
+// TestRetryAfter tests parsing the Retry-After header of GoogleAPI errors.
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	header := func(v string) http.Header {
+		h := make(http.Header)
+		h.Set("Retry-After", v)
+		return h
+	}
+
+	for _, tt := range []struct {
+		err   error
+		delay time.Duration
+		ok    bool
+	}{
+		{errors.New("generic error"), 0, false},
+		{&googleapi.Error{Code: 429}, 0, false},
+		{&googleapi.Error{Code: 429, Header: header("2")}, 2 * time.Second, true},
+		{&googleapi.Error{Code: 503, Header: header("0")}, 0, true},
+		{&googleapi.Error{Code: 503, Header: header("-1")}, 0, false},
+		{&googleapi.Error{Code: 503, Header: header("soon")}, 0, false},
+		{&googleapi.Error{Code: 503, Header: header("Sun, 01 Jan 2017 00:00:05 GMT")}, 5 * time.Second, true},
+		{&googleapi.Error{Code: 503, Header: header("Sat, 31 Dec 2016 23:59:00 GMT")}, 0, true},
+	} {
+		delay, ok := retryAfter(tt.err, now)
+		assert.Equal(tt.ok, ok, tt.err.Error())
+		assert.Equal(tt.delay, delay, tt.err.Error())
+	}
+}
@@ -1,20 +1,87 @@
 package bqstreamer
 
 import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
 	"github.com/dchest/uniuri"
 	bigquery "google.golang.org/api/bigquery/v2"
 )
 
 // Row associates a single BigQuery table row to a project, dataset and table.
+//
+// TableID may include a partition decorator, e.g. "mytable$20240101",
+// for streaming rows into a specific partition. It is sent verbatim,
+// and rows of different partitions are inserted using separate requests.
+// BigQuery only accepts decorators of ingestion-time partitioned tables,
+// for partitions from 31 days in the past up to 16 days in the future,
+// and doesn't allow combining them with a template suffix:
+// https://cloud.google.com/bigquery/docs/streaming-data-into-bigquery#streaming_into_partitioned_tables
 type Row struct {
 	ProjectID,
 	DatasetID,
 	TableID string
+
+	// Row values by column name, encoded as a JSON object.
+	//
+	// RECORD (STRUCT) columns are set to nested maps,
+	// either map[string]bigquery.JsonValue or map[string]interface{},
+	// which are encoded as nested JSON objects.
+	// REPEATED columns are set to slices, e.g. []string or []interface{},
+	// which are encoded as JSON arrays.
+	// Repeated records are slices of maps.
+	//
+	// A nil value is sent as an explicit JSON null, leaving a NULLABLE column
+	// unset. BigQuery rejects nulls for REQUIRED columns,
+	// and treats a null REPEATED column as an empty array.
 	Data map[string]bigquery.JsonValue
 
+	// Row data already encoded as a JSON object, used instead of Data if set.
+	// It is sent as is, saving decoding it into Data and encoding it back,
+	// e.g. a 1KB row takes 9 instead of 86 allocations, see BenchmarkRowRawData.
+	//
+	// NOTE the data is not decoded unless validated using a schema,
+	// so invalid JSON fails the entire insert request.
+	RawData json.RawMessage
+
 	// Used for deduplication:
 	// https://cloud.google.com/bigquery/streaming-data-into-bigquery#dataconsistency
+	//
+	// It is sent as the row's insertId, so retried inserts are deduplicated
+	// by BigQuery on a best effort basis.
+	// An empty value sends no insertId, disabling deduplication for the row.
 	InsertID string
+
+	// Inserts the row to the table named TableID + TemplateSuffix if set,
+	// which BigQuery creates using TableID as a template if non-existent:
+	// https://cloud.google.com/bigquery/streaming-data-into-bigquery#template-tables
+	//
+	// Rows are batched by template suffix as well as by table,
+	// so rows to the same table with different suffixes
+	// are inserted using separate requests.
+	TemplateSuffix string
+
+	// Context the row has been enqueued with, if propagated to its insert,
+	// see SetAsyncPropagateContext().
+	ctx context.Context
+
+	// Receives the row's insert result if set,
+	// see AsyncWorkerGroup.EnqueueWithResult().
+	result *rowResult
+
+	// Time the row has been enqueued to an AsyncWorkerGroup
+	// if a row TTL has been set, see SetAsyncRowTTL().
+	enqueuedAt time.Time
+}
+
+// baseTableID returns given table ID without its partition decorator, if any.
+func baseTableID(tableID string) string {
+	if i := strings.IndexByte(tableID, '$'); i >= 0 {
+		return tableID[:i]
+	}
+	return tableID
 }
 
 // NewRow returns a new Row instance, with an automatically generated insert ID
@@ -29,6 +96,18 @@ func NewRow(projectID, datasetID, tableID string, data map[string]bigquery.JsonV
 	)
 }
 
+// NewRawRow returns a new Row instance using given JSON encoded data,
+// with an automatically generated insert ID.
+func NewRawRow(projectID, datasetID, tableID string, data json.RawMessage) Row {
+	return Row{
+		ProjectID: projectID,
+		DatasetID: datasetID,
+		TableID:   tableID,
+		InsertID:  uniuri.NewLen(uniuri.UUIDLen),
+		RawData:   data,
+	}
+}
+
 // NewRowWithID returns a new Row instance with given insert ID.
 func NewRowWithID(projectID, datasetID, tableID, insertID string, data map[string]bigquery.JsonValue) Row {
 	return Row{
@@ -0,0 +1,146 @@
+package bqstreamer
+
+import (
+	"strings"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// validateRow returns errors for given row data not matching given schema,
+// in the same format BigQuery uses for rejected rows.
+//
+// Values must match schema field names, which are case insensitive,
+// unless unknown values are ignored.
+// Required fields must be set to a non-null value.
+// Nested and repeated records are validated as well.
+//
+// Field types are not validated.
+func validateRow(schema *bigquery.TableSchema, data map[string]bigquery.JsonValue, ignoreUnknownValues bool) []*bigquery.ErrorProto {
+	return validateFields(schema.Fields, data, "", ignoreUnknownValues)
+}
+
+// validateFields validates a record's values against given fields.
+// prefix is the record's location, and is prepended to reported field names.
+func validateFields(fields []*bigquery.TableFieldSchema, data map[string]bigquery.JsonValue, prefix string, ignoreUnknownValues bool) []*bigquery.ErrorProto {
+	var errs []*bigquery.ErrorProto
+
+	byName := make(map[string]*bigquery.TableFieldSchema, len(fields))
+	for _, f := range fields {
+		byName[strings.ToLower(f.Name)] = f
+	}
+
+	values := make(map[string]bigquery.JsonValue, len(data))
+	for k, v := range data {
+		values[strings.ToLower(k)] = v
+		if _, ok := byName[strings.ToLower(k)]; !ok && !ignoreUnknownValues {
+			errs = append(errs, &bigquery.ErrorProto{
+				Reason:   "invalid",
+				Location: prefix + k,
+				Message:  "no such field.",
+			})
+		}
+	}
+
+	for _, f := range fields {
+		v, ok := values[strings.ToLower(f.Name)]
+		if !ok || v == nil {
+			if f.Mode == "REQUIRED" {
+				errs = append(errs, &bigquery.ErrorProto{
+					Reason:   "invalid",
+					Location: prefix + f.Name,
+					Message:  "missing required field.",
+				})
+			}
+			continue
+		}
+		if f.Type != "RECORD" && f.Type != "STRUCT" {
+			continue
+		}
+
+		// Validate nested records, e.g. a repeated record's every element.
+		records := []bigquery.JsonValue{v}
+		if f.Mode == "REPEATED" {
+			switch vs := v.(type) {
+			case []bigquery.JsonValue:
+				records = vs
+			case []interface{}:
+				records = make([]bigquery.JsonValue, 0, len(vs))
+				for _, r := range vs {
+					records = append(records, r)
+				}
+			case []map[string]bigquery.JsonValue:
+				records = make([]bigquery.JsonValue, 0, len(vs))
+				for _, r := range vs {
+					records = append(records, r)
+				}
+			case []map[string]interface{}:
+				records = make([]bigquery.JsonValue, 0, len(vs))
+				for _, r := range vs {
+					records = append(records, r)
+				}
+			}
+		}
+		for _, r := range records {
+			if record, ok := toRecord(r); ok {
+				errs = append(errs, validateFields(f.Fields, record, prefix+f.Name+".", ignoreUnknownValues)...)
+			}
+		}
+	}
+
+	return errs
+}
+
+// toRecord returns given value as a record if it is one.
+func toRecord(v bigquery.JsonValue) (map[string]bigquery.JsonValue, bool) {
+	switch r := v.(type) {
+	case map[string]bigquery.JsonValue:
+		return r, true
+	case map[string]interface{}:
+		record := make(map[string]bigquery.JsonValue, len(r))
+		for k, v := range r {
+			record[k] = v
+		}
+		return record, true
+	}
+	return nil, false
+}
+
+// invalidRows are a table's rows which failed validation,
+// along with their errors.
+type invalidRows struct {
+	rows []Row
+	errs []*bigquery.TableDataInsertAllResponseInsertErrors
+}
+
+// add adds given invalid row.
+func (rows *invalidRows) add(r Row, errs []*bigquery.ErrorProto) {
+	rows.errs = append(rows.errs, &bigquery.TableDataInsertAllResponseInsertErrors{
+		Index:  int64(len(rows.rows)),
+		Errors: errs,
+	})
+	rows.rows = append(rows.rows, r)
+}
+
+// tableInsertErrors returns the invalid rows as rejected by a single
+// insert attempt to given table, which hasn't been executed.
+//
+// Row indexes are relative to the table's invalid rows.
+func (rows *invalidRows) tableInsertErrors(k tableKey) *TableInsertErrors {
+	insertIDs := make([]string, 0, len(rows.rows))
+	for _, r := range rows.rows {
+		insertIDs = append(insertIDs, r.InsertID)
+	}
+
+	return &TableInsertErrors{
+		rows: rows.rows,
+		InsertAttempts: []*TableInsertAttemptErrors{
+			&TableInsertAttemptErrors{
+				rows:      rows.errs,
+				insertIDs: insertIDs,
+				Table:     k.tableID,
+				Dataset:   k.datasetID,
+				Project:   k.projectID,
+			},
+		},
+	}
+}
@@ -0,0 +1,96 @@
+package bqstreamer
+
+import (
+	"testing"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidateRow tests validating rows against a table schema.
+func TestValidateRow(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	schema := &bigquery.TableSchema{
+		Fields: []*bigquery.TableFieldSchema{
+			{Name: "id", Type: "STRING", Mode: "REQUIRED"},
+			{Name: "name", Type: "STRING", Mode: "NULLABLE"},
+			{Name: "address", Type: "RECORD", Fields: []*bigquery.TableFieldSchema{
+				{Name: "city", Type: "STRING", Mode: "REQUIRED"},
+			}},
+			{Name: "tags", Type: "RECORD", Mode: "REPEATED", Fields: []*bigquery.TableFieldSchema{
+				{Name: "key", Type: "STRING"},
+			}},
+		},
+	}
+
+	tests := []struct {
+		data      map[string]bigquery.JsonValue
+		ignore    bool
+		locations []string
+	}{
+		{
+			data: map[string]bigquery.JsonValue{"id": "1"},
+		},
+		{
+			// Field names are case insensitive.
+			data: map[string]bigquery.JsonValue{
+				"ID":      "1",
+				"address": map[string]bigquery.JsonValue{"City": "c"},
+				"tags":    []interface{}{map[string]interface{}{"key": "k"}},
+			},
+		},
+		{
+			data:      map[string]bigquery.JsonValue{"name": "n"},
+			locations: []string{"id"},
+		},
+		{
+			data:      map[string]bigquery.JsonValue{"id": nil},
+			locations: []string{"id"},
+		},
+		{
+			data:      map[string]bigquery.JsonValue{"id": "1", "nmae": "n"},
+			locations: []string{"nmae"},
+		},
+		{
+			data:   map[string]bigquery.JsonValue{"id": "1", "nmae": "n"},
+			ignore: true,
+		},
+		{
+			data: map[string]bigquery.JsonValue{
+				"id":      "1",
+				"address": map[string]interface{}{"town": "t"},
+				"tags":    []bigquery.JsonValue{map[string]bigquery.JsonValue{"value": "v"}},
+			},
+			locations: []string{"address.town", "address.city", "tags.value"},
+		},
+		{
+			// Repeated records can be typed slices of maps.
+			data: map[string]bigquery.JsonValue{
+				"id":   "1",
+				"tags": []map[string]bigquery.JsonValue{{"key": "k"}, {"value": "v"}},
+			},
+			locations: []string{"tags.value"},
+		},
+		{
+			data: map[string]bigquery.JsonValue{
+				"id":      "1",
+				"address": map[string]bigquery.JsonValue{"city": nil},
+				"tags":    []map[string]interface{}{{"value": "v"}},
+			},
+			locations: []string{"address.city", "tags.value"},
+		},
+	}
+
+	for i, test := range tests {
+		var locations []string
+		for _, err := range validateRow(schema, test.data, test.ignore) {
+			assert.Equal("invalid", err.Reason, i)
+			locations = append(locations, err.Location)
+		}
+		assert.Equal(test.locations, locations, i)
+	}
+}
@@ -0,0 +1,117 @@
+package bqstreamer
+
+import "hash/fnv"
+
+// shard returns the worker index of given shard key,
+// using jump consistent hashing over given amount of workers.
+//
+// See "A Fast, Minimal Memory, Consistent Hash Algorithm" by Lamping and Veach:
+// https://arxiv.org/abs/1406.2294
+func shard(key string, numWorkers int) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	k := h.Sum64()
+
+	var b, j int64 = -1, 0
+	for j < int64(numWorkers) {
+		b = j
+		k = k*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((k>>33)+1)))
+	}
+	return int(b)
+}
+
+// perWorkerQueues returns true if every worker reads rows
+// from a row channel of its own, i.e. a shard key has been set
+// using SetAsyncShardKey(), or the PerWorkerQueue dispatch strategy.
+func (s *AsyncWorkerGroup) perWorkerQueues() bool {
+	return s.shardKey != nil || s.dispatch == PerWorkerQueue
+}
+
+// dispatchChans returns the row channels rows are dispatched over
+// if using PerWorkerQueue without a shard key, or nil otherwise.
+//
+// NOTE s.mu must be locked.
+func (s *AsyncWorkerGroup) dispatchChans() []chan Row {
+	if s.shardKey != nil || s.dispatch != PerWorkerQueue {
+		return nil
+	}
+	return s.shardChans
+}
+
+// newShardChans returns a row channel per worker,
+// with the same total capacity as the shared row channel.
+func (s *AsyncWorkerGroup) newShardChans() []chan Row {
+	chans := make([]chan Row, s.numWorkers)
+	for i := range chans {
+		chans[i] = make(chan Row, s.maxRows)
+	}
+	return chans
+}
+
+// workerRowChan returns the row channel read by the worker at given index.
+//
+// NOTE s.mu must be locked.
+func (s *AsyncWorkerGroup) workerRowChan(i int) chan Row {
+	if !s.perWorkerQueues() {
+		return s.rowChan
+	}
+	return s.shardChans[i]
+}
+
+// rowChanFor returns the row channel given row should be sent to.
+//
+// Rows are sent to the shared row channel,
+// unless a shard key has been set using SetAsyncShardKey(),
+// or to the shortest worker's row channel if using PerWorkerQueue.
+//
+// NOTE s.mu must be locked.
+func (s *AsyncWorkerGroup) rowChanFor(row Row) chan Row {
+	switch {
+	case s.shardKey != nil:
+		return s.shardChans[shard(s.shardKey(row), len(s.shardChans))]
+	case s.dispatch == PerWorkerQueue:
+		return shortestChan(s.shardChans)
+	}
+	return s.rowChan
+}
+
+// router is similar to rowChanFor(),
+// but returns a function routing rows to the current row channels,
+// which can be used without locking s.mu.
+//
+// NOTE s.mu must be locked.
+func (s *AsyncWorkerGroup) router() func(Row) chan Row {
+	rowChan, shardChans, shardKey := s.rowChan, s.shardChans, s.shardKey
+	switch {
+	case shardKey != nil:
+		return func(row Row) chan Row {
+			return shardChans[shard(shardKey(row), len(shardChans))]
+		}
+	case s.dispatch == PerWorkerQueue:
+		return func(Row) chan Row { return shortestChan(shardChans) }
+	}
+	return func(Row) chan Row { return rowChan }
+}
+
+// rowCapacity returns the total capacity of the row channels.
+//
+// NOTE s.mu must be locked.
+func (s *AsyncWorkerGroup) rowCapacity() int {
+	n := cap(s.rowChan)
+	for _, c := range s.shardChans {
+		n += cap(c)
+	}
+	return n
+}
+
+// queuedRows returns the amount of rows in the row channels.
+//
+// NOTE s.mu must be locked.
+func (s *AsyncWorkerGroup) queuedRows() int {
+	n := len(s.rowChan)
+	for _, c := range s.shardChans {
+		n += len(c)
+	}
+	return n
+}
@@ -0,0 +1,31 @@
+package bqstreamer
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestShard tests keys are spread over all workers,
+// and only keys of removed workers are moved when removing workers.
+func TestShard(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	counts := make([]int, 4)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		w := shard(key, 4)
+		counts[w]++
+		assert.Equal(w, shard(key, 4))
+		if w < 3 {
+			assert.Equal(w, shard(key, 3))
+		}
+	}
+	for _, n := range counts {
+		assert.True(n > 150, "unbalanced shards %v", counts)
+	}
+	assert.Equal(0, shard("key", 1))
+}
@@ -0,0 +1,224 @@
+package bqstreamer
+
+import (
+	"sync/atomic"
+	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// StatsHandler receives insert related events from workers,
+// e.g. for exporting them as metrics to Prometheus or OpenTelemetry.
+//
+// Handlers may be shared by multiple workers,
+// and must thus be safe for concurrent use.
+//
+// Embed NopStatsHandler in a handler implementation
+// in order to handle only a subset of events.
+type StatsHandler interface {
+	// RowsEnqueued is called when rows are enqueued in a worker.
+	RowsEnqueued(n int)
+
+	// InsertAttempt is called after every insert request to BigQuery,
+	// with the amount of rows sent, the request latency,
+	// and the request error (if any).
+	InsertAttempt(n int, d time.Duration, err error)
+
+	// InsertRetried is called before retrying a failed insert request
+	// of n rows.
+	InsertRetried(n int)
+
+	// RowsInserted is called with the amount of rows successfully inserted
+	// by an insert request.
+	RowsInserted(n int)
+
+	// RowsRejected is called with the amount of rows rejected by BigQuery
+	// in an insert request, e.g. due to invalid values.
+	RowsRejected(n int)
+
+	// RowsRejectedByReason is called along with RowsRejected(),
+	// with the amount of row errors of the given table per error reason,
+	// e.g. "invalid" for rows with invalid values,
+	// or "stopped" for valid rows not inserted due to other invalid rows.
+	//
+	// A row may have multiple errors, and is thus counted for each of them.
+	RowsRejectedByReason(projectID, datasetID, tableID string, reasons map[string]int)
+
+	// RowsDropped is called with the amount of rows which couldn't be
+	// enqueued to an AsyncWorkerGroup, and have thus been dropped,
+	// along with the reason, e.g. a closed group or a full buffer.
+	RowsDropped(n int, reason DropReason)
+
+	// RowsQueueTime is called at the start of every insert operation,
+	// with how long its rows have been enqueued in the worker,
+	// i.e. not including time spent in an AsyncWorkerGroup's row channel.
+	// This tells queueing delay apart from the insert latency
+	// reported by InsertAttempt().
+	RowsQueueTime(h LatencyHistogram)
+}
+
+// NopStatsHandler is a StatsHandler that ignores all events.
+//
+// It is used by default if no StatsHandler has been set.
+type NopStatsHandler struct{}
+
+func (NopStatsHandler) RowsEnqueued(n int)                                          {}
+func (NopStatsHandler) InsertAttempt(n int, d time.Duration, err error)             {}
+func (NopStatsHandler) InsertRetried(n int)                                         {}
+func (NopStatsHandler) RowsInserted(n int)                                          {}
+func (NopStatsHandler) RowsRejected(n int)                                          {}
+func (NopStatsHandler) RowsRejectedByReason(p, d, t string, reasons map[string]int) {}
+func (NopStatsHandler) RowsDropped(n int, reason DropReason)                        {}
+func (NopStatsHandler) RowsQueueTime(h LatencyHistogram)                            {}
+
+// rejectionReasons returns the amount of given row errors per error reason.
+func rejectionReasons(rows []*bigquery.TableDataInsertAllResponseInsertErrors) map[string]int {
+	reasons := map[string]int{}
+	for _, row := range rows {
+		for _, err := range row.Errors {
+			reasons[err.Reason]++
+		}
+	}
+	return reasons
+}
+
+// Stats is a snapshot of an AsyncWorkerGroup's current state
+// and cumulative insert counters, as returned by AsyncWorkerGroup.Stats().
+type Stats struct {
+	// Amount of rows enqueued but not inserted yet,
+	// including rows in the row channel and rows buffered by workers.
+	QueuedRows int
+
+	// Amount of insert requests to BigQuery currently in progress.
+	InFlightInserts int
+
+	// Amount of rows enqueued by workers.
+	EnqueuedRows int64
+
+	// Amount of rows successfully inserted.
+	InsertedRows int64
+
+	// Amount of rows rejected by BigQuery.
+	RejectedRows int64
+
+	// Amount of failed insert requests which have been retried.
+	RetriedInserts int64
+
+	// Amount of insert operations which have failed,
+	// either after too many retries or due to a non-retryable error.
+	FailedInserts int64
+
+	// Amount of duplicate rows collapsed by the dedup key
+	// set using SetAsyncDedupKey(), and thus not inserted.
+	DedupedRows int64
+
+	// Amount of rows inserted by a retry of a timed out insert request,
+	// which may have been inserted by the timed out request already,
+	// in which case BigQuery dropped them as duplicates of their insert IDs.
+	// They're not counted in InsertedRows, nor reported to
+	// StatsHandler.RowsInserted(), TableStats() or the flush callback.
+	//
+	// NOTE This is a heuristic, since BigQuery's response doesn't tell
+	// whether rows were deduplicated, nor does a timed out request tell
+	// whether it succeeded. Rows without an insert ID aren't deduplicated,
+	// and are thus always counted in InsertedRows.
+	DedupSuppressed int64
+
+	// Amount of rows dropped instead of inserted, since they've been
+	// buffered for longer than the row TTL set using SetAsyncRowTTL().
+	ExpiredRows int64
+
+	// Latencies of all insert requests.
+	Latency LatencyHistogram
+
+	// True if inserts have been paused using AsyncWorkerGroup.Pause().
+	Paused bool
+
+	// State of the circuit breaker set using SetAsyncCircuitBreaker().
+	// Always CircuitClosed if none has been set.
+	Circuit CircuitState
+
+	// Accumulated size in bytes of buffered rows, and amount of rows
+	// dropped by the OverflowDropOldest policy, if max buffered bytes
+	// has been set using SetAsyncMaxBufferedBytes(). Always zero otherwise.
+	BufferedBytes int64
+	DroppedRows   int64
+
+	// Accumulated size in bytes of in-flight insert requests
+	// if max in-flight bytes has been set using SetAsyncMaxInFlightBytes().
+	// Always zero otherwise.
+	InFlightBytes int64
+
+	// Amount of retries currently allowed by the retry budget
+	// set using SetAsyncRetryBudget(). Always zero if none has been set.
+	RetryBudget float64
+}
+
+// CloseSummary reports rows inserted and lost while closing
+// an AsyncWorkerGroup, as returned by AsyncWorkerGroup.CloseWithSummary().
+type CloseSummary struct {
+	// Amount of rows successfully inserted while draining.
+	FlushedRows int64
+
+	// Amount of rows rejected by BigQuery while draining.
+	RejectedRows int64
+
+	// Amount of insert operations which have failed while draining,
+	// either after too many retries or due to a non-retryable error.
+	FailedInserts int64
+
+	// Amount of rows abandoned without being inserted, once the close grace
+	// period has passed if SetAsyncCancelInFlightOnClose() has been set,
+	// the same as UndrainedRowsError.Rows. Always zero otherwise.
+	UndrainedRows int64
+}
+
+// LatencyPercentiles returns the 50th, 95th and 99th percentiles
+// of insert request latencies.
+//
+// See LatencyHistogram.Percentile() for how they're estimated.
+func (s Stats) LatencyPercentiles() LatencyPercentiles {
+	return LatencyPercentiles{
+		P50: s.Latency.Percentile(50),
+		P95: s.Latency.Percentile(95),
+		P99: s.Latency.Percentile(99),
+	}
+}
+
+// workerCounters are maintained by workers for Stats(),
+// and may be shared by multiple workers.
+//
+// All fields must be accessed atomically.
+type workerCounters struct {
+	bufferedRows    int64
+	inFlightInserts int64
+	enqueuedRows    int64
+	insertedRows    int64
+	rejectedRows    int64
+	retriedInserts  int64
+	failedInserts   int64
+	dedupedRows     int64
+	dedupSuppressed int64
+	expiredRows     int64
+
+	// Rows abandoned by AsyncWorkerGroup.CloseContext(),
+	// not reported by stats().
+	abandonedRows int64
+}
+
+// stats returns a snapshot of the counters,
+// with the amount of queued rows not counted by workers.
+func (c *workerCounters) stats(queuedRows int) Stats {
+	return Stats{
+		QueuedRows:      queuedRows + int(atomic.LoadInt64(&c.bufferedRows)),
+		InFlightInserts: int(atomic.LoadInt64(&c.inFlightInserts)),
+		EnqueuedRows:    atomic.LoadInt64(&c.enqueuedRows),
+		InsertedRows:    atomic.LoadInt64(&c.insertedRows),
+		RejectedRows:    atomic.LoadInt64(&c.rejectedRows),
+		RetriedInserts:  atomic.LoadInt64(&c.retriedInserts),
+		FailedInserts:   atomic.LoadInt64(&c.failedInserts),
+		DedupedRows:     atomic.LoadInt64(&c.dedupedRows),
+		DedupSuppressed: atomic.LoadInt64(&c.dedupSuppressed),
+		ExpiredRows:     atomic.LoadInt64(&c.expiredRows),
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"testing"
 	"time"
 
+	bigquery "google.golang.org/api/bigquery/v2"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,6 +20,39 @@ func TestSyncWorkerOptions(t *testing.T) {
 	assert.EqualError(SetSyncMaxRetries(-1)(&w), "max retries value must be a non-negative int")
 	assert.EqualError(SetSyncRetryInterval(0)(&w), "retry interval value must be a positive time.Duration")
 	assert.EqualError(SetSyncRetryInterval(-1)(&w), "retry interval value must be a positive time.Duration")
+	assert.EqualError(SetSyncMaxBytes(0)(&w), "max bytes value must be a positive int")
+	assert.EqualError(SetSyncMaxRowsPerRequest(0)(&w), "max rows per request value must be a positive int not exceeding 10000")
+	assert.EqualError(SetSyncMaxRowsPerRequest(10001)(&w), "max rows per request value must be a positive int not exceeding 10000")
+	assert.EqualError(SetSyncEndpoint("")(&w), "endpoint value must be a well-formed http or https URL")
+	assert.EqualError(SetSyncEndpoint("localhost:9050")(&w), "endpoint value must be a well-formed http or https URL")
+	assert.EqualError(SetSyncEndpoint("ftp://localhost/")(&w), "endpoint value must be a well-formed http or https URL")
+	assert.EqualError(SetSyncEndpoint("http://")(&w), "endpoint value must be a well-formed http or https URL")
+	assert.EqualError(SetSyncStatsHandler(nil)(&w), "stats handler is nil")
+	assert.EqualError(SetSyncLogger(nil)(&w), "logger is nil")
+	assert.EqualError(SetSyncInsertTracer(nil)(&w), "insert tracer is nil")
+	assert.EqualError(SetSyncDeadLetterHandler(nil)(&w), "dead-letter handler is nil")
+	assert.EqualError(SetSyncSpillHandler(nil)(&w), "spill handler is nil")
+	assert.EqualError(SetSyncFlushCallback(nil)(&w), "flush callback is nil")
+	assert.EqualError(SetSyncRetryableFunc(nil)(&w), "retryable func is nil")
+	assert.EqualError(SetSyncTableRetryable("p", "d", "t", nil)(&w), "table retryable func is nil")
+	assert.EqualError(SetSyncSchemaRefresh(nil)(&w), "schema refresh is nil")
+	assert.EqualError(SetSyncTraceID(nil)(&w), "trace ID func is nil")
+	assert.EqualError(SetSyncRetryCallback(nil)(&w), "retry callback is nil")
+	assert.EqualError(SetSyncSchema("p", "d", "t", nil)(&w), "schema is nil")
+	assert.EqualError(SetSyncRowTransform(nil)(&w), "row transform is nil")
+	assert.EqualError(SetSyncRowSizer(nil)(&w), "row sizer is nil")
+	assert.EqualError(SetSyncGzipMinBytes(-1)(&w), "gzip min bytes value must be a non-negative int")
+	assert.EqualError(SetSyncDedupKey(nil)(&w), "dedup key is nil")
+	assert.EqualError(SetSyncPreFlush(nil)(&w), "pre-flush is nil")
+	assert.EqualError(SetSyncUserAgent("", false)(&w), "user agent value must be a non-empty string")
+	assert.EqualError(SetSyncQuotaProject("")(&w), "quota project value must be a valid project ID")
+	assert.EqualError(SetSyncQuotaProject("My-Project")(&w), "quota project value must be a valid project ID")
+	assert.EqualError(SetSyncQuotaProject("my-project-")(&w), "quota project value must be a valid project ID")
+	assert.EqualError(SetSyncRetryDeadline(0)(&w), "retry deadline value must be a positive time.Duration")
+	assert.EqualError(SetSyncInsertTimeout(0)(&w), "insert timeout value must be a positive time.Duration")
+	assert.EqualError(SetSyncRetryBackoff(0, time.Second, 2)(&w), "initial backoff value must be a positive time.Duration")
+	assert.EqualError(SetSyncRetryBackoff(time.Second, time.Millisecond, 2)(&w), "max backoff value must not be smaller than initial backoff")
+	assert.EqualError(SetSyncRetryBackoff(time.Second, time.Second, 0.5)(&w), "backoff multiplier value must be at least 1")
 
 	// Test valid arguments
 	w = SyncWorker{}
@@ -25,9 +60,67 @@ func TestSyncWorkerOptions(t *testing.T) {
 	assert.NoError(SetSyncMaxRetries(2)(&w))
 	assert.NoError(SetSyncIgnoreUnknownValues(true)(&w))
 	assert.NoError(SetSyncSkipInvalidRows(true)(&w))
+	assert.NoError(SetSyncRetryStopped(true)(&w))
+	assert.NoError(SetSyncRetryTransientRows(true)(&w))
+	assert.NoError(SetSyncTableOptions("p", "d", "t", TableOptions{SkipInvalidRows: true})(&w))
+	assert.NoError(SetSyncMaxBytes(1024)(&w))
+	assert.NoError(SetSyncMaxRowsPerRequest(500)(&w))
+	assert.NoError(SetSyncGzip(true)(&w))
+	assert.NoError(SetSyncGzipMinBytes(1024)(&w))
+	assert.NoError(SetSyncUserAgent("my-app/1.0", true)(&w))
+	assert.NoError(SetSyncQuotaProject("example.com:billing-project")(&w))
+	assert.NoError(SetSyncQuotaProject("billing-project")(&w))
+	assert.NoError(SetSyncRetryDeadline(time.Minute)(&w))
+	assert.NoError(SetSyncInsertTimeout(5 * time.Second)(&w))
+	assert.NoError(SetSyncRetryBackoff(time.Second, time.Minute, 2)(&w))
+	assert.NoError(SetSyncEndpoint("http://localhost:9050/bigquery/v2")(&w))
+	assert.NoError(SetSyncStatsHandler(NopStatsHandler{})(&w))
+	assert.NoError(SetSyncLogger(nopLogger{})(&w))
+	assert.NoError(SetSyncInsertTracer(&insertTracerRecorder{})(&w))
+	assert.NoError(SetSyncDeadLetterHandler(func(Row, error) {})(&w))
+	assert.NoError(SetSyncSpillHandler(func([]Row) error { return nil })(&w))
+	assert.NoError(SetSyncFlushCallback(func(int, int, string) {})(&w))
+	assert.NoError(SetSyncRetryableFunc(func(error) bool { return true })(&w))
+	assert.NoError(SetSyncTableRetryable("p", "d", "t", func(error) bool { return false })(&w))
+	assert.NoError(SetSyncSchemaRefresh(func(string) error { return nil })(&w))
+	assert.NoError(SetSyncTraceID(func([]Row) string { return "id" })(&w))
+	assert.NoError(SetSyncRetryCallback(func(int, error, time.Duration) {})(&w))
+	schema := &bigquery.TableSchema{}
+	assert.NoError(SetSyncSchema("p", "d", "t", schema)(&w))
+	assert.NoError(SetSyncRowTransform(func(r Row) (Row, error) { return r, nil })(&w))
+	assert.NoError(SetSyncRowSizer(func(Row) int { return 1 })(&w))
+	assert.NoError(SetSyncDedupKey(func(r Row) string { return r.InsertID })(&w))
+	assert.NoError(SetSyncPreFlush(func(rows []Row) []Row { return rows })(&w))
 
 	assert.Equal(2*time.Second, w.retryInterval)
 	assert.Equal(2, w.maxRetries)
 	assert.True(w.ignoreUnknownValues)
 	assert.True(w.skipInvalidRows)
+	assert.True(w.retryStopped)
+	assert.True(w.retryTransientRows)
+	assert.Equal(TableOptions{SkipInvalidRows: true}, w.tableOpts[tableKey{"p", "d", "t"}])
+	assert.Equal(1024, w.maxBytes)
+	assert.Equal(500, w.maxRowsPerRequest)
+	assert.True(w.gzip)
+	assert.Equal(1024, w.gzipMinBytes)
+	assert.Equal("my-app/1.0", w.userAgent)
+	assert.True(w.replaceUserAgent)
+	assert.Equal("billing-project", w.quotaProject)
+	assert.Equal(time.Minute, w.retryDeadline)
+	assert.Equal(5*time.Second, w.insertTimeout)
+	assert.Equal(time.Second, w.backoffInitial)
+	assert.Equal(time.Minute, w.backoffMax)
+	assert.Equal(2.0, w.backoffMultiplier)
+	assert.Equal("http://localhost:9050/bigquery/v2/", w.endpoint)
+	assert.Equal(NopStatsHandler{}, w.stats)
+	assert.Equal(nopLogger{}, w.logger)
+	assert.NotNil(w.tracer)
+	assert.NotNil(w.deadLetter)
+	assert.NotNil(w.retryable)
+	assert.Contains(w.tableRetryable, tableKey{"p", "d", "t"})
+	assert.NotNil(w.schemaRefresh)
+	assert.NotNil(w.traceID)
+	assert.Equal(schema, w.schemas[tableKey{"p", "d", "t"}])
+	assert.NotNil(w.transform)
+	assert.NotNil(w.dedupKey)
 }
@@ -4,7 +4,11 @@ package bqstreamer
 
 import (
 	"errors"
+	"net/url"
+	"strings"
 	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
 )
 
 const (
@@ -16,6 +20,15 @@ const (
 	DefaultSyncRetryInterval = 5 * time.Second
 )
 
+// MaxSyncRetryAfter is the maximum time a worker waits before retrying
+// a rate limited insert, even if BigQuery requests a longer delay using
+// a Retry-After header.
+//
+// A longer Retry-After is cut short on purpose, so a single bogus header
+// can't stall a worker, and its buffered rows, indefinitely.
+// Use SetSyncRetryDeadline() for bounding retries overall.
+const MaxSyncRetryAfter = 1 * time.Minute
+
 type SyncOptionFunc func(*SyncWorker) error
 
 // SetSyncMaxRetries sets the maximum amount of retries a failed insert
@@ -47,6 +60,302 @@ func SetSyncRetryInterval(sleep time.Duration) SyncOptionFunc {
 	}
 }
 
+// SetSyncRetryDeadline bounds the total time spent on an insert operation
+// and its retries, counting from its first attempt,
+// e.g. for bounding worst-case latency when retrying with long backoffs.
+//
+// An insert isn't retried if the retry would start after the deadline,
+// even if retries remain according to SetSyncMaxRetries(),
+// whichever limit is hit first.
+// It is reported with ErrRetryDeadlineExceeded instead,
+// so its rows take the error path as with any other failed insert.
+// Attempts in progress aren't interrupted, see SetSyncInsertTimeout().
+//
+// NOTE value must be a positive time.Duration.
+func SetSyncRetryDeadline(deadline time.Duration) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if deadline <= 0 {
+			return errors.New("retry deadline value must be a positive time.Duration")
+		}
+		w.retryDeadline = deadline
+		return nil
+	}
+}
+
+// SetSyncRetryBackoff sets an exponential backoff with full jitter
+// between retries of a failed insert operation,
+// instead of the flat interval set by SetSyncRetryInterval().
+//
+// The delay before retry attempt n (starting at zero) is a random duration
+// between zero and min(initial * multiplier^n, max).
+// The backoff resets to initial on every new insert operation.
+// If BigQuery requests a longer delay using a Retry-After header,
+// the requested delay is waited instead, up to MaxSyncRetryAfter.
+// A requested delay longer than MaxSyncRetryAfter is cut short on purpose,
+// i.e. the insert is then retried sooner than requested.
+//
+// NOTE initial must be a positive time.Duration, max must not be smaller
+// than initial, and multiplier must be at least 1.
+func SetSyncRetryBackoff(initial, max time.Duration, multiplier float64) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if initial <= 0 {
+			return errors.New("initial backoff value must be a positive time.Duration")
+		}
+		if max < initial {
+			return errors.New("max backoff value must not be smaller than initial backoff")
+		}
+		if multiplier < 1 {
+			return errors.New("backoff multiplier value must be at least 1")
+		}
+		w.backoffInitial = initial
+		w.backoffMax = max
+		w.backoffMultiplier = multiplier
+		return nil
+	}
+}
+
+// SetSyncEndpoint overrides the BigQuery API base URL used for insert
+// operations, e.g. "http://localhost:9050/bigquery/v2/" for pointing
+// the worker at a local BigQuery emulator.
+//
+// NOTE value must be a well-formed http or https URL.
+func SetSyncEndpoint(endpoint string) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("endpoint value must be a well-formed http or https URL")
+		}
+		// Request paths are appended to the base URL,
+		// which must thus end with a slash.
+		if !strings.HasSuffix(endpoint, "/") {
+			endpoint += "/"
+		}
+		w.endpoint = endpoint
+		return nil
+	}
+}
+
+// SetSyncStatsHandler sets a handler receiving insert related events,
+// e.g. for exporting metrics.
+//
+// NOTE value must not be nil.
+func SetSyncStatsHandler(h StatsHandler) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if h == nil {
+			return errors.New("stats handler is nil")
+		}
+		w.stats = h
+		return nil
+	}
+}
+
+// SetSyncLogger sets a logger for worker lifecycle and insert related events,
+// e.g. insert retries.
+//
+// NOTE value must not be nil.
+func SetSyncLogger(l Logger) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if l == nil {
+			return errors.New("logger is nil")
+		}
+		w.logger = l
+		return nil
+	}
+}
+
+// SetSyncInsertTracer sets a tracer called around every insert request
+// to BigQuery. No requests are traced by default.
+//
+// Use bqotel.SetSyncTracerProvider() for tracing using OpenTelemetry.
+//
+// NOTE value must not be nil.
+func SetSyncInsertTracer(t InsertTracer) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if t == nil {
+			return errors.New("insert tracer is nil")
+		}
+		w.tracer = t
+		return nil
+	}
+}
+
+// SetSyncDeadLetterHandler sets a handler called for every row rejected
+// by BigQuery, e.g. due to a schema mismatch or invalid values.
+// Rejected rows are not retried.
+//
+// The handler receives the exact Row that was enqueued,
+// and a *RowRejectedError describing why it was rejected.
+// It is called synchronously during the insert operation,
+// and may be used for persisting bad rows to a dead-letter store.
+//
+// NOTE value must not be nil.
+func SetSyncDeadLetterHandler(h func(row Row, err error)) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if h == nil {
+			return errors.New("dead-letter handler is nil")
+		}
+		w.deadLetter = h
+		return nil
+	}
+}
+
+// SetSyncSpillHandler sets a handler called with the rows of every insert
+// operation which has failed entirely, e.g. after too many retries,
+// due to a non-retryable error, or while the circuit breaker is open.
+//
+// The handler may write rows to a local write-ahead log instead of
+// losing them, to be inserted again once BigQuery is available,
+// e.g. using AsyncWorkerGroup.Replay().
+// It receives rows as enqueued, before being transformed if
+// SetSyncRowTransform() has been set.
+// Rows rejected by BigQuery are not spilled, see SetSyncDeadLetterHandler(),
+// nor are rows of insert operations interrupted by a done context.
+//
+// It is called synchronously during the insert operation,
+// and errors returned by it are logged.
+//
+// NOTE value must not be nil.
+func SetSyncSpillHandler(h func(rows []Row) error) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if h == nil {
+			return errors.New("spill handler is nil")
+		}
+		w.spill = h
+		return nil
+	}
+}
+
+// SetSyncFlushCallback sets a function called after every insert operation,
+// with the amount of rows inserted and rejected per table,
+// identified as "project.dataset.table".
+//
+// Rows may be partially inserted, e.g. when SetSyncSkipInvalidRows()
+// has been set, in which case both amounts are non-zero.
+// Rows neither inserted nor rejected have failed to be inserted entirely,
+// e.g. after too many retries, reported as an insert error.
+// Rows failing schema validation or transformation are counted as rejected.
+//
+// NOTE value must not be nil.
+func SetSyncFlushCallback(f func(inserted, rejected int, table string)) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if f == nil {
+			return errors.New("flush callback is nil")
+		}
+		w.flushCallback = f
+		return nil
+	}
+}
+
+// SetSyncRetryableFunc overrides IsRetryable(),
+// which decides whether a failed insert operation should be retried.
+//
+// NOTE value must not be nil.
+func SetSyncRetryableFunc(f func(err error) bool) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if f == nil {
+			return errors.New("retryable func is nil")
+		}
+		w.retryable = f
+		return nil
+	}
+}
+
+// SetSyncSchemaRefresh sets a function refreshing the schema of a table
+// rejecting rows due to unknown fields, i.e. "no such field" errors,
+// typically since the table's schema lags behind a recently added column.
+// It is given the table as "project.dataset.table",
+// and may e.g. patch the table's schema, or wait for it to propagate.
+//
+// All rejected rows of the request are retried once after it returns nil.
+// Rows keep their insert IDs, guarding against duplicates.
+// The schema is refreshed at most once per table and insert operation,
+// so rows still rejected afterwards are reported as usual,
+// and so are all rejected rows if the function returns an error.
+//
+// NOTE it is only called by InsertWithRetry() and InsertAll(),
+// and must not be nil.
+func SetSyncSchemaRefresh(refresh func(table string) error) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if refresh == nil {
+			return errors.New("schema refresh is nil")
+		}
+		w.schemaRefresh = refresh
+		return nil
+	}
+}
+
+// SetSyncTraceID sets a function returning the trace ID of an insert request
+// of given rows, sent as the request's traceId field,
+// so BigQuery's server side logs can be correlated with client batches,
+// e.g. when asking Google support to trace a particular insert problem.
+// Return the same ID for all requests to use a static ID.
+//
+// The function is called once per request of a single table, holding
+// the rows in their order of enqueueing, and the ID is kept by retries.
+// BigQuery records the ID for debugging purposes only, and doesn't return it
+// in the response. It isn't guaranteed to show in the project's own
+// Cloud Logging entries either, so also log it on the client side
+// for quoting to Google support, e.g. using the tracer set using
+// SetSyncInsertTracer(), which receives it as InsertInfo.TraceID.
+//
+// NOTE BigQuery limits IDs to 36 case sensitive ASCII characters,
+// recommending a UUID.
+func SetSyncTraceID(f func(rows []Row) string) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if f == nil {
+			return errors.New("trace ID func is nil")
+		}
+		w.traceID = f
+		return nil
+	}
+}
+
+// SetSyncTableRetryable overrides IsRetryable(), or the function set using
+// SetSyncRetryableFunc(), for inserts to given table only,
+// e.g. retrying inserts to an append-only log table aggressively,
+// while giving up early on a table relying on deduplication.
+// Use it multiple times for setting functions of multiple tables.
+// Other tables fall back to the worker's function.
+//
+// Same as SetSyncTableOptions(), the function applies to all template tables
+// created from given table, and to all of its partitions
+// unless set for a partition decorator explicitly.
+//
+// NOTE value must not be nil.
+func SetSyncTableRetryable(projectID, datasetID, tableID string, f func(err error) bool) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if f == nil {
+			return errors.New("table retryable func is nil")
+		}
+		if w.tableRetryable == nil {
+			w.tableRetryable = map[tableKey]func(err error) bool{}
+		}
+		w.tableRetryable[tableKey{projectID, datasetID, tableID}] = f
+		return nil
+	}
+}
+
+// SetSyncRetryCallback sets a function called before every retry
+// of an insert operation, right before sleeping between retries,
+// e.g. for alerting on specific errors.
+//
+// It receives the retry's number, starting at 1, the error being retried,
+// and the delay before retrying.
+// For rejected rows retried using SetSyncRetryStopped() or
+// SetSyncRetryTransientRows(), the error is a *RowRejectedError
+// of the first retried row, and the delay is zero for stopped rows.
+//
+// NOTE value must not be nil.
+func SetSyncRetryCallback(f func(attempt int, err error, nextDelay time.Duration)) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if f == nil {
+			return errors.New("retry callback is nil")
+		}
+		w.retryCallback = f
+		return nil
+	}
+}
+
 // SetSyncIgnoreUnknownValues sets whether to accept rows that contain values
 // that do not match the table schema.  The unknown values are ignored.
 // Default is false, which treats unknown values as errors.
@@ -66,3 +375,404 @@ func SetSyncSkipInvalidRows(skip bool) SyncOptionFunc {
 		return nil
 	}
 }
+
+// TableOptions are insert request options of a single table,
+// set using SetSyncTableOptions() or SetAsyncTableOptions().
+type TableOptions struct {
+	// Same as SetSyncIgnoreUnknownValues(), for this table only.
+	IgnoreUnknownValues bool
+
+	// Same as SetSyncSkipInvalidRows(), for this table only.
+	SkipInvalidRows bool
+}
+
+// SetSyncTableOptions sets the insert request options of given table,
+// overriding the ones set using SetSyncIgnoreUnknownValues() and
+// SetSyncSkipInvalidRows(), e.g. for tables with different tolerances
+// to invalid rows. Use it multiple times for setting options of multiple
+// tables. Other tables use the worker's options.
+//
+// Options apply to all template tables created from given table as well,
+// see Row.TemplateSuffix, and to all of its partitions
+// unless set for a partition decorator explicitly.
+func SetSyncTableOptions(projectID, datasetID, tableID string, opts TableOptions) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if w.tableOpts == nil {
+			w.tableOpts = map[tableKey]TableOptions{}
+		}
+		w.tableOpts[tableKey{projectID, datasetID, tableID}] = opts
+		return nil
+	}
+}
+
+// SetSyncRetryStopped sets whether to retry rows rejected with the "stopped"
+// reason, i.e. valid rows not inserted due to other invalid rows
+// in the same request, which happens unless SetSyncSkipInvalidRows() is set.
+//
+// Stopped rows are retried immediately without the invalid rows,
+// counting against the max retries set using SetSyncMaxRetries().
+// Only the invalid rows are then reported as rejected,
+// unless stopped rows are still rejected after too many retries.
+func SetSyncRetryStopped(retry bool) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.retryStopped = retry
+		return nil
+	}
+}
+
+// SetSyncRetryTransientRows sets whether to retry rows rejected due to
+// transient errors, i.e. with the "backendError", "internalError" or "timeout"
+// reasons, e.g. when some rows of a request have been inserted
+// and some have failed, as happens if SetSyncSkipInvalidRows() is set.
+//
+// Only the failed rows are retried, without the rows already inserted
+// or rejected for other reasons, so successful rows aren't inserted twice.
+// Retried rows keep their insert IDs, which further guards against duplicates.
+// Rows are retried after the retry delay, or after the delay requested by
+// a Retry-After header if longer, up to MaxSyncRetryAfter,
+// counting against the max retries set using SetSyncMaxRetries(),
+// and are reported as rejected if they're still rejected after too many retries.
+func SetSyncRetryTransientRows(retry bool) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.retryTransientRows = retry
+		return nil
+	}
+}
+
+// SetSyncMaxBytes sets the maximum accumulated size in bytes of enqueued rows,
+// as encoded in the insert request.
+//
+// Use CanEnqueue() to check whether enqueueing a row would exceed the limit,
+// meaning an insert operation should be executed first.
+// A single row larger than the limit can still be enqueued and sent,
+// but BigQuery will reject it, reported as an insert error.
+//
+// See the following article for BigQuery's request size limits:
+// https://cloud.google.com/bigquery/quotas#streaming_inserts
+//
+// NOTE value must be a positive int.
+func SetSyncMaxBytes(bytes int) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if bytes <= 0 {
+			return errors.New("max bytes value must be a positive int")
+		}
+		w.maxBytes = bytes
+		return nil
+	}
+}
+
+// SetSyncMaxRowsPerRequest sets the maximum amount of rows
+// sent in a single insert request.
+//
+// Tables with more enqueued rows are inserted using multiple requests,
+// so many rows can be enqueued between inserts
+// while keeping requests small, e.g. at BigQuery's recommended 500 rows:
+// https://cloud.google.com/bigquery/quotas#streaming_inserts
+//
+// Row errors are still reported relative to the table's enqueued rows.
+//
+// NOTE value must be a positive int, not exceeding BigQuery's limit of 10000.
+// The limit is used by default.
+func SetSyncMaxRowsPerRequest(rows int) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if rows <= 0 || rows > maxRequestRows {
+			return errors.New("max rows per request value must be a positive int not exceeding 10000")
+		}
+		w.maxRowsPerRequest = rows
+		return nil
+	}
+}
+
+// SetSyncInsertTimeout sets the maximum duration of a single insert request,
+// including its entire round trip.
+// By default a request is only bounded by the HTTP client's own timeouts,
+// e.g. when dialing or during the TLS handshake.
+//
+// The timeout applies to every insert attempt separately,
+// so e.g. an insert retried twice with a 5s timeout may take up to ~15s,
+// as well as the delay between retries.
+// Timed out requests fail with an *InsertTimeoutError, and are retried.
+//
+// NOTE value must be a positive time.Duration.
+func SetSyncInsertTimeout(d time.Duration) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if d <= 0 {
+			return errors.New("insert timeout value must be a positive time.Duration")
+		}
+		w.insertTimeout = d
+		return nil
+	}
+}
+
+// SetSyncGzip sets whether to compress insert request bodies using gzip.
+//
+// Rows encoded as JSON usually compress well, reducing egress bandwidth
+// and upload time of large requests, at the cost of CPU time spent
+// compressing every request. For example, BenchmarkGzipTransport compresses
+// a request of 500 small rows (about 60KB) roughly 12x, spending about 0.6ms
+// of CPU time per request, i.e. a throughput of about 90MB/s per core.
+// This is mostly worthwhile for large requests over slow or metered links.
+//
+// NOTE BigQuery enforces its request size limit on the uncompressed payload,
+// so SetSyncMaxBytes() and request splitting are applied to the uncompressed
+// size regardless.
+func SetSyncGzip(enabled bool) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.gzip = enabled
+		return nil
+	}
+}
+
+// SetSyncGzipMinBytes sets the minimum size in bytes of insert request bodies
+// compressed using gzip, if enabled using SetSyncGzip().
+// Smaller bodies are sent uncompressed, since compressing them spends
+// CPU time without saving much bandwidth.
+//
+// The Content-Encoding header is only set on requests actually compressed.
+//
+// NOTE value must be a non-negative int. Defaults to 0, compressing all bodies.
+func SetSyncGzipMinBytes(n int) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if n < 0 {
+			return errors.New("gzip min bytes value must be a non-negative int")
+		}
+		w.gzipMinBytes = n
+		return nil
+	}
+}
+
+// SetSyncUserAgent sets a custom User-Agent header on all insert requests,
+// e.g. for attributing requests in Cloud Monitoring and quota debugging.
+//
+// The given user agent is appended to the BigQuery API client's generic one,
+// or replaces it altogether if replace is true.
+// The header is set on top of the client's transport,
+// and is thus kept by OAuth2 transports.
+//
+// NOTE value must be a non-empty string.
+func SetSyncUserAgent(userAgent string, replace bool) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if userAgent == "" {
+			return errors.New("user agent value must be a non-empty string")
+		}
+		w.userAgent = userAgent
+		w.replaceUserAgent = replace
+		return nil
+	}
+}
+
+// SetSyncQuotaProject bills quota and usage of all insert requests
+// to given project, instead of the project owning the inserted tables,
+// e.g. for requester-pays or cross-project billing setups.
+//
+// It sets the X-Goog-User-Project header on top of the client's transport.
+// The client's credentials must have the serviceusage.services.use
+// permission on the quota project (e.g. the Service Usage Consumer role),
+// otherwise BigQuery rejects all requests with a 403 error,
+// which isn't retried.
+//
+// NOTE value must be a valid project ID,
+// i.e. 6 to 30 lowercase letters, digits or hyphens, starting with a letter.
+func SetSyncQuotaProject(projectID string) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if !projectIDRegexp.MatchString(projectID) {
+			return errors.New("quota project value must be a valid project ID")
+		}
+		w.quotaProject = projectID
+		return nil
+	}
+}
+
+// SetSyncSchema sets the schema of given table,
+// for validating its rows before sending them to BigQuery.
+// Use it multiple times for validating rows of multiple tables.
+// Rows of other tables are not validated.
+// Rows inserted using a partition decorator, e.g. "mytable$20240101",
+// are validated using the schema of their partitioned table.
+//
+// Row values must match the schema's fields, unless unknown values are
+// ignored using SetSyncIgnoreUnknownValues(), and required fields must be set.
+// Field types are not validated.
+//
+// Invalid rows are not inserted. They are reported as rejected instead,
+// in a separate table entry of the returned insert errors,
+// as if rejected by BigQuery.
+// Their row indexes are relative to the table's invalid rows only.
+//
+// This saves a round trip to BigQuery for rows that would be rejected anyways,
+// e.g. due to a typo in a field name.
+func SetSyncSchema(projectID, datasetID, tableID string, schema *bigquery.TableSchema) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if schema == nil {
+			return errors.New("schema is nil")
+		}
+		if w.schemas == nil {
+			w.schemas = map[tableKey]*bigquery.TableSchema{}
+		}
+		w.schemas[tableKey{projectID, datasetID, tableID}] = schema
+		return nil
+	}
+}
+
+// SetSyncRowTransform sets a function transforming every row before insert,
+// e.g. for renaming fields, dropping sensitive values,
+// or adding an ingestion timestamp.
+//
+// Rows are transformed once per insert operation, before being validated
+// against their table's schema if set using SetSyncSchema().
+// Transformed rows are reported in insert errors instead of the enqueued rows.
+//
+// Rows failing to transform are not inserted.
+// They are reported as rejected instead, with the transform error's message,
+// the same as rows not matching their table's schema.
+func SetSyncRowTransform(transform func(Row) (Row, error)) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if transform == nil {
+			return errors.New("row transform is nil")
+		}
+		w.transform = transform
+		return nil
+	}
+}
+
+// SetSyncPreFlush sets a function called with the rows of every insert request
+// right before sending it, returning the rows to actually send,
+// e.g. for adding a batch-level correlation field to all rows,
+// or dropping rows based on conditions over the entire batch.
+//
+// Unlike SetSyncRowTransform(), it's called per request, i.e. with a single
+// table's rows once they've been transformed, validated and split into
+// requests not exceeding BigQuery's limits. Returning no rows skips the request.
+// Rows added by it may cause a request to be rejected as too large,
+// in which case it's split without calling it again.
+//
+// Row errors and failed rows given to the spill handler are the rows
+// returned by it, not the enqueued rows, since they can't be matched.
+func SetSyncPreFlush(preFlush func([]Row) []Row) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if preFlush == nil {
+			return errors.New("pre-flush is nil")
+		}
+		w.preFlush = preFlush
+		return nil
+	}
+}
+
+// SetSyncDedupKey sets a function returning a key for every enqueued row,
+// such that rows of the same table with the same key are collapsed
+// to their last occurrence on every insert operation,
+// e.g. for producers accidentally enqueueing the same row twice.
+//
+// Only rows enqueued by the same worker and inserted together are collapsed,
+// i.e. it doesn't replace BigQuery's best-effort de-duplication
+// using insert IDs. Rows are collapsed before being transformed
+// if SetSyncRowTransform() is set, and rows with an empty key never are.
+// Collapsed rows are counted in Stats().DedupedRows.
+func SetSyncDedupKey(key func(Row) string) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if key == nil {
+			return errors.New("dedup key is nil")
+		}
+		w.dedupKey = key
+		return nil
+	}
+}
+
+// SetSyncRowSizer sets a function estimating a row's size in bytes,
+// as encoded in the insert request, used instead of encoding every
+// enqueued row for tracking the max bytes limit set using SetSyncMaxBytes().
+//
+// Encoding rows is relatively expensive, so users knowing their rows'
+// schema can trade precision for throughput using a cheaper estimate.
+// Insert requests are still encoded, and split by their actual size.
+func SetSyncRowSizer(sizer func(Row) int) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		if sizer == nil {
+			return errors.New("row sizer is nil")
+		}
+		w.sizer = sizer
+		return nil
+	}
+}
+
+// setSyncInsertSemaphore sets a semaphore bounding the amount of concurrent
+// insert requests, shared by all workers of an AsyncWorkerGroup.
+func setSyncInsertSemaphore(sem chan struct{}) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.insertSem = sem
+		return nil
+	}
+}
+
+// setSyncInFlightLimit sets a weighted semaphore bounding the accumulated size
+// of in-flight insert requests, shared by all workers of an AsyncWorkerGroup.
+func setSyncInFlightLimit(l *inFlightLimit) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.inFlight = l
+		return nil
+	}
+}
+
+// setSyncDropped sets the function reporting rows dropped by the worker
+// without being inserted, see AsyncWorkerGroup.dropped().
+func setSyncDropped(f func(rows []Row, reason DropReason)) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.dropped = f
+		return nil
+	}
+}
+
+// setSyncCounters sets the counters maintained for Stats(),
+// shared by all workers of an AsyncWorkerGroup.
+func setSyncCounters(c *workerCounters) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.counters = c
+		return nil
+	}
+}
+
+// setSyncRetryBudget sets a retry budget limiting retries to a fraction of
+// successful insert requests, shared by all workers of an AsyncWorkerGroup.
+func setSyncRetryBudget(b *retryBudget) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.retryBudget = b
+		return nil
+	}
+}
+
+// setSyncHealthWindow sets a window counting recent insert requests
+// and their failures, shared by all workers of an AsyncWorkerGroup.
+func setSyncHealthWindow(h *healthWindow) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.health = h
+		return nil
+	}
+}
+
+// setSyncTableStats sets the per table insert counters maintained for
+// AsyncWorkerGroup.TableStats(), shared by all workers of an AsyncWorkerGroup.
+func setSyncTableStats(s *tableStats) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.tableStats = s
+		return nil
+	}
+}
+
+// setSyncClock sets the clock timing retry backoff,
+// and max delay of an AsyncWorkerGroup's workers, e.g. a fake clock in tests.
+func setSyncClock(c clock) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.clock = c
+		return nil
+	}
+}
+
+// setSyncCircuitBreaker sets a circuit breaker short-circuiting insert requests
+// after too many consecutive failures, shared by all workers of
+// an AsyncWorkerGroup.
+func setSyncCircuitBreaker(b *circuitBreaker) SyncOptionFunc {
+	return func(w *SyncWorker) error {
+		w.breaker = b
+		return nil
+	}
+}
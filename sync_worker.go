@@ -3,8 +3,16 @@
 package bqstreamer
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
 	"net"
 	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	bigquery "google.golang.org/api/bigquery/v2"
@@ -18,21 +26,83 @@ import (
 // TODO make this configurable
 const rowSize = 500
 
+// BigQuery limits for a single insert request.
+// Tables with more enqueued rows are split into multiple requests.
+//
+// https://cloud.google.com/bigquery/quotas#streaming_inserts
+const (
+	// Max amount of rows per request.
+	maxRequestRows = 10000
+
+	// Max request size in bytes, including an estimation of
+	// request fields other than its rows.
+	maxRequestBytes      = 10 * 1024 * 1024
+	requestOverheadBytes = 1024
+
+	// Max amount of times a request rejected as too large is split in halves,
+	// enough for splitting requests of a few hundred rows into single rows.
+	maxSplitDepth = 10
+)
+
 // SyncWorker streams rows to BigQuery in bulk using synchronous calls.
 type SyncWorker struct {
 	// BigQuery client connection.
 	service *bigquery.Service
 
+	// Sends insert requests of rows holding raw JSON data,
+	// see insertAllRaw().
+	client *http.Client
+
+	// Compress insert request bodies larger than gzipMinBytes using gzip.
+	gzip         bool
+	gzipMinBytes int
+
+	// Appended to, or replaces, the User-Agent header of insert requests if set.
+	userAgent        string
+	replaceUserAgent bool
+
+	// Bills insert requests' quota to this project if set.
+	quotaProject string
+
+	// Overrides the BigQuery API base URL if set,
+	// e.g. for using a local emulator.
+	endpoint string
+
 	// Internal list to queue rows for stream insert.
 	rows []Row
 
+	// Times rows were enqueued at, in the same order as rows,
+	// for reporting how long they've been queued.
+	enqueuedAt []time.Time
+
+	// Max accumulated size in bytes of queued rows, as encoded in the
+	// insert request. A zero value means no limit.
+	maxBytes int
+
+	// Max amount of rows per insert request.
+	// Tables with more queued rows are split into multiple requests.
+	maxRowsPerRequest int
+
+	// Accumulated size in bytes of queued rows.
+	// Only tracked if maxBytes is set.
+	rowsBytes int
+
 	// Sleep delay after a rejected insert and before a retry insert attempt.
 	retryInterval time.Duration
 
+	// Exponential backoff between retry insert attempts.
+	// If backoffInitial is zero, retryInterval is used instead.
+	backoffInitial    time.Duration
+	backoffMax        time.Duration
+	backoffMultiplier float64
+
 	// Maximum retry insert attempts for non-rejected row insert
 	// e.g. GoogleAPI HTTP errors, generic HTTP errors, etc.
 	maxRetries int
 
+	// Bounds the total time spent retrying an insert operation if set.
+	retryDeadline time.Duration
+
 	// Accept rows that contain values that do not match the schema.
 	// The unknown values are ignored.
 	// Default is false, which treats unknown values as errors.
@@ -42,20 +112,138 @@ type SyncWorker struct {
 	// The default value is false, which causes the entire request
 	// to fail if any invalid rows exist.
 	skipInvalidRows bool
+
+	// Override ignoreUnknownValues and skipInvalidRows for these tables.
+	tableOpts map[tableKey]TableOptions
+
+	// Insert request latencies of this worker only.
+	latency *latencyHistogram
+
+	// Per table insert counters if set,
+	// shared by all workers of an AsyncWorkerGroup.
+	tableStats *tableStats
+
+	// Returns the trace ID of insert requests of given rows if set.
+	traceID func(rows []Row) string
+
+	// Reports rows dropped without being inserted if set,
+	// i.e. rows whose context's deadline passed before their insert.
+	dropped func(rows []Row, reason DropReason)
+
+	// Times max delay and retry backoff, see setSyncClock().
+	clock clock
+
+	// Refreshes the schema of tables rejecting rows due to unknown fields
+	// if set. refreshedSchemas holds the tables already refreshed during
+	// the current insert operation, and is nil unless retrying inserts.
+	schemaRefresh    func(table string) error
+	refreshedSchemas map[tableKey]bool
+
+	// Retry rows not inserted only due to other invalid rows in the request,
+	// i.e. rejected with the "stopped" reason.
+	retryStopped bool
+
+	// Retry rows rejected due to transient errors,
+	// e.g. with the "backendError" reason.
+	retryTransientRows bool
+
+	// Receives insert related events, e.g. for metrics.
+	stats StatsHandler
+
+	// Logs lifecycle and insert related events.
+	logger Logger
+
+	// Traces every insert request if set.
+	tracer InsertTracer
+
+	// Called for every row rejected by BigQuery if set.
+	deadLetter func(row Row, err error)
+
+	// Receives rows of failed insert operations if set, instead of dropping them.
+	spill func(rows []Row) error
+
+	// Called with the amount of inserted and rejected rows
+	// of every table after every insert operation if set.
+	flushCallback func(inserted, rejected int, table string)
+
+	// Overrides IsRetryable() for deciding whether to retry a failed insert,
+	// if set.
+	retryable func(err error) bool
+
+	// Overrides retryable for inserts to particular tables,
+	// see SetSyncTableRetryable().
+	tableRetryable map[tableKey]func(err error) bool
+
+	// Called before every retry of an insert operation if set.
+	retryCallback func(attempt int, err error, nextDelay time.Duration)
+
+	// Bounds the amount of concurrent insert requests if set,
+	// shared among all workers of an AsyncWorkerGroup.
+	insertSem chan struct{}
+
+	// Bounds the accumulated size of in-flight insert requests if set,
+	// shared among all workers of an AsyncWorkerGroup.
+	inFlight *inFlightLimit
+
+	// Minimum delay before retrying a rate limited insert,
+	// doubled on every consecutive rate limited insert,
+	// and reset after a successful one.
+	rateLimitDelay time.Duration
+
+	// Rows of these tables are validated against their schema before insert.
+	schemas map[tableKey]*bigquery.TableSchema
+
+	// Transforms every row before insert if set.
+	transform func(Row) (Row, error)
+
+	// Collapses enqueued rows with the same key before insert if set.
+	dedupKey func(Row) string
+
+	// Replaces the rows of every insert request right before sending it if set.
+	preFlush func([]Row) []Row
+
+	// Estimates enqueued rows' size instead of encoding them if set.
+	sizer func(Row) int
+
+	// Max duration of a single insert request, including its round trip.
+	// A zero value means no limit.
+	insertTimeout time.Duration
+
+	// Short-circuits insert requests after too many consecutive failures if set,
+	// shared among all workers of an AsyncWorkerGroup.
+	breaker *circuitBreaker
+
+	// Limits retries to a fraction of successful insert requests if set,
+	// shared among all workers of an AsyncWorkerGroup.
+	retryBudget *retryBudget
+
+	// Counts recent insert requests for AsyncWorkerGroup.Healthy() if set.
+	health *healthWindow
+
+	// Counts buffered rows, in-flight inserts and insert results,
+	// shared among all workers of an AsyncWorkerGroup.
+	counters *workerCounters
 }
 
+// insertTableFunc inserts given table's rows in a single insert operation,
+// which may consist of multiple insert attempts.
+//
+// If templateSuffix is set, rows are inserted to the table named
+// tableID + templateSuffix, created using tableID as a template.
+type insertTableFunc func(ctx context.Context, projectID, datasetID, tableID, templateSuffix string, tbl table) *TableInsertErrors
+
 // NewSyncWorker returns a new SyncWorker.
 func NewSyncWorker(client *http.Client, options ...SyncOptionFunc) (*SyncWorker, error) {
-	service, err := bigquery.New(client)
-	if err != nil {
-		return nil, err
-	}
-
 	w := SyncWorker{
-		service:       service,
-		rows:          make([]Row, 0, rowSize),
-		retryInterval: DefaultSyncRetryInterval,
-		maxRetries:    DefaultSyncMaxRetries,
+		rows:              make([]Row, 0, rowSize),
+		retryInterval:     DefaultSyncRetryInterval,
+		maxRetries:        DefaultSyncMaxRetries,
+		stats:             NopStatsHandler{},
+		logger:            nopLogger{},
+		counters:          &workerCounters{},
+		latency:           &latencyHistogram{},
+		maxRowsPerRequest: maxRequestRows,
+		clock:             realClock{},
 	}
 
 	// Override defaults with options if given.
@@ -65,12 +253,67 @@ func NewSyncWorker(client *http.Client, options ...SyncOptionFunc) (*SyncWorker,
 		}
 	}
 
+	// Wrap the client's transport for compressing requests,
+	// without modifying the given client.
+	if w.gzip && client != nil {
+		c := *client
+		c.Transport = &gzipTransport{base: client.Transport, minBytes: w.gzipMinBytes}
+		client = &c
+	}
+
+	// Wrap the client's transport for setting the User-Agent header,
+	// including the OAuth2 transport if any.
+	if w.userAgent != "" && client != nil {
+		c := *client
+		c.Transport = &userAgentTransport{userAgent: w.userAgent, replace: w.replaceUserAgent, base: client.Transport}
+		client = &c
+	}
+
+	// Wrap the client's transport for setting the quota project header.
+	if w.quotaProject != "" && client != nil {
+		c := *client
+		c.Transport = &quotaProjectTransport{projectID: w.quotaProject, base: client.Transport}
+		client = &c
+	}
+
+	service, err := bigquery.New(client)
+	if err != nil {
+		return nil, err
+	}
+	w.service = service
+	w.client = client
+
+	if w.endpoint != "" {
+		w.service.BasePath = w.endpoint
+	}
+
 	return &w, nil
 }
 
 // Enqueue enqueues rows for insert in bulk.
+//
+// Rows may target different projects, datasets and tables.
+// They are grouped by table on insert.
 func (w *SyncWorker) Enqueue(row Row) {
+	w.enqueue(row, w.encodedSize(row))
+}
+
+// enqueue enqueues a row of given size in bytes.
+func (w *SyncWorker) enqueue(row Row, size int) {
 	w.rows = append(w.rows, row)
+	w.enqueuedAt = append(w.enqueuedAt, time.Now())
+	w.rowsBytes += size
+	atomic.AddInt64(&w.counters.bufferedRows, 1)
+	atomic.AddInt64(&w.counters.enqueuedRows, 1)
+	w.stats.RowsEnqueued(1)
+}
+
+// reset removes all enqueued rows.
+func (w *SyncWorker) reset() {
+	atomic.AddInt64(&w.counters.bufferedRows, -int64(len(w.rows)))
+	w.rows = w.rows[:0]
+	w.enqueuedAt = w.enqueuedAt[:0]
+	w.rowsBytes = 0
 }
 
 // RowLen returns the number of enqueued rows in the worker,
@@ -79,6 +322,62 @@ func (w *SyncWorker) RowLen() int {
 	return len(w.rows)
 }
 
+// ByteLen returns the accumulated size in bytes of enqueued rows,
+// as encoded in the insert request.
+//
+// NOTE size is tracked only if a max bytes limit has been set
+// using SetSyncMaxBytes(). Otherwise zero is returned.
+func (w *SyncWorker) ByteLen() int {
+	return w.rowsBytes
+}
+
+// CanEnqueue returns false if enqueueing given row would cause
+// the accumulated size of enqueued rows to exceed the max bytes limit,
+// meaning an insert operation should be executed first.
+//
+// It always returns true if no rows are enqueued or no limit has been set.
+func (w *SyncWorker) CanEnqueue(row Row) bool {
+	return !w.exceedsMaxBytes(w.encodedSize(row))
+}
+
+// exceedsMaxBytes returns true if enqueueing a row of given size
+// would exceed the max bytes limit.
+//
+// A single row is allowed to exceed the limit if no other rows are enqueued.
+func (w *SyncWorker) exceedsMaxBytes(size int) bool {
+	return w.maxBytes > 0 && len(w.rows) > 0 && w.rowsBytes+size > w.maxBytes
+}
+
+// encodedSize returns given row's size in bytes,
+// using the same JSON encoding used for the insert request,
+// or as estimated by the row sizer if set.
+//
+// Zero is returned if no max bytes limit has been set.
+func (w *SyncWorker) encodedSize(row Row) int {
+	if w.maxBytes == 0 {
+		return 0
+	}
+	if w.sizer != nil {
+		return w.sizer(row)
+	}
+
+	return encodedRowSize(requestRow(row))
+}
+
+// encodedRowSize returns given request row's size in bytes,
+// as encoded in the insert request.
+func encodedRowSize(row *bigquery.TableDataInsertAllRequestRows) int {
+	b, err := json.Marshal(row)
+	if err != nil {
+		// The insert request will fail encoding this row anyways,
+		// so don't let it count against the limit.
+		return 0
+	}
+
+	// Add one byte for the separating comma in the rows JSON array.
+	return len(b) + 1
+}
+
 // Insert executes an insert operation in bulk.
 // It sorts rows by tables, and inserts them using separate insert requests.
 // It also splits rows for the same table if too many rows have been queued,
@@ -87,7 +386,16 @@ func (w *SyncWorker) RowLen() int {
 // The insert blocks until a response is returned.
 // The response contains insert and row errors for the inserted tables.
 func (w *SyncWorker) Insert() *InsertErrors {
-	insertErrs := w.insertAll(w.insertTable)
+	return w.InsertContext(context.Background())
+}
+
+// InsertContext is similar to Insert(),
+// but executes insert requests using given context.
+//
+// The context is also passed to the insert tracer,
+// if one has been set using SetSyncInsertTracer().
+func (w *SyncWorker) InsertContext(ctx context.Context) *InsertErrors {
+	insertErrs := w.insertAll(ctx, w.insertTable)
 	return insertErrs
 }
 
@@ -97,109 +405,822 @@ func (w *SyncWorker) Insert() *InsertErrors {
 // See the following article for more info:
 // https://cloud.google.com/bigquery/troubleshooting-errors
 func (w *SyncWorker) InsertWithRetry() *InsertErrors {
-	insertErrs := w.insertAll(w.insertTableWithRetry)
+	return w.InsertWithRetryContext(context.Background())
+}
+
+// InsertWithRetryContext is similar to InsertWithRetry(),
+// but executes insert requests using given context.
+func (w *SyncWorker) InsertWithRetryContext(ctx context.Context) *InsertErrors {
+	// Schemas are refreshed at most once per table and insert operation.
+	if w.schemaRefresh != nil {
+		w.refreshedSchemas = map[tableKey]bool{}
+		defer func() { w.refreshedSchemas = nil }()
+	}
+
+	insertErrs := w.insertAll(ctx, w.insertTableWithRetry)
 	return insertErrs
 }
 
+// InsertAll enqueues given rows and inserts them using InsertWithRetry(),
+// blocking until all rows have been inserted or have permanently failed.
+//
+// It is a convenience for batch jobs and tests,
+// which don't need to enqueue rows separately.
+// Rows already enqueued are inserted as well.
+func (w *SyncWorker) InsertAll(rows []Row) *InsertErrors {
+	return w.InsertAllContext(context.Background(), rows)
+}
+
+// InsertAllContext is similar to InsertAll(),
+// but executes insert requests using given context.
+func (w *SyncWorker) InsertAllContext(ctx context.Context, rows []Row) *InsertErrors {
+	for _, r := range rows {
+		w.Enqueue(r)
+	}
+	return w.InsertWithRetryContext(ctx)
+}
+
 // insertAll takes all rows, sorts them across projects, datasets, and tables,
 // then inserts them to their respectable tables in BigQuery using InsertAll().
-func (w *SyncWorker) insertAll(insertFunc func(projectID, datasetID, tableID string, tbl table) *TableInsertErrors) *InsertErrors {
+func (w *SyncWorker) insertAll(ctx context.Context, insertFunc insertTableFunc) *InsertErrors {
 	// Reset rows queue when finished.
-	defer func() { w.rows = w.rows[:0] }()
+	defer w.reset()
+
+	if len(w.enqueuedAt) > 0 {
+		w.stats.RowsQueueTime(queueTimes(w.enqueuedAt, time.Now()))
+	}
+
+	// Sort rows by template suffix -> project -> dataset -> table heirarchy.
+	// Necessary because each InsertAll() request has to be for a single table,
+	// and a single template suffix.
+	//
+	// The source rows of every table are kept as well, in the same order,
+	// for matching rejected rows by their index.
+	//
+	// Rows are spilled as enqueued, i.e. before being transformed,
+	// so they're transformed once again when replayed.
+	suffixes := map[string]projects{}
+	sources := map[string]map[tableKey][]Row{}
+	invalid := map[tableKey]*invalidRows{}
+	var enqueued map[string]map[tableKey][]Row
+	if w.spill != nil {
+		enqueued = map[string]map[tableKey][]Row{}
+	}
+	var (
+		contexts map[string]map[tableKey]*insertContext
+		expired  []Row
+	)
+	rows := w.rows
+	if w.dedupKey != nil {
+		rows = w.dedup(rows)
+	}
+	for _, r := range rows {
+		orig := r
+
+		// Drop rows whose producer's deadline has already passed,
+		// instead of failing the insert of the table's other rows.
+		if deadlineExceeded(orig) {
+			expired = append(expired, orig)
+			continue
+		}
 
-	// Sort rows by project -> dataset -> table heirarchy.
-	// Necessary because each InsertAll() request has to be for a single table.
-	ps := projects{}
-	for _, r := range w.rows {
+		// Set aside rows failing to transform, or not matching their table's
+		// schema, so they aren't sent at all.
+		r, errs := w.validate(r)
+		r.result = orig.result
 		p, d, t := r.ProjectID, r.DatasetID, r.TableID
+		k := tableKey{p, d, t}
+		if len(errs) > 0 {
+			if invalid[k] == nil {
+				invalid[k] = &invalidRows{}
+			}
+			invalid[k].add(r, errs)
+			continue
+		}
 
 		// Create project, dataset and table if uninitalized.
+		ps, ok := suffixes[r.TemplateSuffix]
+		if !ok {
+			ps = projects{}
+			suffixes[r.TemplateSuffix] = ps
+		}
 		initTableIfNotExists(ps, p, d, t)
+		if sources[r.TemplateSuffix] == nil {
+			sources[r.TemplateSuffix] = map[tableKey][]Row{}
+		}
+		sources[r.TemplateSuffix][k] = append(sources[r.TemplateSuffix][k], r)
+		if enqueued != nil {
+			if enqueued[r.TemplateSuffix] == nil {
+				enqueued[r.TemplateSuffix] = map[tableKey][]Row{}
+			}
+			enqueued[r.TemplateSuffix][k] = append(enqueued[r.TemplateSuffix][k], orig)
+		}
+		if orig.ctx != nil {
+			if contexts == nil {
+				contexts = map[string]map[tableKey]*insertContext{}
+			}
+			if contexts[r.TemplateSuffix] == nil {
+				contexts[r.TemplateSuffix] = map[tableKey]*insertContext{}
+			}
+			c := contexts[r.TemplateSuffix][k]
+			if c == nil {
+				c = &insertContext{}
+				contexts[r.TemplateSuffix][k] = c
+			}
+			c.add(orig.ctx)
+		}
 
-		// Append row to table,
-		// and generate random row ID of 16 character length, for de-duplication purposes.
-		ps[p][d][t] = append(ps[p][d][t], &bigquery.TableDataInsertAllRequestRows{
-			InsertId: r.InsertID,
-			Json:     r.Data,
-		})
+		// Append row to table.
+		// The row's insert ID is sent as is for de-duplication purposes,
+		// and omitted from the request if empty.
+		ps[p][d][t] = append(ps[p][d][t], requestRow(r))
 	}
 
 	// Stream insert each table to BigQuery.
 	//
 	// TODO insert concurrently
 	var insertErrs InsertErrors
-	for pID, p := range ps {
-		for dID, d := range p {
-			for tID := range d {
-				tableErrs := insertFunc(pID, dID, tID, d[tID])
-				insertErrs.Tables = append(insertErrs.Tables, tableErrs)
+	var results flushResults
+	if w.flushCallback != nil {
+		results = flushResults{}
+	}
+	for suffix, ps := range suffixes {
+		for pID, p := range ps {
+			for dID, d := range p {
+				for tID := range d {
+					// Insert using the contexts rows have been enqueued with, if any.
+					tableCtx, cancel := contexts[suffix][tableKey{pID, dID, tID}].context(ctx, len(d[tID]))
+					tableErrs := w.insertTableInChunks(tableCtx, insertFunc, pID, dID, tID, suffix, d[tID], sources[suffix][tableKey{pID, dID, tID}])
+					// Rows failing due to their producers' deadlines
+					// aren't abandoned on purpose, so they're spilled.
+					if tableCtx.Err() != nil && ctx.Err() == nil {
+						tableErrs.failed = notInsertedRows(tableErrs)
+					}
+					cancel()
+					insertErrs.Tables = append(insertErrs.Tables, tableErrs)
+					if w.deadLetter != nil {
+						w.deadLetterRows(tableErrs)
+					}
+					// Rows omitted by the pre-flush hook are resolved as inserted.
+					resolveTable(tableErrs)
+					if w.preFlush != nil {
+						resolveRows(sources[suffix][tableKey{pID, dID, tID}], nil)
+					}
+					if w.spill != nil {
+						// Rows returned by the pre-flush hook can't be matched
+						// to enqueued rows, so they're spilled as sent instead.
+						spilled := enqueued[suffix][tableKey{pID, dID, tID}]
+						if w.preFlush != nil {
+							spilled = tableErrs.rows
+						}
+						w.spillRows(pID, dID, tID, tableErrs.failed, spilled)
+					}
+					if results != nil {
+						r := results.get(tableKey{pID, dID, tID})
+						r.inserted += tableErrs.inserted
+						r.rejected += rejectedRows(tableErrs)
+					}
+				}
 			}
 		}
 	}
 
+	w.expiredContexts(expired)
+
+	// Report invalid rows as rejected, without inserting them.
+	for k, rows := range invalid {
+		tableErrs := rows.tableInsertErrors(k)
+		insertErrs.Tables = append(insertErrs.Tables, tableErrs)
+		atomic.AddInt64(&w.counters.rejectedRows, int64(len(rows.rows)))
+		w.stats.RowsRejected(len(rows.rows))
+		w.stats.RowsRejectedByReason(k.projectID, k.datasetID, k.tableID, rejectionReasons(rows.errs))
+		if w.deadLetter != nil {
+			w.deadLetterRows(tableErrs)
+		}
+		resolveTable(tableErrs)
+		if results != nil {
+			results.get(k).rejected += len(rows.rows)
+		}
+	}
+
+	for k, r := range results {
+		w.flushCallback(r.inserted, r.rejected, k.projectID+"."+k.datasetID+"."+k.tableID)
+	}
+
 	return &insertErrs
 }
 
+// flushResult counts a table's rows inserted and rejected
+// by a single insert operation.
+type flushResult struct {
+	inserted, rejected int
+}
+
+// flushResults are the results of all tables of a single insert operation.
+type flushResults map[tableKey]*flushResult
+
+// get returns given table's result, initializing it if necessary.
+func (results flushResults) get(k tableKey) *flushResult {
+	r, ok := results[k]
+	if !ok {
+		r = &flushResult{}
+		results[k] = r
+	}
+	return r
+}
+
+// deadLetterRows calls the dead-letter handler for every row rejected
+// in given table's insert attempts.
+//
+// Row errors are not consumed, and can still be iterated over afterwards.
+func (w *SyncWorker) deadLetterRows(tableErrs *TableInsertErrors) {
+	for _, rowErr := range tableErrs.rowErrors() {
+		w.deadLetter(rowErr.Row, &RowRejectedError{
+			Errors:  rowErr.Errors,
+			Table:   rowErr.attempt.Table,
+			Dataset: rowErr.attempt.Dataset,
+			Project: rowErr.attempt.Project,
+		})
+	}
+}
+
+// spillRows calls the spill handler with given table's rows
+// at given indices, i.e. rows of failed insert operations.
+func (w *SyncWorker) spillRows(projectID, datasetID, tableID string, indices []int, rows []Row) {
+	if len(indices) == 0 {
+		return
+	}
+
+	spilled := make([]Row, 0, len(indices))
+	for _, i := range indices {
+		spilled = append(spilled, rows[i])
+	}
+	if err := w.spill(spilled); err != nil {
+		w.logger.Errorf("bqstreamer: spilling %d rows of %s.%s.%s failed: %v", len(spilled), projectID, datasetID, tableID, err)
+	}
+}
+
+// failedRows returns the indices of given table's rows
+// if its insert operation has failed entirely, i.e. its last attempt failed.
+// Rows are offset by start, the table's position in the entire table.
+//
+// Rows of insert operations interrupted by a done context aren't included,
+// since they're abandoned on purpose.
+func failedRows(ctx context.Context, tableErrs *TableInsertErrors, start, n int) []int {
+	attempts := tableErrs.InsertAttempts
+	if ctx.Err() != nil || len(attempts) == 0 || attempts[len(attempts)-1].err == nil {
+		return nil
+	}
+
+	indices := make([]int, 0, n)
+	for i := start; i < start+n; i++ {
+		indices = append(indices, i)
+	}
+	return indices
+}
+
+// notInsertedRows returns the indices of given table's rows
+// not inserted due to their insert requests failing, in order.
+func notInsertedRows(tableErrs *TableInsertErrors) []int {
+	indices := make([]int, 0, len(tableErrs.notInserted))
+	for i := range tableErrs.notInserted {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// insertedRows returns the amount of given table's n rows which have been
+// inserted, i.e. all rows neither rejected nor likely suppressed duplicates
+// if its last insert attempt succeeded.
+func insertedRows(tableErrs *TableInsertErrors, n int) int {
+	attempts := tableErrs.InsertAttempts
+	if len(attempts) == 0 || attempts[len(attempts)-1].err != nil {
+		return 0
+	}
+	return n - rejectedRows(tableErrs) - tableErrs.suppressed
+}
+
+// rejectedRows returns the amount of given table's rows rejected by
+// its successful insert attempts.
+func rejectedRows(tableErrs *TableInsertErrors) int {
+	n := 0
+	for _, attempt := range tableErrs.InsertAttempts {
+		if attempt.err == nil {
+			n += len(attempt.rows)
+		}
+	}
+	return n
+}
+
+// insertTableInChunks splits given table's rows into chunks not exceeding
+// BigQuery's request limits, and inserts every chunk using insertFunc.
+// rows are the table's source rows, in the same order.
+//
+// Insert attempts of all chunks are merged in a single TableInsertErrors.
+// Row indices in returned errors are relative to the entire table's rows,
+// and not to the chunk they were inserted in.
+func (w *SyncWorker) insertTableInChunks(ctx context.Context, insertFunc insertTableFunc, projectID, datasetID, tableID, templateSuffix string, tbl table, rows []Row) *TableInsertErrors {
+	// Rows are only encoded for measuring their size if the table could exceed
+	// the request size limit, i.e. if the max bytes limit does not already
+	// keep all enqueued rows below it.
+	// Estimated sizes don't, so rows are always measured if a row sizer is set.
+	sizeBytes := w.maxBytes == 0 || w.maxBytes > maxRequestBytes-requestOverheadBytes || w.sizer != nil
+	chunks := splitTable(tbl, w.maxRowsPerRequest, sizeBytes)
+	if w.preFlush != nil {
+		chunks, tbl, rows = w.preFlushChunks(chunks, rows)
+		if len(chunks) == 0 {
+			return &TableInsertErrors{rows: rows}
+		}
+	}
+	if len(chunks) == 1 {
+		tableInsertErrs := w.insertChunk(w.traceContext(ctx, rows), insertFunc, projectID, datasetID, tableID, templateSuffix, tbl, 0)
+		tableInsertErrs.rows = rows
+		return tableInsertErrs
+	}
+
+	insertIDs := tableInsertIDs(tbl)
+	var tableInsertErrs TableInsertErrors
+	start := 0
+	for _, chunk := range chunks {
+		chunkCtx := w.traceContext(ctx, rows[start:start+len(chunk)])
+		chunkInsertErrs := w.insertChunk(chunkCtx, insertFunc, projectID, datasetID, tableID, templateSuffix, chunk, 0)
+		mergeChunk(&tableInsertErrs, chunkInsertErrs, start, insertIDs)
+		start += len(chunk)
+	}
+	tableInsertErrs.rows = rows
+
+	return &tableInsertErrs
+}
+
+// preFlushChunks calls the pre-flush hook with the source rows of every chunk,
+// given in the same order as the table's rows.
+//
+// It returns chunks of the rows returned by the hook instead,
+// omitting chunks left without rows, so their requests are skipped,
+// along with all of their rows in order, as request rows and source rows.
+func (w *SyncWorker) preFlushChunks(chunks []table, rows []Row) ([]table, table, []Row) {
+	var (
+		flushed []table
+		tbl     table
+		sent    []Row
+	)
+	start := 0
+	for _, chunk := range chunks {
+		// Copy the chunk's rows, so the hook can't modify the source rows
+		// of other chunks by appending to them.
+		chunkRows := w.preFlush(append([]Row(nil), rows[start:start+len(chunk)]...))
+		start += len(chunk)
+		if len(chunkRows) == 0 {
+			continue
+		}
+
+		c := make(table, 0, len(chunkRows))
+		for _, r := range chunkRows {
+			c = append(c, requestRow(r))
+		}
+		flushed = append(flushed, c)
+		tbl = append(tbl, c...)
+		sent = append(sent, chunkRows...)
+	}
+	return flushed, tbl, sent
+}
+
+// insertChunk inserts a single chunk of given table's rows using insertFunc.
+//
+// If BigQuery rejects the request as too large, i.e. 413 Payload Too Large,
+// e.g. since row sizes have been underestimated, the chunk is split in halves
+// which are inserted separately, up to maxSplitDepth times.
+// A single row still too large, or a chunk split too many times,
+// is reported as failed with the request's error.
+//
+// Row indices in returned errors are relative to the chunk's rows,
+// and split requests aren't included in its insert attempts.
+func (w *SyncWorker) insertChunk(ctx context.Context, insertFunc insertTableFunc, projectID, datasetID, tableID, templateSuffix string, tbl table, depth int) *TableInsertErrors {
+	tableInsertErrs := insertFunc(ctx, projectID, datasetID, tableID, templateSuffix, tbl)
+
+	attempts := tableInsertErrs.InsertAttempts
+	if len(tbl) > 1 && depth < maxSplitDepth && ctx.Err() == nil &&
+		len(attempts) > 0 && isPayloadTooLarge(attempts[len(attempts)-1].err) {
+		w.logger.Warnf("bqstreamer: splitting insert of %d rows to %s.%s.%s, payload too large", len(tbl), projectID, datasetID, tableID)
+
+		insertIDs := tableInsertIDs(tbl)
+		split := TableInsertErrors{InsertAttempts: attempts[:len(attempts)-1]}
+		half := len(tbl) / 2
+		mergeChunk(&split, w.insertChunk(ctx, insertFunc, projectID, datasetID, tableID, templateSuffix, tbl[:half], depth+1), 0, insertIDs)
+		mergeChunk(&split, w.insertChunk(ctx, insertFunc, projectID, datasetID, tableID, templateSuffix, tbl[half:], depth+1), half, insertIDs)
+		return &split
+	}
+
+	tableInsertErrs.failed = failedRows(ctx, tableInsertErrs, 0, len(tbl))
+	tableInsertErrs.inserted = insertedRows(tableInsertErrs, len(tbl))
+	if n := len(attempts); n > 0 && attempts[n-1].err != nil {
+		tableInsertErrs.notInserted = make(map[int]error, len(tbl))
+		for i := range tbl {
+			tableInsertErrs.notInserted[i] = attempts[n-1].err
+		}
+	}
+	return tableInsertErrs
+}
+
+// mergeChunk appends the insert attempts of a chunk of a table's rows,
+// starting at given index, to the table's insert attempts.
+//
+// Row indices are offset by the chunk's position in the table,
+// and rows are identified by the table's insertIDs.
+func mergeChunk(tableInsertErrs, chunkInsertErrs *TableInsertErrors, start int, insertIDs []string) {
+	for _, attempt := range chunkInsertErrs.InsertAttempts {
+		for _, row := range attempt.rows {
+			row.Index += int64(start)
+		}
+		if attempt.insertIDs != nil {
+			attempt.insertIDs = insertIDs
+		}
+	}
+	tableInsertErrs.InsertAttempts = append(tableInsertErrs.InsertAttempts, chunkInsertErrs.InsertAttempts...)
+	for _, i := range chunkInsertErrs.failed {
+		tableInsertErrs.failed = append(tableInsertErrs.failed, start+i)
+	}
+	for i, err := range chunkInsertErrs.notInserted {
+		if tableInsertErrs.notInserted == nil {
+			tableInsertErrs.notInserted = map[int]error{}
+		}
+		tableInsertErrs.notInserted[start+i] = err
+	}
+	tableInsertErrs.inserted += chunkInsertErrs.inserted
+	tableInsertErrs.suppressed += chunkInsertErrs.suppressed
+}
+
+// tableInsertIDs returns the insert IDs of given table's rows, in order.
+func tableInsertIDs(tbl table) []string {
+	insertIDs := make([]string, 0, len(tbl))
+	for _, row := range tbl {
+		insertIDs = append(insertIDs, row.InsertId)
+	}
+	return insertIDs
+}
+
+// isPayloadTooLarge returns true if given error is BigQuery rejecting
+// an insert request as too large.
+func isPayloadTooLarge(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusRequestEntityTooLarge
+}
+
+// splitTable splits given table's rows into chunks,
+// each not exceeding maxRows and BigQuery's max size per request.
+// The size limit is only checked if sizeBytes is true.
+//
+// A single row exceeding the size limit is put in a chunk of its own.
+func splitTable(tbl table, maxRows int, sizeBytes bool) []table {
+	if len(tbl) <= 1 || (!sizeBytes && len(tbl) <= maxRows) {
+		return []table{tbl}
+	}
+
+	var chunks []table
+	start, size := 0, requestOverheadBytes
+	for i, row := range tbl {
+		rowSize := 0
+		if sizeBytes {
+			rowSize = encodedRowSize(row)
+		}
+
+		if i > start && (i-start >= maxRows || size+rowSize > maxRequestBytes) {
+			chunks = append(chunks, tbl[start:i])
+			start, size = i, requestOverheadBytes
+		}
+		size += rowSize
+	}
+	return append(chunks, tbl[start:])
+}
+
 // insertTable inserts a single table to BigQuery using InsertAll().
 //
 // It returns tableErrors contains information about rows that were not inserted.
 //
 // TODO cache bigquery service instead of creating a new one every insertTable() call
 // TODO add support for SkipInvalidRows, IgnoreUnknownValues
-func (w *SyncWorker) insertTable(projectID, datasetID, tableID string, tbl table) *TableInsertErrors {
-	res, err := bigquery.NewTabledataService(w.service).
-		InsertAll(
-			projectID, datasetID, tableID,
-			&bigquery.TableDataInsertAllRequest{
-				Kind:                "bigquery#tableDataInsertAllRequest",
-				Rows:                tbl,
-				IgnoreUnknownValues: w.ignoreUnknownValues,
-				SkipInvalidRows:     w.skipInvalidRows,
-			}).
-		Do()
-
-	var rows []*bigquery.TableDataInsertAllResponseInsertErrors
+func (w *SyncWorker) insertTable(ctx context.Context, projectID, datasetID, tableID, templateSuffix string, tbl table) *TableInsertErrors {
+	return w.insertTableAttempt(ctx, 0, false, projectID, datasetID, tableID, templateSuffix, tbl)
+}
+
+// insertTableAttempt is similar to insertTable,
+// but also receives the attempt number, starting at zero, for tracing.
+//
+// If retrying a timed out request, rows inserted which have an insert ID
+// are counted as likely suppressed duplicates instead of inserted rows.
+// See Stats.DedupSuppressed.
+func (w *SyncWorker) insertTableAttempt(ctx context.Context, attempt int, afterTimeout bool, projectID, datasetID, tableID, templateSuffix string, tbl table) *TableInsertErrors {
+	// Don't send the request at all if the circuit is open.
+	if w.breaker != nil && !w.breaker.allow() {
+		return &TableInsertErrors{
+			InsertAttempts: []*TableInsertAttemptErrors{
+				&TableInsertAttemptErrors{
+					err:            ErrCircuitOpen,
+					Table:          tableID,
+					Dataset:        datasetID,
+					Project:        projectID,
+					TemplateSuffix: templateSuffix,
+				},
+			},
+		}
+	}
+
+	// Wait for an insert request slot if concurrent inserts are bounded,
+	// and for enough in-flight bytes if bounded as well.
+	// This is done before tracing the request,
+	// so waiting for a slot isn't counted as request latency.
+	var err error
+	if w.insertSem != nil {
+		select {
+		case w.insertSem <- struct{}{}:
+			defer func() { <-w.insertSem }()
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if w.inFlight != nil && err == nil {
+		n := requestBytes(tbl)
+		if err = w.inFlight.acquire(ctx, n); err == nil {
+			defer w.inFlight.release(n)
+		}
+	}
+	if err != nil {
+		if w.breaker != nil {
+			w.breaker.release()
+		}
+		return &TableInsertErrors{
+			InsertAttempts: []*TableInsertAttemptErrors{
+				&TableInsertAttemptErrors{
+					err:            err,
+					Table:          tableID,
+					Dataset:        datasetID,
+					Project:        projectID,
+					TemplateSuffix: templateSuffix,
+				},
+			},
+		}
+	}
+
+	var finish func(InsertResult)
+	if w.tracer != nil {
+		ctx, finish = w.tracer.StartInsert(ctx, InsertInfo{
+			Project:        projectID,
+			Dataset:        datasetID,
+			Table:          tableID,
+			TemplateSuffix: templateSuffix,
+			Rows:           len(tbl),
+			Attempt:        attempt,
+			TraceID:        traceID(ctx),
+		})
+	}
+
+	// Bound the request's round trip if set,
+	// separately for every attempt.
+	reqCtx := ctx
+	if w.insertTimeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, w.insertTimeout)
+		defer cancel()
+	}
+
+	atomic.AddInt64(&w.counters.inFlightInserts, 1)
+	start := time.Now()
+	opts := w.tableOptions(projectID, datasetID, tableID)
+	req := &bigquery.TableDataInsertAllRequest{
+		Kind:                "bigquery#tableDataInsertAllRequest",
+		Rows:                tbl,
+		IgnoreUnknownValues: opts.IgnoreUnknownValues,
+		SkipInvalidRows:     opts.SkipInvalidRows,
+		TemplateSuffix:      templateSuffix,
+		TraceId:             traceID(ctx),
+	}
+	var res *bigquery.TableDataInsertAllResponse
+	if hasRawRows(tbl) {
+		res, err = w.insertAllRaw(reqCtx, projectID, datasetID, tableID, req)
+	} else {
+		res, err = bigquery.NewTabledataService(w.service).
+			InsertAll(projectID, datasetID, tableID, req).
+			Context(reqCtx).
+			Do()
+	}
+
+	// Report a timed out request as such, so it's retried,
+	// unless the insert operation's context is done as well.
+	if err != nil && ctx.Err() == nil && reqCtx.Err() == context.DeadlineExceeded {
+		err = &InsertTimeoutError{Timeout: w.insertTimeout}
+	}
+	took := time.Since(start)
+	w.latency.observe(took)
+	w.stats.InsertAttempt(len(tbl), took, err)
+	atomic.AddInt64(&w.counters.inFlightInserts, -1)
+
+	// Only transient errors count as failures, since other errors mean
+	// BigQuery is reachable. A done context says nothing about BigQuery.
+	if w.breaker != nil {
+		switch {
+		case ctx.Err() != nil:
+			w.breaker.release()
+		case err != nil && w.shouldRetryInsert(projectID, datasetID, tableID, err):
+			w.breaker.failure()
+		default:
+			w.breaker.success()
+		}
+	}
+	if w.retryBudget != nil && err == nil {
+		w.retryBudget.deposit()
+	}
+	if w.health != nil && ctx.Err() == nil {
+		w.health.observe(err != nil && w.shouldRetryInsert(projectID, datasetID, tableID, err))
+	}
+
+	var (
+		rows       []*bigquery.TableDataInsertAllResponseInsertErrors
+		header     http.Header
+		apiErr     *googleapi.Error
+		suppressed int
+	)
 	if res != nil {
 		rows = res.InsertErrors
+		header = responseHeader(res.Header)
+	} else if errors.As(err, &apiErr) {
+		header = responseHeader(apiErr.Header)
 	}
 
-	insertIDs := make([]string, 0, len(tbl))
-	for _, row := range tbl {
-		insertIDs = append(insertIDs, row.InsertId)
+	if finish != nil {
+		finish(InsertResult{Err: err, RejectedRows: len(rows)})
+	}
+
+	// Rows were either inserted or rejected if the request itself succeeded.
+	// Rejected rows aren't counted as such if they're to be retried,
+	// see insertTableWithRetry().
+	if err == nil {
+		rejected := rows
+		refresh := w.shouldRefreshSchema(projectID, datasetID, tableID, rows)
+		if w.retryStopped || w.retryTransientRows || refresh {
+			rejected = nil
+			for _, row := range rows {
+				if !refresh && !w.isRetryableRow(row) {
+					rejected = append(rejected, row)
+				}
+			}
+		}
+		if len(rejected) > 0 {
+			atomic.AddInt64(&w.counters.rejectedRows, int64(len(rejected)))
+			w.stats.RowsRejected(len(rejected))
+			w.stats.RowsRejectedByReason(projectID, datasetID, tableID, rejectionReasons(rejected))
+		}
+		if len(tbl) > len(rows) {
+			inserted, bytes := len(tbl)-len(rows), 0
+			if w.tableStats != nil {
+				bytes = insertedBytes(tbl, rows)
+			}
+			if afterTimeout {
+				var size int
+				suppressed, size = suppressedRows(tbl, rows)
+				atomic.AddInt64(&w.counters.dedupSuppressed, int64(suppressed))
+				inserted -= suppressed
+				bytes -= size
+			}
+			if inserted > 0 {
+				atomic.AddInt64(&w.counters.insertedRows, int64(inserted))
+				w.stats.RowsInserted(inserted)
+				if w.tableStats != nil {
+					w.tableStats.record(projectID+"."+datasetID+"."+tableID+templateSuffix, inserted, bytes)
+				}
+			}
+		}
 	}
 
+	insertIDs := tableInsertIDs(tbl)
+
 	// Return response as a single table insert attempt.
 	return &TableInsertErrors{
 		InsertAttempts: []*TableInsertAttemptErrors{
 			&TableInsertAttemptErrors{
-				err:       err,
-				rows:      rows,
-				insertIDs: insertIDs,
-				Table:     tableID,
-				Dataset:   datasetID,
-				Project:   projectID,
+				err:            err,
+				rows:           rows,
+				insertIDs:      insertIDs,
+				header:         header,
+				Table:          tableID,
+				Dataset:        datasetID,
+				Project:        projectID,
+				TemplateSuffix: templateSuffix,
 			},
 		},
+		suppressed: suppressed,
+	}
+}
+
+// validate transforms given row if a row transform has been set,
+// and validates it against its table's schema if set.
+//
+// It returns the transformed row, or the row as enqueued if it failed
+// to transform, along with errors if it is invalid,
+// in the same format BigQuery uses for rejected rows.
+func (w *SyncWorker) validate(r Row) (Row, []*bigquery.ErrorProto) {
+	if w.transform != nil {
+		transformed, err := w.transform(r)
+		if err != nil {
+			return r, []*bigquery.ErrorProto{{Reason: "invalid", Message: err.Error()}}
+		}
+		r = transformed
+	}
+
+	schema, ok := w.tableSchema(tableKey{r.ProjectID, r.DatasetID, r.TableID})
+	if !ok {
+		return r, nil
+	}
+	data, err := rowValues(r)
+	if err != nil {
+		return r, []*bigquery.ErrorProto{{Reason: "invalid", Message: err.Error()}}
+	}
+	return r, validateRow(schema, data, w.tableOptions(r.ProjectID, r.DatasetID, r.TableID).IgnoreUnknownValues)
+}
+
+// tableSchema returns the schema of given table set using SetSyncSchema(),
+// falling back to the schema of the partitioned table
+// if given a partition decorator.
+func (w *SyncWorker) tableSchema(k tableKey) (*bigquery.TableSchema, bool) {
+	if schema, ok := w.schemas[k]; ok {
+		return schema, true
+	}
+	schema, ok := w.schemas[tableKey{k.projectID, k.datasetID, baseTableID(k.tableID)}]
+	return schema, ok
+}
+
+// tableOptions returns the insert request options of given table,
+// as set using SetSyncTableOptions(), or the worker's defaults otherwise,
+// falling back to the partitioned table's options the same as tableSchema().
+func (w *SyncWorker) tableOptions(projectID, datasetID, tableID string) TableOptions {
+	if opts, ok := w.tableOpts[tableKey{projectID, datasetID, tableID}]; ok {
+		return opts
+	}
+	if opts, ok := w.tableOpts[tableKey{projectID, datasetID, baseTableID(tableID)}]; ok {
+		return opts
+	}
+	return TableOptions{
+		IgnoreUnknownValues: w.ignoreUnknownValues,
+		SkipInvalidRows:     w.skipInvalidRows,
 	}
 }
 
+// allowRetry returns true if a failed insert request may be retried
+// according to the retry budget, if set.
+func (w *SyncWorker) allowRetry() bool {
+	return w.retryBudget == nil || w.retryBudget.withdraw()
+}
+
 // insertTableWithRetry is similar to insertTable,
 // but also retries insert operations on certain conditions.
-func (w *SyncWorker) insertTableWithRetry(projectID, datasetID, tableID string, tbl table) *TableInsertErrors {
+func (w *SyncWorker) insertTableWithRetry(ctx context.Context, projectID, datasetID, tableID, templateSuffix string, tbl table) *TableInsertErrors {
 	var tableInsertErrs TableInsertErrors
 
+	// Only some of the table's rows are inserted when retrying rejected rows.
+	// indices maps them to their index in the entire table if set.
+	all := tbl
+	var indices []int
+
+	start := w.clock.Now()
 	numRetries := 0
+	timedOut := false
 	for {
 		// Push this table's insert attempt as an additional one
 		// in insert attempts slice.
-		currTableInsertErrs := w.insertTable(projectID, datasetID, tableID, tbl)
+		// Rows inserted after a timed out request may have been inserted by it.
+		currTableInsertErrs := w.insertTableAttempt(ctx, numRetries, timedOut, projectID, datasetID, tableID, templateSuffix, tbl)
 		currInsertAttempt := currTableInsertErrs.InsertAttempts[0]
 		tableInsertErrs.InsertAttempts = append(tableInsertErrs.InsertAttempts, currInsertAttempt)
+		tableInsertErrs.suppressed += currTableInsertErrs.suppressed
+		if currInsertAttempt.err == nil {
+			timedOut = false
+		}
+		if indices != nil {
+			for _, row := range currInsertAttempt.rows {
+				row.Index = int64(indices[row.Index])
+			}
+			currInsertAttempt.insertIDs = tableInsertErrs.InsertAttempts[0].insertIDs
+		}
 
 		// Retry on certain HTTP responses.
-		if w.shouldRetryInsert(currInsertAttempt.err) {
+		if w.shouldRetryInsert(projectID, datasetID, tableID, currInsertAttempt.err) {
+			timedOut = timedOut || isTimeout(currInsertAttempt.err)
+
+			// Abort if the context is done, since retrying would fail anyways.
+			if err := ctx.Err(); err != nil {
+				tableInsertErrs.InsertAttempts = append(tableInsertErrs.InsertAttempts, &TableInsertAttemptErrors{
+					err:            err,
+					Table:          tableID,
+					Dataset:        datasetID,
+					Project:        projectID,
+					TemplateSuffix: templateSuffix,
+				})
+				return &tableInsertErrs
+			}
+
 			// Abort if retries and failed too many times.
 			if numRetries >= w.maxRetries {
+				w.logger.Errorf("bqstreamer: giving up insert of %d rows to %s.%s.%s after %d retries: %v", len(tbl), projectID, datasetID, tableID, numRetries, currInsertAttempt.err)
 				err := &TooManyFailedInsertRetriesError{
 					NumFailedRetries: numRetries,
 					Project:          projectID,
@@ -207,47 +1228,353 @@ func (w *SyncWorker) insertTableWithRetry(projectID, datasetID, tableID string,
 					Table:            tableID,
 				}
 				tableInsertErrs.InsertAttempts = append(tableInsertErrs.InsertAttempts, &TableInsertAttemptErrors{err: err})
+				atomic.AddInt64(&w.counters.failedInserts, 1)
+				return &tableInsertErrs
+			}
+			// Abort if retrying would exceed the retry deadline.
+			wait := w.retryWait(numRetries, currInsertAttempt.err)
+			if w.exceedsRetryDeadline(start, wait) {
+				w.logger.Errorf("bqstreamer: giving up insert of %d rows to %s.%s.%s, retry deadline exceeded: %v", len(tbl), projectID, datasetID, tableID, currInsertAttempt.err)
+				tableInsertErrs.InsertAttempts = append(tableInsertErrs.InsertAttempts, &TableInsertAttemptErrors{
+					err:            ErrRetryDeadlineExceeded,
+					Table:          tableID,
+					Dataset:        datasetID,
+					Project:        projectID,
+					TemplateSuffix: templateSuffix,
+				})
+				atomic.AddInt64(&w.counters.failedInserts, 1)
 				return &tableInsertErrs
 			}
+			// Abort if retrying would exceed the retry budget.
+			if !w.allowRetry() {
+				w.logger.Errorf("bqstreamer: giving up insert of %d rows to %s.%s.%s, retry budget exhausted: %v", len(tbl), projectID, datasetID, tableID, currInsertAttempt.err)
+				tableInsertErrs.InsertAttempts = append(tableInsertErrs.InsertAttempts, &TableInsertAttemptErrors{
+					err:            ErrRetryBudgetExhausted,
+					Table:          tableID,
+					Dataset:        datasetID,
+					Project:        projectID,
+					TemplateSuffix: templateSuffix,
+				})
+				atomic.AddInt64(&w.counters.failedInserts, 1)
+				return &tableInsertErrs
+			}
+			atomic.AddInt64(&w.counters.retriedInserts, 1)
+			w.stats.InsertRetried(len(tbl))
+			w.logger.Warnf("bqstreamer: retrying insert of %d rows to %s.%s.%s (retry %d/%d): %v", len(tbl), projectID, datasetID, tableID, numRetries+1, w.maxRetries, currInsertAttempt.err)
+			if w.retryCallback != nil {
+				w.retryCallback(numRetries+1, currInsertAttempt.err, wait)
+			}
+
+			// Sleep as a backoff mechanism,
+			// and abort if the context is done in the meantime.
+			if err := sleepContext(ctx, w.clock, wait); err != nil {
+				tableInsertErrs.InsertAttempts = append(tableInsertErrs.InsertAttempts, &TableInsertAttemptErrors{
+					err:            err,
+					Table:          tableID,
+					Dataset:        datasetID,
+					Project:        projectID,
+					TemplateSuffix: templateSuffix,
+				})
+				return &tableInsertErrs
+			}
+			numRetries++
+			continue
+		}
+		// Retry all rejected rows once after refreshing the table's schema,
+		// if rejected due to an unknown field, e.g. a newly added column.
+		if currInsertAttempt.err == nil && w.shouldRefreshSchema(projectID, datasetID, tableID, currInsertAttempt.rows) {
+			w.refreshedSchemas[tableKey{projectID, datasetID, tableID}] = true
+			name := projectID + "." + datasetID + "." + tableID
+			if err := w.schemaRefresh(name); err != nil {
+				// Report rejected rows as such after all.
+				w.logger.Errorf("bqstreamer: refreshing schema of %s failed: %v", name, err)
+				atomic.AddInt64(&w.counters.rejectedRows, int64(len(currInsertAttempt.rows)))
+				w.stats.RowsRejected(len(currInsertAttempt.rows))
+				w.stats.RowsRejectedByReason(projectID, datasetID, tableID, rejectionReasons(currInsertAttempt.rows))
+				break
+			}
+
+			retry := make([]int, 0, len(currInsertAttempt.rows))
+			for _, row := range currInsertAttempt.rows {
+				retry = append(retry, int(row.Index))
+			}
+			currInsertAttempt.rows = nil
+			indices = retry
+			tbl = make(table, 0, len(retry))
+			for _, i := range retry {
+				tbl = append(tbl, all[i])
+			}
+			w.logger.Warnf("bqstreamer: retrying insert of %d rejected rows to %s after refreshing its schema", len(tbl), name)
 			numRetries++
-			// Sleep as a backoff mechanism.
-			// TODO add exponential backoff
-			time.Sleep(w.retryInterval)
 			continue
 		}
+
+		// Retry retryable rejected rows only, without the rows already inserted
+		// and the invalid rows, e.g. ones that stopped the others.
+		// Retried rows keep their insert IDs, guarding against duplicates.
+		if currInsertAttempt.err == nil && (w.retryStopped || w.retryTransientRows) {
+			var retryable []int
+			var retryableRows, rejected []*bigquery.TableDataInsertAllResponseInsertErrors
+			transient := false
+			for _, row := range currInsertAttempt.rows {
+				if w.isRetryableRow(row) {
+					retryable = append(retryable, int(row.Index))
+					retryableRows = append(retryableRows, row)
+					transient = transient || !isStopped(row)
+				} else {
+					rejected = append(rejected, row)
+				}
+			}
+			if len(retryable) > 0 {
+				// Stopped rows are retried immediately, but rows rejected
+				// due to transient errors are retried after the retry delay,
+				// same as failed requests, or after the delay requested by
+				// a Retry-After header if longer.
+				var delay time.Duration
+				if transient {
+					delay = w.retryDelay(numRetries)
+					if ra, ok := retryAfterHeader(currInsertAttempt.header, w.clock.Now()); ok {
+						delay = maxRetryAfter(delay, ra)
+					}
+				}
+				giveUp := numRetries >= w.maxRetries || w.exceedsRetryDeadline(start, delay) || !w.allowRetry()
+				if !giveUp && w.retryCallback != nil {
+					w.retryCallback(numRetries+1, &RowRejectedError{
+						Errors:  retryableRows[0].Errors,
+						Table:   tableID,
+						Dataset: datasetID,
+						Project: projectID,
+					}, delay)
+				}
+				if !giveUp && transient {
+					giveUp = sleepContext(ctx, w.clock, delay) != nil
+				}
+				if giveUp {
+					// Report retryable rows as rejected after all.
+					w.logger.Errorf("bqstreamer: giving up insert of %d rejected rows to %s.%s.%s after %d retries", len(retryable), projectID, datasetID, tableID, numRetries)
+					atomic.AddInt64(&w.counters.rejectedRows, int64(len(retryable)))
+					w.stats.RowsRejected(len(retryable))
+					w.stats.RowsRejectedByReason(projectID, datasetID, tableID, rejectionReasons(retryableRows))
+					break
+				}
+
+				currInsertAttempt.rows = rejected
+				indices = retryable
+				tbl = make(table, 0, len(retryable))
+				for _, i := range retryable {
+					tbl = append(tbl, all[i])
+				}
+				atomic.AddInt64(&w.counters.retriedInserts, 1)
+				w.stats.InsertRetried(len(tbl))
+				w.logger.Warnf("bqstreamer: retrying insert of %d rejected rows to %s.%s.%s (retry %d/%d)", len(tbl), projectID, datasetID, tableID, numRetries+1, w.maxRetries)
+				numRetries++
+				continue
+			}
+		}
+
 		// If we reached here, it means the insert operation was successful.
 		// Thus, it is not required to retry the insert operation.
 		//
 		// Return all accumulated errors.
+		if currInsertAttempt.err == nil {
+			// No longer rate limited.
+			w.rateLimitDelay = 0
+		} else {
+			atomic.AddInt64(&w.counters.failedInserts, 1)
+		}
 		break
 	}
 
 	return &tableInsertErrs
 }
 
-// shouldRetryInsert checks for given insert HTTP response error,
-// and returns true if the insert should be retried.
-//
-// There are various cases where a retry is or is not necessary.
-// See the following article for more info:
-// https://cloud.google.com/bigquery/troubleshooting-errors
-//
-func (w *SyncWorker) shouldRetryInsert(err error) bool {
-	if err != nil {
-		switch err := err.(type) {
-		case *googleapi.Error:
-			// Retry on GoogleAPI HTTP server errors 500, 503.
-			if err.Code == 500 || err.Code == 503 {
+// suppressedRows returns the amount of given table's rows inserted by a retry
+// of a timed out request, which are likely suppressed duplicates
+// since they have an insert ID, along with their size in bytes
+// as encoded in the insert request. See Stats.DedupSuppressed.
+func suppressedRows(tbl table, rejected []*bigquery.TableDataInsertAllResponseInsertErrors) (int, int) {
+	isRejected := make(map[int64]bool, len(rejected))
+	for _, row := range rejected {
+		isRejected[row.Index] = true
+	}
+
+	n, size := 0, 0
+	for i, row := range tbl {
+		if row.InsertId != "" && !isRejected[int64(i)] {
+			n++
+			size += encodedRowSize(row)
+		}
+	}
+	return n, size
+}
+
+// isTimeout returns true if given insert request error is a timeout,
+// either the one set using SetSyncInsertTimeout() or the http.Client's,
+// such that the request may have succeeded regardless.
+func isTimeout(err error) bool {
+	var timeoutErr *InsertTimeoutError
+	if errors.As(err, &timeoutErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// exceedsRetryDeadline returns true if retrying an insert operation started
+// at given time after given delay would exceed the retry deadline, if set.
+func (w *SyncWorker) exceedsRetryDeadline(start time.Time, delay time.Duration) bool {
+	return w.retryDeadline > 0 && w.clock.Now().Sub(start)+delay > w.retryDeadline
+}
+
+// transientRowReasons are reasons of row errors which are likely to succeed
+// if the row is retried, as opposed to e.g. "invalid" rows.
+var transientRowReasons = map[string]bool{
+	"backendError":  true,
+	"internalError": true,
+	"timeout":       true,
+}
+
+// isRetryableRow returns true if given rejected row is to be retried,
+// i.e. if all of its errors are either "stopped" errors and stopped rows are
+// retried, or transient errors and rows rejected due to them are retried.
+func (w *SyncWorker) isRetryableRow(row *bigquery.TableDataInsertAllResponseInsertErrors) bool {
+	for _, err := range row.Errors {
+		switch {
+		case err.Reason == "stopped" && w.retryStopped:
+		case transientRowReasons[err.Reason] && w.retryTransientRows:
+		default:
+			return false
+		}
+	}
+	return len(row.Errors) > 0
+}
+
+// shouldRefreshSchema returns true if given table's schema is to be refreshed
+// due to given rejected rows, see SetSyncSchemaRefresh(), i.e. if any of them
+// has been rejected due to an unknown field, and the schema hasn't been
+// refreshed during the current insert operation yet.
+func (w *SyncWorker) shouldRefreshSchema(projectID, datasetID, tableID string, rows []*bigquery.TableDataInsertAllResponseInsertErrors) bool {
+	if w.refreshedSchemas == nil || w.refreshedSchemas[tableKey{projectID, datasetID, tableID}] {
+		return false
+	}
+	for _, row := range rows {
+		for _, err := range row.Errors {
+			if err.Reason == "invalid" && strings.Contains(strings.ToLower(err.Message), "no such field") {
 				return true
 			}
-		case net.Error:
-			// Retry on a network error.
-			return true
 		}
 	}
 	return false
 }
 
+// isStopped returns true if given rejected row was only rejected
+// due to other invalid rows in the same request.
+func isStopped(row *bigquery.TableDataInsertAllResponseInsertErrors) bool {
+	for _, err := range row.Errors {
+		if err.Reason != "stopped" {
+			return false
+		}
+	}
+	return len(row.Errors) > 0
+}
+
+// retryDelay returns the time to sleep before given retry attempt,
+// where zero is the first retry.
+//
+// If exponential backoff is set, a random delay between zero and
+// min(initial * multiplier^attempt, max) is returned ("full jitter").
+// Since attempts are counted per insert operation,
+// the backoff resets to its initial value on every new insert.
+// Otherwise the flat retry interval is returned.
+func (w *SyncWorker) retryDelay(attempt int) time.Duration {
+	if w.backoffInitial <= 0 {
+		return w.retryInterval
+	}
+
+	// Calculate in float space and compare before converting,
+	// since the backoff may overflow an int64 after enough attempts.
+	max := int64(w.backoffMax)
+	if d := float64(w.backoffInitial) * math.Pow(w.backoffMultiplier, float64(attempt)); d < float64(max) {
+		max = int64(d)
+	}
+	if max == math.MaxInt64 {
+		return time.Duration(rand.Int63())
+	}
+
+	return time.Duration(rand.Int63n(max + 1))
+}
+
+// retryWait returns the time to wait before given retry attempt,
+// of an insert that failed with given error.
+//
+// It is the retry delay, unless BigQuery is rate limiting inserts:
+// Then the wait slows down adaptively, doubling on every consecutive
+// rate limited insert up to MaxSyncRetryAfter.
+// The wait is also at least as long as requested by a Retry-After header,
+// capped at MaxSyncRetryAfter as well.
+func (w *SyncWorker) retryWait(attempt int, err error) time.Duration {
+	d := w.retryDelay(attempt)
+
+	if isRateLimited(err) {
+		if w.rateLimitDelay == 0 {
+			w.rateLimitDelay = w.retryInterval
+			if w.backoffInitial > 0 {
+				w.rateLimitDelay = w.backoffInitial
+			}
+		} else {
+			w.rateLimitDelay *= 2
+		}
+		if w.rateLimitDelay > MaxSyncRetryAfter {
+			w.rateLimitDelay = MaxSyncRetryAfter
+		}
+		if w.rateLimitDelay > d {
+			d = w.rateLimitDelay
+		}
+	}
+
+	if ra, ok := retryAfter(err, w.clock.Now()); ok {
+		d = maxRetryAfter(d, ra)
+	}
+
+	return d
+}
+
+// maxRetryAfter returns the longer of given client computed delay,
+// e.g. the retry backoff, and given delay requested by a Retry-After header,
+// so inserts aren't retried sooner than requested by BigQuery.
+// The requested delay is capped at MaxSyncRetryAfter though,
+// so inserts are retried sooner on purpose if a longer one is requested.
+func maxRetryAfter(d, ra time.Duration) time.Duration {
+	if ra > MaxSyncRetryAfter {
+		ra = MaxSyncRetryAfter
+	}
+	if ra > d {
+		return ra
+	}
+	return d
+}
+
+// shouldRetryInsert checks for given insert HTTP response error
+// of given table, and returns true if the insert should be retried.
+//
+// It uses IsRetryable() unless overridden using SetSyncRetryableFunc(),
+// or for given table using SetSyncTableRetryable().
+func (w *SyncWorker) shouldRetryInsert(projectID, datasetID, tableID string, err error) bool {
+	if err == nil {
+		return false
+	}
+	if f, ok := w.tableRetryable[tableKey{projectID, datasetID, tableID}]; ok {
+		return f(err)
+	}
+	if f, ok := w.tableRetryable[tableKey{projectID, datasetID, baseTableID(tableID)}]; ok {
+		return f(err)
+	}
+	if w.retryable != nil {
+		return w.retryable(err)
+	}
+	return IsRetryable(err)
+}
+
 // initTableIfNotExists initializes given project, dataset, and table
 // in project map if not yet initialized.
 func initTableIfNotExists(ps map[string]project, p, d, t string) {
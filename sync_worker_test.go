@@ -2,19 +2,24 @@ package bqstreamer
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"reflect"
 	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"golang.org/x/oauth2"
 	bigquery "google.golang.org/api/bigquery/v2"
 	"google.golang.org/api/googleapi"
 
@@ -446,6 +451,363 @@ func TestSyncIgnoreUnknownValues(t *testing.T) {
 		ps)
 }
 
+// TestSyncWorkerTableOptions tests insert requests use their table's options
+// if set, and the worker's options otherwise.
+func TestSyncWorkerTableOptions(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	reqs := map[string]bigquery.TableDataInsertAllRequest{}
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+			_, _, tableID := getInsertMetadata(req.URL.Path)
+			reqs[tableID] = tableReq
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncIgnoreUnknownValues(true), SetSyncTableOptions("p", "d", "t1", TableOptions{SkipInvalidRows: true}))
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t0", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t1", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	for _, table := range w.Insert().All() {
+		require.NoError(table.Attempts()[0].Error())
+	}
+
+	require.Len(reqs, 2)
+	assert.True(reqs["t0"].IgnoreUnknownValues)
+	assert.False(reqs["t0"].SkipInvalidRows)
+	assert.False(reqs["t1"].IgnoreUnknownValues)
+	assert.True(reqs["t1"].SkipInvalidRows)
+}
+
+// TestSyncWorkerRetryDelay tests the delay between insert retries
+// is either a flat interval, or an exponential backoff with jitter.
+func TestSyncWorkerRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Flat retry interval is used by default.
+	w, err := NewSyncWorker(&http.Client{}, SetSyncRetryInterval(3*time.Second))
+	require.NoError(err)
+	for attempt := 0; attempt < 5; attempt++ {
+		assert.Equal(3*time.Second, w.retryDelay(attempt))
+	}
+
+	// Exponential backoff is capped by max, and jittered between zero and the
+	// calculated backoff.
+	w, err = NewSyncWorker(&http.Client{}, SetSyncRetryBackoff(100*time.Millisecond, 1*time.Second, 2))
+	require.NoError(err)
+	for attempt, max := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		1 * time.Second,
+		1 * time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			d := w.retryDelay(attempt)
+			assert.True(d >= 0 && d <= max, "attempt %d: %s", attempt, d)
+		}
+	}
+
+	// Test a backoff overflowing an int64 is capped without panicking.
+	w, err = NewSyncWorker(&http.Client{}, SetSyncRetryBackoff(1*time.Second, time.Duration(math.MaxInt64), 2))
+	require.NoError(err)
+	for _, attempt := range []int{10, 62, 63, 100, 10000} {
+		assert.True(w.retryDelay(attempt) >= 0)
+	}
+}
+
+// statsRecorder is a StatsHandler recording all events.
+type statsRecorder struct {
+	NopStatsHandler
+
+	enqueued, retried, inserted, rejected int
+	attempts                              []error
+	queueTimes                            []LatencyHistogram
+
+	// Rejection reasons per table.
+	reasons map[string]map[string]int
+}
+
+func (s *statsRecorder) RowsEnqueued(n int) { s.enqueued += n }
+func (s *statsRecorder) InsertAttempt(n int, d time.Duration, err error) {
+	s.attempts = append(s.attempts, err)
+}
+func (s *statsRecorder) InsertRetried(n int) { s.retried += n }
+func (s *statsRecorder) RowsInserted(n int)  { s.inserted += n }
+func (s *statsRecorder) RowsRejected(n int)  { s.rejected += n }
+func (s *statsRecorder) RowsQueueTime(h LatencyHistogram) {
+	s.queueTimes = append(s.queueTimes, h)
+}
+func (s *statsRecorder) RowsRejectedByReason(p, d, t string, reasons map[string]int) {
+	if s.reasons == nil {
+		s.reasons = map[string]map[string]int{}
+	}
+	s.reasons[p+"."+d+"."+t] = reasons
+}
+
+// TestSyncWorkerStatsHandler tests insert events are reported to the stats
+// handler.
+func TestSyncWorkerStatsHandler(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Fail the first request with a server error,
+	// then reject a single row on the retry.
+	calledNum := 0
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"insertErrors":[{"index":1,"errors":[{"reason":"invalid"},{"reason":"invalid"}]},{"index":2,"errors":[{"reason":"stopped"}]}]}`))}
+			if calledNum == 0 {
+				res.StatusCode = 503
+				res.Body = ioutil.NopCloser(bytes.NewBufferString(`{}`))
+			}
+			calledNum++
+
+			return &res, nil
+		})}
+
+	stats := statsRecorder{}
+	w, err := NewSyncWorker(&client, SetSyncStatsHandler(&stats), SetSyncRetryInterval(1*time.Millisecond))
+	require.NoError(err)
+
+	for i := 0; i < 3; i++ {
+		w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k": i}))
+	}
+	w.InsertWithRetry()
+
+	assert.Equal(3, stats.enqueued)
+	require.Len(stats.attempts, 2)
+	assert.Error(stats.attempts[0])
+	assert.NoError(stats.attempts[1])
+	assert.Equal(3, stats.retried)
+	assert.Equal(1, stats.inserted)
+	assert.Equal(2, stats.rejected)
+	assert.Equal(map[string]map[string]int{"p.d.t": {"invalid": 2, "stopped": 1}}, stats.reasons)
+}
+
+// insertTracerRecorder is an InsertTracer recording all traced requests.
+type insertTracerRecorder struct {
+	infos   []InsertInfo
+	results []InsertResult
+}
+
+func (r *insertTracerRecorder) StartInsert(ctx context.Context, info InsertInfo) (context.Context, func(InsertResult)) {
+	r.infos = append(r.infos, info)
+	return ctx, func(res InsertResult) { r.results = append(r.results, res) }
+}
+
+// TestSyncWorkerRowsQueueTime tests how long rows have been enqueued
+// is reported at the start of every insert operation.
+func TestSyncWorkerRowsQueueTime(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	fake := &FakeBigQuery{}
+	stats := &statsRecorder{}
+	w, err := NewSyncWorker(&http.Client{Transport: fake}, SetSyncStatsHandler(stats))
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t2", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	time.Sleep(30 * time.Millisecond)
+	w.Insert()
+
+	// A single histogram is reported per insert operation, for all tables.
+	require.Len(stats.queueTimes, 1)
+	assert.Equal(int64(2), stats.queueTimes[0].Count())
+	assert.True(stats.queueTimes[0].Percentile(1) >= 50*time.Millisecond)
+
+	// Nothing is reported without enqueued rows.
+	w.Insert()
+	assert.Len(stats.queueTimes, 1)
+	assert.Len(w.enqueuedAt, 0)
+}
+
+// TestSyncWorkerInsertTracer tests every insert request is traced,
+// including retries.
+func TestSyncWorkerInsertTracer(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Fail the first request with a server error, and succeed on the retry.
+	calledNum := 0
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+			if calledNum == 0 {
+				res.StatusCode = 503
+			}
+			calledNum++
+
+			return &res, nil
+		})}
+
+	tracer := insertTracerRecorder{}
+	w, err := NewSyncWorker(&client, SetSyncInsertTracer(&tracer), SetSyncRetryInterval(1*time.Millisecond))
+	require.NoError(err)
+
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k1": "v1"}))
+	w.InsertWithRetryContext(context.Background())
+
+	assert.Equal([]InsertInfo{
+		{Project: "p", Dataset: "d", Table: "t", Rows: 2, Attempt: 0},
+		{Project: "p", Dataset: "d", Table: "t", Rows: 2, Attempt: 1},
+	}, tracer.infos)
+	require.Len(tracer.results, 2)
+	assert.Error(tracer.results[0].Err)
+	assert.NoError(tracer.results[1].Err)
+	assert.Equal(0, tracer.results[1].RejectedRows)
+}
+
+// TestSyncWorkerTraceID tests every request is sent with the trace ID
+// of its rows, kept by retries.
+func TestSyncWorkerTraceID(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Fail the first request with a server error, and succeed on the retry.
+	var traceIDs []string
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+			traceIDs = append(traceIDs, tableReq.TraceId)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+			if len(traceIDs) == 1 {
+				res.StatusCode = 503
+			}
+
+			return &res, nil
+		})}
+
+	traceID := func(rows []Row) string {
+		ids := make([]string, 0, len(rows))
+		for _, r := range rows {
+			ids = append(ids, r.InsertID)
+		}
+		return strings.Join(ids, ",")
+	}
+	tracer := insertTracerRecorder{}
+	w, err := NewSyncWorker(&client, SetSyncTraceID(traceID), SetSyncInsertTracer(&tracer), SetSyncMaxRowsPerRequest(2), SetSyncRetryInterval(1*time.Millisecond))
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k2": "v2"}))
+	w.InsertWithRetry()
+
+	assert.Equal([]string{"id0,id1", "id0,id1", "id2"}, traceIDs)
+	require.Len(tracer.infos, 3)
+	assert.Equal("id0,id1", tracer.infos[1].TraceID)
+
+	// Test raw rows are sent with a trace ID as well.
+	w.Enqueue(Row{ProjectID: "p", DatasetID: "d", TableID: "t", InsertID: "id3", RawData: json.RawMessage(`{"k3":"v3"}`)})
+	w.InsertWithRetry()
+	assert.Equal("id3", traceIDs[len(traceIDs)-1])
+}
+
+// TestSyncWorkerInsertWithRetryContext tests retries stop once the context
+// is done, instead of sleeping and retrying until max retries.
+func TestSyncWorkerInsertWithRetryContext(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Always fail with a server error, and cancel the context on the first call.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calledNum := 0
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			calledNum++
+			cancel()
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 503,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncMaxRetries(10), SetSyncRetryInterval(1*time.Minute))
+	require.NoError(err)
+
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+	start := time.Now()
+	tables := w.InsertWithRetryContext(ctx).All()
+	assert.WithinDuration(start, time.Now(), 1*time.Second)
+
+	assert.Equal(1, calledNum)
+	require.Len(tables, 1)
+	attempts := tables[0].Attempts()
+	require.Len(attempts, 2)
+	assert.Equal(context.Canceled, attempts[1].Error())
+
+	// Test the retry sleep is interrupted as well.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	client.Transport = newTransport(func(req *http.Request) (*http.Response, error) {
+		res := http.Response{
+			Header:     make(http.Header),
+			Request:    req,
+			StatusCode: 503,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+		return &res, nil
+	})
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+	start = time.Now()
+	tables = w.InsertWithRetryContext(ctx).All()
+	assert.WithinDuration(start, time.Now(), 1*time.Second)
+	require.Len(tables, 1)
+	attempts = tables[0].Attempts()
+	require.Len(attempts, 2)
+	assert.Equal(context.DeadlineExceeded, attempts[1].Error())
+}
+
 // TestSyncWorkerShouldRetryInsert tests if the shouldRetryInsert function correctly
 // returns true for Google API 500, 503
 func TestSyncWorkerShouldRetryInsert(t *testing.T) {
@@ -470,7 +832,7 @@ func TestSyncWorkerShouldRetryInsert(t *testing.T) {
 		{404, false},
 	} {
 		start := time.Now()
-		retry := w.shouldRetryInsert(
+		retry := w.shouldRetryInsert("p", "d", "t",
 			&googleapi.Error{
 				Code:    tt.code,
 				Message: "m",
@@ -487,102 +849,398 @@ func TestSyncWorkerShouldRetryInsert(t *testing.T) {
 	}
 
 	// Test a non-GoogleAPI error, but a generic error instead.
-	assert.False(w.shouldRetryInsert(errors.New("Non-GoogleAPI error")))
+	assert.False(w.shouldRetryInsert("p", "d", "t", errors.New("Non-GoogleAPI error")))
 }
 
-// TestSyncWorkerInsertAllWithServerErrorResponse tests if an insert failed with a server
-// error (500, 503) triggers a retry insert.
-func TestSyncWorkerInsertAllWithServerErrorResponse(t *testing.T) {
+// TestSyncWorkerRetryPermanentError tests a transient 503 error is retried,
+// while a permanent 403 error is not.
+func TestSyncWorkerRetryPermanentError(t *testing.T) {
 	t.Parallel()
 
 	assert := assert.New(t)
 	require := require.New(t)
 
-	// Mock response to return mocked errors, and notify table was mock "inserted".
-	// Also count the times the insert function was called to make sure it's retried exactly once.
-	inserted := make(chan struct{})
-	calledNum := 0
-	ps := make(projects)
-	client := http.Client{
-		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
-			pID, dID, tID := getInsertMetadata(req.URL.Path)
-			var tableReq bigquery.TableDataInsertAllRequest
-			b, _ := ioutil.ReadAll(req.Body)
-			require.NoError(json.Unmarshal(b, &tableReq))
+	for _, tt := range []struct {
+		code     int
+		attempts int
+	}{
+		{503, 4}, // First attempt and 3 retries.
+		{403, 1},
+	} {
+		calledNum := 0
+		client := http.Client{
+			Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+				calledNum++
+				res := http.Response{
+					Header:     make(http.Header),
+					Request:    req,
+					StatusCode: tt.code,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
 
-			// Return 503 server error on first call, and test if it triggered
-			// a retry insert with exactly same data on the second call.
-			var err error
-			// Default response.
-			var res http.Response
-			switch calledNum {
-			case 0:
-				err = &googleapi.Error{
-					Code:    503,
-					Message: "m", Body: "b",
-					Errors: []googleapi.ErrorItem{
-						googleapi.ErrorItem{Reason: "r1", Message: "m1"},
-						googleapi.ErrorItem{Reason: "r2", Message: "m2"},
-					}}
+				return &res, nil
+			})}
 
-				// Response should be HTTP 503.
-				if b, err := json.Marshal(err); assert.NoError(err) {
-					res = http.Response{
-						Header:     make(http.Header),
-						Request:    req,
-						StatusCode: 503,
-						Body:       ioutil.NopCloser(bytes.NewBuffer(b))}
-				}
-			case 1:
-				// This insert should keep the table as it is,
-				// and trigger retry after server error.
+		w, err := NewSyncWorker(&client, SetSyncMaxRetries(3), SetSyncRetryInterval(1*time.Millisecond))
+		require.NoError(err)
 
-				// Add all table rows to a local projects-datasets-tables map.
-				// This mocks the rows that were inserted to BigQuery, and we will test against them.
-				for _, tr := range tableReq.Rows {
-					assert.NotNil(tr)
+		w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+		tables := w.InsertWithRetry().All()
+		require.Len(tables, 1)
+		assert.Equal(tt.attempts, calledNum, strconv.Itoa(tt.code))
+	}
+}
 
-					// Mock "insert row" to table: Create project, dataset and table
-					// if uninitalized.
-					initTableIfNotExists(ps, pID, dID, tID)
-					ps[pID][dID][tID] = append(ps[pID][dID][tID], &bigquery.TableDataInsertAllRequestRows{tr.InsertId, tr.Json, []string{}, []string{}})
-				}
+// TestSyncWorkerTableRetryable tests every table's inserts are retried
+// according to its own retryable func, falling back to the worker's one.
+func TestSyncWorkerTableRetryable(t *testing.T) {
+	t.Parallel()
 
-				res = http.Response{
-					Header:     make(http.Header),
-					Request:    req,
-					StatusCode: 200,
-					// Empty JSON body, meaning "no errors".
-					Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
-			default:
-				require.Fail("Insert was called more than 2 times")
-			}
+	assert := assert.New(t)
+	require := require.New(t)
 
-			// Notify that this table was mock "inserted".
-			inserted <- struct{}{}
+	// Mock an http.Client that always fails, counting requests per table.
+	var mu sync.Mutex
+	calls := map[string]int{}
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			_, _, tableID := getInsertMetadata(req.URL.Path)
+			mu.Lock()
+			calls[tableID]++
+			mu.Unlock()
 
-			calledNum++
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 503,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
 
 			return &res, nil
 		})}
 
-	// Make retry sleep delay as small as possible and != 0.
-	w, err := NewSyncWorker(&client, SetSyncMaxRetries(10), SetSyncRetryInterval(1*time.Nanosecond))
+	never := func(error) bool { return false }
+	always := func(error) bool { return true }
+	w, err := NewSyncWorker(&client, SetSyncMaxRetries(3), SetSyncRetryInterval(1*time.Millisecond), SetSyncRetryableFunc(never), SetSyncTableRetryable("p", "d", "log", always))
 	require.NoError(err)
 
-	// Queue 5 rows to the same table.
-	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k2": "v2"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id3", map[string]bigquery.JsonValue{"k3": "v3"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id4", map[string]bigquery.JsonValue{"k4": "v4"}))
+	// Test template tables use their base table's func as well.
+	w.Enqueue(NewRow("p", "d", "log", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRow("p", "d", "dedup", map[string]bigquery.JsonValue{"k0": "v0"}))
+	row := NewRow("p", "d", "log", map[string]bigquery.JsonValue{"k0": "v0"})
+	row.TemplateSuffix = "_20160102"
+	w.Enqueue(row)
+	tables := w.InsertWithRetry().All()
+	require.Len(tables, 3)
+
+	// First attempt and 3 retries for the log table, per template table,
+	// while the other table falls back to the worker's func.
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(map[string]int{"log": 8, "dedup": 1}, calls)
+}
 
-	// Test HTTP 503 server error response triggered a retry.
-	//
-	// First loop is for testing initial insert,
-	// second is for testing retry insert happened.
-	var wg sync.WaitGroup
-	for i := 0; i < 2; i++ {
+// TestSyncWorkerRetryDeadline tests a failed insert is given up once
+// retrying would exceed the retry deadline, even if retries remain.
+func TestSyncWorkerRetryDeadline(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	calledNum := 0
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			calledNum++
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 503,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Retries are attempted after 50ms and 100ms,
+	// but not after 150ms.
+	w, err := NewSyncWorker(&client, SetSyncMaxRetries(100), SetSyncRetryInterval(50*time.Millisecond), SetSyncRetryDeadline(120*time.Millisecond))
+	require.NoError(err)
+
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+	start := time.Now()
+	tables := w.InsertWithRetry().All()
+	assert.True(time.Since(start) < time.Second)
+	require.Len(tables, 1)
+	attempts := tables[0].Attempts()
+	assert.Equal(ErrRetryDeadlineExceeded, attempts[len(attempts)-1].Error())
+	assert.True(calledNum >= 1 && calledNum <= 3, "%d attempts", calledNum)
+	assert.Equal(int64(1), w.counters.failedInserts)
+}
+
+// TestSyncWorkerRetryCallback tests the retry callback is called
+// before every retry, with the retried error and the delay before retrying.
+func TestSyncWorkerRetryCallback(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 503,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	var (
+		attempts []int
+		delays   []time.Duration
+		errs     []error
+	)
+	w, err := NewSyncWorker(&client, SetSyncMaxRetries(2), SetSyncRetryInterval(10*time.Millisecond), SetSyncRetryCallback(func(attempt int, err error, nextDelay time.Duration) {
+		attempts = append(attempts, attempt)
+		delays = append(delays, nextDelay)
+		errs = append(errs, err)
+	}))
+	require.NoError(err)
+
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.InsertWithRetry()
+	assert.Equal([]int{1, 2}, attempts)
+	assert.Equal([]time.Duration{10 * time.Millisecond, 10 * time.Millisecond}, delays)
+	require.Len(errs, 2)
+	var apiErr *googleapi.Error
+	require.True(errors.As(errs[0], &apiErr))
+	assert.Equal(503, apiErr.Code)
+}
+
+// TestSyncWorkerRetryAfter tests a rate limited insert is retried only after
+// the delay requested by the Retry-After header.
+func TestSyncWorkerRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var times []time.Time
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			times = append(times, time.Now())
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+			if len(times) == 1 {
+				res.StatusCode = 429
+				res.Header.Set("Retry-After", "2")
+			}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncRetryInterval(1*time.Millisecond))
+	require.NoError(err)
+
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+	tables := w.InsertWithRetry().All()
+	require.Len(tables, 1)
+	attempts := tables[0].Attempts()
+	require.Len(attempts, 2)
+	assert.Error(attempts[0].Error())
+	assert.NoError(attempts[1].Error())
+
+	require.Len(times, 2)
+	assert.True(times[1].Sub(times[0]) >= 2*time.Second, times[1].Sub(times[0]).String())
+}
+
+// TestSyncWorkerRateLimitSlowdown tests rate limited inserts without
+// a Retry-After header are retried with an increasing delay.
+func TestSyncWorkerRateLimitSlowdown(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Rate limit the first two requests.
+	var times []time.Time
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			times = append(times, time.Now())
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+			if len(times) <= 2 {
+				res.StatusCode = 403
+				res.Body = ioutil.NopCloser(bytes.NewBufferString(`{"error":{"code":403,"message":"m","errors":[{"reason":"rateLimitExceeded","message":"m"}]}}`))
+			}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncRetryInterval(50*time.Millisecond))
+	require.NoError(err)
+
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.InsertWithRetry()
+
+	require.Len(times, 3)
+	assert.True(times[1].Sub(times[0]) >= 50*time.Millisecond, times[1].Sub(times[0]).String())
+	assert.True(times[2].Sub(times[1]) >= 100*time.Millisecond, times[2].Sub(times[1]).String())
+
+	// Test the slowdown is reset after a successful insert.
+	assert.Equal(time.Duration(0), w.rateLimitDelay)
+}
+
+// TestSyncWorkerRetryWait tests the wait before retrying a rate limited insert
+// is capped.
+func TestSyncWorkerRetryWait(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	w, err := NewSyncWorker(&http.Client{}, SetSyncRetryInterval(1*time.Millisecond))
+	require.NoError(err)
+
+	h := make(http.Header)
+	h.Set("Retry-After", "3600")
+	assert.Equal(MaxSyncRetryAfter, w.retryWait(0, &googleapi.Error{Code: 503, Header: h}))
+
+	// Test the adaptive slowdown is capped as well.
+	for i := 0; i < 100; i++ {
+		w.retryWait(i, &googleapi.Error{Code: 429})
+	}
+	assert.Equal(MaxSyncRetryAfter, w.rateLimitDelay)
+
+	// Test other errors use the retry delay.
+	w.rateLimitDelay = 0
+	assert.Equal(1*time.Millisecond, w.retryWait(0, &googleapi.Error{Code: 500}))
+}
+
+// TestSyncWorkerInsertSemaphoreContext tests waiting for an insert request slot
+// returns once the context is done.
+func TestSyncWorkerInsertSemaphoreContext(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Hold the only slot, so the insert can't be executed.
+	sem := make(chan struct{}, 1)
+	sem <- struct{}{}
+	w, err := NewSyncWorker(&http.Client{}, setSyncInsertSemaphore(sem))
+	require.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+	tables := w.InsertContext(ctx).All()
+	require.Len(tables, 1)
+	attempts := tables[0].Attempts()
+	require.Len(attempts, 1)
+	assert.Equal(context.DeadlineExceeded, attempts[0].Error())
+}
+
+// TestSyncWorkerInsertAllWithServerErrorResponse tests if an insert failed with a server
+// error (500, 503) triggers a retry insert.
+func TestSyncWorkerInsertAllWithServerErrorResponse(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock response to return mocked errors, and notify table was mock "inserted".
+	// Also count the times the insert function was called to make sure it's retried exactly once.
+	inserted := make(chan struct{})
+	calledNum := 0
+	ps := make(projects)
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			pID, dID, tID := getInsertMetadata(req.URL.Path)
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+
+			// Return 503 server error on first call, and test if it triggered
+			// a retry insert with exactly same data on the second call.
+			var err error
+			// Default response.
+			var res http.Response
+			switch calledNum {
+			case 0:
+				err = &googleapi.Error{
+					Code:    503,
+					Message: "m", Body: "b",
+					Errors: []googleapi.ErrorItem{
+						googleapi.ErrorItem{Reason: "r1", Message: "m1"},
+						googleapi.ErrorItem{Reason: "r2", Message: "m2"},
+					}}
+
+				// Response should be HTTP 503.
+				if b, err := json.Marshal(err); assert.NoError(err) {
+					res = http.Response{
+						Header:     make(http.Header),
+						Request:    req,
+						StatusCode: 503,
+						Body:       ioutil.NopCloser(bytes.NewBuffer(b))}
+				}
+			case 1:
+				// This insert should keep the table as it is,
+				// and trigger retry after server error.
+
+				// Add all table rows to a local projects-datasets-tables map.
+				// This mocks the rows that were inserted to BigQuery, and we will test against them.
+				for _, tr := range tableReq.Rows {
+					assert.NotNil(tr)
+
+					// Mock "insert row" to table: Create project, dataset and table
+					// if uninitalized.
+					initTableIfNotExists(ps, pID, dID, tID)
+					ps[pID][dID][tID] = append(ps[pID][dID][tID], &bigquery.TableDataInsertAllRequestRows{tr.InsertId, tr.Json, []string{}, []string{}})
+				}
+
+				res = http.Response{
+					Header:     make(http.Header),
+					Request:    req,
+					StatusCode: 200,
+					// Empty JSON body, meaning "no errors".
+					Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+			default:
+				require.Fail("Insert was called more than 2 times")
+			}
+
+			// Notify that this table was mock "inserted".
+			inserted <- struct{}{}
+
+			calledNum++
+
+			return &res, nil
+		})}
+
+	// Make retry sleep delay as small as possible and != 0.
+	w, err := NewSyncWorker(&client, SetSyncMaxRetries(10), SetSyncRetryInterval(1*time.Nanosecond))
+	require.NoError(err)
+
+	// Queue 5 rows to the same table.
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k2": "v2"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id3", map[string]bigquery.JsonValue{"k3": "v3"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id4", map[string]bigquery.JsonValue{"k4": "v4"}))
+
+	// Test HTTP 503 server error response triggered a retry.
+	//
+	// First loop is for testing initial insert,
+	// second is for testing retry insert happened.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
 		wg.Add(1)
 		go func(i int) {
 			defer wg.Done()
@@ -683,232 +1341,1729 @@ func TestSyncWorkerInsertAllWithNonServerErrorResponse(t *testing.T) {
 				if b, err := json.Marshal(err); assert.NoError(err) {
 					// Response should be HTTP 503.
 
-					res = http.Response{
-						Header:     make(http.Header),
-						Request:    req,
-						StatusCode: 501,
-						Body:       ioutil.NopCloser(bytes.NewBuffer(b))}
-				}
+					res = http.Response{
+						Header:     make(http.Header),
+						Request:    req,
+						StatusCode: 501,
+						Body:       ioutil.NopCloser(bytes.NewBuffer(b))}
+				}
+
+				for _, tr := range tableReq.Rows {
+					assert.NotNil(tr)
+
+					// Mock "insert row" to table: Create project, dataset and table
+					// if uninitalized.
+					initTableIfNotExists(ps, pID, dID, tID)
+					ps[pID][dID][tID] = append(ps[pID][dID][tID], &bigquery.TableDataInsertAllRequestRows{tr.InsertId, tr.Json, []string{}, []string{}})
+				}
+			default:
+				require.Fail("Insert was called more than once")
+			}
+
+			// Notify that this table was mock "flushed".
+			inserted <- struct{}{}
+
+			calledNum++
+
+			return &res, nil
+		})}
+
+	// Make retry sleep delay as small as possible and != 0.
+	w, err := NewSyncWorker(&client, SetSyncMaxRetries(10), SetSyncRetryInterval(1*time.Nanosecond))
+	require.NoError(err)
+
+	// Queue 5 rows to the same table.
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k2": "v2"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id3", map[string]bigquery.JsonValue{"k3": "v3"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id4", map[string]bigquery.JsonValue{"k4": "v4"}))
+
+	// Test only a single insert occured.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		select {
+		case <-inserted:
+		case <-time.After(1 * time.Second):
+			assert.Fail("initial insert didn't occur as expected")
+		}
+	}()
+
+	insertErrs := w.InsertWithRetry()
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		require.Fail("insert didn't occur as expected")
+	}
+
+	assert.Len(inserted, 0) // Test no more inserts happened.
+	assert.Len(w.rows, 0)   // Test rows were reset after insert.
+
+	// Test rows slice wasn't re-allocated with a larger size.
+	// This make sure we're keeping the same row slice size throught the
+	// Worker's life.
+	assert.Equal(rowSize, cap(w.rows))
+
+	// A 501 error should cause an insertError with a 501 error
+	// to be returned, along with the result (which is empty for this test).
+	tables := insertErrs.All()
+	require.Len(tables, 1)
+	require.Len(tables[0].Attempts(), 1)
+	assert.EqualError(
+		tables[0].Attempts()[0].Error(),
+		`googleapi: got HTTP response code 501 with body: {"code":501,"message":"m","Body":"b","Header":null,"Errors":[{"reason":"r1","message":"m1"},{"reason":"r2","message":"m2"}]}`)
+	assert.Empty(tables[0].Attempts()[0].All())
+
+	assert.Equal(
+		projects{
+			"p": project{
+				"d": dataset{
+					"t": table{
+						&bigquery.TableDataInsertAllRequestRows{"id0", map[string]bigquery.JsonValue{"k0": "v0"}, []string{}, []string{}},
+						&bigquery.TableDataInsertAllRequestRows{"id1", map[string]bigquery.JsonValue{"k1": "v1"}, []string{}, []string{}},
+						&bigquery.TableDataInsertAllRequestRows{"id2", map[string]bigquery.JsonValue{"k2": "v2"}, []string{}, []string{}},
+						&bigquery.TableDataInsertAllRequestRows{"id3", map[string]bigquery.JsonValue{"k3": "v3"}, []string{}, []string{}},
+						&bigquery.TableDataInsertAllRequestRows{"id4", map[string]bigquery.JsonValue{"k4": "v4"}, []string{}, []string{}},
+					}}}},
+		ps)
+}
+
+// TestSyncWorkerMaxRetryInsert tests if a repeatedly failing insert attempt
+// (failing with non-rejected rows errors) is eventually dropped and ignored,
+// and Worker is moving on to the next table insert.
+func TestSyncWorkerMaxRetryInsert(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock response to return mocked errors, and notify table was mock "flushed".
+	// Also count the times the insert function was called to make sure it's retried exactly 3 times.
+	inserted := make(chan struct{})
+	calledNum := 0
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			pID, dID, tID := getInsertMetadata(req.URL.Path)
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+
+			var err error
+
+			// Default response.
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			// Return Google API HTTP 503 error on every call, which should be retried and logged.
+			// Thus, we should expect 3 logged
+			if calledNum < 3 {
+				err = &googleapi.Error{
+					Code:    503,
+					Message: "m",
+					Body:    "b",
+					Errors: []googleapi.ErrorItem{
+						googleapi.ErrorItem{Reason: "r1", Message: "m1"},
+						googleapi.ErrorItem{Reason: "r2", Message: "m2"},
+					}}
+
+				if b, err := json.Marshal(err); assert.NoError(err) {
+					// Response should be HTTP 503.
+					res = http.Response{
+						Header:     make(http.Header),
+						Request:    req,
+						StatusCode: 503,
+						Body:       ioutil.NopCloser(bytes.NewBuffer(b))}
+				}
+
+				// Test request payload.
+				ps := projects{}
+				for _, tr := range tableReq.Rows {
+					assert.NotNil(tr)
+
+					// Mock "insert row" to table: Create project, dataset and table
+					// if uninitalized.
+					initTableIfNotExists(ps, pID, dID, tID)
+					ps[pID][dID][tID] = append(ps[pID][dID][tID], &bigquery.TableDataInsertAllRequestRows{tr.InsertId, tr.Json, []string{}, []string{}})
+				}
+				assert.Equal(
+					projects{
+						"p": project{
+							"d": dataset{
+								"t": table{
+									&bigquery.TableDataInsertAllRequestRows{"id0", map[string]bigquery.JsonValue{"k0": "v0"}, []string{}, []string{}},
+									&bigquery.TableDataInsertAllRequestRows{"id1", map[string]bigquery.JsonValue{"k1": "v1"}, []string{}, []string{}},
+									&bigquery.TableDataInsertAllRequestRows{"id2", map[string]bigquery.JsonValue{"k2": "v2"}, []string{}, []string{}},
+									&bigquery.TableDataInsertAllRequestRows{"id3", map[string]bigquery.JsonValue{"k3": "v3"}, []string{}, []string{}},
+									&bigquery.TableDataInsertAllRequestRows{"id4", map[string]bigquery.JsonValue{"k4": "v4"}, []string{}, []string{}},
+								}}}},
+					ps)
+			} else {
+				assert.Fail("Insert was called more than 3 times")
+			}
+
+			// Notify that this table was mock "inserted".
+			inserted <- struct{}{}
+
+			calledNum++
+
+			return &res, nil
+		})}
+
+	// Set a row threshold to 5 so it will flush immediately on calling Start().
+	// Also make retry sleep delay as small as possible and != 0.
+	w, err := NewSyncWorker(&client, SetSyncMaxRetries(3), SetSyncRetryInterval(1*time.Nanosecond))
+	require.NoError(err)
+
+	// Queue 5 rows to the same table.
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k2": "v2"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id3", map[string]bigquery.JsonValue{"k3": "v3"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id4", map[string]bigquery.JsonValue{"k4": "v4"}))
+
+	// Test only a single insert occured.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			select {
+			case <-inserted:
+			case <-time.After(1 * time.Second):
+				assert.Fail("insert didn't occur as expected", strconv.Itoa(i))
+			}
+		}(i)
+	}
+
+	insertErrs := w.InsertWithRetry()
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		require.Fail("insert didn't occur as expected")
+	}
+
+	assert.Len(inserted, 0) // Test no more inserts happened.
+	assert.Len(w.rows, 0)   // Test rows were reset after insert.
+
+	tables := insertErrs.All()
+	require.Len(tables, 1)
+	// 3 attempts and the 4th is the one that aborts the insert operation.
+	require.Len(tables[0].Attempts(), 4)
+	assert.EqualError(tables[0].Attempts()[0].Error(), `googleapi: got HTTP response code 503 with body: {"code":503,"message":"m","Body":"b","Header":null,"Errors":[{"reason":"r1","message":"m1"},{"reason":"r2","message":"m2"}]}`)
+	assert.EqualError(tables[0].Attempts()[1].Error(), `googleapi: got HTTP response code 503 with body: {"code":503,"message":"m","Body":"b","Header":null,"Errors":[{"reason":"r1","message":"m1"},{"reason":"r2","message":"m2"}]}`)
+	assert.EqualError(tables[0].Attempts()[2].Error(), `googleapi: got HTTP response code 503 with body: {"code":503,"message":"m","Body":"b","Header":null,"Errors":[{"reason":"r1","message":"m1"},{"reason":"r2","message":"m2"}]}`)
+	assert.EqualError(tables[0].Attempts()[3].Error(), "Insert table p.d.t retried 4 times, dropping insert and moving on")
+}
+
+// TestSyncWorkerInsertID tests rows' insert IDs are sent as insertId
+// in the request body, and omitted for rows with an empty insert ID.
+func TestSyncWorkerInsertID(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var body []byte
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			body, _ = ioutil.ReadAll(req.Body)
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client)
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "", map[string]bigquery.JsonValue{"k1": "v1"}))
+
+	tables := w.Insert().All()
+	require.Len(tables, 1)
+	require.NoError(tables[0].Attempts()[0].Error())
+
+	// Unmarshal rows into raw maps, to test which keys were sent.
+	var tableReq struct {
+		Rows []map[string]json.RawMessage `json:"rows"`
+	}
+	require.NoError(json.Unmarshal(body, &tableReq))
+	require.Len(tableReq.Rows, 2)
+	assert.Equal(json.RawMessage(`"id0"`), tableReq.Rows[0]["insertId"])
+	assert.NotContains(tableReq.Rows[1], "insertId")
+}
+
+// TestSyncWorkerNestedData tests nested records are sent as nested JSON objects,
+// repeated fields as JSON arrays, and nil values as explicit nulls.
+func TestSyncWorkerNestedData(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var body []byte
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			body, _ = ioutil.ReadAll(req.Body)
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	schema := &bigquery.TableSchema{
+		Fields: []*bigquery.TableFieldSchema{
+			{Name: "id", Type: "STRING", Mode: "REQUIRED"},
+			{Name: "tags", Type: "STRING", Mode: "REPEATED"},
+			{Name: "user", Type: "RECORD", Fields: []*bigquery.TableFieldSchema{
+				{Name: "name", Type: "STRING"},
+				{Name: "address", Type: "RECORD", Fields: []*bigquery.TableFieldSchema{
+					{Name: "city", Type: "STRING", Mode: "REQUIRED"},
+					{Name: "zip", Type: "STRING", Mode: "NULLABLE"},
+				}},
+			}},
+		},
+	}
+	w, err := NewSyncWorker(&client, SetSyncSchema("p", "d", "t", schema))
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{
+		"id":   "0",
+		"tags": []string{"t0", "t1"},
+		"user": map[string]bigquery.JsonValue{
+			"name": "n",
+			"address": map[string]interface{}{
+				"city": "c",
+				"zip":  nil,
+			},
+		},
+	}))
+
+	tables := w.Insert().All()
+	require.Len(tables, 1)
+	require.NoError(tables[0].Attempts()[0].Error())
+	assert.Empty(tables[0].Attempts()[0].All())
+
+	// Unmarshal rows into raw maps, to test the encoded JSON structure.
+	var tableReq struct {
+		Rows []map[string]json.RawMessage `json:"rows"`
+	}
+	require.NoError(json.Unmarshal(body, &tableReq))
+	require.Len(tableReq.Rows, 1)
+	assert.Equal(
+		`{"id":"0","tags":["t0","t1"],"user":{"address":{"city":"c","zip":null},"name":"n"}}`,
+		string(tableReq.Rows[0]["json"]))
+}
+
+// TestSyncWorkerEndpoint tests insert requests are sent to a custom endpoint
+// if one is set.
+func TestSyncWorkerEndpoint(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var reqURL string
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			reqURL = req.URL.String()
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncEndpoint("http://localhost:9050/bigquery/v2"))
+	require.NoError(err)
+
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+	tables := w.Insert().All()
+	require.Len(tables, 1)
+	require.NoError(tables[0].Attempts()[0].Error())
+
+	assert.Contains(reqURL, "http://localhost:9050/bigquery/v2/projects/p/datasets/d/tables/t/insertAll")
+}
+
+// TestSyncWorkerInsertAllRows tests inserting a slice of rows with retries.
+func TestSyncWorkerInsertAllRows(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Fail the first request, and count rows sent by the retry.
+	var requests, inserted int
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+
+			requests++
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+			if requests == 1 {
+				res.StatusCode = 503
+			} else {
+				inserted += len(tableReq.Rows)
+			}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncRetryInterval(1*time.Millisecond))
+	require.NoError(err)
+
+	rows := []Row{
+		NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}),
+		NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k1": "v1"}),
+	}
+	tables := w.InsertAll(rows).All()
+	require.Len(tables, 1)
+	attempts := tables[0].Attempts()
+	require.Len(attempts, 2)
+	assert.Error(attempts[0].Error())
+	assert.NoError(attempts[1].Error())
+
+	assert.Equal(2, requests)
+	assert.Equal(2, inserted)
+	assert.Zero(w.RowLen())
+}
+
+// TestSyncWorkerTemplateSuffix tests rows to the same table with different
+// template suffixes are inserted using separate requests.
+func TestSyncWorkerTemplateSuffix(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Count rows sent per template suffix.
+	rows := map[string]int{}
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+			rows[tableReq.TemplateSuffix] += len(tableReq.Rows)
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client)
+	require.NoError(err)
+
+	for _, suffix := range []string{"", "_a", "_a", "_b"} {
+		r := NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"})
+		r.TemplateSuffix = suffix
+		w.Enqueue(r)
+	}
+	tables := w.Insert().All()
+
+	assert.Equal(map[string]int{"": 1, "_a": 2, "_b": 1}, rows)
+	require.Len(tables, 3)
+	suffixes := map[string]bool{}
+	for _, table := range tables {
+		attempts := table.Attempts()
+		require.Len(attempts, 1)
+		assert.Equal("t", attempts[0].Table)
+		suffixes[attempts[0].TemplateSuffix] = true
+	}
+	assert.Equal(map[string]bool{"": true, "_a": true, "_b": true}, suffixes)
+}
+
+// TestSyncWorkerInsertTimeout tests insert requests exceeding the insert
+// timeout are retried.
+func TestSyncWorkerInsertTimeout(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that hangs until the request is canceled.
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncInsertTimeout(10*time.Millisecond), SetSyncRetryInterval(1*time.Millisecond), SetSyncMaxRetries(2))
+	require.NoError(err)
+
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"k0": "v0"}))
+	tables := w.InsertWithRetry().All()
+	require.Len(tables, 1)
+
+	// Test every attempt timed out separately, until giving up.
+	attempts := tables[0].Attempts()
+	require.Len(attempts, 4)
+	for _, attempt := range attempts[:3] {
+		assert.Equal(&InsertTimeoutError{Timeout: 10 * time.Millisecond}, attempt.Error())
+	}
+	assert.IsType(&TooManyFailedInsertRetriesError{}, attempts[3].Error())
+	assert.True(IsRetryable(&InsertTimeoutError{}))
+}
+
+// TestSyncWorkerDedupSuppressed tests rows with insert IDs inserted by a retry
+// of a timed out request are counted as likely suppressed duplicates,
+// and are excluded from all inserted row counts.
+func TestSyncWorkerDedupSuppressed(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock an http.Client that times out once, then succeeds.
+	calls := 0
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				<-req.Context().Done()
+				return nil, req.Context().Err()
+			}
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	stats := &statsRecorder{}
+	tableStats := newTableStats(10)
+	flushed := 0
+	w, err := NewSyncWorker(&client, SetSyncInsertTimeout(10*time.Millisecond), SetSyncRetryInterval(1*time.Millisecond), SetSyncMaxRetries(2),
+		SetSyncStatsHandler(stats), setSyncTableStats(tableStats),
+		SetSyncFlushCallback(func(inserted, rejected int, table string) { flushed += inserted }))
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "", map[string]bigquery.JsonValue{"k2": "v2"}))
+	tables := w.InsertWithRetry().All()
+	require.Len(tables, 1)
+	require.Len(tables[0].Attempts(), 2)
+	assert.NoError(tables[0].Attempts()[1].Error())
+
+	// Test rows without an insert ID are counted as inserted regardless.
+	counters := w.counters.stats(0)
+	assert.EqualValues(1, counters.InsertedRows)
+	assert.EqualValues(2, counters.DedupSuppressed)
+	assert.Equal(1, stats.inserted)
+	assert.Equal(1, flushed)
+	assert.EqualValues(1, tableStats.snapshot()["p.d.t"].InsertedRows)
+
+	// Test rows inserted without a prior timeout are counted as inserted.
+	w.Enqueue(NewRowWithID("p", "d", "t", "id3", map[string]bigquery.JsonValue{"k3": "v3"}))
+	w.InsertWithRetry()
+	counters = w.counters.stats(0)
+	assert.EqualValues(2, counters.InsertedRows)
+	assert.EqualValues(2, counters.DedupSuppressed)
+	assert.Equal(2, stats.inserted)
+	assert.Equal(2, flushed)
+	assert.EqualValues(2, tableStats.snapshot()["p.d.t"].InsertedRows)
+}
+
+// TestSyncWorkerGzip tests insert request bodies are compressed using gzip
+// if set.
+func TestSyncWorkerGzip(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var (
+		encoding string
+		tableReq bigquery.TableDataInsertAllRequest
+	)
+	mock := newTransport(func(req *http.Request) (*http.Response, error) {
+		encoding = req.Header.Get("Content-Encoding")
+		zr, err := gzip.NewReader(req.Body)
+		require.NoError(err)
+		require.NoError(json.NewDecoder(zr).Decode(&tableReq))
+
+		res := http.Response{
+			Header:     make(http.Header),
+			Request:    req,
+			StatusCode: 200,
+			// Empty JSON body, meaning "no errors".
+			Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+		return &res, nil
+	})
+	client := http.Client{Transport: mock}
+
+	w, err := NewSyncWorker(&client, SetSyncGzip(true))
+	require.NoError(err)
+
+	// Test the given client isn't modified.
+	assert.Equal(mock, client.Transport)
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	tables := w.Insert().All()
+	require.Len(tables, 1)
+	require.NoError(tables[0].Attempts()[0].Error())
+
+	assert.Equal("gzip", encoding)
+	require.Len(tableReq.Rows, 1)
+	assert.Equal("id0", tableReq.Rows[0].InsertId)
+	assert.Equal(bigquery.JsonValue("v0"), tableReq.Rows[0].Json["k0"])
+}
+
+// TestSyncWorkerGzipMinBytes tests only insert request bodies larger than
+// gzip min bytes are compressed, and marked as such.
+func TestSyncWorkerGzipMinBytes(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var (
+		encoding string
+		tableReq bigquery.TableDataInsertAllRequest
+	)
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			encoding = req.Header.Get("Content-Encoding")
+			body := req.Body
+			if encoding == "gzip" {
+				zr, err := gzip.NewReader(req.Body)
+				require.NoError(err)
+				body = zr
+			}
+			tableReq = bigquery.TableDataInsertAllRequest{}
+			require.NoError(json.NewDecoder(body).Decode(&tableReq))
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncGzip(true), SetSyncGzipMinBytes(1024))
+	require.NoError(err)
+
+	// Test a small request is sent uncompressed.
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	tables := w.Insert().All()
+	require.Len(tables, 1)
+	require.NoError(tables[0].Attempts()[0].Error())
+	assert.Equal("", encoding)
+	require.Len(tableReq.Rows, 1)
+
+	// Test a large request is compressed.
+	for i := 0; i < 50; i++ {
+		w.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k0": "v0"}))
+	}
+	tables = w.Insert().All()
+	require.Len(tables, 1)
+	require.NoError(tables[0].Attempts()[0].Error())
+	assert.Equal("gzip", encoding)
+	require.Len(tableReq.Rows, 50)
+}
+
+// TestSyncWorkerUserAgent tests a custom User-Agent header is appended to
+// or replaces the generic one, and is kept by OAuth2 transports.
+func TestSyncWorkerUserAgent(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var userAgent, auth string
+	mock := newTransport(func(req *http.Request) (*http.Response, error) {
+		userAgent = req.Header.Get("User-Agent")
+		auth = req.Header.Get("Authorization")
+
+		res := http.Response{
+			Header:     make(http.Header),
+			Request:    req,
+			StatusCode: 200,
+			// Empty JSON body, meaning "no errors".
+			Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+		return &res, nil
+	})
+	client := http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}),
+		Base:   mock,
+	}}
+
+	tests := []struct {
+		row       Row
+		replace   bool
+		userAgent string
+	}{
+		{
+			row:       NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}),
+			userAgent: googleapi.UserAgent + " my-app/1.0",
+		},
+		{
+			row:       NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}),
+			replace:   true,
+			userAgent: "my-app/1.0",
+		},
+		{
+			// Raw rows are sent using a separate request.
+			row:       NewRawRow("p", "d", "t", json.RawMessage(`{"k0":"v0"}`)),
+			userAgent: googleapi.UserAgent + " my-app/1.0",
+		},
+	}
+
+	for i, test := range tests {
+		w, err := NewSyncWorker(&client, SetSyncUserAgent("my-app/1.0", test.replace))
+		require.NoError(err)
+
+		w.Enqueue(test.row)
+		tables := w.Insert().All()
+		require.Len(tables, 1)
+		require.NoError(tables[0].Attempts()[0].Error())
+
+		assert.Equal(test.userAgent, userAgent, i)
+		assert.Equal("Bearer token", auth, i)
+	}
+}
+
+// TestSyncWorkerQuotaProject tests the quota project header is set on
+// insert requests, including raw rows' requests.
+func TestSyncWorkerQuotaProject(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var quotaProject []string
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			quotaProject = append(quotaProject, req.Header.Get("X-Goog-User-Project"))
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncQuotaProject("billing-project"))
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRawRow("p", "d", "t2", json.RawMessage(`{"k0":"v0"}`)))
+	for _, tbl := range w.Insert().All() {
+		require.NoError(tbl.Attempts()[0].Error())
+	}
+	assert.Equal([]string{"billing-project", "billing-project"}, quotaProject)
+}
+
+// TestSyncWorkerPartitionDecorator tests table IDs with a partition decorator
+// are sent verbatim, and share their partitioned table's schema and options.
+func TestSyncWorkerPartitionDecorator(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var tables []string
+	var skipInvalidRows []bool
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+			_, _, tID := getInsertMetadata(req.URL.Path)
+			tables = append(tables, tID)
+			skipInvalidRows = append(skipInvalidRows, tableReq.SkipInvalidRows)
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	schema := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{{Name: "k0", Type: "STRING"}}}
+	w, err := NewSyncWorker(&client,
+		SetSyncSchema("p", "d", "mytable", schema),
+		SetSyncTableOptions("p", "d", "mytable", TableOptions{SkipInvalidRows: true}))
+	require.NoError(err)
+
+	// The second partition includes a raw row,
+	// sending its rows using a separate request path.
+	for i, tableID := range []string{"mytable$20240101", "mytable$20240102"} {
+		w.Enqueue(NewRowWithID("p", "d", tableID, "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+		w.Enqueue(NewRowWithID("p", "d", tableID, "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+		if i == 1 {
+			w.Enqueue(NewRawRow("p", "d", tableID, json.RawMessage(`{"k0":"v0"}`)))
+		}
+
+		tables = nil
+		skipInvalidRows = nil
+		insertErrs := w.Insert()
+
+		// Invalid rows are rejected using the partitioned table's schema.
+		assert.Equal([]string{tableID}, tables)
+		assert.Equal([]bool{true}, skipInvalidRows)
+		var rejected []string
+		for _, tbl := range insertErrs.All() {
+			for _, row := range tbl.Attempts()[0].All() {
+				rejected = append(rejected, row.InsertID)
+			}
+		}
+		assert.Equal([]string{"id1"}, rejected, tableID)
+	}
+}
+
+// TestSyncWorkerSchema tests rows not matching their table's schema
+// are reported as rejected without being sent to BigQuery.
+func TestSyncWorkerSchema(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var tableReq bigquery.TableDataInsertAllRequest
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	schema := &bigquery.TableSchema{
+		Fields: []*bigquery.TableFieldSchema{{Name: "k0", Type: "STRING"}},
+	}
+	var deadLetters []error
+	w, err := NewSyncWorker(&client, SetSyncSchema("p", "d", "t", schema), SetSyncDeadLetterHandler(func(row Row, err error) {
+		deadLetters = append(deadLetters, err)
+	}))
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	tables := w.Insert().All()
+
+	// Test only the valid row was sent.
+	require.Len(tableReq.Rows, 1)
+	assert.Equal("id0", tableReq.Rows[0].InsertId)
+
+	// Test the invalid row was reported as rejected.
+	require.Len(tables, 2)
+	if len(tables[0].Attempts()[0].rows) == 0 {
+		tables[0], tables[1] = tables[1], tables[0]
+	}
+	attempts := tables[0].Attempts()
+	require.Len(attempts, 1)
+	assert.NoError(attempts[0].Error())
+	rowErrs := attempts[0].All()
+	require.Len(rowErrs, 1)
+	assert.Equal("id1", rowErrs[0].InsertID)
+	assert.Equal("k1", rowErrs[0].All()[0].Location)
+	require.Len(deadLetters, 1)
+	assert.EqualError(deadLetters[0], "Row rejected by table p.d.t: invalid: no such field.")
+}
+
+// TestSyncWorkerPreFlush tests the rows of every request are replaced
+// by the pre-flush hook, and requests left without rows are skipped.
+func TestSyncWorkerPreFlush(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock response to report the first row of every request as invalid.
+	var requestIDs [][]string
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+			var ids []string
+			for _, row := range tableReq.Rows {
+				ids = append(ids, row.InsertId)
+			}
+			requestIDs = append(requestIDs, ids)
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"insertErrors":[{"index":0,"errors":[{"reason":"invalid"}]}]}`))}
+
+			return &res, nil
+		})}
+
+	// Drop the first row of every request, and the request of "id4" entirely.
+	var calls [][]Row
+	w, err := NewSyncWorker(&client, SetSyncMaxRowsPerRequest(2), SetSyncPreFlush(func(rows []Row) []Row {
+		calls = append(calls, rows)
+		if rows[0].InsertID == "id4" {
+			return nil
+		}
+		return rows[1:]
+	}))
+	require.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		w.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k": "v"}))
+	}
+	insertErrs := w.Insert()
+	assert.Len(calls, 3)
+	assert.Equal([][]string{{"id1"}, {"id3"}}, requestIDs)
+
+	// Test row errors are matched to the rows sent.
+	rowErrs := insertErrs.RowErrors()
+	require.Len(rowErrs, 2)
+	assert.Equal("id1", rowErrs[0].Row.InsertID)
+	assert.Equal("id3", rowErrs[1].Row.InsertID)
+}
+
+// TestSyncWorkerRowTransform tests rows are transformed before insert,
+// and rows failing to transform are reported as rejected.
+func TestSyncWorkerRowTransform(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var tableReq bigquery.TableDataInsertAllRequest
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	// Rename field k0 to k1, and fail rows without it.
+	var deadLetters []Row
+	w, err := NewSyncWorker(&client, SetSyncRowTransform(func(r Row) (Row, error) {
+		v, ok := r.Data["k0"]
+		if !ok {
+			return Row{}, errors.New("missing k0")
+		}
+		r.Data = map[string]bigquery.JsonValue{"k1": v}
+		return r, nil
+	}), SetSyncDeadLetterHandler(func(r Row, err error) {
+		deadLetters = append(deadLetters, r)
+	}))
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k2": "v2"}))
+	tables := w.Insert().All()
+
+	// Test only the transformed row was sent.
+	require.Len(tableReq.Rows, 1)
+	assert.Equal("id0", tableReq.Rows[0].InsertId)
+	assert.Equal(map[string]bigquery.JsonValue{"k1": "v0"}, tableReq.Rows[0].Json)
+
+	// Test the failed row was reported as rejected, as enqueued.
+	require.Len(tables, 2)
+	require.Len(deadLetters, 1)
+	assert.Equal("id1", deadLetters[0].InsertID)
+	assert.Equal(map[string]bigquery.JsonValue{"k2": "v2"}, deadLetters[0].Data)
+}
+
+// TestSyncWorkerDedupKey tests rows of the same table with the same key
+// are collapsed to their last occurrence before insert.
+func TestSyncWorkerDedupKey(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	sent := map[string][]string{}
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+			_, _, tID := getInsertMetadata(req.URL.Path)
+			for _, r := range tableReq.Rows {
+				sent[tID] = append(sent[tID], r.Json["v"].(string))
+			}
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncDedupKey(func(r Row) string {
+		key, _ := r.Data["key"].(string)
+		return key
+	}))
+	require.NoError(err)
+
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"key": "a", "v": "a0"}))
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"key": "b", "v": "b0"}))
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"key": "a", "v": "a1"}))
+	w.Enqueue(NewRow("p", "d", "t2", map[string]bigquery.JsonValue{"key": "a", "v": "a2"}))
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"v": "n0"}))
+	w.Enqueue(NewRow("p", "d", "t", map[string]bigquery.JsonValue{"v": "n1"}))
+	for _, tbl := range w.Insert().All() {
+		require.NoError(tbl.Attempts()[0].Error())
+	}
+
+	// Rows of other tables, and rows without a key aren't collapsed.
+	assert.Equal(map[string][]string{"t": {"b0", "a1", "n0", "n1"}, "t2": {"a2"}}, sent)
+	assert.Equal(int64(1), w.counters.stats(0).DedupedRows)
+	assert.Equal(0, w.RowLen())
+	assert.Equal(0, w.counters.stats(0).QueuedRows)
+}
+
+// TestSyncWorkerDeadLetterHandler tests rejected rows are passed to the
+// dead-letter handler, matched to their source rows.
+func TestSyncWorkerDeadLetterHandler(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Reject the first row of table t1, and the last row of table t2.
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			_, _, tID := getInsertMetadata(req.URL.Path)
+			body := `{"insertErrors":[{"index":0,"errors":[{"reason":"invalid","message":"m1"}]}]}`
+			if tID == "t2" {
+				body = `{"insertErrors":[{"index":2,"errors":[{"reason":"invalid","message":"m2"}]}]}`
+			}
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body))}
+
+			return &res, nil
+		})}
+
+	var (
+		rows []Row
+		errs []error
+	)
+	w, err := NewSyncWorker(&client, SetSyncDeadLetterHandler(func(row Row, err error) {
+		rows = append(rows, row)
+		errs = append(errs, err)
+	}))
+	require.NoError(err)
+
+	// Interleave rows of both tables.
+	var enqueued []Row
+	for i := 0; i < 3; i++ {
+		for _, tID := range []string{"t1", "t2"} {
+			r := NewRow("p", "d", tID, map[string]bigquery.JsonValue{"k": tID + strconv.Itoa(i)})
+			enqueued = append(enqueued, r)
+			w.Enqueue(r)
+		}
+	}
+
+	insertErrs := w.Insert()
+
+	require.Len(rows, 2)
+	// Tables are inserted in no particular order.
+	if rows[0].TableID == "t2" {
+		rows[0], rows[1] = rows[1], rows[0]
+		errs[0], errs[1] = errs[1], errs[0]
+	}
+	assert.Equal(enqueued[0], rows[0])
+	assert.Equal(enqueued[5], rows[1])
+	assert.EqualError(errs[0], "Row rejected by table p.d.t1: invalid: m1")
+	assert.EqualError(errs[1], "Row rejected by table p.d.t2: invalid: m2")
+
+	// Row errors should still be reported as usual.
+	var numRowErrs int
+	for _, table := range insertErrs.All() {
+		for _, attempt := range table.Attempts() {
+			numRowErrs += len(attempt.All())
+		}
+	}
+	assert.Equal(2, numRowErrs)
+}
+
+// TestSyncWorkerSpillHandler tests rows of failed insert operations
+// are spilled as enqueued, including rows of failed chunks only.
+func TestSyncWorkerSpillHandler(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Fail all requests of table t1, and the second request of table t2.
+	var t2Requests int
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			_, _, tID := getInsertMetadata(req.URL.Path)
+			code := 200
+			switch tID {
+			case "t1":
+				code = 400
+			case "t2":
+				t2Requests++
+				if t2Requests == 2 {
+					code = 400
+				}
+			}
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: code,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+
+			return &res, nil
+		})}
+
+	var spilled [][]Row
+	w, err := NewSyncWorker(&client, SetSyncMaxRowsPerRequest(2), SetSyncRowTransform(func(r Row) (Row, error) {
+		r.Data = map[string]bigquery.JsonValue{"transformed": true}
+		return r, nil
+	}), SetSyncSpillHandler(func(rows []Row) error {
+		spilled = append(spilled, rows)
+		return errors.New("disk full")
+	}))
+	require.NoError(err)
+
+	rows := map[string][]Row{}
+	for i := 0; i < 3; i++ {
+		for _, tID := range []string{"t1", "t2"} {
+			r := NewRow("p", "d", tID, map[string]bigquery.JsonValue{"k": tID + strconv.Itoa(i)})
+			rows[tID] = append(rows[tID], r)
+			w.Enqueue(r)
+		}
+	}
+	w.Insert()
+
+	require.Len(spilled, 2)
+	// Tables are inserted in no particular order.
+	if spilled[0][0].TableID == "t2" {
+		spilled[0], spilled[1] = spilled[1], spilled[0]
+	}
+	assert.Equal(rows["t1"], spilled[0])
+	assert.Equal(rows["t2"][2:], spilled[1])
+}
+
+// TestSyncWorkerFlushCallback tests the amount of inserted and rejected rows
+// of every table is reported after an insert operation.
+func TestSyncWorkerFlushCallback(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Reject the first row of every request of table t1, and fail table t2.
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			_, _, tID := getInsertMetadata(req.URL.Path)
+			code, body := 200, `{"insertErrors":[{"index":0,"errors":[{"reason":"invalid"}]}]}`
+			if tID == "t2" {
+				code, body = 400, `{}`
+			}
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: code,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body))}
+
+			return &res, nil
+		})}
+
+	results := map[string][2]int{}
+	w, err := NewSyncWorker(&client, SetSyncSkipInvalidRows(true), SetSyncMaxRowsPerRequest(2), SetSyncRowTransform(func(r Row) (Row, error) {
+		if r.Data["k"] == "bad" {
+			return r, errors.New("bad row")
+		}
+		return r, nil
+	}), SetSyncFlushCallback(func(inserted, rejected int, table string) {
+		results[table] = [2]int{inserted, rejected}
+	}))
+	require.NoError(err)
+
+	// Table t1 is split into 2 requests, and a row fails to transform.
+	for i := 0; i < 4; i++ {
+		w.Enqueue(NewRow("p", "d", "t1", map[string]bigquery.JsonValue{"k": strconv.Itoa(i)}))
+	}
+	w.Enqueue(NewRow("p", "d", "t1", map[string]bigquery.JsonValue{"k": "bad"}))
+	w.Enqueue(NewRow("p", "d", "t2", map[string]bigquery.JsonValue{"k": "0"}))
+	w.Insert()
+
+	assert.Equal(map[string][2]int{
+		"p.d.t1": {2, 3},
+		"p.d.t2": {0, 0},
+	}, results)
+}
+
+// TestSyncWorkerResponseHeader tests relevant headers of BigQuery's responses
+// are kept in insert attempts, for both successful and failed requests.
+func TestSyncWorkerResponseHeader(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Fail the first request with a quota error, and accept the second.
+	var calls int
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			calls++
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+			res.Header.Set("Content-Type", "application/json")
+			res.Header.Set("X-Goog-Quota-Remaining", strconv.Itoa(2-calls))
+			if calls == 1 {
+				res.StatusCode = 429
+				res.Header.Set("Retry-After", "0")
+				res.Body = ioutil.NopCloser(bytes.NewBufferString(`{"error":{"code":429,"message":"quota exceeded"}}`))
+			}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncMaxRetries(1), SetSyncRetryInterval(1*time.Millisecond))
+	require.NoError(err)
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	tables := w.InsertWithRetry().All()
+	require.Len(tables, 1)
+	attempts := tables[0].Attempts()
+	require.Len(attempts, 2)
+
+	assert.Error(attempts[0].Error())
+	assert.Equal(http.Header{"Retry-After": {"0"}, "X-Goog-Quota-Remaining": {"1"}}, attempts[0].Header())
+	assert.NoError(attempts[1].Error())
+	assert.Equal(http.Header{"X-Goog-Quota-Remaining": {"0"}}, attempts[1].Header())
+	assert.Equal([]string{"id0"}, attempts[1].InsertIDs())
+}
+
+// TestSyncWorkerMaxBytes tests enqueued rows' size is tracked,
+// and CanEnqueue() reports when the max bytes limit would be exceeded.
+func TestSyncWorkerMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Test size isn't tracked if no limit has been set.
+	w, err := NewSyncWorker(&http.Client{})
+	require.NoError(err)
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	assert.Equal(0, w.ByteLen())
+
+	// Every row is encoded as {"insertId":"idN","json":{"kN":"vN"}}
+	// which is 37 bytes long, plus a separating comma.
+	w, err = NewSyncWorker(&http.Client{}, SetSyncMaxBytes(2*38))
+	require.NoError(err)
+
+	// Test a single row is always allowed, even when exceeding the limit.
+	big := NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k": string(make([]byte, 100))})
+	assert.True(w.CanEnqueue(big))
+
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	assert.Equal(38, w.ByteLen())
+	assert.True(w.CanEnqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"})))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	assert.Equal(2*38, w.ByteLen())
+	assert.False(w.CanEnqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k2": "v2"})))
+
+	// Test a row sizer is used instead of encoding rows.
+	w, err = NewSyncWorker(&http.Client{}, SetSyncMaxBytes(25), SetSyncRowSizer(func(r Row) int { return 10 * len(r.Data) }))
+	require.NoError(err)
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0", "k1": "v1"}))
+	assert.Equal(20, w.ByteLen())
+	assert.False(w.CanEnqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k0": "v0"})))
+}
+
+// TestSyncWorkerInsertSplit tests a table with more rows than allowed in
+// a single request is split into multiple requests,
+// and row errors are reported relative to the entire table's rows.
+func TestSyncWorkerInsertSplit(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock response to report the first row of every request as invalid.
+	var requestRows []int
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			b, _ := ioutil.ReadAll(req.Body)
+			require.NoError(json.Unmarshal(b, &tableReq))
+			requestRows = append(requestRows, len(tableReq.Rows))
+
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"insertErrors":[{"index":0,"errors":[{"reason":"invalid"}]}]}`))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client)
+	require.NoError(err)
+
+	for i := 0; i < 15000; i++ {
+		w.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k": "v"}))
+	}
+	tables := w.Insert().All()
+
+	// Test two requests were made.
+	assert.Equal([]int{10000, 5000}, requestRows)
+
+	// Test each request has its own insert attempt,
+	// and row errors are relative to the entire table.
+	require.Len(tables, 1)
+	attempts := tables[0].Attempts()
+	require.Len(attempts, 2)
+	for i, index := range []int64{0, 10000} {
+		assert.NoError(attempts[i].Error())
+		rows := attempts[i].All()
+		require.Len(rows, 1)
+		assert.Equal(index, rows[0].Index)
+		assert.Equal(fmt.Sprintf("id%d", index), rows[0].InsertID)
+	}
+}
+
+// TestSyncWorkerMaxRowsPerRequest tests a table is split into requests
+// of at most the max rows per request,
+// and row errors are reported relative to the entire table's rows.
+func TestSyncWorkerMaxRowsPerRequest(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock response to report the last row of every request as invalid.
+	var requestRows []int
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+			requestRows = append(requestRows, len(tableReq.Rows))
+
+			body := fmt.Sprintf(`{"insertErrors":[{"index":%d,"errors":[{"reason":"invalid"}]}]}`, len(tableReq.Rows)-1)
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncMaxRowsPerRequest(2))
+	require.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		w.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k": "v"}))
+	}
+	insertErrs := w.Insert()
+	assert.Equal([]int{2, 2, 1}, requestRows)
+
+	rowErrs := insertErrs.RowErrors()
+	require.Len(rowErrs, 3)
+	for i, index := range []int{1, 3, 4} {
+		assert.Equal(index, rowErrs[i].Index)
+		assert.Equal(fmt.Sprintf("id%d", index), rowErrs[i].Row.InsertID)
+	}
+}
+
+// TestSyncWorkerPayloadTooLarge tests requests rejected as too large
+// are split in halves and inserted separately,
+// and a single row too large is reported as failed.
+func TestSyncWorkerPayloadTooLarge(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
 
-				for _, tr := range tableReq.Rows {
-					assert.NotNil(tr)
+	// Mock BigQuery rejecting all requests including row "id2" as too large.
+	var requestRows []int
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+			requestRows = append(requestRows, len(tableReq.Rows))
 
-					// Mock "insert row" to table: Create project, dataset and table
-					// if uninitalized.
-					initTableIfNotExists(ps, pID, dID, tID)
-					ps[pID][dID][tID] = append(ps[pID][dID][tID], &bigquery.TableDataInsertAllRequestRows{tr.InsertId, tr.Json, []string{}, []string{}})
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				// Empty JSON body, meaning "no errors".
+				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+			for _, row := range tableReq.Rows {
+				if row.InsertId == "id2" {
+					res.StatusCode = 413
+					res.Body = ioutil.NopCloser(bytes.NewBufferString(`{"error":{"code":413,"message":"Request payload size exceeds the limit"}}`))
 				}
-			default:
-				require.Fail("Insert was called more than once")
 			}
 
-			// Notify that this table was mock "flushed".
-			inserted <- struct{}{}
-
-			calledNum++
-
 			return &res, nil
 		})}
 
-	// Make retry sleep delay as small as possible and != 0.
-	w, err := NewSyncWorker(&client, SetSyncMaxRetries(10), SetSyncRetryInterval(1*time.Nanosecond))
+	var spilled []Row
+	w, err := NewSyncWorker(&client, SetSyncSpillHandler(func(rows []Row) error {
+		spilled = append(spilled, rows...)
+		return nil
+	}))
 	require.NoError(err)
 
-	// Queue 5 rows to the same table.
-	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k2": "v2"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id3", map[string]bigquery.JsonValue{"k3": "v3"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id4", map[string]bigquery.JsonValue{"k4": "v4"}))
-
-	// Test only a single insert occured.
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	for i := 0; i < 4; i++ {
+		w.Enqueue(NewRowWithID("p", "d", "t", fmt.Sprintf("id%d", i), map[string]bigquery.JsonValue{"k": "v"}))
+	}
+	tables := w.InsertWithRetry().All()
+	assert.Equal([]int{4, 2, 2, 1, 1}, requestRows)
+	assert.Equal(int64(3), w.counters.stats(0).InsertedRows)
 
-		select {
-		case <-inserted:
-		case <-time.After(1 * time.Second):
-			assert.Fail("initial insert didn't occur as expected")
-		}
-	}()
+	// Test only the single row too large failed.
+	require.Len(tables, 1)
+	attempts := tables[0].Attempts()
+	require.Len(attempts, 3)
+	assert.NoError(attempts[0].Error())
+	assert.True(isPayloadTooLarge(attempts[1].Error()))
+	assert.NoError(attempts[2].Error())
+	require.Len(spilled, 1)
+	assert.Equal("id2", spilled[0].InsertID)
+}
 
-	insertErrs := w.InsertWithRetry()
+// TestSyncWorkerSchemaRefresh tests rows rejected due to an unknown field
+// are retried once after refreshing the table's schema.
+func TestSyncWorkerSchemaRefresh(t *testing.T) {
+	t.Parallel()
 
-	done := make(chan struct{})
-	go func() { wg.Wait(); close(done) }()
-	select {
-	case <-done:
-	case <-time.After(1 * time.Second):
-		require.Fail("insert didn't occur as expected")
-	}
+	assert := assert.New(t)
+	require := require.New(t)
 
-	assert.Len(inserted, 0) // Test no more inserts happened.
-	assert.Len(w.rows, 0)   // Test rows were reset after insert.
+	for _, tt := range []struct {
+		name       string
+		fixes      bool
+		refreshErr error
+		requests   int
+		rejected   int
+	}{
+		{"fixed", true, nil, 2, 0},
+		{"still lagging", false, nil, 2, 3},
+		{"refresh failed", true, errors.New("boom"), 1, 3},
+	} {
+		// Mock BigQuery rejecting rows with the "new" field until refreshed,
+		// stopping all other rows in the same request.
+		requests, refreshed := 0, false
+		client := http.Client{
+			Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+				var tableReq bigquery.TableDataInsertAllRequest
+				require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+				requests++
+
+				var insertErrs bigquery.TableDataInsertAllResponse
+				if !refreshed {
+					for i, row := range tableReq.Rows {
+						rowErr := &bigquery.ErrorProto{Reason: "stopped"}
+						if _, ok := row.Json["new"]; ok {
+							rowErr = &bigquery.ErrorProto{Reason: "invalid", Message: "no such field: new."}
+						}
+						insertErrs.InsertErrors = append(insertErrs.InsertErrors, &bigquery.TableDataInsertAllResponseInsertErrors{
+							Index:  int64(i),
+							Errors: []*bigquery.ErrorProto{rowErr},
+						})
+					}
+				}
+				body, err := json.Marshal(&insertErrs)
+				require.NoError(err)
 
-	// Test rows slice wasn't re-allocated with a larger size.
-	// This make sure we're keeping the same row slice size throught the
-	// Worker's life.
-	assert.Equal(rowSize, cap(w.rows))
+				res := http.Response{
+					Header:     make(http.Header),
+					Request:    req,
+					StatusCode: 200,
+					Body:       ioutil.NopCloser(bytes.NewBuffer(body))}
 
-	// A 501 error should cause an insertError with a 501 error
-	// to be returned, along with the result (which is empty for this test).
-	tables := insertErrs.All()
-	require.Len(tables, 1)
-	require.Len(tables[0].Attempts(), 1)
-	assert.EqualError(
-		tables[0].Attempts()[0].Error(),
-		`googleapi: got HTTP response code 501 with body: {"code":501,"message":"m","Body":"b","Header":null,"Errors":[{"reason":"r1","message":"m1"},{"reason":"r2","message":"m2"}]}`)
-	assert.Empty(tables[0].Attempts()[0].All())
+				return &res, nil
+			})}
 
-	assert.Equal(
-		projects{
-			"p": project{
-				"d": dataset{
-					"t": table{
-						&bigquery.TableDataInsertAllRequestRows{"id0", map[string]bigquery.JsonValue{"k0": "v0"}, []string{}, []string{}},
-						&bigquery.TableDataInsertAllRequestRows{"id1", map[string]bigquery.JsonValue{"k1": "v1"}, []string{}, []string{}},
-						&bigquery.TableDataInsertAllRequestRows{"id2", map[string]bigquery.JsonValue{"k2": "v2"}, []string{}, []string{}},
-						&bigquery.TableDataInsertAllRequestRows{"id3", map[string]bigquery.JsonValue{"k3": "v3"}, []string{}, []string{}},
-						&bigquery.TableDataInsertAllRequestRows{"id4", map[string]bigquery.JsonValue{"k4": "v4"}, []string{}, []string{}},
-					}}}},
-		ps)
+		var tables []string
+		refresh := func(table string) error {
+			tables = append(tables, table)
+			refreshed = tt.fixes && tt.refreshErr == nil
+			return tt.refreshErr
+		}
+		stats := &statsRecorder{}
+		w, err := NewSyncWorker(&client, SetSyncSchemaRefresh(refresh), SetSyncStatsHandler(stats))
+		require.NoError(err)
+
+		w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k": "v"}))
+		w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"new": "v"}))
+		w.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k": "v"}))
+		insertErrs := w.InsertWithRetry()
+
+		// Test the schema was refreshed once, and rows were retried once.
+		assert.Equal([]string{"p.d.t"}, tables, tt.name)
+		assert.Equal(tt.requests, requests, tt.name)
+		assert.Len(insertErrs.RowErrors(), tt.rejected, tt.name)
+		assert.Equal(tt.rejected, stats.rejected, tt.name)
+		assert.Equal(3-tt.rejected, stats.inserted, tt.name)
+	}
 }
 
-// TestSyncWorkerMaxRetryInsert tests if a repeatedly failing insert attempt
-// (failing with non-rejected rows errors) is eventually dropped and ignored,
-// and Worker is moving on to the next table insert.
-func TestSyncWorkerMaxRetryInsert(t *testing.T) {
+// TestSyncWorkerRetryStopped tests rows stopped by invalid rows are retried
+// without them, and only the invalid rows are reported as rejected.
+func TestSyncWorkerRetryStopped(t *testing.T) {
 	t.Parallel()
 
 	assert := assert.New(t)
 	require := require.New(t)
 
-	// Mock response to return mocked errors, and notify table was mock "flushed".
-	// Also count the times the insert function was called to make sure it's retried exactly 3 times.
-	inserted := make(chan struct{})
-	calledNum := 0
+	// Mock BigQuery rejecting rows with key "invalid",
+	// stopping all other rows in the same request.
+	var requestIDs [][]string
 	client := http.Client{
 		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
-			pID, dID, tID := getInsertMetadata(req.URL.Path)
 			var tableReq bigquery.TableDataInsertAllRequest
-			b, _ := ioutil.ReadAll(req.Body)
-			require.NoError(json.Unmarshal(b, &tableReq))
-
-			var err error
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+
+			var ids []string
+			var insertErrs bigquery.TableDataInsertAllResponse
+			invalid := false
+			for _, row := range tableReq.Rows {
+				ids = append(ids, row.InsertId)
+				if _, ok := row.Json["invalid"]; ok {
+					invalid = true
+				}
+			}
+			requestIDs = append(requestIDs, ids)
+			if invalid {
+				for i, row := range tableReq.Rows {
+					reason := "stopped"
+					if _, ok := row.Json["invalid"]; ok {
+						reason = "invalid"
+					}
+					insertErrs.InsertErrors = append(insertErrs.InsertErrors, &bigquery.TableDataInsertAllResponseInsertErrors{
+						Index:  int64(i),
+						Errors: []*bigquery.ErrorProto{{Reason: reason}},
+					})
+				}
+			}
+			body, err := json.Marshal(&insertErrs)
+			require.NoError(err)
 
-			// Default response.
 			res := http.Response{
 				Header:     make(http.Header),
 				Request:    req,
 				StatusCode: 200,
-				// Empty JSON body, meaning "no errors".
-				Body: ioutil.NopCloser(bytes.NewBufferString(`{}`))}
+				Body:       ioutil.NopCloser(bytes.NewBuffer(body))}
 
-			// Return Google API HTTP 503 error on every call, which should be retried and logged.
-			// Thus, we should expect 3 logged
-			if calledNum < 3 {
-				err = &googleapi.Error{
-					Code:    503,
-					Message: "m",
-					Body:    "b",
-					Errors: []googleapi.ErrorItem{
-						googleapi.ErrorItem{Reason: "r1", Message: "m1"},
-						googleapi.ErrorItem{Reason: "r2", Message: "m2"},
-					}}
+			return &res, nil
+		})}
 
-				if b, err := json.Marshal(err); assert.NoError(err) {
-					// Response should be HTTP 503.
-					res = http.Response{
-						Header:     make(http.Header),
-						Request:    req,
-						StatusCode: 503,
-						Body:       ioutil.NopCloser(bytes.NewBuffer(b))}
-				}
+	stats := &statsRecorder{}
+	w, err := NewSyncWorker(&client, SetSyncRetryStopped(true), SetSyncStatsHandler(stats))
+	require.NoError(err)
 
-				// Test request payload.
-				ps := projects{}
-				for _, tr := range tableReq.Rows {
-					assert.NotNil(tr)
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k": "v"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"invalid": "v"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k": "v"}))
+	insertErrs := w.InsertWithRetry()
 
-					// Mock "insert row" to table: Create project, dataset and table
-					// if uninitalized.
-					initTableIfNotExists(ps, pID, dID, tID)
-					ps[pID][dID][tID] = append(ps[pID][dID][tID], &bigquery.TableDataInsertAllRequestRows{tr.InsertId, tr.Json, []string{}, []string{}})
+	// Test the stopped rows were retried without the invalid row.
+	assert.Equal([][]string{{"id0", "id1", "id2"}, {"id0", "id2"}}, requestIDs)
+
+	// Test only the invalid row was reported as rejected.
+	rowErrs := insertErrs.RowErrors()
+	require.Len(rowErrs, 1)
+	assert.Equal("id1", rowErrs[0].Row.InsertID)
+	assert.Equal(1, rowErrs[0].Index)
+	assert.Equal(1, stats.rejected)
+	assert.Equal(2, stats.inserted)
+	assert.Equal(2, stats.retried)
+	assert.Equal(map[string]int{"invalid": 1}, stats.reasons["p.d.t"])
+}
+
+// TestSyncWorkerRetryTransientRows tests only rows rejected due to transient
+// errors are retried after a partially successful insert,
+// without the inserted and invalid rows.
+func TestSyncWorkerRetryTransientRows(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Mock BigQuery skipping rows with key "invalid",
+	// and failing rows with key "flaky" on their first attempt only.
+	var requestIDs [][]string
+	flaky := map[string]bool{}
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			var tableReq bigquery.TableDataInsertAllRequest
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+			assert.True(tableReq.SkipInvalidRows)
+
+			var ids []string
+			var insertErrs bigquery.TableDataInsertAllResponse
+			for i, row := range tableReq.Rows {
+				ids = append(ids, row.InsertId)
+				reason := ""
+				if _, ok := row.Json["invalid"]; ok {
+					reason = "invalid"
+				} else if _, ok := row.Json["flaky"]; ok && !flaky[row.InsertId] {
+					flaky[row.InsertId] = true
+					reason = "backendError"
+				}
+				if reason != "" {
+					insertErrs.InsertErrors = append(insertErrs.InsertErrors, &bigquery.TableDataInsertAllResponseInsertErrors{
+						Index:  int64(i),
+						Errors: []*bigquery.ErrorProto{{Reason: reason}},
+					})
 				}
-				assert.Equal(
-					projects{
-						"p": project{
-							"d": dataset{
-								"t": table{
-									&bigquery.TableDataInsertAllRequestRows{"id0", map[string]bigquery.JsonValue{"k0": "v0"}, []string{}, []string{}},
-									&bigquery.TableDataInsertAllRequestRows{"id1", map[string]bigquery.JsonValue{"k1": "v1"}, []string{}, []string{}},
-									&bigquery.TableDataInsertAllRequestRows{"id2", map[string]bigquery.JsonValue{"k2": "v2"}, []string{}, []string{}},
-									&bigquery.TableDataInsertAllRequestRows{"id3", map[string]bigquery.JsonValue{"k3": "v3"}, []string{}, []string{}},
-									&bigquery.TableDataInsertAllRequestRows{"id4", map[string]bigquery.JsonValue{"k4": "v4"}, []string{}, []string{}},
-								}}}},
-					ps)
-			} else {
-				assert.Fail("Insert was called more than 3 times")
 			}
+			requestIDs = append(requestIDs, ids)
+			body, err := json.Marshal(&insertErrs)
+			require.NoError(err)
 
-			// Notify that this table was mock "inserted".
-			inserted <- struct{}{}
-
-			calledNum++
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBuffer(body))}
 
 			return &res, nil
 		})}
 
-	// Set a row threshold to 5 so it will flush immediately on calling Start().
-	// Also make retry sleep delay as small as possible and != 0.
-	w, err := NewSyncWorker(&client, SetSyncMaxRetries(3), SetSyncRetryInterval(1*time.Nanosecond))
+	stats := &statsRecorder{}
+	w, err := NewSyncWorker(&client, SetSyncSkipInvalidRows(true), SetSyncRetryTransientRows(true), SetSyncRetryInterval(1*time.Millisecond), SetSyncStatsHandler(stats))
 	require.NoError(err)
 
-	// Queue 5 rows to the same table.
-	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"k2": "v2"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id3", map[string]bigquery.JsonValue{"k3": "v3"}))
-	w.Enqueue(NewRowWithID("p", "d", "t", "id4", map[string]bigquery.JsonValue{"k4": "v4"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k": "v"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id1", map[string]bigquery.JsonValue{"invalid": "v"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id2", map[string]bigquery.JsonValue{"flaky": "v"}))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id3", map[string]bigquery.JsonValue{"k": "v"}))
+	insertErrs := w.InsertWithRetry()
 
-	// Test only a single insert occured.
-	var wg sync.WaitGroup
-	for i := 0; i < 3; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+	// Test only the flaky row was retried, with its insert ID.
+	assert.Equal([][]string{{"id0", "id1", "id2", "id3"}, {"id2"}}, requestIDs)
+
+	// Test only the invalid row was reported as rejected.
+	rowErrs := insertErrs.RowErrors()
+	require.Len(rowErrs, 1)
+	assert.Equal("id1", rowErrs[0].Row.InsertID)
+	assert.Equal(1, rowErrs[0].Index)
+	assert.Equal(1, stats.rejected)
+	assert.Equal(3, stats.inserted)
+	assert.Equal(1, stats.retried)
+	assert.Equal(map[string]int{"invalid": 1}, stats.reasons["p.d.t"])
+
+	// Test rows still failing after too many retries are reported as rejected.
+	w, err = NewSyncWorker(&client, SetSyncSkipInvalidRows(true), SetSyncRetryTransientRows(true), SetSyncMaxRetries(0))
+	require.NoError(err)
+	w.Enqueue(NewRowWithID("p", "d", "t", "id4", map[string]bigquery.JsonValue{"flaky": "v"}))
+	rowErrs = w.InsertWithRetry().RowErrors()
+	require.Len(rowErrs, 1)
+	assert.Equal("id4", rowErrs[0].Row.InsertID)
+}
 
-			select {
-			case <-inserted:
-			case <-time.After(1 * time.Second):
-				assert.Fail("insert didn't occur as expected", strconv.Itoa(i))
-			}
-		}(i)
-	}
+// TestSplitTable tests splitting table rows according to BigQuery's
+// max rows and size per request limits.
+func TestSplitTable(t *testing.T) {
+	t.Parallel()
 
-	insertErrs := w.InsertWithRetry()
+	assert := assert.New(t)
 
-	done := make(chan struct{})
-	go func() { wg.Wait(); close(done) }()
-	select {
-	case <-done:
-	case <-time.After(1 * time.Second):
-		require.Fail("insert didn't occur as expected")
+	row := func(size int) *bigquery.TableDataInsertAllRequestRows {
+		return &bigquery.TableDataInsertAllRequestRows{Json: map[string]bigquery.JsonValue{"k": string(bytes.Repeat([]byte("a"), size))}}
 	}
 
-	assert.Len(inserted, 0) // Test no more inserts happened.
-	assert.Len(w.rows, 0)   // Test rows were reset after insert.
+	// Test a small table isn't split.
+	assert.Len(splitTable(table{row(1), row(1)}, maxRequestRows, true), 1)
 
-	tables := insertErrs.All()
-	require.Len(tables, 1)
-	// 3 attempts and the 4th is the one that aborts the insert operation.
-	require.Len(tables[0].Attempts(), 4)
-	assert.EqualError(tables[0].Attempts()[0].Error(), `googleapi: got HTTP response code 503 with body: {"code":503,"message":"m","Body":"b","Header":null,"Errors":[{"reason":"r1","message":"m1"},{"reason":"r2","message":"m2"}]}`)
-	assert.EqualError(tables[0].Attempts()[1].Error(), `googleapi: got HTTP response code 503 with body: {"code":503,"message":"m","Body":"b","Header":null,"Errors":[{"reason":"r1","message":"m1"},{"reason":"r2","message":"m2"}]}`)
-	assert.EqualError(tables[0].Attempts()[2].Error(), `googleapi: got HTTP response code 503 with body: {"code":503,"message":"m","Body":"b","Header":null,"Errors":[{"reason":"r1","message":"m1"},{"reason":"r2","message":"m2"}]}`)
-	assert.EqualError(tables[0].Attempts()[3].Error(), "Insert table p.d.t retried 4 times, dropping insert and moving on")
+	// Test splitting by size: two 6MB rows can't fit in a single request.
+	chunks := splitTable(table{row(6 << 20), row(6 << 20), row(1)}, maxRequestRows, true)
+	if assert.Len(chunks, 2) {
+		assert.Len(chunks[0], 1)
+		assert.Len(chunks[1], 2)
+	}
+
+	// Test a single row exceeding the size limit is still sent on its own.
+	chunks = splitTable(table{row(11 << 20), row(1)}, maxRequestRows, true)
+	if assert.Len(chunks, 2) {
+		assert.Len(chunks[0], 1)
+		assert.Len(chunks[1], 1)
+	}
+	// Test size isn't checked if not required.
+	assert.Len(splitTable(table{row(6 << 20), row(6 << 20), row(1)}, maxRequestRows, false), 1)
+
+	// Test splitting by a lower max rows.
+	chunks = splitTable(table{row(1), row(1), row(1)}, 2, false)
+	if assert.Len(chunks, 2) {
+		assert.Len(chunks[0], 2)
+		assert.Len(chunks[1], 1)
+	}
 }
 
 // getInsertMetadata is a helper function that fetches the project, dataset,
@@ -934,3 +3089,52 @@ func newTransport(roundTrip func(*http.Request) (*http.Response, error)) *transp
 	return &transport{roundTrip}
 }
 func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) { return t.roundTrip(req) }
+
+// TestSyncWorkerRawData tests rows holding raw JSON data are sent as is,
+// alongside rows holding values.
+func TestSyncWorkerRawData(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var (
+		path     string
+		tableReq bigquery.TableDataInsertAllRequest
+	)
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			path = req.URL.Path
+			require.NoError(json.NewDecoder(req.Body).Decode(&tableReq))
+
+			// Reject the raw row.
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(`{"insertErrors":[{"index":1,"errors":[{"reason":"invalid"}]}]}`))}
+
+			return &res, nil
+		})}
+
+	w, err := NewSyncWorker(&client, SetSyncEndpoint("http://localhost/bigquery/v2/"))
+	require.NoError(err)
+
+	raw := NewRawRow("p", "d", "t", json.RawMessage(`{"k1":"v1","k2":{"k3":3}}`))
+	w.Enqueue(NewRowWithID("p", "d", "t", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(raw)
+	insertErrs := w.Insert()
+
+	assert.Equal("/bigquery/v2/projects/p/datasets/d/tables/t/insertAll", path)
+	assert.Equal("bigquery#tableDataInsertAllRequest", tableReq.Kind)
+	require.Len(tableReq.Rows, 2)
+	assert.Equal("id0", tableReq.Rows[0].InsertId)
+	assert.Equal(map[string]bigquery.JsonValue{"k0": "v0"}, tableReq.Rows[0].Json)
+	assert.Equal(raw.InsertID, tableReq.Rows[1].InsertId)
+	assert.Equal(map[string]bigquery.JsonValue{"k1": "v1", "k2": map[string]interface{}{"k3": 3.0}}, tableReq.Rows[1].Json)
+
+	// Test the rejected row is matched to its enqueued row.
+	rowErrs := insertErrs.RowErrors()
+	require.Len(rowErrs, 1)
+	assert.Equal(raw.InsertID, rowErrs[0].Row.InsertID)
+}
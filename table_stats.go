@@ -0,0 +1,129 @@
+package bqstreamer
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// TableStat is a snapshot of a single table's insert counters,
+// as returned by AsyncWorkerGroup.TableStats().
+type TableStat struct {
+	// Amount of rows successfully inserted to the table,
+	// and their size in bytes as encoded in insert requests.
+	InsertedRows  int64
+	InsertedBytes int64
+
+	// Time the table has started being tracked,
+	// i.e. its first successful insert request since tracking began,
+	// or since it was last evicted.
+	Since time.Time
+
+	// Average throughput since the table has started being tracked.
+	// Diff snapshots' counters for throughput over shorter intervals.
+	RowsPerSecond  float64
+	BytesPerSecond float64
+}
+
+// tableStats maintains the insert counters of up to a maximum amount of tables,
+// evicting the least recently inserted table once exceeded,
+// shared by all workers of an AsyncWorkerGroup.
+type tableStats struct {
+	max int
+	now func() time.Time
+
+	mu sync.Mutex
+	// Tables by name, most recently inserted first.
+	tables map[string]*list.Element
+	lru    *list.List
+}
+
+// trackedTable is a single table's entry in tableStats.
+type trackedTable struct {
+	name string
+	stat TableStat
+}
+
+func newTableStats(max int) *tableStats {
+	return &tableStats{
+		max:    max,
+		now:    time.Now,
+		tables: map[string]*list.Element{},
+		lru:    list.New(),
+	}
+}
+
+// record adds given amount of inserted rows and bytes to given table,
+// tracking it if not tracked yet.
+func (s *tableStats) record(name string, rows, bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.tables[name]
+	if ok {
+		s.lru.MoveToFront(e)
+	} else {
+		e = s.lru.PushFront(&trackedTable{name: name, stat: TableStat{Since: s.now()}})
+		s.tables[name] = e
+		if s.lru.Len() > s.max {
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.tables, oldest.Value.(*trackedTable).name)
+		}
+	}
+
+	t := e.Value.(*trackedTable)
+	t.stat.InsertedRows += int64(rows)
+	t.stat.InsertedBytes += int64(bytes)
+}
+
+// snapshot returns a copy of all tracked tables' stats by name,
+// with their throughput as of now.
+func (s *tableStats) snapshot() map[string]TableStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	stats := make(map[string]TableStat, len(s.tables))
+	for name, e := range s.tables {
+		stat := e.Value.(*trackedTable).stat
+		if secs := now.Sub(stat.Since).Seconds(); secs > 0 {
+			stat.RowsPerSecond = float64(stat.InsertedRows) / secs
+			stat.BytesPerSecond = float64(stat.InsertedBytes) / secs
+		}
+		stats[name] = stat
+	}
+	return stats
+}
+
+// insertedBytes returns the size in bytes of given table's rows
+// not rejected by BigQuery, as encoded in the insert request.
+func insertedBytes(tbl table, rejected []*bigquery.TableDataInsertAllResponseInsertErrors) int {
+	isRejected := make(map[int64]bool, len(rejected))
+	for _, row := range rejected {
+		isRejected[row.Index] = true
+	}
+
+	n := 0
+	for i, row := range tbl {
+		if !isRejected[int64(i)] {
+			n += encodedRowSize(row)
+		}
+	}
+	return n
+}
+
+// TableStats returns a snapshot of the insert counters of every table
+// inserted to, keyed by "project.dataset.table" + template suffix,
+// if enabled using SetAsyncTableStats(). It returns nil otherwise.
+//
+// The returned map is a copy, and is safe to use concurrently
+// with the AsyncWorkerGroup.
+func (s *AsyncWorkerGroup) TableStats() map[string]TableStat {
+	if s.tableStats == nil {
+		return nil
+	}
+	return s.tableStats.snapshot()
+}
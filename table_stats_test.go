@@ -0,0 +1,99 @@
+package bqstreamer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// TestTableStats tests tracked tables are evicted least recently inserted first,
+// and throughput is calculated since a table has started being tracked.
+func TestTableStats(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	now := time.Unix(0, 0)
+	s := newTableStats(2)
+	s.now = func() time.Time { return now }
+
+	s.record("p.d.t0", 10, 100)
+	s.record("p.d.t1", 1, 10)
+	now = now.Add(2 * time.Second)
+	s.record("p.d.t0", 10, 100)
+
+	stats := s.snapshot()
+	assert.Equal(map[string]TableStat{
+		"p.d.t0": TableStat{InsertedRows: 20, InsertedBytes: 200, Since: time.Unix(0, 0), RowsPerSecond: 10, BytesPerSecond: 100},
+		"p.d.t1": TableStat{InsertedRows: 1, InsertedBytes: 10, Since: time.Unix(0, 0), RowsPerSecond: 0.5, BytesPerSecond: 5},
+	}, stats)
+
+	// Test the snapshot is a copy.
+	delete(stats, "p.d.t0")
+	assert.Len(s.snapshot(), 2)
+
+	// Test the least recently inserted table is evicted,
+	// and is tracked anew once inserted to again.
+	s.record("p.d.t2", 1, 10)
+	stats = s.snapshot()
+	assert.Len(stats, 2)
+	assert.Contains(stats, "p.d.t0")
+	assert.Contains(stats, "p.d.t2")
+
+	s.record("p.d.t1", 1, 10)
+	stats = s.snapshot()
+	assert.Len(stats, 2)
+	assert.NotContains(stats, "p.d.t0")
+	assert.Equal(int64(1), stats["p.d.t1"].InsertedRows)
+	assert.Equal(now, stats["p.d.t1"].Since)
+}
+
+// TestSyncWorkerTableStats tests only inserted rows are counted per table.
+func TestSyncWorkerTableStats(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+	require := require.New(t)
+
+	client := http.Client{
+		Transport: newTransport(func(req *http.Request) (*http.Response, error) {
+			body := `{}`
+			if _, _, tableID := getInsertMetadata(req.URL.Path); tableID == "t1" {
+				body = `{"insertErrors":[{"index":0,"errors":[{"reason":"invalid"}]}]}`
+			}
+			res := http.Response{
+				Header:     make(http.Header),
+				Request:    req,
+				StatusCode: 200,
+				Body:       ioutil.NopCloser(bytes.NewBufferString(body))}
+
+			return &res, nil
+		})}
+
+	s := newTableStats(10)
+	w, err := NewSyncWorker(&client, setSyncTableStats(s))
+	require.NoError(err)
+
+	w.Enqueue(NewRowWithID("p", "d", "t0", "id0", map[string]bigquery.JsonValue{"k0": "v0"}))
+	w.Enqueue(NewRowWithID("p", "d", "t0", "id1", map[string]bigquery.JsonValue{"k1": "v1"}))
+	w.Enqueue(NewRowWithID("p", "d", "t1", "id2", map[string]bigquery.JsonValue{"k2": "v2"}))
+	r3 := NewRowWithID("p", "d", "t2", "id3", map[string]bigquery.JsonValue{"k3": "v3"})
+	r3.TemplateSuffix = "_s"
+	w.Enqueue(r3)
+	w.Insert()
+
+	stats := s.snapshot()
+	require.Len(stats, 2)
+	assert.Equal(int64(2), stats["p.d.t0"].InsertedRows)
+	// Each row is encoded as e.g. {"insertId":"id0","json":{"k0":"v0"}},
+	// followed by a comma.
+	assert.Equal(int64(2*38), stats["p.d.t0"].InsertedBytes)
+	assert.Equal(int64(1), stats["p.d.t2_s"].InsertedRows)
+	assert.NotContains(stats, "p.d.t1")
+}
@@ -0,0 +1,46 @@
+package bqstreamer
+
+import "context"
+
+// InsertTracer traces insert requests to BigQuery,
+// e.g. by wrapping every request in an OpenTelemetry span.
+//
+// See the bqotel subpackage for an OpenTelemetry implementation.
+// Keeping tracing behind this interface means bqstreamer itself
+// does not depend on any tracing library.
+type InsertTracer interface {
+	// StartInsert is called before every insert request.
+	//
+	// It returns the context used for executing the request,
+	// and a function which is called with the request's result
+	// once it has completed.
+	StartInsert(ctx context.Context, info InsertInfo) (context.Context, func(InsertResult))
+}
+
+// InsertInfo describes a single insert request.
+type InsertInfo struct {
+	// The project, dataset and table the rows are inserted to.
+	Project, Dataset, Table string
+
+	// The template suffix of the table, if any. See Row.TemplateSuffix.
+	TemplateSuffix string
+
+	// Amount of rows sent in the request.
+	Rows int
+
+	// The insert attempt number, starting at zero.
+	// Retries of a failed insert have a positive attempt number.
+	Attempt int
+
+	// The request's trace ID if set using SetSyncTraceID(), empty otherwise.
+	TraceID string
+}
+
+// InsertResult describes the result of a single insert request.
+type InsertResult struct {
+	// The request error, if any.
+	Err error
+
+	// Amount of rows rejected by BigQuery, e.g. due to invalid values.
+	RejectedRows int
+}
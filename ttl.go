@@ -0,0 +1,37 @@
+package bqstreamer
+
+import "sync/atomic"
+
+// dropExpired removes enqueued rows buffered for longer than the row TTL
+// set using SetAsyncRowTTL(), reporting them as dropped.
+//
+// Rows are aged since they've been enqueued to the group,
+// including time spent in the row channel.
+func (w *asyncWorker) dropExpired() {
+	if w.rowTTL <= 0 {
+		return
+	}
+
+	now := w.worker.clock.Now()
+	var expired []Row
+	rows := w.worker.rows[:0]
+	enqueuedAt := w.worker.enqueuedAt[:0]
+	for i, r := range w.worker.rows {
+		if !r.enqueuedAt.IsZero() && now.Sub(r.enqueuedAt) > w.rowTTL {
+			expired = append(expired, r)
+			continue
+		}
+		rows = append(rows, r)
+		enqueuedAt = append(enqueuedAt, w.worker.enqueuedAt[i])
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	w.worker.rows = rows
+	w.worker.enqueuedAt = enqueuedAt
+	atomic.AddInt64(&w.worker.counters.bufferedRows, -int64(len(expired)))
+	atomic.AddInt64(&w.worker.counters.expiredRows, int64(len(expired)))
+	w.worker.logger.Warnf("bqstreamer: dropping %d rows older than %s", len(expired), w.rowTTL)
+	w.dropped(expired, DropExpired)
+}
@@ -0,0 +1,37 @@
+package bqstreamer
+
+import "net/http"
+
+// userAgentTransport is an http.RoundTripper setting a custom User-Agent
+// header on every request, before sending it using the base RoundTripper.
+//
+// It wraps the client's entire transport, e.g. an OAuth2 transport,
+// so the header is kept regardless of how requests are authenticated.
+type userAgentTransport struct {
+	userAgent string
+
+	// Replace the User-Agent set by the BigQuery API client if true,
+	// instead of appending to it.
+	replace bool
+
+	// Uses http.DefaultTransport if nil.
+	base http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ua := t.userAgent
+	if prev := req.Header.Get("User-Agent"); prev != "" && !t.replace {
+		ua = prev + " " + ua
+	}
+
+	// A RoundTripper must not modify the given request.
+	r := req.Clone(req.Context())
+	r.Header.Set("User-Agent", ua)
+
+	return base.RoundTrip(r)
+}
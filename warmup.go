@@ -0,0 +1,64 @@
+package bqstreamer
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// warmupTimeout bounds a worker's warmup request, see SetAsyncWarmup().
+const warmupTimeout = 10 * time.Second
+
+// warmup sends a cheap request to the BigQuery API base URL,
+// establishing a connection in the client's pool and fetching
+// an OAuth2 token if required, so the first insert doesn't pay for them.
+//
+// Any HTTP response is a successful warmup, even an error status.
+func (w *SyncWorker) warmup(ctx context.Context) error {
+	req, err := http.NewRequest("HEAD", w.service.BasePath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", googleapi.UserAgent)
+
+	res, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// Drain the body, so the connection is reused.
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	return err
+}
+
+// warmupClient warms up the worker's HTTP client, see SyncWorker.warmup().
+// It returns early once the worker is closed.
+//
+// Failures are logged, since the first insert establishes
+// the connection anyways.
+func (w *asyncWorker) warmupClient() {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(w.ctx, warmupTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-w.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := w.worker.warmup(ctx); err != nil {
+		w.worker.logger.Warnf("bqstreamer: warmup failed: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package bqstreamer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// waterMark signals the depth of the row channel crossing the high water mark
+// set using SetAsyncHighWaterMark(), and recovering below the low water mark,
+// shared by an AsyncWorkerGroup and all of its workers.
+//
+// The low water mark is below the high one, so callbacks don't flap
+// while the depth hovers around a single threshold.
+type waterMark struct {
+	high, low     float64
+	onHigh, onLow func(depth, capacity int)
+
+	// Set while above the high water mark, until recovering below the low one.
+	// Accessed atomically, and only changed while holding mu,
+	// which serializes callbacks so they're called in order.
+	above int32
+	mu    sync.Mutex
+}
+
+// observe observes the row channel's current depth and capacity,
+// calling the high or low water mark callback if crossed.
+//
+// It's cheap as long as no threshold is crossed,
+// since it's called for every enqueued row.
+func (m *waterMark) observe(depth, capacity int) {
+	if atomic.LoadInt32(&m.above) == 0 {
+		if float64(depth) < m.high*float64(capacity) {
+			return
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if atomic.LoadInt32(&m.above) == 0 {
+			atomic.StoreInt32(&m.above, 1)
+			m.onHigh(depth, capacity)
+		}
+		return
+	}
+
+	if float64(depth) > m.low*float64(capacity) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if atomic.LoadInt32(&m.above) == 1 {
+		atomic.StoreInt32(&m.above, 0)
+		if m.onLow != nil {
+			m.onLow(depth, capacity)
+		}
+	}
+}
+
+// observeDepth observes the depth of given row channel if a water mark
+// has been set, or of all given dispatch channels if set.
+func (s *AsyncWorkerGroup) observeDepth(rowChan chan Row, dispatchChans []chan Row) {
+	if s.waterMark == nil {
+		return
+	}
+	if dispatchChans == nil {
+		s.waterMark.observe(len(rowChan), cap(rowChan))
+		return
+	}
+	depth, capacity := 0, 0
+	for _, c := range dispatchChans {
+		depth += len(c)
+		capacity += cap(c)
+	}
+	s.waterMark.observe(depth, capacity)
+}
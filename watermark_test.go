@@ -0,0 +1,41 @@
+package bqstreamer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWaterMark tests water mark callbacks are called once per crossing,
+// and not while the depth hovers between the water marks.
+func TestWaterMark(t *testing.T) {
+	t.Parallel()
+
+	assert := assert.New(t)
+
+	var calls []string
+	m := waterMark{
+		high:   0.8,
+		low:    0.2,
+		onHigh: func(depth, capacity int) { calls = append(calls, "high") },
+		onLow:  func(depth, capacity int) { calls = append(calls, "low") },
+	}
+
+	for _, depth := range []int{0, 5, 7, 8, 9, 10, 5, 3, 8, 2, 1, 0, 5} {
+		m.observe(depth, 10)
+	}
+	assert.Equal([]string{"high", "low"}, calls)
+
+	m.observe(10, 10)
+	m.observe(0, 10)
+	assert.Equal([]string{"high", "low", "high", "low"}, calls)
+
+	// Test the high water mark is re-armed without a low water mark callback.
+	calls = nil
+	m.onLow = nil
+	m.observe(8, 10)
+	m.observe(8, 10)
+	m.observe(2, 10)
+	m.observe(8, 10)
+	assert.Equal([]string{"high", "high"}, calls)
+}